@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"ai-json/internal/analyze"
 	"ai-json/internal/api"
+	"ai-json/internal/config"
+	"ai-json/internal/fswatch"
 	"ai-json/internal/ingest"
 	"ai-json/internal/store"
 )
@@ -20,6 +28,18 @@ func main() {
 		pollSeconds      int
 		minFileAgeSecond int
 		maxPastSeconds   int
+		ingestMode       string
+		reconcileSeconds int
+		maxFileAttempts  int
+		quarantineDir    string
+		ingestWorkers    int
+		shutdownSeconds  int
+		schemaDir        string
+		rulesPath        string
+		retentionPath    string
+		retentionTTLs    string
+		retentionSeconds int
+		promoteFields    string
 	)
 	flag.StringVar(&addr, "addr", ":8080", "HTTP listen address")
 	flag.StringVar(&dbPath, "db", "./data/ai-json.db", "sqlite database path")
@@ -27,8 +47,39 @@ func main() {
 	flag.IntVar(&pollSeconds, "poll-seconds", 5, "periodic stream scan interval in seconds (0 disables scheduler)")
 	flag.IntVar(&minFileAgeSecond, "min-file-age-seconds", 2, "minimum file age before ingesting JSON files")
 	flag.IntVar(&maxPastSeconds, "max-past-seconds", 60, "maximum age (by epoch filename) allowed for ingestion")
+	flag.StringVar(&ingestMode, "ingest-mode", "poll", "background ingestion mode: poll|watch")
+	flag.IntVar(&reconcileSeconds, "reconcile-seconds", 60, "full reconcile scan interval in watch mode")
+	flag.IntVar(&maxFileAttempts, "max-file-attempts", 3, "failed read/parse attempts before an event file is quarantined")
+	flag.StringVar(&quarantineDir, "quarantine-dir", "", "directory receiving quarantined event files (empty keeps them in place, ignored)")
+	flag.IntVar(&ingestWorkers, "ingest-workers", 4, "cameras read and parsed concurrently per ingestion pass")
+	flag.IntVar(&shutdownSeconds, "shutdown-timeout-seconds", 30, "time allowed for in-flight requests and ingestion to finish after SIGINT/SIGTERM")
+	flag.StringVar(&schemaDir, "schema-dir", "", "directory of <event_type>.v<N>.json schemas overriding or extending the built-in ones (used by /v1/ingest/events?validate=...)")
+	flag.StringVar(&rulesPath, "rules", "", "JSON rules file adding to or overriding the built-in validation rules (used by /v1/ingest/events?validate=...)")
+	flag.StringVar(&retentionPath, "retention-file", "", "JSON retention policy file with per-event-type TTLs")
+	flag.StringVar(&retentionTTLs, "retention-ttl", "", "comma-separated event_type=ttl pairs applied over --retention-file (e.g. person_tracked=7d,default=90d,fingerprints=180d)")
+	flag.IntVar(&retentionSeconds, "retention-seconds", 3600, "interval between retention runs in seconds (0 disables; nothing runs without a policy)")
+	flag.StringVar(&promoteFields, "promote-fields", "", "comma-separated name=$.path[:text|real|integer] raw_json fields to index as field_<name> columns, filterable as field.<name> (e.g. distance=$.distance:real)")
 	flag.Parse()
 
+	if ingestMode != "poll" && ingestMode != "watch" {
+		fatalf("invalid --ingest-mode %q (expected poll or watch)", ingestMode)
+	}
+
+	validator, err := analyze.LoadValidator(schemaDir, rulesPath)
+	if err != nil {
+		fatalf("load validation config: %v", err)
+	}
+
+	retention, err := store.RetentionPolicyFromFlags(retentionPath, retentionTTLs)
+	if err != nil {
+		fatalf("load retention policy: %v", err)
+	}
+
+	promoted, err := store.ParsePromotedFields(promoteFields)
+	if err != nil {
+		fatalf("invalid --promote-fields: %v", err)
+	}
+
 	if err := os.MkdirAll("./data", 0o755); err != nil {
 		fatalf("create data dir: %v", err)
 	}
@@ -37,18 +88,73 @@ func main() {
 	if err != nil {
 		fatalf("open store: %v", err)
 	}
-	defer s.Close()
+	added, err := s.PromoteFields(promoted)
+	if err != nil {
+		_ = s.Close()
+		fatalf("promote fields: %v", err)
+	}
+	for _, f := range added {
+		fmt.Fprintf(os.Stdout, "promoted field %s from %s (%s)\n", f.Name, f.Path, f.Type)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var background sync.WaitGroup
+
+	streamConfig := config.NewManager(streamPath)
+	if st := streamConfig.Status(); st.LastError != "" {
+		fmt.Fprintf(os.Stderr, "stream config not loaded: %s\n", st.LastError)
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		watchStreamConfig(ctx, streamConfig, time.Duration(pollSeconds)*time.Second)
+	}()
 
 	minAge := time.Duration(minFileAgeSecond) * time.Second
 	maxPast := time.Duration(maxPastSeconds) * time.Second
-	if pollSeconds > 0 {
-		go runPeriodicIngestion(s, streamPath, time.Duration(pollSeconds)*time.Second, minAge, maxPast)
+	runner := &ingest.Runner{
+		Store:           s,
+		StreamPath:      streamPath,
+		MinFileAge:      minAge,
+		MaxPastAge:      maxPast,
+		MaxFileAttempts: maxFileAttempts,
+		QuarantineDir:   quarantineDir,
+		Workers:         ingestWorkers,
+		Config:          streamConfig,
+	}
+	switch {
+	case ingestMode == "watch":
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runWatchIngestion(ctx, runner, time.Duration(reconcileSeconds)*time.Second, time.Duration(pollSeconds)*time.Second)
+		}()
+	case pollSeconds > 0:
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runPeriodicIngestion(ctx, runner, time.Duration(pollSeconds)*time.Second)
+		}()
+	}
+
+	if !retention.Empty() && retentionSeconds > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runPeriodicRetention(ctx, s, retention, time.Duration(retentionSeconds)*time.Second)
+		}()
 	}
 
 	h := api.New(s)
 	h.DefaultStream = streamPath
 	h.DefaultMinAge = minAge
 	h.DefaultMaxPastAge = maxPast
+	h.MaxFileAttempts = maxFileAttempts
+	h.QuarantineDir = quarantineDir
+	h.IngestWorkers = ingestWorkers
+	h.Config = streamConfig
+	h.Validator = validator
 
 	srv := &http.Server{
 		Addr:              addr,
@@ -60,24 +166,139 @@ func main() {
 	}
 
 	fmt.Fprintf(os.Stdout, "ai-json-api listening on %s using db %s\n", addr, dbPath)
-	fmt.Fprintf(os.Stdout, "stream=%s poll_seconds=%d min_file_age_seconds=%d max_past_seconds=%d\n", streamPath, pollSeconds, minFileAgeSecond, maxPastSeconds)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		fatalf("listen: %v", err)
+	fmt.Fprintf(os.Stdout, "stream=%s ingest_mode=%s poll_seconds=%d reconcile_seconds=%d min_file_age_seconds=%d max_past_seconds=%d\n", streamPath, ingestMode, pollSeconds, reconcileSeconds, minFileAgeSecond, maxPastSeconds)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-serveErr:
+		if err != nil && err != http.ErrServerClosed {
+			stop()
+			background.Wait()
+			_ = s.Close()
+			fatalf("listen: %v", err)
+		}
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stdout, "shutting down")
+	}
+	stop()
+
+	// Drain HTTP first so no request starts new work, then wait for
+	// backfills and background ingestion to finish their current file, and
+	// close the store last.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownSeconds)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "http shutdown: %v\n", err)
+	}
+	if err := h.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "backfill shutdown: %v\n", err)
+	}
+	ingestDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(ingestDone)
+	}()
+	select {
+	case <-ingestDone:
+	case <-shutdownCtx.Done():
+		fmt.Fprintln(os.Stderr, "background ingestion did not stop in time")
+	}
+	if err := s.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "close store: %v\n", err)
 	}
 }
 
-func runPeriodicIngestion(st *store.Store, streamPath string, interval time.Duration, minFileAge time.Duration, maxPast time.Duration) {
-	runner := ingest.Runner{Store: st, StreamPath: streamPath, MinFileAge: minFileAge, MaxPastAge: maxPast}
+func runPeriodicIngestion(ctx context.Context, runner *ingest.Runner, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
-		stats, err := runner.RunOnce()
+		stats, err := runner.RunOnceContext(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logIngestion("periodic", stats, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runPeriodicRetention rolls up and deletes expired events now and then
+// every interval.
+func runPeriodicRetention(ctx context.Context, s *store.Store, policy store.RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := s.ApplyRetentionContext(ctx, policy, time.Now(), false)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "periodic ingestion error: %v\n", err)
-		} else if stats.ProcessedFiles > 0 {
-			fmt.Fprintf(os.Stdout, "periodic ingestion processed=%d inserted=%d skipped=%d\n", stats.ProcessedFiles, stats.InsertedEvents, stats.SkippedFiles)
+			fmt.Fprintf(os.Stderr, "retention error: %v\n", err)
+		} else if res.Deleted > 0 {
+			fmt.Fprintf(os.Stdout, "retention deleted=%d event_types=%d\n", res.Deleted, len(res.EventTypes))
 		}
-		<-ticker.C
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runWatchIngestion ingests files on filesystem notifications. The watcher
+// retries failed directory watches itself; if it stops with an error (no
+// notification support on this platform, or anything else), ingestion falls
+// back to polling rather than stopping.
+func runWatchIngestion(ctx context.Context, runner *ingest.Runner, reconcile time.Duration, fallbackPoll time.Duration) {
+	w := ingest.Watcher{
+		Runner:            runner,
+		ReconcileInterval: reconcile,
+		OnRun:             func(stats ingest.RunStats, err error) { logIngestion("watch", stats, err) },
+	}
+	err := w.Run(ctx)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	if fallbackPoll <= 0 {
+		// --poll-seconds=0; poll at the reconcile interval instead.
+		fallbackPoll = max(w.ReconcileInterval, time.Second)
+	}
+	if errors.Is(err, fswatch.ErrUnsupported) {
+		fmt.Fprintf(os.Stderr, "watch ingestion unavailable (%v); falling back to polling\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "watch ingestion stopped (%v); falling back to polling\n", err)
+	}
+	runPeriodicIngestion(ctx, runner, fallbackPoll)
+}
+
+// watchStreamConfig reloads the stream config whenever the file changes and
+// logs every reload, including rejected edits.
+func watchStreamConfig(ctx context.Context, m *config.Manager, fallbackPoll time.Duration) {
+	err := m.Watch(ctx, fallbackPoll, func(err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stream config reload rejected, keeping version %d: %v\n", m.Version(), err)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "stream config reloaded version=%d\n", m.Version())
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stream config watch stopped: %v\n", err)
+	}
+}
+
+func logIngestion(mode string, stats ingest.RunStats, err error) {
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stdout, "%s ingestion stopped processed=%d inserted=%d\n", mode, stats.ProcessedFiles, stats.InsertedEvents)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s ingestion error: %v\n", mode, err)
+	} else if stats.ProcessedFiles > 0 || stats.FailedFiles > 0 {
+		fmt.Fprintf(os.Stdout, "%s ingestion processed=%d inserted=%d skipped=%d failed=%d quarantined=%d\n", mode, stats.ProcessedFiles, stats.InsertedEvents, stats.SkippedFiles, stats.FailedFiles, stats.QuarantinedFiles)
 	}
 }
 
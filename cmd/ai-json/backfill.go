@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"ai-json/internal/ingest"
+	"ai-json/internal/store"
+)
+
+// runBackfill implements `ai-json backfill`: load historical event files into
+// SQLite regardless of the live max-past window.
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	var (
+		dbPath           string
+		streamPath       string
+		classIDsFlag     string
+		cameraIDsFlag    string
+		fromEpoch        int64
+		toEpoch          int64
+		chunkSize        int
+		resumeID         int64
+		minFileAgeSecond int
+	)
+	fs.StringVar(&dbPath, "db", "./data/ai-json.db", "sqlite database path")
+	fs.StringVar(&streamPath, "stream", "stream.json", "stream config path")
+	fs.StringVar(&classIDsFlag, "class-ids", "", "comma-separated class IDs to backfill (default all)")
+	fs.StringVar(&cameraIDsFlag, "camera-ids", "", "comma-separated camera IDs to backfill (default all)")
+	fs.Int64Var(&fromEpoch, "from", 0, "first file epoch to include (unix seconds, 0 = unbounded)")
+	fs.Int64Var(&toEpoch, "to", 0, "last file epoch to include (unix seconds, 0 = unbounded)")
+	fs.IntVar(&chunkSize, "chunk-size", 100, "files per committed progress chunk")
+	fs.Int64Var(&resumeID, "resume", 0, "resume an interrupted backfill job by id")
+	fs.IntVar(&minFileAgeSecond, "min-file-age-seconds", 2, "minimum file age before ingesting JSON files")
+	_ = fs.Parse(args)
+
+	s, err := store.Open(dbPath)
+	if err != nil {
+		exitf("open store: %v", err)
+	}
+	defer s.Close()
+
+	runner := &ingest.Runner{Store: s, StreamPath: streamPath, MinFileAge: time.Duration(minFileAgeSecond) * time.Second}
+	jobID := resumeID
+	if jobID == 0 {
+		job, err := runner.StartBackfill(ingest.BackfillRequest{
+			ClassIDs:  splitList(classIDsFlag),
+			CameraIDs: splitList(cameraIDsFlag),
+			FromEpoch: fromEpoch,
+			ToEpoch:   toEpoch,
+			ChunkSize: chunkSize,
+		})
+		if err != nil {
+			exitf("start backfill: %v", err)
+		}
+		jobID = job.ID
+		fmt.Fprintf(os.Stderr, "backfill job %d started\n", jobID)
+	}
+
+	job, err := runner.RunBackfill(jobID, func(j store.BackfillJob) {
+		fmt.Fprintf(os.Stderr, "backfill job=%d status=%s files=%d/%d processed=%d inserted=%d skipped=%d\n", j.ID, j.Status, j.DoneFiles, j.TotalFiles, j.ProcessedFiles, j.InsertedEvents, j.SkippedFiles)
+	})
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(job)
+	if err != nil {
+		exitf("backfill failed (resume with --resume %d): %v", jobID, err)
+	}
+}
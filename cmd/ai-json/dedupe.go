@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"ai-json/internal/store"
+)
+
+// runDedupe implements `ai-json dedupe`: fingerprint events stored before
+// content-hash deduplication existed and remove the duplicates among them.
+func runDedupe(args []string) {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	var (
+		dbPath string
+		dryRun bool
+	)
+	fs.StringVar(&dbPath, "db", "./data/ai-json.db", "sqlite database path")
+	fs.BoolVar(&dryRun, "dry-run", false, "report duplicates without deleting them")
+	_ = fs.Parse(args)
+
+	s, err := store.Open(dbPath)
+	if err != nil {
+		exitf("open store: %v", err)
+	}
+	defer s.Close()
+
+	res, err := s.Dedupe(dryRun)
+	if err != nil {
+		exitf("dedupe failed: %v", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(res)
+}
@@ -14,6 +14,23 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill":
+			runBackfill(os.Args[2:])
+			return
+		case "dedupe":
+			runDedupe(os.Args[2:])
+			return
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "retention":
+			runRetention(os.Args[2:])
+			return
+		}
+	}
+
 	var (
 		inputPaths     multiFlag
 		globPatterns   multiFlag
@@ -24,6 +41,9 @@ func main() {
 		cameraIDsFlag  string
 		minConfidence  float64
 		maxIssues      int
+		trackGap       float64
+		schemaDir      string
+		rulesPath      string
 		strict         bool
 		help           bool
 	)
@@ -37,6 +57,9 @@ func main() {
 	flag.StringVar(&cameraIDsFlag, "camera-ids", "", "comma-separated camera IDs to include (uses stream_camera_id or camera_id)")
 	flag.Float64Var(&minConfidence, "min-confidence", 0, "minimum confidence threshold")
 	flag.IntVar(&maxIssues, "max-issues", 50, "max issues to print in text report (0 = all)")
+	flag.Float64Var(&trackGap, "track-gap", analyze.DefaultTrackGapSeconds, "seconds without events after which a track segment ends (tracks in --format json)")
+	flag.StringVar(&schemaDir, "schema-dir", "", "directory of <event_type>.v<N>.json schemas overriding or extending the built-in ones")
+	flag.StringVar(&rulesPath, "rules", "", "JSON rules file adding to or overriding the built-in validation rules")
 	flag.BoolVar(&strict, "strict", false, "exit with code 1 when validation errors are found")
 	flag.BoolVar(&help, "help", false, "show usage")
 	flag.Parse()
@@ -52,28 +75,49 @@ func main() {
 		}
 	}
 
+	allowedTypes := parseSet(eventTypesFlag)
+	allowedClasses := parseSet(classIDsFlag)
+	allowedCameras := parseSet(cameraIDsFlag)
+
+	// Events are analyzed as they are decoded, so input size is not bounded
+	// by memory. The text report only prints the first --max-issues issues,
+	// so only those are kept.
+	analyzer := analyze.NewAnalyzer()
+	analyzer.TrackGapSeconds = trackGap
+	if strings.ToLower(format) == "text" {
+		analyzer.MaxIssues = maxIssues
+	}
+	if schemaDir != "" || rulesPath != "" {
+		validator, err := analyze.LoadValidator(schemaDir, rulesPath)
+		if err != nil {
+			exitf("validation config error: %v", err)
+		}
+		analyzer.Validator = validator
+	}
+	add := func(ev model.Event) error {
+		if keepEvent(ev, allowedTypes, allowedClasses, allowedCameras, minConfidence) {
+			analyzer.Add(ev)
+		}
+		return nil
+	}
+
 	var (
 		ds  input.Dataset
 		err error
 	)
 	if streamPath != "" {
-		ds, err = input.LoadFromStreamConfig(streamPath)
+		ds, err = input.StreamFromStreamConfig(streamPath, add)
 	} else {
 		if len(inputPaths) == 0 && len(globPatterns) == 0 {
 			globPatterns = append(globPatterns, ".material/samples/*.json")
 		}
-		ds, err = input.Load(inputPaths, globPatterns)
+		ds, err = input.Stream(inputPaths, globPatterns, add)
 	}
 	if err != nil {
 		exitf("input error: %v", err)
 	}
 
-	allowedTypes := parseSet(eventTypesFlag)
-	allowedClasses := parseSet(classIDsFlag)
-	allowedCameras := parseSet(cameraIDsFlag)
-	filtered := filterEvents(ds.Events, allowedTypes, allowedClasses, allowedCameras, minConfidence)
-
-	res := analyze.Run(filtered)
+	res := analyzer.Result()
 
 	switch strings.ToLower(format) {
 	case "text":
@@ -98,6 +142,17 @@ func main() {
 	}
 }
 
+func splitList(csv string) []string {
+	out := make([]string, 0)
+	for _, p := range strings.Split(csv, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
 func parseSet(csv string) map[string]struct{} {
 	out := map[string]struct{}{}
 	for _, p := range strings.Split(csv, ",") {
@@ -110,45 +165,40 @@ func parseSet(csv string) map[string]struct{} {
 	return out
 }
 
-func filterEvents(events []model.Event, allowedTypes, allowedClasses, allowedCameras map[string]struct{}, minConfidence float64) []model.Event {
-	if len(allowedTypes) == 0 && len(allowedClasses) == 0 && len(allowedCameras) == 0 && minConfidence <= 0 {
-		return events
-	}
-	out := make([]model.Event, 0, len(events))
-	for _, ev := range events {
-		if len(allowedTypes) > 0 {
-			eventType := ev.EventTypeName()
-			if _, ok := allowedTypes[eventType]; !ok {
-				continue
-			}
+// keepEvent applies the --event-types, --class-ids, --camera-ids and
+// --min-confidence filters.
+func keepEvent(ev model.Event, allowedTypes, allowedClasses, allowedCameras map[string]struct{}, minConfidence float64) bool {
+	if len(allowedTypes) > 0 {
+		eventType := ev.EventTypeName()
+		if _, ok := allowedTypes[eventType]; !ok {
+			return false
+		}
+	}
+	if len(allowedClasses) > 0 {
+		classID, ok := ev.String("stream_class_id")
+		if !ok || classID == "" {
+			classID, _ = ev.String("room_id")
 		}
-		if len(allowedClasses) > 0 {
-			classID, ok := ev.String("stream_class_id")
-			if !ok || classID == "" {
-				classID, _ = ev.String("room_id")
-			}
-			if _, ok := allowedClasses[classID]; !ok {
-				continue
-			}
+		if _, ok := allowedClasses[classID]; !ok {
+			return false
 		}
-		if len(allowedCameras) > 0 {
-			cameraID, ok := ev.String("stream_camera_id")
-			if !ok || cameraID == "" {
-				cameraID, _ = ev.String("camera_id")
-			}
-			if _, ok := allowedCameras[cameraID]; !ok {
-				continue
-			}
+	}
+	if len(allowedCameras) > 0 {
+		cameraID, ok := ev.String("stream_camera_id")
+		if !ok || cameraID == "" {
+			cameraID, _ = ev.String("camera_id")
 		}
-		if minConfidence > 0 {
-			c, ok := ev.Float64("confidence")
-			if !ok || c < minConfidence {
-				continue
-			}
+		if _, ok := allowedCameras[cameraID]; !ok {
+			return false
 		}
-		out = append(out, ev)
 	}
-	return out
+	if minConfidence > 0 {
+		c, ok := ev.Float64("confidence")
+		if !ok || c < minConfidence {
+			return false
+		}
+	}
+	return true
 }
 
 func printUsage() {
@@ -156,12 +206,19 @@ func printUsage() {
 	fmt.Fprintln(os.Stdout)
 	fmt.Fprintln(os.Stdout, "Usage:")
 	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json [flags]")
+	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json backfill [backfill flags]")
+	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json dedupe --db ./data/ai-json.db [--dry-run]")
+	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json migrate --db ./data/ai-json.db [--dry-run]")
+	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json retention --db ./data/ai-json.db [--retention-file retention.json] [--retention-ttl person_tracked=7d] [--dry-run]")
 	fmt.Fprintln(os.Stdout)
 	fmt.Fprintln(os.Stdout, "Examples:")
 	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json --stream stream.json")
 	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json --stream stream.json --format json")
 	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json --stream stream.json --class-ids class-a --camera-ids front --event-types person_tracked,role_assigned --min-confidence 0.6")
 	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json --glob '.material/samples/*.json' --format text")
+	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json --stream stream.json --format json --track-gap 10")
+	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json --glob './incoming/*.json' --schema-dir ./schemas --rules ./rules.json --strict")
+	fmt.Fprintln(os.Stdout, "  go run ./cmd/ai-json backfill --stream stream.json --db ./data/ai-json.db --class-ids class-a --from 1771200000 --to 1771286399")
 	fmt.Fprintln(os.Stdout)
 	fmt.Fprintln(os.Stdout, "Flags:")
 	flag.PrintDefaults()
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"ai-json/internal/store"
+)
+
+// runMigrate implements `ai-json migrate`: bring a database to the schema
+// version of this binary, or with --dry-run print the SQL that would run.
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var (
+		dbPath string
+		dryRun bool
+	)
+	fs.StringVar(&dbPath, "db", "./data/ai-json.db", "sqlite database path")
+	fs.BoolVar(&dryRun, "dry-run", false, "print the pending migrations' SQL without applying it")
+	_ = fs.Parse(args)
+
+	s, err := store.OpenWithoutMigrating(dbPath)
+	if err != nil {
+		exitf("open store: %v", err)
+	}
+	defer s.Close()
+
+	from, err := s.SchemaVersion()
+	if err != nil {
+		exitf("migrate failed: %v", err)
+	}
+	applied, err := s.Migrate(dryRun)
+	if err != nil {
+		exitf("migrate failed: %v", err)
+	}
+
+	if dryRun {
+		fmt.Printf("-- schema version %d, %d pending migration(s) to version %d\n", from, len(applied), store.LatestSchemaVersion())
+		for _, m := range applied {
+			fmt.Printf("\n-- migration %d: %s\n", m.Version, m.Name)
+			for _, stmt := range m.Statements {
+				fmt.Printf("%s;\n", stmt)
+			}
+		}
+		return
+	}
+	for i := range applied {
+		applied[i].Statements = nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]any{
+		"from_version": from,
+		"to_version":   store.LatestSchemaVersion(),
+		"applied":      applied,
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"ai-json/internal/store"
+)
+
+// runRetention implements `ai-json retention`: roll up and delete the raw
+// events older than their event type's TTL.
+func runRetention(args []string) {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	var (
+		dbPath     string
+		policyPath string
+		ttls       string
+		dryRun     bool
+	)
+	fs.StringVar(&dbPath, "db", "./data/ai-json.db", "sqlite database path")
+	fs.StringVar(&policyPath, "retention-file", "", "JSON retention policy file")
+	fs.StringVar(&ttls, "retention-ttl", "", "comma-separated event_type=ttl pairs applied over the file (e.g. person_tracked=7d,default=90d,fingerprints=180d)")
+	fs.BoolVar(&dryRun, "dry-run", false, "report what would be rolled up and deleted without changing the database")
+	_ = fs.Parse(args)
+
+	policy, err := store.RetentionPolicyFromFlags(policyPath, ttls)
+	if err != nil {
+		exitf("retention policy: %v", err)
+	}
+	if policy.Empty() {
+		exitf("retention policy keeps every event; set --retention-file or --retention-ttl")
+	}
+
+	s, err := store.Open(dbPath)
+	if err != nil {
+		exitf("open store: %v", err)
+	}
+	defer s.Close()
+
+	res, err := s.ApplyRetention(policy, time.Now(), dryRun)
+	if err != nil {
+		exitf("retention failed: %v", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(res)
+}
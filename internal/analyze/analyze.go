@@ -1,10 +1,13 @@
 package analyze
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"math/rand/v2"
 	"sort"
 	"strings"
+	"sync"
 
 	"ai-json/internal/model"
 )
@@ -22,6 +25,9 @@ type ValidationIssue struct {
 	Message    string   `json:"message"`
 	EventIndex int      `json:"event_index"`
 	EventType  string   `json:"event_type"`
+	// Path is a JSON pointer to the offending value ("/track_ids/1"), empty
+	// when the issue concerns the event as a whole.
+	Path string `json:"path,omitempty"`
 }
 
 type StatSummary struct {
@@ -63,224 +69,530 @@ type Analysis struct {
 	TimestampOffset      StatSummary       `json:"timestamp_offset_seconds"`
 	StabilizerSkew       StatSummary       `json:"timestamp_stabilizer_skew_seconds"`
 	Issues               []ValidationIssue `json:"issues"`
-	ErrorCount           int               `json:"error_count"`
-	WarningCount         int               `json:"warning_count"`
+	// TemporalIssues are the ordering, duplicate and lifecycle issues found
+	// across events (see temporal.go), sorted by event index.
+	TemporalIssues     []ValidationIssue `json:"temporal_issues"`
+	TemporalIssueCount int               `json:"temporal_issue_count"`
+	// ErrorCount and WarningCount cover Issues and TemporalIssues.
+	ErrorCount   int `json:"error_count"`
+	WarningCount int `json:"warning_count"`
+	// Tracks summarizes when each track was visible (see tracks.go).
+	Tracks []TrackSummary `json:"tracks"`
+}
+
+// Analyzer accumulates an Analysis one event at a time, so events can be
+// streamed from disk instead of collected in a slice first. Its memory grows
+// with the number of distinct IDs (persons, tracks, cameras, proximity
+// pairs) and with the issues and track segments it reports, not with the
+// number of events:
+//
+//   - the percentile summaries keep a uniform sample of at most MaxSamples
+//     values per field, so P50 and P95 are exact up to that many values and
+//     estimated beyond; count, min, max and avg are always exact
+//   - the temporal lifecycle checks and the track analytics hold at most
+//     OrderWindow track events before processing them in timestamp order,
+//     carrying each track's state over to the next window, so events that
+//     arrive more than a window out of order are taken as they come
+//   - the duplicate-in-frame check remembers the last OrderWindow frame
+//     subjects
+type Analyzer struct {
+	// MaxIssues caps the issues kept for the result, separately for Issues
+	// and TemporalIssues (0 keeps all); ErrorCount and WarningCount still
+	// count every issue.
+	MaxIssues int
+	// Validator checks each event; nil uses DefaultValidator.
+	Validator *Validator
+	// TrackGapSeconds is the track segment gap threshold; 0 uses
+	// DefaultTrackGapSeconds. Set it before the first Add.
+	TrackGapSeconds float64
+	// MaxSamples is the sample size of each percentile summary; 0 uses
+	// DefaultMaxSamples. Set it before the first Add.
+	MaxSamples int
+	// OrderWindow is the number of track events held for timestamp
+	// ordering; 0 uses DefaultOrderWindow. Set it before the first Add.
+	OrderWindow int
+
+	total              int
+	typeCounts         map[string]int
+	streamClassCounts  map[string]int
+	streamCameraCounts map[string]int
+	roomCounts         map[string]int
+	cameraCounts       map[string]int
+	roleCounts         map[string]int
+	orientationCounts  map[string]int
+	personEventCounts  map[string]int
+	proximityCounts    map[string]int
+	uniquePerson       map[string]struct{}
+	uniqueGlobal       map[int64]struct{}
+	uniqueTrack        map[int64]struct{}
+	issues             []ValidationIssue
+	temporal           *temporalState
+	temporalIssues     []ValidationIssue
+	temporalCount      int
+	tracks             *TrackBuilder
+	errCount           int
+	warnCount          int
+
+	rng      *rand.Rand
+	conf     sampler
+	frameAge sampler
+	delay    sampler
+	offset   sampler
+	skew     sampler
+}
+
+// DefaultMaxSamples is the percentile sample size when MaxSamples is not
+// set.
+const DefaultMaxSamples = 10000
+
+// DefaultOrderWindow is the number of track events held for timestamp
+// ordering when OrderWindow is not set.
+const DefaultOrderWindow = 100000
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{
+		typeCounts:         map[string]int{},
+		streamClassCounts:  map[string]int{},
+		streamCameraCounts: map[string]int{},
+		roomCounts:         map[string]int{},
+		cameraCounts:       map[string]int{},
+		roleCounts:         map[string]int{},
+		orientationCounts:  map[string]int{},
+		personEventCounts:  map[string]int{},
+		proximityCounts:    map[string]int{},
+		uniquePerson:       map[string]struct{}{},
+		uniqueGlobal:       map[int64]struct{}{},
+		uniqueTrack:        map[int64]struct{}{},
+		issues:             make([]ValidationIssue, 0),
+	}
 }
 
 func Run(events []model.Event) Analysis {
-	typeCounts := map[string]int{}
-	streamClassCounts := map[string]int{}
-	streamCameraCounts := map[string]int{}
-	roomCounts := map[string]int{}
-	cameraCounts := map[string]int{}
-	roleCounts := map[string]int{}
-	orientationCounts := map[string]int{}
-	personEventCounts := map[string]int{}
-	proximityCounts := map[string]int{}
-	uniquePerson := map[string]struct{}{}
-	uniqueGlobal := map[int64]struct{}{}
-	uniqueTrack := map[int64]struct{}{}
-	issues := make([]ValidationIssue, 0)
+	a := NewAnalyzer()
+	for _, ev := range events {
+		a.Add(ev)
+	}
+	return a.Result()
+}
 
-	conf := make([]float64, 0, len(events))
-	frameAge := make([]float64, 0, len(events))
-	delay := make([]float64, 0, len(events))
-	offset := make([]float64, 0, len(events))
-	skew := make([]float64, 0, len(events))
+// RunStream analyzes the events produced by stream without holding them in
+// memory. An error from stream is returned with no analysis.
+func RunStream(stream model.EventStream) (Analysis, error) {
+	a := NewAnalyzer()
+	err := stream(func(ev model.Event) error {
+		a.Add(ev)
+		return nil
+	})
+	if err != nil {
+		return Analysis{}, err
+	}
+	return a.Result(), nil
+}
 
-	for i, ev := range events {
-		common, commonProblems := ev.ParseCommonFields()
+// Add accounts for the next event; its EventIndex is the number of events
+// added before it.
+func (a *Analyzer) Add(ev model.Event) {
+	if a.total == 0 {
+		a.start()
+	}
+	i := a.total
+	a.total++
 
-		eventType := common.EventType
-		if eventType == "" {
-			eventType = "unknown"
-		}
-		typeCounts[eventType]++
+	common, commonProblems := ev.ParseCommonFields()
 
-		if common.RoomID != "" {
-			roomCounts[common.RoomID]++
-		}
-		if common.CameraID != "" {
-			cameraCounts[common.CameraID]++
-		}
-		if streamClassID, ok := ev.String("stream_class_id"); ok && streamClassID != "" {
-			streamClassCounts[streamClassID]++
-		}
-		if streamCameraID, ok := ev.String("stream_camera_id"); ok && streamCameraID != "" {
-			streamCameraCounts[streamCameraID]++
-		}
+	eventType := common.EventType
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	a.typeCounts[eventType]++
 
-		for _, p := range commonProblems {
-			issues = append(issues, ValidationIssue{
-				Severity:   SeverityError,
-				Code:       "missing_common_field",
-				Message:    p,
-				EventIndex: i,
-				EventType:  eventType,
-			})
-		}
+	if common.RoomID != "" {
+		a.roomCounts[common.RoomID]++
+	}
+	if common.CameraID != "" {
+		a.cameraCounts[common.CameraID]++
+	}
+	if streamClassID, ok := ev.String("stream_class_id"); ok && streamClassID != "" {
+		a.streamClassCounts[streamClassID]++
+	}
+	if streamCameraID, ok := ev.String("stream_camera_id"); ok && streamCameraID != "" {
+		a.streamCameraCounts[streamCameraID]++
+	}
+
+	if common.PersonID != nil && *common.PersonID != "" {
+		a.uniquePerson[*common.PersonID] = struct{}{}
+		a.personEventCounts[*common.PersonID]++
+	}
+	if common.GlobalPersonID != nil {
+		a.uniqueGlobal[*common.GlobalPersonID] = struct{}{}
+	}
+	if common.TrackID != nil {
+		a.uniqueTrack[*common.TrackID] = struct{}{}
+	}
+
+	if c, ok := ev.Float64("confidence"); ok {
+		a.conf.add(c, a.rng)
+	}
+	if v, ok := ev.Float64("frame_age_seconds"); ok {
+		a.frameAge.add(v, a.rng)
+	}
+	if v, ok := ev.Float64("frame_transport_delay_seconds"); ok {
+		a.delay.add(v, a.rng)
+	}
+	if v, ok := ev.Float64("timestamp_offset_seconds"); ok {
+		a.offset.add(v, a.rng)
+	}
+	if v, ok := ev.Float64("timestamp_stabilizer_skew_seconds"); ok {
+		a.skew.add(v, a.rng)
+	}
+
+	if role, ok := ev.String("role"); ok && role != "" {
+		a.roleCounts[role]++
+	}
+	if orientation, ok := ev.String("orientation"); ok && orientation != "" {
+		a.orientationCounts[orientation]++
+	}
 
-		if common.Confidence < 0 || common.Confidence > 1 {
-			issues = append(issues, ValidationIssue{Severity: SeverityWarn, Code: "confidence_out_of_range", Message: fmt.Sprintf("confidence %.3f out of [0,1]", common.Confidence), EventIndex: i, EventType: eventType})
+	if common.TrackID != nil && IsTrackEvent(eventType) {
+		if ts, ok := ev.Float64("timestamp"); ok {
+			a.tracks.Add(TrackPoint{ClassID: classID(ev, common), CameraID: cameraID(ev, common), TrackID: *common.TrackID, EventType: eventType, Timestamp: ts})
 		}
+	}
 
-		if common.PersonID != nil && *common.PersonID != "" {
-			uniquePerson[*common.PersonID] = struct{}{}
-			personEventCounts[*common.PersonID]++
+	if eventType == "proximity_event" {
+		pairKeys := proximityPairKeys(ev)
+		for _, k := range pairKeys {
+			a.proximityCounts[k]++
 		}
-		if common.GlobalPersonID != nil {
-			uniqueGlobal[*common.GlobalPersonID] = struct{}{}
+	}
+
+	validator := a.Validator
+	if validator == nil {
+		validator = DefaultValidator()
+	}
+	for _, issue := range validator.validate(ev, i, common, commonProblems) {
+		a.count(issue)
+		if a.MaxIssues <= 0 || len(a.issues) < a.MaxIssues {
+			a.issues = append(a.issues, issue)
 		}
-		if common.TrackID != nil {
-			uniqueTrack[*common.TrackID] = struct{}{}
+	}
+	for _, issue := range a.temporal.add(ev, i, common, eventType) {
+		a.count(issue)
+		a.temporalCount++
+		// These arrive in event order, so the first MaxIssues are the only
+		// ones that can make the cut once lifecycle issues are merged in.
+		if a.MaxIssues <= 0 || len(a.temporalIssues) < a.MaxIssues {
+			a.temporalIssues = append(a.temporalIssues, issue)
 		}
+	}
+}
 
-		if c, ok := ev.Float64("confidence"); ok {
-			conf = append(conf, c)
-		}
-		if v, ok := ev.Float64("frame_age_seconds"); ok {
-			frameAge = append(frameAge, v)
-			if v < 0 {
-				issues = append(issues, ValidationIssue{Severity: SeverityError, Code: "negative_frame_age", Message: "frame_age_seconds must be >= 0", EventIndex: i, EventType: eventType})
-			}
+// start applies the options before the first event.
+func (a *Analyzer) start() {
+	samples := a.MaxSamples
+	if samples <= 0 {
+		samples = DefaultMaxSamples
+	}
+	window := a.OrderWindow
+	if window <= 0 {
+		window = DefaultOrderWindow
+	}
+	// A fixed seed keeps the estimates of a given input reproducible.
+	a.rng = rand.New(rand.NewPCG(1, 2))
+	for _, s := range []*sampler{&a.conf, &a.frameAge, &a.delay, &a.offset, &a.skew} {
+		s.size = samples
+	}
+	a.temporal = newTemporalState(window, a.MaxIssues)
+	a.tracks = NewTrackBuilder(a.TrackGapSeconds)
+	a.tracks.Window = window
+}
+
+func (a *Analyzer) count(issue ValidationIssue) {
+	switch issue.Severity {
+	case SeverityError:
+		a.errCount++
+	case SeverityWarn:
+		a.warnCount++
+	}
+}
+
+// Result returns the analysis of the events added so far.
+func (a *Analyzer) Result() Analysis {
+	errCount, warnCount := a.errCount, a.warnCount
+	var lifecycle []ValidationIssue
+	lifecycleCount := 0
+	if a.temporal != nil {
+		var errs, warns int
+		lifecycle, errs, warns = a.temporal.lifecycleIssues()
+		errCount += errs
+		warnCount += warns
+		lifecycleCount = errs + warns
+	}
+	temporal := append(append(make([]ValidationIssue, 0, len(a.temporalIssues)+len(lifecycle)), a.temporalIssues...), lifecycle...)
+	sort.SliceStable(temporal, func(i, j int) bool { return temporal[i].EventIndex < temporal[j].EventIndex })
+	temporalCount := a.temporalCount + lifecycleCount
+	if a.MaxIssues > 0 && len(temporal) > a.MaxIssues {
+		temporal = temporal[:a.MaxIssues]
+	}
+	tracks := make([]TrackSummary, 0)
+	if a.tracks != nil {
+		tracks = a.tracks.Result()
+	}
+
+	return Analysis{
+		TotalEvents:          a.total,
+		EventTypeCounts:      toKeyCounts(a.typeCounts),
+		StreamClassCounts:    toKeyCounts(a.streamClassCounts),
+		StreamCameraCounts:   toKeyCounts(a.streamCameraCounts),
+		RoomCounts:           toKeyCounts(a.roomCounts),
+		CameraCounts:         toKeyCounts(a.cameraCounts),
+		RoleCounts:           toKeyCounts(a.roleCounts),
+		OrientationCounts:    toKeyCounts(a.orientationCounts),
+		TopPersonEventCounts: limitKeyCounts(toKeyCounts(a.personEventCounts), 10),
+		TopProximityPairs:    limitPairCounts(toPairCounts(a.proximityCounts), 10),
+		UniquePersonIDs:      len(a.uniquePerson),
+		UniqueGlobalIDs:      len(a.uniqueGlobal),
+		UniqueTrackIDs:       len(a.uniqueTrack),
+		Confidence:           a.conf.summary(),
+		FrameAgeSeconds:      a.frameAge.summary(),
+		TransportDelay:       a.delay.summary(),
+		TimestampOffset:      a.offset.summary(),
+		StabilizerSkew:       a.skew.summary(),
+		Issues:               a.issues,
+		TemporalIssues:       temporal,
+		TemporalIssueCount:   temporalCount,
+		ErrorCount:           errCount,
+		WarningCount:         warnCount,
+		Tracks:               tracks,
+	}
+}
+
+// Validator produces the validation issues of single events: the required
+// common fields, the rules, the shape and typed checks, then the JSON Schema
+// registered for the event's type and "schema_version" (latest when absent).
+// A schema violation at or below a path an earlier check already reported is
+// dropped, so a problem is reported once, under its stable code. It is safe
+// for concurrent use.
+type Validator struct {
+	Schemas *SchemaRegistry
+	Rules   *RuleSet
+}
+
+// NewValidator returns a validator using schemas and rules; nil disables
+// schema validation or the rules respectively.
+func NewValidator(schemas *SchemaRegistry, rules *RuleSet) *Validator {
+	return &Validator{Schemas: schemas, Rules: rules}
+}
+
+var (
+	defaultValidatorOnce sync.Once
+	builtinValidator     *Validator
+)
+
+// DefaultValidator returns the shared validator using the built-in schemas
+// and rules.
+func DefaultValidator() *Validator {
+	defaultValidatorOnce.Do(func() { builtinValidator = NewValidator(DefaultSchemas(), BuiltinRules()) })
+	return builtinValidator
+}
+
+// LoadValidator returns a validator using the built-in schemas overlaid with
+// schemaDir and the built-in rules merged with the rules file at rulesPath;
+// empty arguments keep the built-ins.
+func LoadValidator(schemaDir, rulesPath string) (*Validator, error) {
+	schemas := DefaultSchemas()
+	rules := BuiltinRules()
+	var err error
+	if schemaDir != "" {
+		if schemas, err = LoadSchemaDir(schemaDir); err != nil {
+			return nil, err
 		}
-		if v, ok := ev.Float64("frame_transport_delay_seconds"); ok {
-			delay = append(delay, v)
-			if v < -0.10 {
-				issues = append(issues, ValidationIssue{Severity: SeverityError, Code: "negative_transport_delay", Message: "frame_transport_delay_seconds is strongly negative (< -0.10s)", EventIndex: i, EventType: eventType})
-			} else if v < 0 {
-				issues = append(issues, ValidationIssue{Severity: SeverityWarn, Code: "negative_transport_delay", Message: "frame_transport_delay_seconds slightly negative (possible clock jitter)", EventIndex: i, EventType: eventType})
-			}
+	}
+	if rulesPath != "" {
+		if rules, err = LoadRules(rulesPath); err != nil {
+			return nil, err
 		}
-		if v, ok := ev.Float64("timestamp_offset_seconds"); ok {
-			offset = append(offset, v)
+	}
+	return NewValidator(schemas, rules), nil
+}
+
+// Validate returns the issues of ev, reported as the idx-th event.
+func (v *Validator) Validate(ev model.Event, idx int) []ValidationIssue {
+	common, problems := ev.ParseCommonFields()
+	return v.validate(ev, idx, common, problems)
+}
+
+func (v *Validator) validate(ev model.Event, idx int, common model.CommonFields, commonProblems []string) []ValidationIssue {
+	issues := make([]ValidationIssue, 0)
+	eventType := common.EventType
+	if eventType == "" {
+		eventType = "unknown"
+	}
+
+	for _, p := range commonProblems {
+		issues = append(issues, ValidationIssue{
+			Severity:   SeverityError,
+			Code:       "missing_common_field",
+			Message:    p,
+			EventIndex: idx,
+			EventType:  eventType,
+			Path:       commonProblemPath(p),
+		})
+	}
+
+	v.Rules.evaluate(ev, eventType, common.Pipeline, idx, &issues)
+	validateShape(ev, eventType, idx, &issues)
+	validateEventSpecific(ev, eventType, idx, &issues)
+	if v.Schemas != nil && common.EventType != "" {
+		v.validateSchema(ev, eventType, idx, &issues)
+	}
+	return issues
+}
+
+func (v *Validator) validateSchema(ev model.Event, eventType string, idx int, issues *[]ValidationIssue) {
+	add := func(severity Severity, code, message, path string) {
+		*issues = append(*issues, ValidationIssue{Severity: severity, Code: code, Message: message, EventIndex: idx, EventType: eventType, Path: path})
+	}
+
+	version := 0
+	if raw, ok := ev.Raw["schema_version"]; ok {
+		n, ok := model.AsInt64(raw)
+		if !ok || n < 1 {
+			add(SeverityError, "invalid_schema_version", "schema_version must be a positive integer", "/schema_version")
+			return
 		}
-		if v, ok := ev.Float64("timestamp_stabilizer_skew_seconds"); ok {
-			skew = append(skew, v)
+		version = int(n)
+	}
+	schema, version, ok := v.Schemas.Lookup(eventType, version)
+	if !ok {
+		if version > 0 {
+			add(SeverityWarn, "unknown_schema_version", fmt.Sprintf("no schema for %s version %d", eventType, version), "/schema_version")
 		}
+		return
+	}
 
-		if role, ok := ev.String("role"); ok && role != "" {
-			roleCounts[role]++
+	reported := make([]string, 0, len(*issues))
+	for _, issue := range *issues {
+		if issue.Path != "" {
+			reported = append(reported, issue.Path)
 		}
-		if orientation, ok := ev.String("orientation"); ok && orientation != "" {
-			orientationCounts[orientation]++
+	}
+	for _, e := range v.Schemas.Validate(schema, ev.Raw) {
+		if coveredPath(e.Path, reported) {
+			continue
 		}
+		add(SeverityError, "schema_"+snakeCase(e.Keyword), e.Message, e.Path)
+	}
+}
 
-		validateShape(ev, eventType, i, &issues)
-		validateEventSpecific(ev, eventType, i, &issues)
-
-		if eventType == "proximity_event" {
-			pairKeys := proximityPairKeys(ev)
-			for _, k := range pairKeys {
-				proximityCounts[k]++
-			}
+// coveredPath reports whether path equals or lies below one of reported.
+func coveredPath(path string, reported []string) bool {
+	for _, p := range reported {
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
 		}
 	}
+	return false
+}
 
-	errCount := 0
-	warnCount := 0
-	for _, issue := range issues {
-		switch issue.Severity {
-		case SeverityError:
-			errCount++
-		case SeverityWarn:
-			warnCount++
-		}
+// commonProblemPath maps a ParseCommonFields problem such as
+// "invalid or missing confidence" to the pointer of its field.
+func commonProblemPath(problem string) string {
+	field := problem[strings.LastIndex(problem, " ")+1:]
+	if field == "event_type/type" {
+		field = "event_type"
 	}
+	return "/" + field
+}
 
-	return Analysis{
-		TotalEvents:          len(events),
-		EventTypeCounts:      toKeyCounts(typeCounts),
-		StreamClassCounts:    toKeyCounts(streamClassCounts),
-		StreamCameraCounts:   toKeyCounts(streamCameraCounts),
-		RoomCounts:           toKeyCounts(roomCounts),
-		CameraCounts:         toKeyCounts(cameraCounts),
-		RoleCounts:           toKeyCounts(roleCounts),
-		OrientationCounts:    toKeyCounts(orientationCounts),
-		TopPersonEventCounts: limitKeyCounts(toKeyCounts(personEventCounts), 10),
-		TopProximityPairs:    limitPairCounts(toPairCounts(proximityCounts), 10),
-		UniquePersonIDs:      len(uniquePerson),
-		UniqueGlobalIDs:      len(uniqueGlobal),
-		UniqueTrackIDs:       len(uniqueTrack),
-		Confidence:           summarize(conf),
-		FrameAgeSeconds:      summarize(frameAge),
-		TransportDelay:       summarize(delay),
-		TimestampOffset:      summarize(offset),
-		StabilizerSkew:       summarize(skew),
-		Issues:               issues,
-		ErrorCount:           errCount,
-		WarningCount:         warnCount,
+// snakeCase turns a schema keyword such as "minItems" or "$ref" into the
+// issue code suffix "min_items" or "ref".
+func snakeCase(keyword string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimPrefix(keyword, "$") {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
 	}
+	return b.String()
 }
 
 func validateShape(ev model.Event, eventType string, idx int, issues *[]ValidationIssue) {
 	if bbox, ok := ev.Raw["bbox"]; ok {
 		arr, ok := bbox.([]any)
 		if !ok || len(arr) != 4 {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_bbox", Message: "bbox must contain 4 numbers", EventIndex: idx, EventType: eventType})
+			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_bbox", Message: "bbox must contain 4 numbers", EventIndex: idx, EventType: eventType, Path: "/bbox"})
 			return
 		}
 		vals := make([]float64, 0, 4)
 		for _, item := range arr {
-			n, ok := item.(float64)
+			n, ok := model.AsFloat64(item)
 			if !ok {
-				*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_bbox", Message: "bbox values must be numeric", EventIndex: idx, EventType: eventType})
+				*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_bbox", Message: "bbox values must be numeric", EventIndex: idx, EventType: eventType, Path: "/bbox"})
 				return
 			}
 			vals = append(vals, n)
 		}
 		if vals[0] >= vals[2] || vals[1] >= vals[3] {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_bbox_order", Message: "bbox requires x1<x2 and y1<y2", EventIndex: idx, EventType: eventType})
+			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_bbox_order", Message: "bbox requires x1<x2 and y1<y2", EventIndex: idx, EventType: eventType, Path: "/bbox"})
 		}
 	}
 }
 
 func validateEventSpecific(ev model.Event, eventType string, idx int, issues *[]ValidationIssue) {
-	personEvents := map[string]bool{
-		"person_tracked":           true,
-		"person_detected":          true,
-		"person_lost":              true,
-		"head_orientation_changed": true,
-		"posture_changed":          true,
-		"sleeping_suspected":       true,
-		"role_assigned":            true,
-	}
-
-	if personEvents[eventType] {
-		if _, ok := ev.NullableInt64("track_id"); !ok {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "missing_track_id", Message: "person event missing track_id", EventIndex: idx, EventType: eventType})
+	typed, err := ev.Typed()
+	var decodeErr *model.DecodeError
+	if err != nil && !errors.As(err, &decodeErr) {
+		// Event types outside the catalog have no specific checks.
+		return
+	}
+	add := func(severity Severity, code, message, path string) {
+		*issues = append(*issues, ValidationIssue{Severity: severity, Code: code, Message: message, EventIndex: idx, EventType: eventType, Path: path})
+	}
+
+	if _, ok := typed.(model.PersonEvent); ok {
+		if decodeErr.Has("track_id") {
+			add(SeverityError, "missing_track_id", "person event missing track_id", "/track_id")
 		}
-		if _, ok := ev.NullableString("person_id"); !ok {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityWarn, Code: "invalid_person_id", Message: "person_id has invalid type", EventIndex: idx, EventType: eventType})
+		if decodeErr.Has("person_id") {
+			add(SeverityWarn, "invalid_person_id", "person_id has invalid type", "/person_id")
 		}
 	}
 
-	if eventType == "proximity_event" {
-		tIDs, ok1 := ev.Int64Slice("track_ids")
-		gIDs, ok2 := ev.Int64Slice("global_ids")
-		pIDs, ok3 := ev.StringSlice("person_ids")
-		if !ok1 || !ok2 || !ok3 || len(tIDs) == 0 {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_proximity_ids", Message: "proximity_event requires non-empty track_ids/global_ids/person_ids", EventIndex: idx, EventType: eventType})
+	switch t := typed.(type) {
+	case *model.ProximityEvent:
+		if t.TrackIDs == nil || t.GlobalIDs == nil || t.PersonIDs == nil || len(t.TrackIDs) == 0 {
+			add(SeverityError, "invalid_proximity_ids", "proximity_event requires non-empty track_ids/global_ids/person_ids", "/track_ids")
 		}
-		if ok1 && ok2 && len(tIDs) != len(gIDs) {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "proximity_id_length_mismatch", Message: "track_ids and global_ids length mismatch", EventIndex: idx, EventType: eventType})
+		if t.TrackIDs != nil && t.GlobalIDs != nil && len(t.TrackIDs) != len(t.GlobalIDs) {
+			add(SeverityError, "proximity_id_length_mismatch", "track_ids and global_ids length mismatch", "/global_ids")
 		}
-		if ok1 && ok3 && len(tIDs) != len(pIDs) {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "proximity_person_length_mismatch", Message: "track_ids and person_ids length mismatch", EventIndex: idx, EventType: eventType})
+		if t.TrackIDs != nil && t.PersonIDs != nil && len(t.TrackIDs) != len(t.PersonIDs) {
+			add(SeverityError, "proximity_person_length_mismatch", "track_ids and person_ids length mismatch", "/person_ids")
 		}
-		if d, ok := ev.Float64("distance"); !ok || d < 0 {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_distance", Message: "distance must be >= 0", EventIndex: idx, EventType: eventType})
-		}
-		if d, ok := ev.Float64("duration_seconds"); !ok || d < 0 {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_duration", Message: "duration_seconds must be >= 0", EventIndex: idx, EventType: eventType})
+	case *model.FrameTick:
+		if t.DetectionsCount == nil || *t.DetectionsCount < 0 {
+			add(SeverityError, "invalid_detections_count", "detections_count must be a non-negative integer", "/detections_count")
 		}
 	}
+}
 
-	if eventType == "frame_tick" {
-		if v, ok := ev.Float64("detections_count"); !ok || v < 0 || math.Mod(v, 1) != 0 {
-			*issues = append(*issues, ValidationIssue{Severity: SeverityError, Code: "invalid_detections_count", Message: "detections_count must be a non-negative integer", EventIndex: idx, EventType: eventType})
-		}
+// classID is the event's stream_class_id, else its room_id.
+func classID(ev model.Event, common model.CommonFields) string {
+	if id, ok := ev.String("stream_class_id"); ok && id != "" {
+		return id
+	}
+	return common.RoomID
+}
+
+// cameraID is the event's stream_camera_id, else its camera_id.
+func cameraID(ev model.Event, common model.CommonFields) string {
+	if id, ok := ev.String("stream_camera_id"); ok && id != "" {
+		return id
 	}
+	return common.CameraID
 }
 
 func proximityPairKeys(ev model.Event) []string {
@@ -302,21 +614,46 @@ func proximityPairKeys(ev model.Event) []string {
 	return pairs
 }
 
-func summarize(values []float64) StatSummary {
-	if len(values) == 0 {
+// sampler summarizes a stream of values. Count, min, max and sum are exact;
+// the percentiles come from a uniform reservoir sample of at most size
+// values, so they are exact until more values than that were added.
+type sampler struct {
+	size     int
+	n        int
+	min, max float64
+	sum      float64
+	values   []float64
+}
+
+func (s *sampler) add(v float64, rng *rand.Rand) {
+	if s.n == 0 || v < s.min {
+		s.min = v
+	}
+	if s.n == 0 || v > s.max {
+		s.max = v
+	}
+	s.n++
+	s.sum += v
+	if len(s.values) < s.size {
+		s.values = append(s.values, v)
+		return
+	}
+	if j := rng.IntN(s.n); j < len(s.values) {
+		s.values[j] = v
+	}
+}
+
+func (s *sampler) summary() StatSummary {
+	if s.n == 0 {
 		return StatSummary{}
 	}
-	sorted := append([]float64(nil), values...)
+	sorted := append([]float64(nil), s.values...)
 	sort.Float64s(sorted)
-	var sum float64
-	for _, v := range sorted {
-		sum += v
-	}
 	return StatSummary{
-		Count: len(sorted),
-		Min:   sorted[0],
-		Max:   sorted[len(sorted)-1],
-		Avg:   sum / float64(len(sorted)),
+		Count: s.n,
+		Min:   s.min,
+		Max:   s.max,
+		Avg:   s.sum / float64(s.n),
 		P50:   quantile(sorted, 0.50),
 		P95:   quantile(sorted, 0.95),
 	}
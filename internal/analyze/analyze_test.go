@@ -1,7 +1,12 @@
 package analyze
 
 import (
+	"bytes"
+	"fmt"
+	"math"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"ai-json/internal/model"
@@ -149,3 +154,102 @@ func TestRunStreamClassCameraCounts(t *testing.T) {
 		t.Fatalf("unexpected camera counts: %+v", res.StreamCameraCounts)
 	}
 }
+
+func TestRunStreamMatchesRun(t *testing.T) {
+	b, err := os.ReadFile("../../.material/samples/1771233054.json")
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	events, err := model.ParseEvents(b)
+	if err != nil {
+		t.Fatalf("parse sample: %v", err)
+	}
+	want := Run(events)
+	got, err := RunStream(func(yield func(model.Event) error) error {
+		return model.DecodeEvents(bytes.NewReader(b), yield)
+	})
+	if err != nil {
+		t.Fatalf("run stream: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("streamed analysis differs from Run")
+	}
+
+	a := NewAnalyzer()
+	a.MaxIssues = 1
+	for _, ev := range events {
+		a.Add(ev)
+	}
+	capped := a.Result()
+	perEvent := want.ErrorCount + want.WarningCount - want.TemporalIssueCount
+	if len(capped.Issues) != min(1, perEvent) || len(capped.TemporalIssues) != min(1, want.TemporalIssueCount) || capped.TemporalIssueCount != want.TemporalIssueCount || capped.ErrorCount != want.ErrorCount || capped.WarningCount != want.WarningCount {
+		t.Fatalf("expected at most 1 kept issue per list with full counts, got %d+%d issues, %d/%d", len(capped.Issues), len(capped.TemporalIssues), capped.ErrorCount, capped.WarningCount)
+	}
+}
+
+func TestAnalyzerBoundedState(t *testing.T) {
+	b, err := os.ReadFile("../../.material/samples/1771233054.json")
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	events, err := model.ParseEvents(b)
+	if err != nil {
+		t.Fatalf("parse sample: %v", err)
+	}
+	want := Run(events)
+
+	// A window smaller than the input cuts the sample's in-order tracks the
+	// same way, and a small sample keeps the exact statistics exact.
+	a := NewAnalyzer()
+	a.OrderWindow = 3
+	a.MaxSamples = 5
+	for _, ev := range events {
+		a.Add(ev)
+	}
+	got := a.Result()
+	if !reflect.DeepEqual(got.Tracks, want.Tracks) {
+		t.Fatalf("windowed tracks differ:\n got %+v\nwant %+v", got.Tracks, want.Tracks)
+	}
+	if !reflect.DeepEqual(got.TemporalIssues, want.TemporalIssues) || got.TemporalIssueCount != want.TemporalIssueCount {
+		t.Fatalf("windowed temporal issues differ:\n got %+v\nwant %+v", got.TemporalIssues, want.TemporalIssues)
+	}
+	c, w := got.Confidence, want.Confidence
+	if c.Count != w.Count || c.Min != w.Min || c.Max != w.Max || math.Abs(c.Avg-w.Avg) > 1e-9 {
+		t.Fatalf("sampled confidence count/min/max/avg differ: got %+v want %+v", c, w)
+	}
+	if c.P50 < c.Min || c.P50 > c.Max || c.P95 < c.Min || c.P95 > c.Max {
+		t.Fatalf("sampled percentiles out of range: %+v", c)
+	}
+	if len(a.conf.values) != 5 {
+		t.Fatalf("expected 5 kept samples, got %d", len(a.conf.values))
+	}
+}
+
+func TestAnalyzerOrderWindowCarriesLifecycle(t *testing.T) {
+	ev := func(eventType string, ts float64) string {
+		return fmt.Sprintf(`{"event_type":%q,"room_id":"r1","camera_id":"c1","pipeline":"p1","confidence":0.5,"track_id":1,"person_id":"p1","timestamp":%g,"frame_timestamp":%g,"frame_source_timestamp":%g,"emitted_at":%g}`, eventType, ts, ts, ts, ts)
+	}
+	// The detection arrives after the loss (a timestamp regression); both
+	// are in the first window. The tracked event after the loss falls in
+	// the second one.
+	events, err := model.ParseEvents([]byte(strings.Join([]string{
+		ev("person_lost", 3),
+		ev("person_detected", 1),
+		ev("person_tracked", 4),
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	a := NewAnalyzer()
+	a.OrderWindow = 2
+	for _, e := range events {
+		a.Add(e)
+	}
+	res := a.Result()
+	if res.TemporalIssueCount != 2 || res.TemporalIssues[0].Code != "track_timestamp_regression" || res.TemporalIssues[1].Code != "track_event_after_lost" || res.TemporalIssues[1].EventIndex != 2 {
+		t.Fatalf("expected a regression and track_event_after_lost at event 2, got %+v", res.TemporalIssues)
+	}
+	if len(res.Tracks) != 1 || len(res.Tracks[0].Cameras[0].Segments) != 2 || res.Tracks[0].LostCount != 1 {
+		t.Fatalf("expected a lost segment then a new one, got %+v", res.Tracks)
+	}
+}
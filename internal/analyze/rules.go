@@ -0,0 +1,307 @@
+package analyze
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ai-json/internal/model"
+)
+
+// Rules are declarative numeric checks. A rule selects events by type and
+// pipeline, reads one field by JSON pointer and asserts a comparison; when
+// the assertion fails it reports an issue with the rule's code and severity.
+// The threshold checks the analyzer ships with are rules themselves
+// (builtin_rules.json), so a rules file can retune or disable them by id.
+
+//go:embed builtin_rules.json
+var builtinRulesJSON []byte
+
+// Rule is one entry of a rules file.
+type Rule struct {
+	// ID names the rule; a rule in a rules file replaces the built-in rule
+	// with the same ID.
+	ID       string   `json:"id"`
+	Code     string   `json:"code"`
+	Severity Severity `json:"severity"`
+	// EventTypes and Pipelines select the events the rule applies to;
+	// empty selects all.
+	EventTypes []string `json:"event_types,omitempty"`
+	Pipelines  []string `json:"pipelines,omitempty"`
+	// Field is a JSON pointer into the event, e.g. "/frame_age_seconds".
+	Field string `json:"field"`
+	// Op is the assertion: gt, gte, lt, lte, eq, ne (against Value) or
+	// between (Min <= value <= Max).
+	Op    string   `json:"op"`
+	Value *float64 `json:"value,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	// Required makes a missing or non-numeric field an issue too; otherwise
+	// such events are skipped.
+	Required bool `json:"required,omitempty"`
+	// Message is the issue message; "{value}" is replaced by the field's
+	// value. Empty derives one from the assertion.
+	Message string `json:"message,omitempty"`
+	// Disabled turns a built-in rule of the same ID off.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// RulesFile is the JSON document read by LoadRules.
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// RuleSet is an ordered list of enabled, validated rules.
+type RuleSet struct {
+	rules []Rule
+}
+
+var builtinRuleSet = mustBuiltinRules()
+
+func mustBuiltinRules() *RuleSet {
+	rules, err := ParseRules(builtinRulesJSON)
+	if err != nil {
+		panic("analyze: built-in rules: " + err.Error())
+	}
+	rs, err := NewRuleSet(rules)
+	if err != nil {
+		panic("analyze: built-in rules: " + err.Error())
+	}
+	return rs
+}
+
+// BuiltinRules returns the rules the analyzer applies by default.
+func BuiltinRules() *RuleSet {
+	return builtinRuleSet
+}
+
+// ParseRules decodes a rules file. Unknown keys are rejected so a typo does
+// not silently disable a check.
+func ParseRules(data []byte) ([]Rule, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var f RulesFile
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("decode rules: %w", err)
+	}
+	return f.Rules, nil
+}
+
+// LoadRules reads the rules file at path and merges it over the built-in
+// rules: a rule whose ID matches a built-in one replaces it in place (or
+// removes it when disabled) and other rules are appended in file order.
+func LoadRules(path string) (*RuleSet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	custom, err := ParseRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	rs, err := builtinRuleSet.Merge(custom)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rs, nil
+}
+
+// NewRuleSet validates rules and returns them as a set; disabled rules are
+// dropped.
+func NewRuleSet(rules []Rule) (*RuleSet, error) {
+	return (&RuleSet{}).Merge(rules)
+}
+
+// Merge returns a new set with rules applied over rs as described for
+// LoadRules.
+func (rs *RuleSet) Merge(rules []Rule) (*RuleSet, error) {
+	out := append([]Rule(nil), rs.rules...)
+	seen := map[string]bool{}
+	for i, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, r.ID, err)
+		}
+		if seen[r.ID] {
+			return nil, fmt.Errorf("rule %d: duplicate id %q", i, r.ID)
+		}
+		seen[r.ID] = true
+
+		replaced := false
+		for j := range out {
+			if out[j].ID == r.ID {
+				out[j], replaced = r, true
+				break
+			}
+		}
+		if !replaced {
+			out = append(out, r)
+		}
+	}
+	enabled := out[:0]
+	for _, r := range out {
+		if !r.Disabled {
+			enabled = append(enabled, r)
+		}
+	}
+	return &RuleSet{rules: enabled}, nil
+}
+
+// Rules returns the rules of the set in evaluation order.
+func (rs *RuleSet) Rules() []Rule {
+	return append([]Rule(nil), rs.rules...)
+}
+
+func (r Rule) validate() error {
+	if r.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if r.Disabled {
+		// Only the ID matters for turning a rule off.
+		return nil
+	}
+	if r.Code == "" {
+		return fmt.Errorf("code is required")
+	}
+	if r.Severity != SeverityError && r.Severity != SeverityWarn {
+		return fmt.Errorf("severity must be %q or %q", SeverityError, SeverityWarn)
+	}
+	if !strings.HasPrefix(r.Field, "/") {
+		return fmt.Errorf("field must be a JSON pointer such as /confidence")
+	}
+	switch r.Op {
+	case "gt", "gte", "lt", "lte", "eq", "ne":
+		if r.Value == nil {
+			return fmt.Errorf("op %s requires value", r.Op)
+		}
+	case "between":
+		if r.Min == nil || r.Max == nil || *r.Min > *r.Max {
+			return fmt.Errorf("op between requires min <= max")
+		}
+	default:
+		return fmt.Errorf("unknown op %q", r.Op)
+	}
+	return nil
+}
+
+func (r Rule) selects(eventType, pipeline string) bool {
+	return (len(r.EventTypes) == 0 || slices.Contains(r.EventTypes, eventType)) &&
+		(len(r.Pipelines) == 0 || slices.Contains(r.Pipelines, pipeline))
+}
+
+// holds reports whether v satisfies the rule's assertion.
+func (r Rule) holds(v float64) bool {
+	switch r.Op {
+	case "gt":
+		return v > *r.Value
+	case "gte":
+		return v >= *r.Value
+	case "lt":
+		return v < *r.Value
+	case "lte":
+		return v <= *r.Value
+	case "eq":
+		return v == *r.Value
+	case "ne":
+		return v != *r.Value
+	case "between":
+		return v >= *r.Min && v <= *r.Max
+	}
+	return false
+}
+
+func (r Rule) message(v float64, present bool) string {
+	if r.Message != "" {
+		value := "null"
+		if present {
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return strings.ReplaceAll(r.Message, "{value}", value)
+	}
+	name := strings.TrimPrefix(r.Field, "/")
+	switch r.Op {
+	case "between":
+		return fmt.Sprintf("%s must be within [%s,%s]", name, formatNumber(*r.Min), formatNumber(*r.Max))
+	case "gt":
+		return fmt.Sprintf("%s must be > %s", name, formatNumber(*r.Value))
+	case "gte":
+		return fmt.Sprintf("%s must be >= %s", name, formatNumber(*r.Value))
+	case "lt":
+		return fmt.Sprintf("%s must be < %s", name, formatNumber(*r.Value))
+	case "lte":
+		return fmt.Sprintf("%s must be <= %s", name, formatNumber(*r.Value))
+	case "eq":
+		return fmt.Sprintf("%s must be %s", name, formatNumber(*r.Value))
+	}
+	return fmt.Sprintf("%s must not be %s", name, formatNumber(*r.Value))
+}
+
+// evaluate appends the issues of ev. When several rules with the same code
+// fail on the same field, only the first of the most severe is reported, so
+// e.g. a strongly negative delay is an error and not also a warning.
+func (rs *RuleSet) evaluate(ev model.Event, eventType, pipeline string, idx int, issues *[]ValidationIssue) {
+	if rs == nil {
+		return
+	}
+	start := len(*issues)
+	for _, r := range rs.rules {
+		if !r.selects(eventType, pipeline) {
+			continue
+		}
+		raw, present := lookupPointer(ev.Raw, r.Field)
+		v, numeric := model.AsFloat64(raw)
+		if !present || !numeric {
+			if r.Required {
+				*issues = append(*issues, ValidationIssue{Severity: r.Severity, Code: r.Code, Message: r.message(0, false), EventIndex: idx, EventType: eventType, Path: r.Field})
+			}
+			continue
+		}
+		if r.holds(v) {
+			continue
+		}
+		issue := ValidationIssue{Severity: r.Severity, Code: r.Code, Message: r.message(v, true), EventIndex: idx, EventType: eventType, Path: r.Field}
+		replaced := false
+		for i := start; i < len(*issues); i++ {
+			prev := &(*issues)[i]
+			if prev.Code == issue.Code && prev.Path == issue.Path {
+				if prev.Severity == SeverityWarn && issue.Severity == SeverityError {
+					*prev = issue
+				}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			*issues = append(*issues, issue)
+		}
+	}
+}
+
+// lookupPointer resolves a JSON pointer against a decoded event.
+func lookupPointer(obj map[string]any, pointer string) (any, bool) {
+	var cur any = obj
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = unescapePointer(token)
+		switch node := cur.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -0,0 +1,97 @@
+package analyze
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinRulesReportMostSevere(t *testing.T) {
+	v := NewValidator(nil, BuiltinRules())
+	for _, tc := range []struct {
+		delay    string
+		severity Severity
+	}{
+		{"-0.05", SeverityWarn},
+		{"-0.25", SeverityError},
+		{"0.01", ""},
+	} {
+		ev := mustEvent(t, `{"event_type":"frame_tick",`+strings.Replace(schemaTestCommon, `"frame_transport_delay_seconds":0`, `"frame_transport_delay_seconds":`+tc.delay, 1)+`,"detections_count":1}`)
+		issues := v.Validate(ev, 0)
+		if tc.severity == "" {
+			if len(issues) != 0 {
+				t.Fatalf("delay %s: expected no issues, got %+v", tc.delay, issues)
+			}
+			continue
+		}
+		if len(issues) != 1 || issues[0].Code != "negative_transport_delay" || issues[0].Severity != tc.severity || issues[0].Path != "/frame_transport_delay_seconds" {
+			t.Fatalf("delay %s: expected one %s, got %+v", tc.delay, tc.severity, issues)
+		}
+	}
+
+	ev := mustEvent(t, `{"event_type":"proximity_event",`+strings.Replace(schemaTestCommon, `"confidence":0.5`, `"confidence":1.5`, 1)+`,"track_ids":[1],"global_ids":[1],"person_ids":["a"],"duration_seconds":"x"}`)
+	issues := v.Validate(ev, 0)
+	if !issueAt(issues, "confidence_out_of_range", "/confidence") || !issueAt(issues, "invalid_distance", "/distance") || !issueAt(issues, "invalid_duration", "/duration_seconds") {
+		t.Fatalf("expected confidence, distance and duration issues, got %+v", issues)
+	}
+	if issues[0].Message != "confidence 1.5 out of [0,1]" || issues[0].Severity != SeverityWarn {
+		t.Fatalf("unexpected confidence issue: %+v", issues[0])
+	}
+}
+
+func TestLoadRulesOverridesBuiltins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{"rules": [
+	  {"id": "transport_delay_strongly_negative", "code": "negative_transport_delay", "severity": "error",
+	   "pipelines": ["p1"], "field": "/frame_transport_delay_seconds", "op": "gte", "value": -0.5},
+	  {"id": "transport_delay_jitter", "disabled": true},
+	  {"id": "inference_window", "code": "summary_score_low", "severity": "warn",
+	   "event_types": ["teacher_engagement"], "field": "/summary/scores/0", "op": "between", "min": 0.2, "max": 1}
+	]}`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := LoadRules(path)
+	if err != nil {
+		t.Fatalf("load rules: %v", err)
+	}
+	if got, want := len(rs.Rules()), len(BuiltinRules().Rules()); got != want {
+		t.Fatalf("expected %d rules (one replaced, one disabled, one added), got %d", want, got)
+	}
+	v := NewValidator(nil, rs)
+
+	withDelay := func(pipeline, delay string) string {
+		c := strings.Replace(schemaTestCommon, `"frame_transport_delay_seconds":0`, `"frame_transport_delay_seconds":`+delay, 1)
+		return strings.Replace(c, `"pipeline":"p1"`, `"pipeline":"`+pipeline+`"`, 1)
+	}
+	if issues := v.Validate(mustEvent(t, `{"event_type":"frame_tick",`+withDelay("p1", "-0.3")+`,"detections_count":1}`), 0); len(issues) != 0 {
+		t.Fatalf("p1 tolerates -0.3 now, got %+v", issues)
+	}
+	if issues := v.Validate(mustEvent(t, `{"event_type":"frame_tick",`+withDelay("p2", "-0.9")+`,"detections_count":1}`), 0); len(issues) != 0 {
+		t.Fatalf("the rule is scoped to p1, got %+v", issues)
+	}
+	issues := v.Validate(mustEvent(t, `{"event_type":"frame_tick",`+withDelay("p1", "-0.9")+`,"detections_count":1}`), 0)
+	if len(issues) != 1 || issues[0].Message != "frame_transport_delay_seconds must be >= -0.5" {
+		t.Fatalf("expected derived message, got %+v", issues)
+	}
+
+	issues = v.Validate(mustEvent(t, `{"type":"teacher_engagement",`+schemaTestCommon+`,"summary":{"scores":[0.1]}}`), 3)
+	if len(issues) != 1 || issues[0].Code != "summary_score_low" || issues[0].Path != "/summary/scores/0" || issues[0].EventIndex != 3 {
+		t.Fatalf("expected nested rule issue, got %+v", issues)
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"id": "x", "code": "c", "severity": "fatal", "field": "/a", "op": "gt", "value": 1}]}`,
+		`{"rules": [{"id": "x", "code": "c", "severity": "warn", "field": "a", "op": "gt", "value": 1}]}`,
+		`{"rules": [{"id": "x", "code": "c", "severity": "warn", "field": "/a", "op": "between", "min": 2, "max": 1}]}`,
+		`{"rules": [{"id": "x", "code": "c", "severity": "warn", "field": "/a", "op": "gt", "treshold": 1}]}`,
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRules(path); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
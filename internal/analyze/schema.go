@@ -0,0 +1,659 @@
+package analyze
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ai-json/internal/model"
+)
+
+// Event schemas are JSON Schema documents named <event_type>.v<version>.json.
+// Other documents (such as common.v1.json) are only reachable through $ref.
+//
+// The validator implements the subset of JSON Schema (2020-12) the catalog
+// needs: type, enum, const, required, properties, additionalProperties,
+// items, minItems, maxItems, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minLength, maxLength, pattern, allOf, anyOf, oneOf, not
+// and $ref, either to another document by name ("common.v1.json") or to a
+// local definition ("#/$defs/bbox"). Annotations ($schema, $id, $comment,
+// title, description, default, examples, deprecated, readOnly, writeOnly)
+// are allowed; any other keyword fails to compile rather than being
+// silently skipped. As in JSON Schema, numeric strings are not numbers.
+
+//go:embed schemas/*.json
+var builtinSchemaFS embed.FS
+
+var schemaFileRe = regexp.MustCompile(`^(.+)\.v([1-9][0-9]*)\.json$`)
+
+// Schema is a compiled JSON Schema document or subschema.
+type Schema struct {
+	types            []string
+	enum             []any
+	constValue       any
+	hasConst         bool
+	required         []string
+	properties       map[string]*Schema
+	additional       *Schema
+	noAdditional     bool
+	items            *Schema
+	minItems         *int
+	maxItems         *int
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	minLength        *int
+	maxLength        *int
+	pattern          *regexp.Regexp
+	allOf            []*Schema
+	anyOf            []*Schema
+	oneOf            []*Schema
+	not              *Schema
+	ref              string
+	// root is the document the schema belongs to, for "#/$defs/..." refs.
+	root *Schema
+	defs map[string]*Schema
+}
+
+// SchemaError is one schema violation. Path is a JSON pointer into the event
+// ("" is the event itself) and Keyword the failing schema keyword.
+type SchemaError struct {
+	Path    string `json:"path"`
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+// CompileSchema parses a JSON Schema document.
+func CompileSchema(data []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode schema: %w", err)
+	}
+	return compileNode(doc, nil, "")
+}
+
+func compileNode(v any, root *Schema, at string) (*Schema, error) {
+	if b, ok := v.(bool); ok {
+		// true accepts everything, false nothing.
+		s := &Schema{root: root}
+		if !b {
+			s.not = &Schema{root: root}
+		}
+		if root == nil {
+			s.root = s
+		}
+		return s, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("schema%s: expected object or boolean", at)
+	}
+	s := &Schema{root: root}
+	if root == nil {
+		s.root = s
+	}
+	sub := func(key string, val any) (*Schema, error) {
+		return compileNode(val, s.root, at+"/"+key)
+	}
+	subList := func(key string, val any) ([]*Schema, error) {
+		arr, ok := val.([]any)
+		if !ok || len(arr) == 0 {
+			return nil, fmt.Errorf("schema%s/%s: expected non-empty array", at, key)
+		}
+		out := make([]*Schema, 0, len(arr))
+		for i, item := range arr {
+			c, err := sub(key+"/"+strconv.Itoa(i), item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, c)
+		}
+		return out, nil
+	}
+	number := func(key string, val any) (*float64, error) {
+		f, ok := model.AsFloat64(val)
+		if _, isString := val.(string); !ok || isString {
+			return nil, fmt.Errorf("schema%s/%s: expected number", at, key)
+		}
+		return &f, nil
+	}
+	count := func(key string, val any) (*int, error) {
+		n, ok := model.AsInt64(val)
+		if _, isString := val.(string); !ok || isString || n < 0 {
+			return nil, fmt.Errorf("schema%s/%s: expected non-negative integer", at, key)
+		}
+		i := int(n)
+		return &i, nil
+	}
+
+	var err error
+	for key, val := range obj {
+		switch key {
+		case "type":
+			switch t := val.(type) {
+			case string:
+				s.types = []string{t}
+			case []any:
+				for _, item := range t {
+					name, ok := item.(string)
+					if !ok {
+						return nil, fmt.Errorf("schema%s/type: expected string", at)
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, fmt.Errorf("schema%s/type: expected string or array", at)
+			}
+			for _, name := range s.types {
+				switch name {
+				case "object", "array", "string", "number", "integer", "boolean", "null":
+				default:
+					return nil, fmt.Errorf("schema%s/type: unknown type %q", at, name)
+				}
+			}
+		case "enum":
+			arr, ok := val.([]any)
+			if !ok {
+				return nil, fmt.Errorf("schema%s/enum: expected array", at)
+			}
+			s.enum = arr
+		case "const":
+			s.constValue, s.hasConst = val, true
+		case "required":
+			arr, ok := val.([]any)
+			if !ok {
+				return nil, fmt.Errorf("schema%s/required: expected array", at)
+			}
+			for _, item := range arr {
+				name, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("schema%s/required: expected strings", at)
+				}
+				s.required = append(s.required, name)
+			}
+		case "properties", "$defs":
+			props, ok := val.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("schema%s/%s: expected object", at, key)
+			}
+			m := make(map[string]*Schema, len(props))
+			for name, p := range props {
+				if m[name], err = sub(key+"/"+escapePointer(name), p); err != nil {
+					return nil, err
+				}
+			}
+			if key == "properties" {
+				s.properties = m
+			} else {
+				s.defs = m
+			}
+		case "additionalProperties":
+			if b, ok := val.(bool); ok {
+				s.noAdditional = !b
+				continue
+			}
+			if s.additional, err = sub(key, val); err != nil {
+				return nil, err
+			}
+		case "items":
+			if s.items, err = sub(key, val); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			s.minItems, err = count(key, val)
+		case "maxItems":
+			s.maxItems, err = count(key, val)
+		case "minLength":
+			s.minLength, err = count(key, val)
+		case "maxLength":
+			s.maxLength, err = count(key, val)
+		case "minimum":
+			s.minimum, err = number(key, val)
+		case "maximum":
+			s.maximum, err = number(key, val)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = number(key, val)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = number(key, val)
+		case "pattern":
+			p, ok := val.(string)
+			if !ok {
+				return nil, fmt.Errorf("schema%s/pattern: expected string", at)
+			}
+			if s.pattern, err = regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("schema%s/pattern: %w", at, err)
+			}
+		case "allOf":
+			s.allOf, err = subList(key, val)
+		case "anyOf":
+			s.anyOf, err = subList(key, val)
+		case "oneOf":
+			s.oneOf, err = subList(key, val)
+		case "not":
+			s.not, err = sub(key, val)
+		case "$ref":
+			ref, ok := val.(string)
+			if !ok || ref == "" {
+				return nil, fmt.Errorf("schema%s/$ref: expected string", at)
+			}
+			if strings.HasPrefix(ref, "#") && !strings.HasPrefix(ref, "#/$defs/") {
+				return nil, fmt.Errorf("schema%s/$ref: only #/$defs/<name> local references are supported", at)
+			}
+			s.ref = ref
+		case "$schema", "$id", "$comment", "title", "description", "default", "examples", "deprecated", "readOnly", "writeOnly":
+		default:
+			return nil, fmt.Errorf("schema%s: unsupported keyword %q", at, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// SchemaRegistry holds the schema documents by file name. It is safe for
+// concurrent use.
+type SchemaRegistry struct {
+	mu     sync.RWMutex
+	docs   map[string]*Schema
+	latest map[string]int
+}
+
+// NewSchemaRegistry returns an empty registry.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{docs: map[string]*Schema{}, latest: map[string]int{}}
+}
+
+var (
+	builtinOnce    sync.Once
+	builtinSchemas *SchemaRegistry
+)
+
+// DefaultSchemas returns a new registry holding the built-in catalog schemas
+// (internal/analyze/schemas). Registering into it does not affect other
+// registries.
+func DefaultSchemas() *SchemaRegistry {
+	builtinOnce.Do(func() {
+		builtinSchemas = NewSchemaRegistry()
+		if err := builtinSchemas.loadFS(builtinSchemaFS, "schemas"); err != nil {
+			panic("analyze: built-in schemas: " + err.Error())
+		}
+	})
+	r := NewSchemaRegistry()
+	builtinSchemas.mu.RLock()
+	defer builtinSchemas.mu.RUnlock()
+	for name, s := range builtinSchemas.docs {
+		r.docs[name] = s
+	}
+	for name, v := range builtinSchemas.latest {
+		r.latest[name] = v
+	}
+	return r
+}
+
+// LoadSchemaDir returns the built-in schemas overlaid with every *.json
+// document in dir; a file named like a built-in one replaces it.
+func LoadSchemaDir(dir string) (*SchemaRegistry, error) {
+	r := DefaultSchemas()
+	if err := r.loadFS(os.DirFS(dir), "."); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *SchemaRegistry) loadFS(fsys fs.FS, dir string) error {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("read schema %s: %w", name, err)
+		}
+		if err := r.Register(path.Base(name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register compiles data and stores it under name, replacing any document
+// of that name. Names of the form <event_type>.v<version>.json make the
+// document the schema of that event type and version.
+func (r *SchemaRegistry) Register(name string, data []byte) error {
+	s, err := CompileSchema(data)
+	if err != nil {
+		return fmt.Errorf("schema %s: %w", name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.docs[name] = s
+	if m := schemaFileRe.FindStringSubmatch(name); m != nil {
+		v, _ := strconv.Atoi(m[2])
+		if v > r.latest[m[1]] {
+			r.latest[m[1]] = v
+		}
+	}
+	return nil
+}
+
+// Lookup returns the schema of eventType at version, or at its latest
+// version when version is 0.
+func (r *SchemaRegistry) Lookup(eventType string, version int) (*Schema, int, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if version <= 0 {
+		version = r.latest[eventType]
+		if version == 0 {
+			return nil, 0, false
+		}
+	}
+	s, ok := r.docs[eventType+".v"+strconv.Itoa(version)+".json"]
+	return s, version, ok
+}
+
+// Validate checks v against s, resolving document references through r.
+func (r *SchemaRegistry) Validate(s *Schema, v any) []SchemaError {
+	var out []SchemaError
+	r.validate(s, v, "", &out, 0)
+	return out
+}
+
+// maxRefDepth bounds $ref chains, which could otherwise loop.
+const maxRefDepth = 32
+
+func (r *SchemaRegistry) validate(s *Schema, v any, ptr string, out *[]SchemaError, depth int) {
+	fail := func(keyword, format string, args ...any) {
+		*out = append(*out, SchemaError{Path: ptr, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.ref != "" {
+		target, err := r.resolve(s)
+		switch {
+		case err != nil:
+			fail("$ref", "%v", err)
+		case depth >= maxRefDepth:
+			fail("$ref", "$ref %q nested too deeply", s.ref)
+		default:
+			r.validate(target, v, ptr, out, depth+1)
+		}
+	}
+
+	if len(s.types) > 0 && !matchesAnyType(v, s.types) {
+		fail("type", "must be %s, got %s", strings.Join(s.types, " or "), jsonType(v))
+		// The remaining keywords assume the right type.
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, want := range s.enum {
+			if jsonEqual(v, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "must be one of %s", compactJSON(s.enum))
+		}
+	}
+	if s.hasConst && !jsonEqual(v, s.constValue) {
+		fail("const", "must be %s", compactJSON(s.constValue))
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := val[name]; !ok {
+				*out = append(*out, SchemaError{Path: ptr + "/" + escapePointer(name), Keyword: "required", Message: "required property missing"})
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := ptr + "/" + escapePointer(k)
+			if p, ok := s.properties[k]; ok {
+				r.validate(p, val[k], child, out, depth)
+				continue
+			}
+			if s.noAdditional {
+				*out = append(*out, SchemaError{Path: child, Keyword: "additionalProperties", Message: "property not allowed"})
+			} else if s.additional != nil {
+				r.validate(s.additional, val[k], child, out, depth)
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(val) < *s.minItems {
+			fail("minItems", "must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(val) > *s.maxItems {
+			fail("maxItems", "must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range val {
+				r.validate(s.items, item, ptr+"/"+strconv.Itoa(i), out, depth)
+			}
+		}
+	case string:
+		length := len([]rune(val))
+		if s.minLength != nil && length < *s.minLength {
+			fail("minLength", "must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("maxLength", "must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			fail("pattern", "must match %q", s.pattern.String())
+		}
+	case json.Number, float64:
+		n, _ := model.AsFloat64(val)
+		r.checkNumber(s, n, fail)
+	}
+
+	for _, sub := range s.allOf {
+		r.validate(sub, v, ptr, out, depth)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if len(r.Validate(sub, v)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("anyOf", "must match at least one of %d schemas", len(s.anyOf))
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if len(r.Validate(sub, v)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("oneOf", "must match exactly one of %d schemas, matched %d", len(s.oneOf), matched)
+		}
+	}
+	if s.not != nil && len(r.Validate(s.not, v)) == 0 {
+		fail("not", "must not match the schema")
+	}
+}
+
+func (r *SchemaRegistry) checkNumber(s *Schema, n float64, fail func(keyword, format string, args ...any)) {
+	if s.minimum != nil && n < *s.minimum {
+		fail("minimum", "must be >= %s", formatNumber(*s.minimum))
+	}
+	if s.maximum != nil && n > *s.maximum {
+		fail("maximum", "must be <= %s", formatNumber(*s.maximum))
+	}
+	if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+		fail("exclusiveMinimum", "must be > %s", formatNumber(*s.exclusiveMinimum))
+	}
+	if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+		fail("exclusiveMaximum", "must be < %s", formatNumber(*s.exclusiveMaximum))
+	}
+}
+
+// resolve returns the target of s.ref: a local "#/$defs/<name>" definition
+// or a registered document, optionally followed by such a fragment.
+func (r *SchemaRegistry) resolve(s *Schema) (*Schema, error) {
+	doc, frag, _ := strings.Cut(s.ref, "#")
+	root := s.root
+	if doc != "" {
+		r.mu.RLock()
+		target, ok := r.docs[doc]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unresolved $ref %q", s.ref)
+		}
+		root = target
+	}
+	if frag == "" {
+		return root, nil
+	}
+	name, ok := strings.CutPrefix(frag, "/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", s.ref)
+	}
+	target, ok := root.defs[unescapePointer(name)]
+	if !ok {
+		return nil, fmt.Errorf("unresolved $ref %q", s.ref)
+	}
+	return target, nil
+}
+
+func matchesAnyType(v any, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "null":
+			if v == nil {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "object":
+			if _, ok := v.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			switch v.(type) {
+			case json.Number, float64:
+				return true
+			}
+		case "integer":
+			if isInteger(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isInteger reports whether v is a JSON number without a fractional part;
+// 1.0 is an integer, "1" is not.
+func isInteger(v any) bool {
+	switch n := v.(type) {
+	case json.Number:
+		if _, err := n.Int64(); err == nil {
+			return true
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case float64:
+		return n == math.Trunc(n) && !math.IsInf(n, 0)
+	}
+	return false
+}
+
+func jsonType(v any) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := n.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// jsonEqual compares two decoded JSON values, numbers by value.
+func jsonEqual(a, b any) bool {
+	x, aNum := jsonNumber(a)
+	y, bNum := jsonNumber(b)
+	if aNum || bNum {
+		return aNum && bNum && x == y
+	}
+	return compactJSON(a) == compactJSON(b)
+}
+
+func jsonNumber(v any) (float64, bool) {
+	switch v.(type) {
+	case json.Number, float64:
+		return model.AsFloat64(v)
+	}
+	return 0, false
+}
+
+func compactJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// escapePointer escapes a key for use as a JSON pointer token (RFC 6901).
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func unescapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
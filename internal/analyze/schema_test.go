@@ -0,0 +1,149 @@
+package analyze
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ai-json/internal/model"
+)
+
+const schemaTestCommon = `"room_id":"r1","camera_id":"c1","pipeline":"p1","confidence":0.5,
+	"timestamp":1,"frame_timestamp":1,"frame_source_timestamp":1,"emitted_at":1,
+	"timestamp_offset_seconds":0,"timestamp_stabilizer_skew_seconds":0,
+	"frame_age_seconds":0,"frame_transport_delay_seconds":0`
+
+func mustEvent(t *testing.T, s string) model.Event {
+	t.Helper()
+	events, err := model.ParseEvents([]byte(s))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("parse %s: %v", s, err)
+	}
+	return events[0]
+}
+
+func issueAt(issues []ValidationIssue, code, path string) bool {
+	for _, it := range issues {
+		if it.Code == code && it.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuiltinSchemasCoverCatalog(t *testing.T) {
+	schemas := DefaultSchemas()
+	for _, name := range model.EventTypes() {
+		if _, _, ok := schemas.Lookup(name, 0); !ok {
+			t.Errorf("no schema for %s", name)
+		}
+	}
+}
+
+func TestValidateSchemaReportsPointers(t *testing.T) {
+	ev := mustEvent(t, `{"type":"cheating_suspicion",`+schemaTestCommon+`,
+		"score":1.5,"track_ids":[3,"x"],"severity":2,"summary":{"note":"ok"}}`)
+	issues := DefaultValidator().Validate(ev, 4)
+
+	for _, want := range []struct{ code, path string }{
+		{"schema_maximum", "/score"},
+		{"schema_type", "/track_ids/1"},
+		{"schema_type", "/severity"},
+	} {
+		if !issueAt(issues, want.code, want.path) {
+			t.Fatalf("missing %s at %s: %+v", want.code, want.path, issues)
+		}
+	}
+	if len(issues) != 3 {
+		t.Fatalf("expected 3 issues, got %+v", issues)
+	}
+	if issues[0].EventIndex != 4 || issues[0].EventType != "cheating_suspicion" || issues[0].Severity != SeverityError {
+		t.Fatalf("unexpected issue fields: %+v", issues[0])
+	}
+
+	// Numeric strings are not numbers, and 3.0 is an integer.
+	quoted := mustEvent(t, `{"type":"cheating_suspicion",`+schemaTestCommon+`,"score":"0.5","track_ids":["3",3.0]}`)
+	issues = DefaultValidator().Validate(quoted, 0)
+	if !issueAt(issues, "schema_type", "/score") || !issueAt(issues, "schema_type", "/track_ids/0") || len(issues) != 2 {
+		t.Fatalf("expected numeric strings to be rejected, got %+v", issues)
+	}
+}
+
+func TestCompileSchemaRejectsUnsupportedKeywords(t *testing.T) {
+	if _, err := CompileSchema([]byte(`{"$id":"x.v1.json","title":"t","description":"d","properties":{"a":{"type":"integer","default":1}}}`)); err != nil {
+		t.Fatalf("annotations should compile: %v", err)
+	}
+	for _, doc := range []string{
+		`{"uniqueItems":true}`,
+		`{"properties":{"ids":{"type":"array","uniqueItems":true}}}`,
+		`{"patternProperties":{"^x_":{"type":"string"}}}`,
+		`{"items":{"format":"date-time"}}`,
+		`{"if":{"required":["a"]},"then":{"required":["b"]}}`,
+		`{"dependentRequired":{"a":["b"]}}`,
+	} {
+		if _, err := CompileSchema([]byte(doc)); err == nil {
+			t.Fatalf("expected %s to be rejected", doc)
+		}
+	}
+}
+
+func TestValidateSchemaDefersToBuiltinChecks(t *testing.T) {
+	ev := mustEvent(t, `{"event_type":"proximity_event",`+schemaTestCommon+`,
+		"track_ids":[1,2],"global_ids":[1,2],"person_ids":["a","b"],"distance":-1,"duration_seconds":1}`)
+	issues := DefaultValidator().Validate(ev, 0)
+	if len(issues) != 1 || issues[0].Code != "invalid_distance" || issues[0].Path != "/distance" {
+		t.Fatalf("expected only invalid_distance, got %+v", issues)
+	}
+
+	noTrack := mustEvent(t, `{"event_type":"person_lost",`+schemaTestCommon+`,"person_id":"p"}`)
+	issues = DefaultValidator().Validate(noTrack, 0)
+	if len(issues) != 1 || issues[0].Code != "missing_track_id" {
+		t.Fatalf("expected only missing_track_id, got %+v", issues)
+	}
+}
+
+func TestLoadSchemaDirOverridesAndVersions(t *testing.T) {
+	dir := t.TempDir()
+	v2 := `{
+	  "allOf": [{"$ref": "common.v1.json"}],
+	  "required": ["reason"],
+	  "properties": {
+	    "reason": {"$ref": "#/$defs/reason"},
+	    "skipped_frames": {"type": "integer", "exclusiveMinimum": 0}
+	  },
+	  "$defs": {"reason": {"enum": ["decode_error", "backpressure"]}}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "frame_skipped.v2.json"), []byte(v2), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	schemas, err := LoadSchemaDir(dir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	v := NewValidator(schemas, BuiltinRules())
+
+	latest := mustEvent(t, `{"event_type":"frame_skipped",`+schemaTestCommon+`,"reason":"other","skipped_frames":0}`)
+	issues := v.Validate(latest, 0)
+	if !issueAt(issues, "schema_enum", "/reason") || !issueAt(issues, "schema_exclusive_minimum", "/skipped_frames") || len(issues) != 2 {
+		t.Fatalf("expected v2 issues, got %+v", issues)
+	}
+
+	v1 := mustEvent(t, `{"event_type":"frame_skipped","schema_version":1,`+schemaTestCommon+`,"reason":"other","skipped_frames":0}`)
+	if issues := v.Validate(v1, 0); len(issues) != 0 {
+		t.Fatalf("expected v1 to accept, got %+v", issues)
+	}
+	v3 := mustEvent(t, `{"event_type":"frame_skipped","schema_version":3,`+schemaTestCommon+`}`)
+	if issues := v.Validate(v3, 0); len(issues) != 1 || issues[0].Code != "unknown_schema_version" || issues[0].Severity != SeverityWarn {
+		t.Fatalf("expected unknown_schema_version, got %+v", issues)
+	}
+	if _, _, ok := DefaultSchemas().Lookup("frame_skipped", 2); ok {
+		t.Fatalf("directory schemas leaked into the defaults")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.v1.json"), []byte(`{"type":"nope"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSchemaDir(dir); err == nil {
+		t.Fatalf("expected error for invalid schema")
+	}
+}
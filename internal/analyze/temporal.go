@@ -0,0 +1,246 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ai-json/internal/model"
+)
+
+// Temporal checks look at events relative to each other rather than in
+// isolation. Events are grouped by camera (room_id + camera_id) and by track
+// within it:
+//
+//   - track_timestamp_regression (error): an event's timestamp is earlier
+//     than one already seen for the same track
+//   - camera_frame_regression (error): a frame_tick's frame_timestamp is
+//     earlier than an earlier frame_tick of the same camera
+//   - emitted_before_timestamp (error): emitted_at is before timestamp
+//   - duplicate_in_frame (error): the same event type for the same subject
+//     (track_id, track_ids, object_id or group_id; frame_tick has none)
+//     appears twice in one camera frame
+//   - person_lost_without_detected (warn): in timestamp order, a track's
+//     person_lost has no person_detected before it
+//   - track_event_after_lost (warn): in timestamp order, a track has events
+//     after its person_lost and before a new person_detected
+//
+// The ordering and duplicate checks run as events arrive. The lifecycle
+// checks hold each track's events until window track events are held in
+// all, then sort each track's events by timestamp and check them, carrying
+// the track's detected/lost state over to its next events; the events still
+// held are checked the same way when the result is built. The duplicate
+// check remembers the last window frame subjects.
+
+// temporalEvent is what the lifecycle checks keep of a track event.
+type temporalEvent struct {
+	idx       int
+	eventType string
+	ts        float64
+}
+
+// temporalTrack is the state of one camera track.
+type temporalTrack struct {
+	trackID int64
+	// last is the latest timestamp seen for the track.
+	last float64
+	// events are the track's unchecked events in input order; detected and
+	// lost are the lifecycle state after the checked ones.
+	events   []temporalEvent
+	detected bool
+	lost     bool
+}
+
+type temporalState struct {
+	window     int
+	maxIssues  int
+	tracks     map[string]*temporalTrack
+	trackOrder []string
+	held       int
+	// checked holds the lifecycle issues of the checked events, trimmed to
+	// the maxIssues lowest event indices when maxIssues > 0; checkedErrs
+	// and checkedWarns count all of them.
+	checked      []ValidationIssue
+	checkedErrs  int
+	checkedWarns int
+	lastFrame    map[string]float64
+	frames       map[string]int
+	frameOrder   []string
+}
+
+// newTemporalState returns a state holding at most window track events and
+// frame subjects (0 holds all) that keeps at most maxIssues checked
+// lifecycle issues (0 keeps all).
+func newTemporalState(window, maxIssues int) *temporalState {
+	return &temporalState{
+		window:    window,
+		maxIssues: maxIssues,
+		tracks:    map[string]*temporalTrack{},
+		lastFrame: map[string]float64{},
+		frames:    map[string]int{},
+	}
+}
+
+// add records the event and returns the ordering and duplicate issues it
+// causes.
+func (t *temporalState) add(ev model.Event, idx int, common model.CommonFields, eventType string) []ValidationIssue {
+	var issues []ValidationIssue
+	issue := func(severity Severity, code, path, format string, args ...any) {
+		issues = append(issues, ValidationIssue{Severity: severity, Code: code, Message: fmt.Sprintf(format, args...), EventIndex: idx, EventType: eventType, Path: path})
+	}
+	camera := common.RoomID + "/" + common.CameraID
+
+	ts, hasTS := ev.Float64("timestamp")
+	if emitted, ok := ev.Float64("emitted_at"); ok && hasTS && emitted < ts {
+		issue(SeverityError, "emitted_before_timestamp", "/emitted_at", "emitted_at %s is before timestamp %s", formatNumber(emitted), formatNumber(ts))
+	}
+
+	if common.TrackID != nil && hasTS {
+		key := camera + "#" + strconv.FormatInt(*common.TrackID, 10)
+		tr, ok := t.tracks[key]
+		if !ok {
+			tr = &temporalTrack{trackID: *common.TrackID, last: ts}
+			t.tracks[key] = tr
+			t.trackOrder = append(t.trackOrder, key)
+		}
+		if ts < tr.last {
+			issue(SeverityError, "track_timestamp_regression", "/timestamp", "timestamp %s is before %s already seen for track %d on %s", formatNumber(ts), formatNumber(tr.last), *common.TrackID, camera)
+		} else {
+			tr.last = ts
+		}
+		tr.events = append(tr.events, temporalEvent{idx: idx, eventType: eventType, ts: ts})
+		t.held++
+		if t.window > 0 && t.held >= t.window {
+			t.checkHeld()
+		}
+	}
+
+	frameTS, hasFrame := ev.Float64("frame_timestamp")
+	if eventType == "frame_tick" && hasFrame {
+		if last, ok := t.lastFrame[camera]; ok && frameTS < last {
+			issue(SeverityError, "camera_frame_regression", "/frame_timestamp", "frame_timestamp %s is before %s already seen on %s", formatNumber(frameTS), formatNumber(last), camera)
+		} else {
+			t.lastFrame[camera] = frameTS
+		}
+	}
+
+	if subject, ok := frameSubject(ev, common, eventType); ok && hasFrame {
+		key := camera + "|" + formatNumber(frameTS) + "|" + eventType + "|" + subject
+		if first, ok := t.frames[key]; ok {
+			issue(SeverityError, "duplicate_in_frame", "", "duplicate of event #%d in frame %s on %s", first, formatNumber(frameTS), camera)
+		} else {
+			t.frames[key] = idx
+			t.frameOrder = append(t.frameOrder, key)
+			if t.window > 0 && len(t.frameOrder) > t.window {
+				delete(t.frames, t.frameOrder[0])
+				t.frameOrder = t.frameOrder[1:]
+			}
+		}
+	}
+	return issues
+}
+
+// frameSubject identifies what an event is about within a frame. Events
+// without a subject other than frame_tick are not checked for duplicates,
+// since several may legitimately share a frame.
+func frameSubject(ev model.Event, common model.CommonFields, eventType string) (string, bool) {
+	if common.TrackID != nil {
+		return "track:" + strconv.FormatInt(*common.TrackID, 10), true
+	}
+	if ids, ok := ev.Int64Slice("track_ids"); ok && len(ids) > 0 {
+		sorted := append([]int64(nil), ids...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		parts := make([]string, len(sorted))
+		for i, id := range sorted {
+			parts[i] = strconv.FormatInt(id, 10)
+		}
+		return "tracks:" + strings.Join(parts, ","), true
+	}
+	for _, key := range []string{"object_id", "group_id"} {
+		if v, ok := ev.Raw[key]; ok && v != nil {
+			return key + ":" + fmt.Sprint(v), true
+		}
+	}
+	return "", eventType == "frame_tick"
+}
+
+// checkHeld runs the lifecycle checks over the held events and keeps the
+// issues.
+func (t *temporalState) checkHeld() {
+	var issues []ValidationIssue
+	for _, key := range t.trackOrder {
+		t.tracks[key].check(key, &issues)
+	}
+	t.held = 0
+	for _, issue := range issues {
+		switch issue.Severity {
+		case SeverityError:
+			t.checkedErrs++
+		case SeverityWarn:
+			t.checkedWarns++
+		}
+	}
+	t.checked = append(t.checked, issues...)
+	if t.maxIssues > 0 && len(t.checked) > t.maxIssues {
+		sort.SliceStable(t.checked, func(i, j int) bool { return t.checked[i].EventIndex < t.checked[j].EventIndex })
+		t.checked = t.checked[:t.maxIssues]
+	}
+}
+
+// lifecycleIssues returns the lifecycle issues of all events, sorted by
+// event index, with the number of errors and warnings among them; the list
+// is trimmed like checked. It does not modify the state.
+func (t *temporalState) lifecycleIssues() ([]ValidationIssue, int, int) {
+	var held []ValidationIssue
+	for _, key := range t.trackOrder {
+		tr := *t.tracks[key]
+		tr.check(key, &held)
+	}
+	errs, warns := t.checkedErrs, t.checkedWarns
+	for _, issue := range held {
+		switch issue.Severity {
+		case SeverityError:
+			errs++
+		case SeverityWarn:
+			warns++
+		}
+	}
+	issues := append(append(make([]ValidationIssue, 0, len(t.checked)+len(held)), t.checked...), held...)
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].EventIndex < issues[j].EventIndex })
+	if t.maxIssues > 0 && len(issues) > t.maxIssues {
+		issues = issues[:t.maxIssues]
+	}
+	return issues, errs, warns
+}
+
+// check runs the person_detected/person_lost sequence checks over the
+// track's unchecked events in timestamp order, appending the issues, and
+// marks them checked.
+func (tr *temporalTrack) check(key string, issues *[]ValidationIssue) {
+	if len(tr.events) == 0 {
+		return
+	}
+	events := append([]temporalEvent(nil), tr.events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].ts < events[j].ts })
+	camera, _, _ := strings.Cut(key, "#")
+
+	for _, e := range events {
+		switch e.eventType {
+		case "person_detected":
+			tr.detected, tr.lost = true, false
+		case "person_lost":
+			if !tr.detected {
+				*issues = append(*issues, ValidationIssue{Severity: SeverityWarn, Code: "person_lost_without_detected", Message: fmt.Sprintf("track %d on %s lost without an earlier person_detected", tr.trackID, camera), EventIndex: e.idx, EventType: e.eventType, Path: "/track_id"})
+			}
+			tr.detected, tr.lost = false, true
+		default:
+			if tr.lost {
+				*issues = append(*issues, ValidationIssue{Severity: SeverityWarn, Code: "track_event_after_lost", Message: fmt.Sprintf("track %d on %s has events after person_lost", tr.trackID, camera), EventIndex: e.idx, EventType: e.eventType, Path: "/track_id"})
+				// One issue per lost track is enough.
+				tr.lost = false
+			}
+		}
+	}
+	tr.events = nil
+}
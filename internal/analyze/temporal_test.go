@@ -0,0 +1,71 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"ai-json/internal/model"
+)
+
+func TestTemporalChecks(t *testing.T) {
+	ev := func(eventType, camera string, track int, ts, frame float64, extra string) string {
+		trackField := ""
+		if track > 0 {
+			trackField = fmt.Sprintf(`"track_id":%d,"person_id":"p%d",`, track, track)
+		}
+		return fmt.Sprintf(`{"event_type":%q,"room_id":"r1","camera_id":%q,"pipeline":"p1","confidence":0.5,%s"timestamp":%g,"frame_timestamp":%g,"frame_source_timestamp":%g,"emitted_at":%g,"timestamp_offset_seconds":0,"timestamp_stabilizer_skew_seconds":0,"frame_age_seconds":0,"frame_transport_delay_seconds":0%s}`,
+			eventType, camera, trackField, ts, frame, frame, ts+1, extra)
+	}
+	lines := []string{
+		ev("person_detected", "c1", 1, 10, 10, ""),
+		ev("person_tracked", "c1", 1, 12, 12, ""),
+		ev("person_tracked", "c1", 1, 11, 11, ""),
+		ev("person_lost", "c1", 2, 5, 5, ""),
+		ev("person_lost", "c1", 1, 13, 13, ""),
+		ev("person_tracked", "c1", 1, 14, 14, ""),
+		ev("frame_tick", "c1", 0, 100, 100, `,"detections_count":1`),
+		ev("frame_tick", "c1", 0, 99, 99, `,"detections_count":1`),
+		ev("person_tracked", "c1", 3, 20, 50, `,"emitted_at":19`),
+		ev("person_tracked", "c1", 3, 20, 50, ""),
+		ev("person_detected", "c2", 1, 1, 1, ""),
+	}
+	events, err := model.ParseEvents([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	res := Run(events)
+
+	type key struct {
+		code string
+		idx  int
+	}
+	got := map[key]Severity{}
+	for _, it := range res.TemporalIssues {
+		got[key{it.Code, it.EventIndex}] = it.Severity
+	}
+	want := map[key]Severity{
+		{"track_timestamp_regression", 2}:   SeverityError,
+		{"person_lost_without_detected", 3}: SeverityWarn,
+		{"track_event_after_lost", 5}:       SeverityWarn,
+		{"camera_frame_regression", 7}:      SeverityError,
+		{"emitted_before_timestamp", 8}:     SeverityError,
+		{"duplicate_in_frame", 9}:           SeverityError,
+	}
+	if len(got) != len(want) || res.TemporalIssueCount != len(want) {
+		t.Fatalf("expected %d temporal issues, got %+v", len(want), res.TemporalIssues)
+	}
+	for k, sev := range want {
+		if got[k] != sev {
+			t.Fatalf("expected %s %s at event %d, got %+v", sev, k.code, k.idx, res.TemporalIssues)
+		}
+	}
+	for i := 1; i < len(res.TemporalIssues); i++ {
+		if res.TemporalIssues[i-1].EventIndex > res.TemporalIssues[i].EventIndex {
+			t.Fatalf("temporal issues not sorted by event: %+v", res.TemporalIssues)
+		}
+	}
+	if res.ErrorCount != 4 || res.WarningCount != 2 || len(res.Issues) != 0 {
+		t.Fatalf("unexpected counts %d/%d with %d per-event issues", res.ErrorCount, res.WarningCount, len(res.Issues))
+	}
+}
@@ -0,0 +1,313 @@
+package analyze
+
+import (
+	"sort"
+	"strings"
+)
+
+// Track analytics rebuild when each track was visible from its
+// person_detected, person_tracked and person_lost events. A track is keyed
+// by class (stream_class_id, else room_id) and track_id, and broken down by
+// camera (stream_camera_id, else camera_id). On each camera the events are
+// taken in timestamp order and cut into segments:
+//
+//   - person_detected starts a new segment
+//   - person_tracked extends the open segment, or starts one when none is
+//     open or the previous event is more than the gap threshold ago
+//   - person_lost ends the open segment at its timestamp
+//
+// A segment's end_reason is "lost", "gap" (the next event came too late),
+// "redetected" (a person_detected followed) or "open" (still visible at the
+// last event). The time between two segments of a camera is a gap.
+
+// DefaultTrackGapSeconds is the longest silence within one segment when no
+// gap threshold is set.
+const DefaultTrackGapSeconds = 30.0
+
+// TrackPoint is what the track analytics need of one person event.
+type TrackPoint struct {
+	ClassID   string
+	CameraID  string
+	TrackID   int64
+	EventType string
+	Timestamp float64
+}
+
+type TrackSegment struct {
+	Start           float64 `json:"start"`
+	End             float64 `json:"end"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Events          int     `json:"events"`
+	StartEventType  string  `json:"start_event_type"`
+	EndReason       string  `json:"end_reason"`
+}
+
+type TrackGap struct {
+	From    float64 `json:"from"`
+	To      float64 `json:"to"`
+	Seconds float64 `json:"seconds"`
+}
+
+type TrackCamera struct {
+	CameraID       string         `json:"camera_id"`
+	FirstSeen      float64        `json:"first_seen"`
+	LastSeen       float64        `json:"last_seen"`
+	VisibleSeconds float64        `json:"visible_seconds"`
+	Events         int            `json:"events"`
+	Segments       []TrackSegment `json:"segments"`
+	Gaps           []TrackGap     `json:"gaps"`
+}
+
+type TrackSummary struct {
+	ClassID   string  `json:"class_id"`
+	TrackID   int64   `json:"track_id"`
+	FirstSeen float64 `json:"first_seen"`
+	LastSeen  float64 `json:"last_seen"`
+	// VisibleSeconds is the union of the segments of all cameras, so time
+	// seen by two cameras at once counts once.
+	VisibleSeconds float64 `json:"visible_seconds"`
+	Events         int     `json:"events"`
+	LostCount      int     `json:"lost_count"`
+	// ReacquiredCount is the number of segments after the first on each
+	// camera.
+	ReacquiredCount int           `json:"reacquired_count"`
+	GapCount        int           `json:"gap_count"`
+	Cameras         []TrackCamera `json:"cameras"`
+}
+
+// IsTrackEvent reports whether events of eventType feed the track
+// analytics.
+func IsTrackEvent(eventType string) bool {
+	switch eventType {
+	case "person_detected", "person_tracked", "person_lost":
+		return true
+	}
+	return false
+}
+
+// TrackBuilder collects track points and summarizes them. Unless Window is
+// set, it keeps the type and timestamp of every point until Result.
+type TrackBuilder struct {
+	// Window is the number of points held before they are cut into
+	// segments in timestamp order; each camera's open segment carries over
+	// to its next points, so a point that arrives after a later point of
+	// its camera was cut is taken in arrival order. 0 holds every point
+	// until Result. Set it before the first Add.
+	Window int
+
+	gap    float64
+	tracks map[trackKey]map[string]*segmenter
+	held   int
+}
+
+type trackKey struct {
+	classID string
+	trackID int64
+}
+
+type trackPoint struct {
+	eventType string
+	ts        float64
+}
+
+// NewTrackBuilder returns a builder that splits segments at silences longer
+// than gapSeconds (DefaultTrackGapSeconds when <= 0).
+func NewTrackBuilder(gapSeconds float64) *TrackBuilder {
+	if gapSeconds <= 0 {
+		gapSeconds = DefaultTrackGapSeconds
+	}
+	return &TrackBuilder{gap: gapSeconds, tracks: map[trackKey]map[string]*segmenter{}}
+}
+
+// Add records p; points of other event types are ignored.
+func (b *TrackBuilder) Add(p TrackPoint) {
+	if !IsTrackEvent(p.EventType) {
+		return
+	}
+	key := trackKey{classID: p.ClassID, trackID: p.TrackID}
+	cameras, ok := b.tracks[key]
+	if !ok {
+		cameras = map[string]*segmenter{}
+		b.tracks[key] = cameras
+	}
+	s, ok := cameras[p.CameraID]
+	if !ok {
+		s = newSegmenter(p.CameraID)
+		cameras[p.CameraID] = s
+	}
+	s.points = append(s.points, trackPoint{eventType: p.EventType, ts: p.Timestamp})
+	b.held++
+	if b.Window > 0 && b.held >= b.Window {
+		for _, cameras := range b.tracks {
+			for _, s := range cameras {
+				s.cut(b.gap)
+			}
+		}
+		b.held = 0
+	}
+}
+
+// Result returns one summary per track, ordered by class and track ID. It
+// does not modify the builder.
+func (b *TrackBuilder) Result() []TrackSummary {
+	out := make([]TrackSummary, 0, len(b.tracks))
+	for key, cameras := range b.tracks {
+		t := TrackSummary{ClassID: key.classID, TrackID: key.trackID, Cameras: make([]TrackCamera, 0, len(cameras))}
+		var intervals [][2]float64
+		for _, s := range cameras {
+			c, lost := s.result(b.gap)
+			if len(t.Cameras) == 0 || c.FirstSeen < t.FirstSeen {
+				t.FirstSeen = c.FirstSeen
+			}
+			if len(t.Cameras) == 0 || c.LastSeen > t.LastSeen {
+				t.LastSeen = c.LastSeen
+			}
+			t.Events += c.Events
+			t.LostCount += lost
+			t.GapCount += len(c.Gaps)
+			if len(c.Segments) > 1 {
+				t.ReacquiredCount += len(c.Segments) - 1
+			}
+			for _, s := range c.Segments {
+				intervals = append(intervals, [2]float64{s.Start, s.End})
+			}
+			t.Cameras = append(t.Cameras, c)
+		}
+		sort.Slice(t.Cameras, func(i, j int) bool { return t.Cameras[i].CameraID < t.Cameras[j].CameraID })
+		t.VisibleSeconds = unionSeconds(intervals)
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ClassID != out[j].ClassID {
+			return strings.Compare(out[i].ClassID, out[j].ClassID) < 0
+		}
+		return out[i].TrackID < out[j].TrackID
+	})
+	return out
+}
+
+// segmenter cuts one camera's points into segments. points are the points
+// not cut yet; c holds the segments closed so far and counts the cut
+// points, open is the segment still open after them.
+type segmenter struct {
+	points []trackPoint
+	c      TrackCamera
+	open   *TrackSegment
+	lost   int
+}
+
+func newSegmenter(cameraID string) *segmenter {
+	return &segmenter{c: TrackCamera{CameraID: cameraID, Segments: make([]TrackSegment, 0), Gaps: make([]TrackGap, 0)}}
+}
+
+// result returns the camera's summary with the number of person_lost
+// events. It does not modify s.
+func (s *segmenter) result(gap float64) (TrackCamera, int) {
+	r := *s
+	r.c.Segments = append(make([]TrackSegment, 0, len(s.c.Segments)+1), s.c.Segments...)
+	if s.open != nil {
+		open := *s.open
+		r.open = &open
+	}
+	r.cut(gap)
+	r.closeOpen("open")
+
+	c := r.c
+	for i := 1; i < len(c.Segments); i++ {
+		from, to := c.Segments[i-1].End, c.Segments[i].Start
+		c.Gaps = append(c.Gaps, TrackGap{From: from, To: to, Seconds: to - from})
+	}
+	for _, seg := range c.Segments {
+		c.VisibleSeconds += seg.DurationSeconds
+	}
+	return c, r.lost
+}
+
+// cut takes the held points in timestamp order, continuing the open
+// segment.
+func (s *segmenter) cut(gap float64) {
+	if len(s.points) == 0 {
+		return
+	}
+	sorted := append([]trackPoint(nil), s.points...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ts < sorted[j].ts })
+	s.points = nil
+
+	start := func(p trackPoint) {
+		s.open = &TrackSegment{Start: p.ts, End: p.ts, Events: 1, StartEventType: p.eventType}
+	}
+	for _, p := range sorted {
+		if s.c.Events == 0 || p.ts < s.c.FirstSeen {
+			s.c.FirstSeen = p.ts
+		}
+		if s.c.Events == 0 || p.ts > s.c.LastSeen {
+			s.c.LastSeen = p.ts
+		}
+		s.c.Events++
+
+		timedOut := s.open != nil && p.ts-s.open.End > gap
+		switch p.eventType {
+		case "person_detected":
+			if timedOut {
+				s.closeOpen("gap")
+			} else {
+				s.closeOpen("redetected")
+			}
+			start(p)
+		case "person_tracked":
+			if timedOut {
+				s.closeOpen("gap")
+			}
+			if s.open == nil {
+				start(p)
+				continue
+			}
+			s.open.End = p.ts
+			s.open.Events++
+		case "person_lost":
+			s.lost++
+			if timedOut {
+				s.closeOpen("gap")
+			}
+			if s.open == nil {
+				// A loss with nothing visible before it is counted but
+				// covers no time.
+				continue
+			}
+			s.open.End = p.ts
+			s.open.Events++
+			s.closeOpen("lost")
+		}
+	}
+}
+
+func (s *segmenter) closeOpen(reason string) {
+	if s.open == nil {
+		return
+	}
+	s.open.EndReason = reason
+	s.open.DurationSeconds = s.open.End - s.open.Start
+	s.c.Segments = append(s.c.Segments, *s.open)
+	s.open = nil
+}
+
+// unionSeconds returns the total length covered by intervals.
+func unionSeconds(intervals [][2]float64) float64 {
+	if len(intervals) == 0 {
+		return 0
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i][0] < intervals[j][0] })
+	total := 0.0
+	cur := intervals[0]
+	for _, iv := range intervals[1:] {
+		if iv[0] > cur[1] {
+			total += cur[1] - cur[0]
+			cur = iv
+			continue
+		}
+		if iv[1] > cur[1] {
+			cur[1] = iv[1]
+		}
+	}
+	return total + cur[1] - cur[0]
+}
@@ -0,0 +1,77 @@
+package analyze
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTrackBuilderSegments(t *testing.T) {
+	b := NewTrackBuilder(5)
+	for _, p := range []TrackPoint{
+		// Out of order on purpose: segments follow timestamps.
+		{"c1", "front", 16, "person_tracked", 12},
+		{"c1", "front", 16, "person_detected", 10},
+		{"c1", "front", 16, "person_lost", 14},
+		{"c1", "front", 16, "person_tracked", 30},
+		{"c1", "front", 16, "person_tracked", 33},
+		{"c1", "front", 16, "person_tracked", 50},
+		{"c1", "back", 16, "person_detected", 13},
+		{"c1", "back", 16, "person_tracked", 17},
+		{"c1", "back", 16, "head_orientation_changed", 21},
+		{"c1", "front", 4, "person_lost", 1},
+		{"c0", "front", 16, "person_detected", 1},
+	} {
+		b.Add(p)
+	}
+	tracks := b.Result()
+	if len(tracks) != 3 || tracks[0].ClassID != "c0" || tracks[1].TrackID != 4 || tracks[2].TrackID != 16 {
+		t.Fatalf("unexpected tracks order: %+v", tracks)
+	}
+
+	lostOnly := tracks[1]
+	if lostOnly.LostCount != 1 || lostOnly.VisibleSeconds != 0 || len(lostOnly.Cameras[0].Segments) != 0 {
+		t.Fatalf("a lone person_lost covers no time: %+v", lostOnly)
+	}
+
+	tr := tracks[2]
+	if tr.FirstSeen != 10 || tr.LastSeen != 50 || tr.Events != 8 || tr.LostCount != 1 {
+		t.Fatalf("unexpected totals: %+v", tr)
+	}
+	// front: [10,14] lost, [30,33] gap, [50,50] open; back: [13,17] open.
+	// The union is [10,17] + [30,33].
+	if tr.VisibleSeconds != 10 || tr.ReacquiredCount != 2 || tr.GapCount != 2 {
+		t.Fatalf("unexpected visibility: %+v", tr)
+	}
+	if len(tr.Cameras) != 2 || tr.Cameras[0].CameraID != "back" || tr.Cameras[0].VisibleSeconds != 4 {
+		t.Fatalf("unexpected back camera: %+v", tr.Cameras)
+	}
+	front := tr.Cameras[1]
+	wantReasons := []string{"lost", "gap", "open"}
+	if len(front.Segments) != len(wantReasons) || front.VisibleSeconds != 7 {
+		t.Fatalf("unexpected front segments: %+v", front)
+	}
+	for i, reason := range wantReasons {
+		if front.Segments[i].EndReason != reason {
+			t.Fatalf("segment %d: expected %s, got %+v", i, reason, front.Segments[i])
+		}
+	}
+	if front.Segments[1].StartEventType != "person_tracked" || front.Segments[0].Events != 3 {
+		t.Fatalf("unexpected segment fields: %+v", front.Segments)
+	}
+	if len(front.Gaps) != 2 || front.Gaps[0] != (TrackGap{From: 14, To: 30, Seconds: 16}) || math.Abs(front.Gaps[1].Seconds-17) > 1e-9 {
+		t.Fatalf("unexpected gaps: %+v", front.Gaps)
+	}
+}
+
+func TestTrackBuilderRedetection(t *testing.T) {
+	b := NewTrackBuilder(0)
+	b.Add(TrackPoint{"c1", "front", 1, "person_detected", 0})
+	b.Add(TrackPoint{"c1", "front", 1, "person_tracked", 20})
+	b.Add(TrackPoint{"c1", "front", 1, "person_detected", 25})
+	b.Add(TrackPoint{"c1", "front", 1, "person_tracked", 50})
+
+	segs := b.Result()[0].Cameras[0].Segments
+	if len(segs) != 2 || segs[0].EndReason != "redetected" || segs[1].End != 50 || segs[1].EndReason != "open" {
+		t.Fatalf("expected the default 30s gap to keep the second segment open: %+v", segs)
+	}
+}
@@ -1,17 +1,26 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"ai-json/internal/analyze"
+	"ai-json/internal/config"
 	"ai-json/internal/ingest"
+	"ai-json/internal/input"
 	"ai-json/internal/media"
 	"ai-json/internal/model"
 	"ai-json/internal/store"
@@ -22,10 +31,48 @@ type Server struct {
 	DefaultStream     string
 	DefaultMinAge     time.Duration
 	DefaultMaxPastAge time.Duration
+	MaxFileAttempts   int
+	QuarantineDir     string
+	IngestWorkers     int
+	// Config, when set, serves the stream config at DefaultStream from memory
+	// and is what /v1/config/stream reports and /v1/config/reload refreshes.
+	Config *config.Manager
+	// Validator checks events posted with /v1/ingest/events?validate=...;
+	// nil uses the built-in schemas.
+	Validator *analyze.Validator
+
+	backfillMu      sync.Mutex
+	activeBackfills map[int64]struct{}
+
+	// background is the parent context of work started by requests but
+	// outliving them (backfills); Shutdown cancels it and waits on running.
+	background     context.Context
+	stopBackground context.CancelFunc
+	running        sync.WaitGroup
 }
 
 func New(s *store.Store) *Server {
-	return &Server{Store: s, DefaultStream: "stream.json", DefaultMinAge: 2 * time.Second, DefaultMaxPastAge: 1 * time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{Store: s, DefaultStream: "stream.json", DefaultMinAge: 2 * time.Second, DefaultMaxPastAge: 1 * time.Minute, activeBackfills: map[int64]struct{}{}, background: ctx, stopBackground: cancel}
+}
+
+// Shutdown cancels background backfills and waits for them to stop between
+// files, or until ctx is done. Stopped jobs are marked failed and can be
+// resumed by job_id. Call it after http.Server.Shutdown so no new backfill
+// starts meanwhile.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopBackground()
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (s *Server) Handler() http.Handler {
@@ -33,12 +80,24 @@ func (s *Server) Handler() http.Handler {
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/v1/ingest/events", s.handleIngestEvents)
 	mux.HandleFunc("/v1/ingest/stream", s.handleIngestStream)
+	mux.HandleFunc("/v1/ingest/backfill", s.handleBackfill)
+	mux.HandleFunc("/v1/ingest/failures", s.handleListFailures)
+	mux.HandleFunc("/v1/ingest/runs", s.handleIngestRuns)
+	mux.HandleFunc("/v1/ingest/status", s.handleIngestStatus)
+	mux.HandleFunc("/v1/ingest/failures/{id}/retry", s.handleRetryFailure)
+	mux.HandleFunc("/v1/ingest/file-actions", s.handleFileActions)
+	mux.HandleFunc("/v1/config/stream", s.handleStreamConfig)
+	mux.HandleFunc("/v1/config/reload", s.handleReloadConfig)
 	mux.HandleFunc("/v1/events", s.handleListEvents)
 	mux.HandleFunc("/v1/special-events", s.handleSpecialEvents)
 	mux.HandleFunc("/v1/special-events-with-images", s.handleSpecialEventsWithImages)
 	mux.HandleFunc("/v1/event-images", s.handleEventImages)
 	mux.HandleFunc("/v1/image", s.handleImage)
 	mux.HandleFunc("/v1/student-metrics/daily", s.handleStudentDailyMetrics)
+	mux.HandleFunc("/v1/tracks", s.handleTracks)
+	mux.HandleFunc("/v1/persons", s.handleListPersons)
+	mux.HandleFunc("/v1/persons/reconcile", s.handleReconcilePersons)
+	mux.HandleFunc("/v1/persons/{id}/timeline", s.handlePersonTimeline)
 	mux.HandleFunc("/v1/summary", s.handleSummary)
 	return withHeaders(mux)
 }
@@ -59,45 +118,117 @@ func (s *Server) handleIngestEvents(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only POST allowed")
 		return
 	}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "read_body_failed", err.Error())
-		return
-	}
-	events, err := model.ParseEvents(body)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_events_payload", err.Error())
-		return
-	}
-
 	classID := strings.TrimSpace(r.URL.Query().Get("class_id"))
 	cameraID := strings.TrimSpace(r.URL.Query().Get("camera_id"))
 	source := strings.TrimSpace(r.URL.Query().Get("source"))
 	if source == "" {
 		source = "api:/v1/ingest/events"
 	}
+	mode := strings.TrimSpace(r.URL.Query().Get("validate"))
+	switch mode {
+	case "":
+		mode = "off"
+	case "off", "warn", "strict":
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_validate", "validate must be strict, warn or off")
+		return
+	}
+	validator := s.Validator
+	if validator == nil {
+		validator = analyze.DefaultValidator()
+	}
 
-	for i := range events {
-		if classID != "" {
-			events[i].Raw["stream_class_id"] = classID
+	// The body is decoded and stored event by event; a payload error rolls
+	// back everything stored before it. In strict mode the first event with
+	// an error-severity issue stops storing, but decoding goes on to collect
+	// the remaining issues before the batch is rolled back.
+	var (
+		payloadErr error
+		issues     = make([]analyze.ValidationIssue, 0)
+		checked    int
+		errorCount int
+		warnCount  int
+	)
+	stream := func(yield func(model.Event) error) error {
+		var storeErr error
+		err := model.DecodeEvents(r.Body, func(ev model.Event) error {
+			if mode != "off" {
+				for _, issue := range validator.Validate(ev, checked) {
+					if issue.Severity == analyze.SeverityError {
+						errorCount++
+					} else {
+						warnCount++
+					}
+					if len(issues) < maxIngestIssues {
+						issues = append(issues, issue)
+					}
+				}
+			}
+			checked++
+			if mode == "strict" && errorCount > 0 {
+				return nil
+			}
+			if classID != "" {
+				ev.Raw["stream_class_id"] = classID
+			}
+			if cameraID != "" {
+				ev.Raw["stream_camera_id"] = cameraID
+			}
+			storeErr = yield(ev)
+			return storeErr
+		})
+		if err != nil && storeErr == nil {
+			payloadErr = err
 		}
-		if cameraID != "" {
-			events[i].Raw["stream_camera_id"] = cameraID
+		if err == nil && mode == "strict" && errorCount > 0 {
+			return errValidationFailed
 		}
+		return err
+	}
+	res, err := s.Store.InsertEventStreamContext(r.Context(), stream, source)
+	if payloadErr != nil {
+		writeError(w, http.StatusBadRequest, "invalid_events_payload", payloadErr.Error())
+		return
+	}
+	if errors.Is(err, errValidationFailed) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"error": map[string]any{
+				"code":    "validation_failed",
+				"message": fmt.Sprintf("%d events checked: %d errors, %d warnings; nothing was stored", checked, errorCount, warnCount),
+			},
+			"error_count":   errorCount,
+			"warning_count": warnCount,
+			"issues":        issues,
+		})
+		return
 	}
-
-	n, err := s.Store.InsertEvents(events, source)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "insert_failed", err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"inserted": n,
-		"source":   source,
-	})
+	out := map[string]any{
+		"inserted":   res.Inserted,
+		"duplicates": res.Duplicates,
+		"source":     source,
+	}
+	if mode != "off" {
+		out["validate"] = mode
+		out["error_count"] = errorCount
+		out["warning_count"] = warnCount
+		out["issues"] = issues
+	}
+	writeJSON(w, http.StatusOK, out)
 }
 
+// maxIngestIssues caps the issues listed by /v1/ingest/events; the counts
+// cover all of them.
+const maxIngestIssues = 100
+
+// errValidationFailed ends a strict /v1/ingest/events stream so its batch is
+// rolled back.
+var errValidationFailed = errors.New("validation failed")
+
 func (s *Server) handleIngestStream(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only POST allowed")
@@ -127,45 +258,426 @@ func (s *Server) handleIngestStream(w http.ResponseWriter, r *http.Request) {
 		maxPast = time.Duration(n) * time.Second
 	}
 
-	runner := ingest.Runner{Store: s.Store, StreamPath: streamPath, MinFileAge: minAge, MaxPastAge: maxPast}
-	stats, err := runner.RunOnce()
+	runner := s.newRunner(streamPath, minAge, maxPast)
+	runner.Trigger = store.TriggerAPI
+	stats, err := runner.RunOnceContext(r.Context())
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "stream_ingest_failed", err.Error())
 		return
 	}
 
 	writeJSON(w, http.StatusOK, map[string]any{
-		"inserted":         stats.InsertedEvents,
-		"processed_files":  stats.ProcessedFiles,
-		"skipped_files":    stats.SkippedFiles,
-		"stream_path":      streamPath,
-		"max_past_seconds": int(maxPast.Seconds()),
+		"inserted":          stats.InsertedEvents,
+		"duplicates":        stats.DuplicateEvents,
+		"processed_files":   stats.ProcessedFiles,
+		"skipped_files":     stats.SkippedFiles,
+		"failed_files":      stats.FailedFiles,
+		"quarantined_files": stats.QuarantinedFiles,
+		"moved_files":       stats.MovedFiles,
+		"deleted_files":     stats.DeletedFiles,
+		"failed_actions":    stats.FailedActions,
+		"cameras":           stats.Cameras,
+		"stream_path":       streamPath,
+		"max_past_seconds":  int(maxPast.Seconds()),
 	})
 }
 
-func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+func (s *Server) newRunner(streamPath string, minAge, maxPast time.Duration) *ingest.Runner {
+	runner := &ingest.Runner{
+		Store:           s.Store,
+		StreamPath:      streamPath,
+		MinFileAge:      minAge,
+		MaxPastAge:      maxPast,
+		MaxFileAttempts: s.MaxFileAttempts,
+		QuarantineDir:   s.QuarantineDir,
+		Workers:         s.IngestWorkers,
+	}
+	if s.managesStream(streamPath) {
+		runner.Config = s.Config
+	}
+	return runner
+}
+
+// managesStream reports whether streamPath is the config held by s.Config.
+func (s *Server) managesStream(streamPath string) bool {
+	if s.Config == nil {
+		return false
+	}
+	abs, err := filepath.Abs(streamPath)
+	return err == nil && abs == s.Config.Path()
+}
+
+// imageResolver builds a resolver for streamPath, using the in-memory config
+// when streamPath is the managed one.
+func (s *Server) imageResolver(streamPath string) (*media.StreamImageResolver, error) {
+	if s.managesStream(streamPath) {
+		resolved, err := s.Config.Current()
+		if err != nil {
+			return nil, err
+		}
+		return media.NewResolverFromStream(resolved), nil
+	}
+	return media.NewStreamImageResolver(streamPath)
+}
+
+func (s *Server) handleStreamConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	if s.Config == nil {
+		writeError(w, http.StatusServiceUnavailable, "config_unavailable", "stream config is not managed by this server")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Config.Status())
+}
+
+func (s *Server) handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only POST allowed")
+		return
+	}
+	if s.Config == nil {
+		writeError(w, http.StatusServiceUnavailable, "config_unavailable", "stream config is not managed by this server")
+		return
+	}
+	if err := s.Config.Reload(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "stream_config_invalid", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Config.Status())
+}
+
+func (s *Server) handleIngestRuns(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
 		return
 	}
-	filter, err := parseFilter(r)
+	q := r.URL.Query()
+	trigger := strings.TrimSpace(q.Get("trigger"))
+	switch trigger {
+	case "", store.TriggerScheduler, store.TriggerAPI, store.TriggerBackfill:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_trigger", "trigger must be scheduler, api or backfill")
+		return
+	}
+	status := strings.TrimSpace(q.Get("status"))
+	if status != "" && status != store.RunSucceeded && status != store.RunFailed {
+		writeError(w, http.StatusBadRequest, "invalid_status", "status must be succeeded or failed")
+		return
+	}
+	limit := 50
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid limit")
+			return
+		}
+		limit = n
+	}
+	runs, err := s.Store.ListIngestRuns(trigger, status, limit)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"runs": runs})
+}
+
+func (s *Server) handleIngestStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	now := time.Now()
+	resp := map[string]any{
+		"now":          now.Unix(),
+		"last_run":     nil,
+		"last_success": nil,
+	}
+	last, ok, err := s.Store.LatestIngestRun("")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ingest_status_failed", err.Error())
+		return
+	}
+	if ok {
+		resp["last_run"] = last
+	}
+	success, ok, err := s.Store.LatestIngestRun(store.RunSucceeded)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ingest_status_failed", err.Error())
+		return
+	}
+	if ok {
+		resp["last_success"] = success
+	}
+	cameras, err := s.Store.ListCameraIngestStatus(now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ingest_status_failed", err.Error())
+		return
+	}
+	resp["cameras"] = cameras
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleListFailures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	if status != "" && status != store.FailureRetrying && status != store.FailureQuarantined {
+		writeError(w, http.StatusBadRequest, "invalid_status", "status must be retrying or quarantined")
+		return
+	}
+	limit := 200
+	if v := strings.TrimSpace(r.URL.Query().Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid limit")
+			return
+		}
+		limit = n
+	}
+	failures, err := s.Store.ListIngestFailures(status, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"failures": failures})
+}
 
-	rows, total, err := s.Store.ListEvents(filter)
+func (s *Server) handleFileActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	q := r.URL.Query()
+	action := strings.TrimSpace(q.Get("action"))
+	switch action {
+	case "", input.AfterIngestMove, input.AfterIngestDelete:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_action", "action must be move or delete")
+		return
+	}
+	limit := 100
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid limit")
+			return
+		}
+		limit = n
+	}
+	actions, err := s.Store.ListFileActions(strings.TrimSpace(q.Get("path")), action, limit)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]any{"actions": actions})
+}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"total":  total,
-		"limit":  filter.Limit,
-		"offset": filter.Offset,
-		"events": rows,
-	})
+func (s *Server) handleRetryFailure(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only POST allowed")
+		return
+	}
+	id, err := parseInt64Required(r.PathValue("id"), "id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_failure_id", err.Error())
+		return
+	}
+	f, err := s.Store.GetIngestFailure(id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "failure_not_found", "ingest failure not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failure_lookup_failed", err.Error())
+		return
+	}
+
+	runner := s.newRunner(s.DefaultStream, s.DefaultMinAge, s.DefaultMaxPastAge)
+	stats, err := runner.RetryFailure(f.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "retry_failed", err.Error())
+		return
+	}
+	resp := map[string]any{
+		"path":            f.Path,
+		"processed_files": stats.ProcessedFiles,
+		"inserted":        stats.InsertedEvents,
+		"failure":         nil,
+	}
+	if current, ok, err := s.Store.GetIngestFailureByPath(f.Path); err == nil && ok {
+		resp["failure"] = current
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetBackfill(w, r)
+	case http.MethodPost:
+		s.handleStartBackfill(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET and POST allowed")
+	}
+}
+
+// handleStartBackfill creates a backfill job (or resumes one when job_id is
+// given) and runs it in the background. Progress is read back through
+// GET /v1/ingest/backfill.
+func (s *Server) handleStartBackfill(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	streamPath := strings.TrimSpace(q.Get("stream_path"))
+	if streamPath == "" {
+		streamPath = s.DefaultStream
+	}
+	runner := s.newRunner(streamPath, s.DefaultMinAge, s.DefaultMaxPastAge)
+
+	var (
+		job store.BackfillJob
+		err error
+	)
+	if v := strings.TrimSpace(q.Get("job_id")); v != "" {
+		id, perr := parseInt64Required(v, "job_id")
+		if perr != nil {
+			writeError(w, http.StatusBadRequest, "invalid_job_id", perr.Error())
+			return
+		}
+		job, err = s.Store.GetBackfillJob(id)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "backfill_job_not_found", "backfill job not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "backfill_lookup_failed", err.Error())
+			return
+		}
+		runner.StreamPath = job.StreamPath
+	} else {
+		req := ingest.BackfillRequest{
+			ClassIDs:  splitCSV(q.Get("class_ids")),
+			CameraIDs: splitCSV(q.Get("camera_ids")),
+		}
+		if v := strings.TrimSpace(q.Get("from_ts")); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "invalid_from_ts", "from_ts must be a unix epoch in seconds")
+				return
+			}
+			req.FromEpoch = n
+		}
+		if v := strings.TrimSpace(q.Get("to_ts")); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "invalid_to_ts", "to_ts must be a unix epoch in seconds")
+				return
+			}
+			req.ToEpoch = n
+		}
+		if v := strings.TrimSpace(q.Get("chunk_size")); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid_chunk_size", "chunk_size must be > 0")
+				return
+			}
+			req.ChunkSize = n
+		}
+		job, err = runner.StartBackfill(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "backfill_start_failed", err.Error())
+			return
+		}
+	}
+
+	s.backfillMu.Lock()
+	if _, running := s.activeBackfills[job.ID]; running {
+		s.backfillMu.Unlock()
+		writeError(w, http.StatusConflict, "backfill_already_running", "backfill job is already running")
+		return
+	}
+	s.activeBackfills[job.ID] = struct{}{}
+	s.backfillMu.Unlock()
+
+	s.running.Add(1)
+	go func(id int64) {
+		defer s.running.Done()
+		defer func() {
+			s.backfillMu.Lock()
+			delete(s.activeBackfills, id)
+			s.backfillMu.Unlock()
+		}()
+		_, _ = runner.RunBackfillContext(s.background, id, nil)
+	}(job.ID)
+
+	writeJSON(w, http.StatusAccepted, map[string]any{"job": job})
+}
+
+func (s *Server) handleGetBackfill(w http.ResponseWriter, r *http.Request) {
+	if v := strings.TrimSpace(r.URL.Query().Get("job_id")); v != "" {
+		id, err := parseInt64Required(v, "job_id")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_job_id", err.Error())
+			return
+		}
+		job, err := s.Store.GetBackfillJob(id)
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "backfill_job_not_found", "backfill job not found")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "backfill_lookup_failed", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"job": job})
+		return
+	}
+	jobs, err := s.Store.ListBackfillJobs(50)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "backfill_lookup_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": jobs})
+}
+
+func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	filter, err := s.parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	page, offsetPaging, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	if offsetPaging {
+		rows, total, err := s.Store.ListEventsContext(r.Context(), filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"total":  total,
+			"limit":  filter.Limit,
+			"offset": filter.Offset,
+			"events": rows,
+		})
+		return
+	}
+
+	out, err := s.Store.ListEventsPageContext(r.Context(), filter, page)
+	if err != nil {
+		writePageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
 }
 
 func (s *Server) handleSpecialEvents(w http.ResponseWriter, r *http.Request) {
@@ -173,7 +685,12 @@ func (s *Server) handleSpecialEvents(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
 		return
 	}
-	filter, err := parseFilter(r)
+	filter, err := s.parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	page, offsetPaging, err := parsePage(r)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
 		return
@@ -192,20 +709,54 @@ func (s *Server) handleSpecialEvents(w http.ResponseWriter, r *http.Request) {
 	}
 	filter.FromTS = &dayStart
 	filter.ToTS = &dayEnd
+	date := time.Unix(int64(dayStart), 0).UTC().Format("2006-01-02")
 
-	events, total, err := s.Store.ListEvents(filter)
+	if offsetPaging {
+		events, total, err := s.Store.ListEventsContext(r.Context(), filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"date":        date,
+			"event_types": eventTypes,
+			"total":       total,
+			"limit":       filter.Limit,
+			"offset":      filter.Offset,
+			"events":      events,
+		})
+		return
+	}
+
+	out, err := s.Store.ListEventsPageContext(r.Context(), filter, page)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+		writePageError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"date":        time.Unix(int64(dayStart), 0).UTC().Format("2006-01-02"),
+	resp := map[string]any{
+		"date":        date,
 		"event_types": eventTypes,
-		"total":       total,
-		"limit":       filter.Limit,
-		"offset":      filter.Offset,
-		"events":      events,
-	})
+		"sort":        out.Sort,
+		"order":       out.Order,
+		"limit":       out.Limit,
+		"next_cursor": out.NextCursor,
+		"prev_cursor": out.PrevCursor,
+		"events":      out.Events,
+	}
+	if out.Total != nil {
+		resp["total"] = *out.Total
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// writePageError reports a failed ListEventsPage call: a bad cursor is the
+// client's error, anything else the server's.
+func writePageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrInvalidCursor) {
+		writeError(w, http.StatusBadRequest, "invalid_cursor", err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
 }
 
 func (s *Server) handleSpecialEventsWithImages(w http.ResponseWriter, r *http.Request) {
@@ -213,7 +764,7 @@ func (s *Server) handleSpecialEventsWithImages(w http.ResponseWriter, r *http.Re
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
 		return
 	}
-	filter, err := parseFilter(r)
+	filter, err := s.parseFilter(r)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
 		return
@@ -231,7 +782,7 @@ func (s *Server) handleSpecialEventsWithImages(w http.ResponseWriter, r *http.Re
 	if streamPath == "" {
 		streamPath = s.DefaultStream
 	}
-	resolver, err := media.NewStreamImageResolver(streamPath)
+	resolver, err := s.imageResolver(streamPath)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "stream_resolve_failed", err.Error())
 		return
@@ -251,7 +802,7 @@ func (s *Server) handleSpecialEventsWithImages(w http.ResponseWriter, r *http.Re
 	filter.FromTS = &dayStart
 	filter.ToTS = &dayEnd
 
-	events, total, err := s.Store.ListEvents(filter)
+	events, total, err := s.Store.ListEventsContext(r.Context(), filter)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
 		return
@@ -307,7 +858,7 @@ func (s *Server) handleEventImages(w http.ResponseWriter, r *http.Request) {
 		streamPath = s.DefaultStream
 	}
 
-	ev, err := s.Store.GetEventByID(eventID)
+	ev, err := s.Store.GetEventByIDContext(r.Context(), eventID)
 	if err == sql.ErrNoRows {
 		writeError(w, http.StatusNotFound, "event_not_found", "event not found")
 		return
@@ -323,22 +874,46 @@ func (s *Server) handleEventImages(w http.ResponseWriter, r *http.Request) {
 	classID := firstNonEmpty(ev.StreamClassID, ev.RoomID)
 	cameraID := firstNonEmpty(ev.StreamCameraID, ev.CameraID)
 
-	resolver, err := media.NewStreamImageResolver(streamPath)
+	resolver, err := s.imageResolver(streamPath)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "stream_resolve_failed", err.Error())
 		return
 	}
 	ctx := resolver.BuildContext(classID, cameraID, int64(*ev.Timestamp), window, "/v1/image")
+	action, err := s.sourceFileAction(ev.SourceFile)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "file_action_lookup_failed", err.Error())
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
-		"event_id":       ev.ID,
-		"class_id":       classID,
-		"camera_id":      cameraID,
-		"event_ts":       int64(*ev.Timestamp),
-		"window_seconds": window,
-		"images":         ctx,
+		"event_id":           ev.ID,
+		"class_id":           classID,
+		"camera_id":          cameraID,
+		"event_ts":           int64(*ev.Timestamp),
+		"window_seconds":     window,
+		"images":             ctx,
+		"source_file":        ev.SourceFile,
+		"source_file_action": action,
 	})
 }
 
+// sourceFileAction returns the latest successful after_ingest action of the
+// file an event was read from (of its archive for archive members), or nil
+// when the file was left in place.
+func (s *Server) sourceFileAction(sourceFile string) (*store.FileAction, error) {
+	path, _, _ := input.SplitMemberPath(sourceFile)
+	actions, err := s.Store.ListFileActions(path, "", 10)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range actions {
+		if a.Error == "" {
+			return &a, nil
+		}
+	}
+	return nil, nil
+}
+
 func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
@@ -359,7 +934,7 @@ func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
 	if streamPath == "" {
 		streamPath = s.DefaultStream
 	}
-	resolver, err := media.NewStreamImageResolver(streamPath)
+	resolver, err := s.imageResolver(streamPath)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "stream_resolve_failed", err.Error())
 		return
@@ -396,7 +971,7 @@ func (s *Server) handleStudentDailyMetrics(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	classIDs := splitCSV(r.URL.Query().Get("class_ids"))
-	metrics, err := s.Store.DailyStudentMetrics(dayStart, dayEnd, classIDs)
+	metrics, err := s.Store.DailyStudentMetricsContext(r.Context(), dayStart, dayEnd, classIDs)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "daily_metrics_failed", err.Error())
 		return
@@ -407,17 +982,176 @@ func (s *Server) handleStudentDailyMetrics(w http.ResponseWriter, r *http.Reques
 	})
 }
 
+func (s *Server) handleTracks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	filter, err := s.parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 200
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	gap := analyze.DefaultTrackGapSeconds
+	if v := strings.TrimSpace(r.URL.Query().Get("gap_seconds")); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid gap_seconds")
+			return
+		}
+		gap = n
+	}
+	dayStart, dayEnd, err := parseDayRange(r.URL.Query().Get("date"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_date", err.Error())
+		return
+	}
+	filter.FromTS = &dayStart
+	filter.ToTS = &dayEnd
+
+	builder := analyze.NewTrackBuilder(gap)
+	err = s.Store.TrackEventsContext(r.Context(), filter, func(ev store.TrackEvent) error {
+		builder.Add(analyze.TrackPoint{ClassID: ev.ClassID, CameraID: ev.CameraID, TrackID: ev.TrackID, EventType: ev.EventType, Timestamp: ev.Timestamp})
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+		return
+	}
+	tracks := builder.Result()
+	total := len(tracks)
+	page := tracks[min(filter.Offset, total):min(filter.Offset+filter.Limit, total)]
+	writeJSON(w, http.StatusOK, map[string]any{
+		"date":        time.Unix(int64(dayStart), 0).UTC().Format("2006-01-02"),
+		"gap_seconds": gap,
+		"total":       total,
+		"limit":       filter.Limit,
+		"offset":      filter.Offset,
+		"tracks":      page,
+	})
+}
+
+func (s *Server) handleReconcilePersons(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only POST allowed")
+		return
+	}
+	q := r.URL.Query()
+	classIDs := splitCSV(q.Get("class_ids"))
+	var opts store.ReconcileOptions
+	if v := strings.TrimSpace(q.Get("min_overlap_seconds")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid min_overlap_seconds")
+			return
+		}
+		opts.MinOverlapSeconds = n
+	}
+	for name, dst := range map[string]*float64{"min_score": &opts.MinScore, "margin": &opts.Margin} {
+		if v := strings.TrimSpace(q.Get(name)); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n <= 0 || n > 1 {
+				writeError(w, http.StatusBadRequest, "invalid_query", "invalid "+name)
+				return
+			}
+			*dst = n
+		}
+	}
+	res, err := s.Store.ReconcilePersonsContext(r.Context(), classIDs, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "reconcile_failed", err.Error())
+		return
+	}
+	if classIDs == nil {
+		classIDs = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"class_ids":            classIDs,
+		"events":               res.Events,
+		"persons":              res.Persons,
+		"identities":           res.Identities,
+		"multi_camera_persons": res.MultiCamera,
+	})
+}
+
+func (s *Server) handleListPersons(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	filter, err := s.parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	persons, total, err := s.Store.ListPersonsContext(r.Context(), store.PersonFilter{
+		ClassIDs:  filter.ClassIDs,
+		CameraIDs: filter.CameraIDs,
+		Limit:     filter.Limit,
+		Offset:    filter.Offset,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"total":   total,
+		"limit":   filter.Limit,
+		"offset":  filter.Offset,
+		"persons": persons,
+	})
+}
+
+func (s *Server) handlePersonTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
+		return
+	}
+	filter, err := s.parseFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+	person, err := s.Store.GetPersonContext(r.Context(), r.PathValue("id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "person_not_found", "person not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+		return
+	}
+	events, total, err := s.Store.PersonTimelineContext(r.Context(), person, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "query_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"person": person,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+		"events": events,
+	})
+}
+
 func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "only GET allowed")
 		return
 	}
-	filter, err := parseFilter(r)
+	filter, err := s.parseFilter(r)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "invalid_query", err.Error())
 		return
 	}
-	summary, err := s.Store.Summary(filter)
+	summary, err := s.Store.SummaryContext(r.Context(), filter)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "summary_failed", err.Error())
 		return
@@ -425,7 +1159,9 @@ func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"summary": summary})
 }
 
-func parseFilter(r *http.Request) (store.EventFilter, error) {
+// parseFilter reads the event filters shared by the query endpoints,
+// including predicates on promoted fields.
+func (s *Server) parseFilter(r *http.Request) (store.EventFilter, error) {
 	q := r.URL.Query()
 	f := store.EventFilter{
 		EventTypes: splitCSV(q.Get("event_types")),
@@ -434,6 +1170,13 @@ func parseFilter(r *http.Request) (store.EventFilter, error) {
 		Limit:      200,
 		Offset:     0,
 	}
+	for _, v := range splitCSV(q.Get("track_ids")) {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid track_ids")
+		}
+		f.TrackIDs = append(f.TrackIDs, n)
+	}
 	if v := strings.TrimSpace(q.Get("min_confidence")); v != "" {
 		n, err := strconv.ParseFloat(v, 64)
 		if err != nil {
@@ -469,9 +1212,111 @@ func parseFilter(r *http.Request) (store.EventFilter, error) {
 		}
 		f.Offset = n
 	}
+	fields, err := s.parseFieldPredicates(q)
+	if err != nil {
+		return f, err
+	}
+	f.Fields = fields
 	return f, nil
 }
 
+// parseFieldPredicates reads field.<name>=v1,v2 (any of the values) and
+// field.<name>.min / field.<name>.max (inclusive bounds) for promoted
+// fields.
+func (s *Server) parseFieldPredicates(q url.Values) ([]store.FieldPredicate, error) {
+	byName := map[string]*store.FieldPredicate{}
+	for key, values := range q {
+		rest, ok := strings.CutPrefix(key, "field.")
+		if !ok {
+			continue
+		}
+		name, bound, _ := strings.Cut(rest, ".")
+		field, ok := s.Store.PromotedField(name)
+		if !ok {
+			names := make([]string, 0)
+			for _, f := range s.Store.PromotedFields() {
+				names = append(names, f.Name)
+			}
+			return nil, fmt.Errorf("unknown field %q (promoted fields: %s)", name, strings.Join(names, ", "))
+		}
+		p := byName[name]
+		if p == nil {
+			p = &store.FieldPredicate{Field: name}
+			byName[name] = p
+		}
+		raw := strings.TrimSpace(values[len(values)-1])
+		switch bound {
+		case "":
+			for _, part := range splitCSV(raw) {
+				v, err := field.Value(part)
+				if err != nil {
+					return nil, err
+				}
+				p.In = append(p.In, v)
+			}
+		case "min", "max":
+			v, err := field.Value(raw)
+			if err != nil {
+				return nil, err
+			}
+			if bound == "min" {
+				p.Min = v
+			} else {
+				p.Max = v
+			}
+		default:
+			return nil, fmt.Errorf("invalid %s (expected field.%s, field.%s.min or field.%s.max)", key, name, name, name)
+		}
+	}
+	out := make([]store.FieldPredicate, 0, len(byName))
+	for _, p := range byName {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+	return out, nil
+}
+
+// parsePage reads the keyset pagination parameters of /v1/events and
+// /v1/special-events. offsetPaging is true when the request pages with the
+// deprecated offset parameter instead, which is served as before (newest id
+// first, with total); mixing it with a cursor or another order is refused
+// rather than ignored.
+func parsePage(r *http.Request) (p store.EventPageRequest, offsetPaging bool, err error) {
+	q := r.URL.Query()
+	p = store.EventPageRequest{
+		Sort:   strings.TrimSpace(q.Get("sort")),
+		Order:  strings.TrimSpace(q.Get("order")),
+		Cursor: strings.TrimSpace(q.Get("cursor")),
+	}
+	if strings.TrimSpace(q.Get("offset")) != "" {
+		if p.Cursor != "" {
+			return p, false, fmt.Errorf("offset cannot be combined with cursor")
+		}
+		if (p.Sort != "" && p.Sort != store.SortByID) || (p.Order != "" && p.Order != store.OrderDesc) {
+			return p, false, fmt.Errorf("offset paging only supports sort=id&order=desc; page with cursor instead")
+		}
+		return p, true, nil
+	}
+	switch p.Sort {
+	case "", store.SortByID, store.SortByTimestamp:
+	default:
+		return p, false, fmt.Errorf("invalid sort (expected id or timestamp)")
+	}
+	switch p.Order {
+	case "", store.OrderAsc, store.OrderDesc:
+	default:
+		return p, false, fmt.Errorf("invalid order (expected asc or desc)")
+	}
+	if v := strings.TrimSpace(q.Get("include_total")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, false, fmt.Errorf("invalid include_total")
+		}
+		p.IncludeTotal = b
+	}
+	return p, false, nil
+}
+
 func parseDayRange(dateParam string) (float64, float64, error) {
 	var day time.Time
 	if strings.TrimSpace(dateParam) == "" {
@@ -8,9 +8,12 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"ai-json/internal/analyze"
+	"ai-json/internal/config"
 	"ai-json/internal/store"
 )
 
@@ -39,7 +42,7 @@ func TestIngestAndQuery(t *testing.T) {
 		t.Fatalf("ingest status: %d body=%s", rr.Code, rr.Body.String())
 	}
 
-	req2 := httptest.NewRequest(http.MethodGet, "/v1/events?class_ids=class-a&camera_ids=front", nil)
+	req2 := httptest.NewRequest(http.MethodGet, "/v1/events?class_ids=class-a&camera_ids=front&include_total=true", nil)
 	rr2 := httptest.NewRecorder()
 	s.Handler().ServeHTTP(rr2, req2)
 	if rr2.Code != http.StatusOK {
@@ -55,6 +58,177 @@ func TestIngestAndQuery(t *testing.T) {
 	}
 }
 
+func TestEventsCursorPagination(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	var lines []string
+	for i := 0; i < 5; i++ {
+		lines = append(lines, `{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","pipeline":"p1","confidence":0.9,"timestamp":`+strconvI(int64(100-i))+`,"track_id":`+strconvI(int64(i))+`}`)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/ingest/events?class_id=class-a", bytes.NewReader([]byte("["+strings.Join(lines, ",")+"]")))
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ingest status: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	get := func(query string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/events?"+query, nil)
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		var resp map[string]any
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+
+	// Ascending by timestamp walks the events newest-inserted first.
+	var tracks []string
+	query := "sort=timestamp&order=asc&limit=2"
+	for pages := 0; query != ""; pages++ {
+		rr, resp := get(query)
+		if rr.Code != http.StatusOK || pages > 3 {
+			t.Fatalf("page %d: status %d body=%s", pages, rr.Code, rr.Body.String())
+		}
+		if _, ok := resp["total"]; ok {
+			t.Fatalf("total should be opt-in: %v", resp)
+		}
+		for _, ev := range resp["events"].([]any) {
+			tracks = append(tracks, strconvF(ev.(map[string]any)["track_id"].(float64)))
+		}
+		query = ""
+		if next := resp["next_cursor"].(string); next != "" {
+			query = "limit=2&cursor=" + next
+		}
+	}
+	if strings.Join(tracks, ",") != "4,3,2,1,0" {
+		t.Fatalf("unexpected order: %v", tracks)
+	}
+
+	if _, resp := get("include_total=true&limit=1"); resp["total"].(float64) != 5 || resp["order"] != "desc" || resp["prev_cursor"] != "" {
+		t.Fatalf("unexpected first page: %v", resp)
+	}
+	if rr, resp := get("cursor=bogus"); rr.Code != http.StatusBadRequest || resp["error"].(map[string]any)["code"] != "invalid_cursor" {
+		t.Fatalf("expected invalid_cursor, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Offset paging still works as before, newest first with the total.
+	rr, resp := get("limit=2&offset=2")
+	if rr.Code != http.StatusOK || resp["total"].(float64) != 5 || resp["offset"].(float64) != 2 || len(resp["events"].([]any)) != 2 {
+		t.Fatalf("unexpected offset page: %d %s", rr.Code, rr.Body.String())
+	}
+	if track := resp["events"].([]any)[0].(map[string]any)["track_id"].(float64); track != 2 {
+		t.Fatalf("expected track 2 first on the offset page, got %v", track)
+	}
+	if _, ok := resp["next_cursor"]; ok {
+		t.Fatalf("offset pages have no cursors: %v", resp)
+	}
+	_, first := get("limit=1")
+	for _, query := range []string{"offset=2&cursor=" + first["next_cursor"].(string), "offset=2&sort=timestamp", "offset=0&order=asc"} {
+		if rr, resp := get(query); rr.Code != http.StatusBadRequest || resp["error"].(map[string]any)["code"] != "invalid_query" {
+			t.Fatalf("%s: expected invalid_query, got %d %s", query, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestEventsPromotedFieldFilters(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+	if _, err := s.Store.PromoteFields([]store.PromotedField{{Name: "distance", Path: "$.distance", Type: "real"}}); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+
+	body := `[
+		{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","pipeline":"p1","timestamp":10,"posture":"sitting","distance":1.5},
+		{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","pipeline":"p1","timestamp":20,"posture":"standing","distance":3},
+		{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","pipeline":"p2","timestamp":30,"posture":"lying","distance":6}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "/v1/ingest/events?class_id=class-a", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ingest status: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		var resp map[string]any
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+	for query, want := range map[string]int{
+		"field.posture=standing,sitting":            2,
+		"field.posture=lying&field.pipeline=p1":     0,
+		"field.distance.min=2":                      2,
+		"field.distance.min=2&field.distance.max=3": 1,
+		"field.pipeline=p1&field.distance.max=2":    1,
+	} {
+		rr, resp := get("/v1/events?" + query)
+		if rr.Code != http.StatusOK || len(resp["events"].([]any)) != want {
+			t.Fatalf("%s: expected %d events, got %d %s", query, want, rr.Code, rr.Body.String())
+		}
+	}
+	if rr, resp := get("/v1/summary?field.posture=standing"); rr.Code != http.StatusOK || resp["summary"].(map[string]any)["total_events"].(float64) != 1 {
+		t.Fatalf("unexpected summary: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, query := range []string{"field.mood=happy", "field.distance=far", "field.posture.between=a", "field.distance.max="} {
+		rr, resp := get("/v1/events?" + query)
+		if rr.Code != http.StatusBadRequest || resp["error"].(map[string]any)["code"] != "invalid_query" {
+			t.Fatalf("%s: expected invalid_query, got %d %s", query, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestIngestEventsValidation(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	common := `"room_id":"class-a","camera_id":"front","pipeline":"p1","confidence":0.9,"timestamp":1,"frame_timestamp":1,"frame_source_timestamp":1,"emitted_at":1,"timestamp_offset_seconds":0,"timestamp_stabilizer_skew_seconds":0,"frame_age_seconds":0.1,"frame_transport_delay_seconds":0.1`
+	payload := []byte(`{"event_type":"frame_tick",` + common + `,"detections_count":2}
+{"type":"teacher_engagement",` + common + `,"score":2,"person_ids":[7]}`)
+	post := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/ingest/events"+query, bytes.NewReader(payload))
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	type ingestResp struct {
+		Inserted   int                       `json:"inserted"`
+		ErrorCount int                       `json:"error_count"`
+		Issues     []analyze.ValidationIssue `json:"issues"`
+	}
+
+	rr := post("?validate=strict")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("strict status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var strict ingestResp
+	if err := json.Unmarshal(rr.Body.Bytes(), &strict); err != nil {
+		t.Fatalf("decode strict: %v", err)
+	}
+	if strict.ErrorCount != 2 || len(strict.Issues) != 2 || strict.Issues[0].Path != "/person_ids/0" || strict.Issues[1].Path != "/score" || strict.Issues[0].EventIndex != 1 {
+		t.Fatalf("unexpected strict issues: %s", rr.Body.String())
+	}
+	if _, total, err := s.Store.ListEvents(store.EventFilter{Limit: 10}); err != nil || total != 0 {
+		t.Fatalf("strict rejection stored %d events (err %v)", total, err)
+	}
+
+	rr = post("?validate=warn")
+	var warn ingestResp
+	if err := json.Unmarshal(rr.Body.Bytes(), &warn); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("warn status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if warn.Inserted != 2 || warn.ErrorCount != 2 || len(warn.Issues) != 2 {
+		t.Fatalf("unexpected warn response: %s", rr.Body.String())
+	}
+
+	if rr = post("?validate=loud"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid validate, got %d", rr.Code)
+	}
+}
+
 func TestSpecialEventsAndEventImagesAndImageServe(t *testing.T) {
 	root := t.TempDir()
 	classDir := filepath.Join(root, "class-a")
@@ -170,6 +344,446 @@ func TestStudentDailyMetrics(t *testing.T) {
 	}
 }
 
+func TestTracksEndpoint(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+	day := time.Date(2026, 2, 16, 0, 0, 0, 0, time.UTC).Unix()
+	ev := func(eventType, camera string, track int64, offset float64) string {
+		return `{"event_type":"` + eventType + `","room_id":"room1","camera_id":"` + camera + `","pipeline":"p1","confidence":0.9,"timestamp":` + strconvF(float64(day)+offset) + `,"frame_timestamp":1,"frame_source_timestamp":1,"emitted_at":1,"timestamp_offset_seconds":0,"timestamp_stabilizer_skew_seconds":0,"frame_age_seconds":0.1,"frame_transport_delay_seconds":0.1,"track_id":` + strconvI(track) + `,"person_id":"p"}`
+	}
+	payload := []byte("[" + strings.Join([]string{
+		ev("person_detected", "front", 16, 10),
+		ev("person_tracked", "front", 16, 15),
+		ev("person_lost", "front", 16, 20),
+		ev("person_tracked", "front", 16, 100),
+		ev("person_tracked", "back", 16, 12),
+		ev("person_tracked", "front", 5, 10),
+		ev("person_tracked", "front", 16, 86400+10),
+	}, ",") + "]")
+	req := httptest.NewRequest(http.MethodPost, "/v1/ingest/events?class_id=class-a", bytes.NewReader(payload))
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ingest status: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	get := func(query string) (*httptest.ResponseRecorder, []analyze.TrackSummary, int) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/tracks?date=2026-02-16"+query, nil)
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		var resp struct {
+			Total  int                    `json:"total"`
+			Tracks []analyze.TrackSummary `json:"tracks"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp.Tracks, resp.Total
+	}
+
+	rr, tracks, total := get("&class_ids=class-a&track_ids=16")
+	if rr.Code != http.StatusOK || total != 1 || len(tracks) != 1 {
+		t.Fatalf("tracks status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	tr := tracks[0]
+	if tr.ClassID != "class-a" || tr.Events != 5 || tr.LostCount != 1 || tr.ReacquiredCount != 1 || len(tr.Cameras) != 2 || tr.VisibleSeconds != 10 {
+		t.Fatalf("unexpected track: %+v", tr)
+	}
+
+	if _, tracks, total = get("&camera_ids=back"); total != 1 || len(tracks[0].Cameras) != 1 || tracks[0].Cameras[0].CameraID != "back" {
+		t.Fatalf("expected only the back camera, got %+v", tracks)
+	}
+	if _, tracks, total = get("&limit=1&offset=1"); total != 2 || len(tracks) != 1 || tracks[0].TrackID != 16 {
+		t.Fatalf("unexpected page: total %d %+v", total, tracks)
+	}
+	if rr, _, _ = get("&gap_seconds=0"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for gap_seconds=0, got %d", rr.Code)
+	}
+}
+
+func TestPersonsEndpoints(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+	var events []string
+	for ts := 0; ts < 15; ts++ {
+		for _, id := range []string{`"camera_id":"front","global_person_id":3`, `"camera_id":"back","global_person_id":8`} {
+			events = append(events, `{"event_type":"person_tracked","room_id":"class-a",`+id+`,"pipeline":"p1","confidence":0.9,"timestamp":`+strconv.Itoa(ts)+`,"track_id":1,"person_id":"p"}`)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/ingest/events", bytes.NewReader([]byte("["+strings.Join(events, ",")+"]")))
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ingest status: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+	if rr = serve(http.MethodPost, "/v1/persons/reconcile?class_ids=class-a"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"multi_camera_persons": 1`) {
+		t.Fatalf("reconcile status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr = serve(http.MethodPost, "/v1/persons/reconcile?min_score=2"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for min_score=2, got %d", rr.Code)
+	}
+
+	rr = serve(http.MethodGet, "/v1/persons?class_ids=class-a")
+	var list struct {
+		Total   int            `json:"total"`
+		Persons []store.Person `json:"persons"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || list.Total != 1 || len(list.Persons[0].Cameras) != 2 {
+		t.Fatalf("persons status: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = serve(http.MethodGet, "/v1/persons/"+list.Persons[0].ID+"/timeline?limit=4")
+	var timeline struct {
+		Total  int                 `json:"total"`
+		Events []store.EventRecord `json:"events"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &timeline); err != nil || timeline.Total != 30 || len(timeline.Events) != 4 {
+		t.Fatalf("timeline status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	if timeline.Events[0].CameraID == timeline.Events[1].CameraID {
+		t.Fatalf("expected both cameras in the merged timeline: %+v", timeline.Events)
+	}
+	if rr = serve(http.MethodGet, "/v1/persons/class-a:nobody/timeline"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestBackfillEndpoint(t *testing.T) {
+	root := t.TempDir()
+	classDir := filepath.Join(root, "class-a")
+	mustMkdir(t, filepath.Join(classDir, "front", "images"))
+	mustMkdir(t, filepath.Join(classDir, "back", "images"))
+	mustMkdir(t, filepath.Join(classDir, "front", "events"))
+	mustMkdir(t, filepath.Join(classDir, "back", "events"))
+	oldTs := time.Now().Add(-48 * time.Hour).Unix()
+	oldFile := filepath.Join(classDir, "front", "events", strconvI(oldTs)+".json")
+	mustWrite(t, oldFile, []byte(`[{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","timestamp":1}]`))
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(oldFile, past, past); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"class-a","base_dir":"class-a","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	s, cleanup := testServer(t)
+	defer cleanup()
+	s.DefaultStream = cfgPath
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/ingest/backfill?class_ids=class-a&from_ts="+strconvI(oldTs-1)+"&to_ts="+strconvI(oldTs+1), nil)
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("backfill status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var started struct {
+		Job store.BackfillJob `json:"job"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &started); err != nil {
+		t.Fatalf("decode backfill start: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req2 := httptest.NewRequest(http.MethodGet, "/v1/ingest/backfill?job_id="+strconvI(started.Job.ID), nil)
+		rr2 := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr2, req2)
+		if rr2.Code != http.StatusOK {
+			t.Fatalf("backfill status lookup: %d body=%s", rr2.Code, rr2.Body.String())
+		}
+		var got struct {
+			Job store.BackfillJob `json:"job"`
+		}
+		if err := json.Unmarshal(rr2.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decode backfill job: %v", err)
+		}
+		if got.Job.Status == store.BackfillCompleted {
+			if got.Job.InsertedEvents != 1 {
+				t.Fatalf("expected 1 inserted event, got %+v", got.Job)
+			}
+			return
+		}
+		if got.Job.Status == store.BackfillFailed || time.Now().After(deadline) {
+			t.Fatalf("backfill did not complete: %+v", got.Job)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestIngestFailuresEndpoints(t *testing.T) {
+	root := t.TempDir()
+	classDir := filepath.Join(root, "class-a")
+	mustMkdir(t, filepath.Join(classDir, "front", "images"))
+	mustMkdir(t, filepath.Join(classDir, "back", "images"))
+	mustMkdir(t, filepath.Join(classDir, "front", "events"))
+	mustMkdir(t, filepath.Join(classDir, "back", "events"))
+	badFile := filepath.Join(classDir, "front", "events", strconvI(time.Now().Unix())+".json")
+	mustWrite(t, badFile, []byte(`{not json`))
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(badFile, past, past); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"class-a","base_dir":"class-a","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	s, cleanup := testServer(t)
+	defer cleanup()
+	s.DefaultStream = cfgPath
+	s.DefaultMaxPastAge = time.Hour
+	s.MaxFileAttempts = 1
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/ingest/stream", nil)
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("stream ingest status: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/ingest/failures?status=quarantined", nil)
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failures status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Failures []store.IngestFailure `json:"failures"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode failures: %v", err)
+	}
+	if len(listed.Failures) != 1 || listed.Failures[0].Error == "" {
+		t.Fatalf("expected one quarantined failure, got %+v", listed.Failures)
+	}
+
+	mustWrite(t, badFile, []byte(`[{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","timestamp":1}]`))
+	req = httptest.NewRequest(http.MethodPost, "/v1/ingest/failures/"+strconvI(listed.Failures[0].ID)+"/retry", nil)
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("retry status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var retried map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &retried); err != nil {
+		t.Fatalf("decode retry: %v", err)
+	}
+	if retried["inserted"].(float64) != 1 || retried["failure"] != nil {
+		t.Fatalf("unexpected retry response: %v", retried)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/ingest/failures/999/retry", nil)
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown failure, got %d", rr.Code)
+	}
+}
+
+func TestFileActionsAndEventImagesSource(t *testing.T) {
+	root := t.TempDir()
+	classDir := filepath.Join(root, "class-a")
+	mustMkdir(t, filepath.Join(classDir, "front", "images"))
+	mustMkdir(t, filepath.Join(classDir, "back", "images"))
+	mustMkdir(t, filepath.Join(classDir, "front", "events"))
+	mustMkdir(t, filepath.Join(classDir, "back", "events"))
+	nowTs := time.Now().Unix()
+	eventFile := filepath.Join(classDir, "front", "events", strconvI(nowTs)+".json")
+	mustWrite(t, eventFile, []byte(`[{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","timestamp":`+strconvI(nowTs)+`}]`))
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(eventFile, past, past); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"class-a","base_dir":"class-a","cameras":[
+		{"id":"front","after_ingest":{"action":"move","archive_dir":"archive","grace_seconds":0}},{"id":"back"}]}]}`))
+
+	s, cleanup := testServer(t)
+	defer cleanup()
+	s.DefaultStream = cfgPath
+	s.DefaultMaxPastAge = time.Hour
+
+	// The file is moved on the pass after the one that ingested it.
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/ingest/stream", nil)
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("stream ingest status: %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/ingest/file-actions?action=move", nil)
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("file actions status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var listed struct {
+		Actions []store.FileAction `json:"actions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &listed); err != nil {
+		t.Fatalf("decode file actions: %v", err)
+	}
+	if len(listed.Actions) != 1 || listed.Actions[0].Path != eventFile || listed.Actions[0].DestPath == "" {
+		t.Fatalf("expected one move action for %s, got %+v", eventFile, listed.Actions)
+	}
+
+	events, _, err := s.Store.ListEvents(store.EventFilter{})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d err=%v", len(events), err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/v1/event-images?event_id="+strconvI(events[0].ID)+"&stream_path="+cfgPath, nil)
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("event images status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var images struct {
+		SourceFile       string            `json:"source_file"`
+		SourceFileAction *store.FileAction `json:"source_file_action"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &images); err != nil {
+		t.Fatalf("decode event images: %v", err)
+	}
+	if images.SourceFile != eventFile || images.SourceFileAction == nil || images.SourceFileAction.DestPath != listed.Actions[0].DestPath {
+		t.Fatalf("unexpected source file info: %+v", images)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/ingest/file-actions?action=rename", nil)
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown action, got %d", rr.Code)
+	}
+}
+
+func TestIngestRunsAndStatus(t *testing.T) {
+	root := t.TempDir()
+	classDir := filepath.Join(root, "class-a")
+	mustMkdir(t, filepath.Join(classDir, "front", "images"))
+	mustMkdir(t, filepath.Join(classDir, "back", "images"))
+	mustMkdir(t, filepath.Join(classDir, "front", "events"))
+	mustMkdir(t, filepath.Join(classDir, "back", "events"))
+	epoch := time.Now().Add(-10 * time.Second).Unix()
+	file := filepath.Join(classDir, "front", "events", strconvI(epoch)+".json")
+	mustWrite(t, file, []byte(`[{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","timestamp":1}]`))
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(file, past, past); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"class-a","base_dir":"class-a","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	s, cleanup := testServer(t)
+	defer cleanup()
+	s.DefaultStream = cfgPath
+	s.DefaultMaxPastAge = time.Hour
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/ingest/stream", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("stream ingest status: %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/ingest/runs?trigger=api", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("runs status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var runs struct {
+		Runs []store.IngestRun `json:"runs"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &runs); err != nil {
+		t.Fatalf("decode runs: %v", err)
+	}
+	if len(runs.Runs) != 1 || runs.Runs[0].Status != store.RunSucceeded {
+		t.Fatalf("expected one successful api run, got %+v", runs.Runs)
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/ingest/status", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var status struct {
+		LastSuccess *store.IngestRun           `json:"last_success"`
+		Cameras     []store.CameraIngestStatus `json:"cameras"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode status: %v", err)
+	}
+	if status.LastSuccess == nil || len(status.Cameras) != 2 {
+		t.Fatalf("unexpected status: %s", rr.Body.String())
+	}
+	for _, c := range status.Cameras {
+		if c.CameraID == "front" && (c.NewestFileEpoch != epoch || c.LagSeconds == nil || *c.LagSeconds < 10) {
+			t.Fatalf("unexpected front camera status: %+v", c)
+		}
+		if c.LastSuccessRunID != runs.Runs[0].ID {
+			t.Fatalf("expected last success run %d, got %+v", runs.Runs[0].ID, c)
+		}
+	}
+}
+
+func TestStreamConfigEndpoints(t *testing.T) {
+	s, cleanup := testServer(t)
+	defer cleanup()
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/config/stream", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a config manager, got %d", rr.Code)
+	}
+
+	root := t.TempDir()
+	for _, cls := range []string{"class-a", "class-b"} {
+		mustMkdir(t, filepath.Join(root, cls, "front", "images"))
+		mustMkdir(t, filepath.Join(root, cls, "back", "images"))
+		mustMkdir(t, filepath.Join(root, cls, "front", "events"))
+		mustMkdir(t, filepath.Join(root, cls, "back", "events"))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"class-a","base_dir":"class-a","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+	s.DefaultStream = cfgPath
+	s.Config = config.NewManager(cfgPath)
+
+	mustWrite(t, cfgPath, []byte(`{"classes":[]}`))
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/config/reload", nil))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "stream_config_invalid") {
+		t.Fatalf("expected 422 for invalid config, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/config/stream", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("config status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	var st config.Status
+	if err := json.Unmarshal(rr.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode config: %v", err)
+	}
+	if st.Version != 1 || st.LastError == "" || st.Stream == nil || st.Stream.Classes[0].ClassID != "class-a" {
+		t.Fatalf("expected previous config with last error, got %+v", st)
+	}
+
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"class-b","base_dir":"class-b","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/config/reload", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reload status: %d body=%s", rr.Code, rr.Body.String())
+	}
+	st = config.Status{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode reload: %v", err)
+	}
+	if st.Version != 2 || st.LastError != "" || st.Stream.Classes[0].ClassID != "class-b" {
+		t.Fatalf("expected reloaded config version 2, got %+v", st)
+	}
+}
+
 func testServer(t *testing.T) (*Server, func()) {
 	t.Helper()
 	dbPath := filepath.Join(t.TempDir(), "api.db")
@@ -0,0 +1,204 @@
+// Package config keeps the resolved stream configuration in memory and
+// reloads it when stream.json changes, keeping the last version that
+// validated.
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ai-json/internal/fswatch"
+	"ai-json/internal/input"
+)
+
+// reloadDebounce delays a reload after a change notification so editors that
+// write in several steps are read once they are done.
+const reloadDebounce = 200 * time.Millisecond
+
+// Manager holds the last-known-good ResolvedStream for one stream config
+// path. A change that fails validation is rejected and the previous version
+// stays in use.
+type Manager struct {
+	path string
+
+	mu          sync.RWMutex
+	current     input.ResolvedStream
+	loaded      bool
+	version     int64
+	loadedAt    time.Time
+	lastErr     error
+	lastErrAt   time.Time
+	lastModTime time.Time
+	lastSize    int64
+}
+
+// Status describes the manager state for API responses.
+type Status struct {
+	Path        string                `json:"path"`
+	Version     int64                 `json:"version"`
+	LoadedAt    string                `json:"loaded_at,omitempty"`
+	LastError   string                `json:"last_error,omitempty"`
+	LastErrorAt string                `json:"last_error_at,omitempty"`
+	Stream      *input.ResolvedStream `json:"stream"`
+}
+
+// NewManager loads path once. A failing initial load is not fatal: the error
+// is kept and Current reports it until a valid version is loaded.
+func NewManager(path string) *Manager {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	m := &Manager{path: abs}
+	_ = m.Reload()
+	return m
+}
+
+// Path returns the absolute config path.
+func (m *Manager) Path() string { return m.path }
+
+// Current returns the active config, or the load error when no version has
+// validated yet.
+func (m *Manager) Current() (input.ResolvedStream, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.loaded {
+		return input.ResolvedStream{}, m.lastErr
+	}
+	return m.current, nil
+}
+
+// Version increases every time a new config is swapped in.
+func (m *Manager) Version() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.version
+}
+
+// Reload resolves and validates the file and swaps it in on success. On
+// failure the previous config stays active and the validation error is
+// returned and remembered.
+func (m *Manager) Reload() error {
+	info, statErr := os.Stat(m.path)
+	resolved, err := input.ResolveStreamConfig(m.path)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if statErr == nil {
+		m.lastModTime = info.ModTime()
+		m.lastSize = info.Size()
+	}
+	if err != nil {
+		m.lastErr = err
+		m.lastErrAt = time.Now()
+		return err
+	}
+	m.current = resolved
+	m.loaded = true
+	m.version++
+	m.loadedAt = time.Now()
+	m.lastErr = nil
+	m.lastErrAt = time.Time{}
+	return nil
+}
+
+// Status returns a snapshot of the manager state.
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	st := Status{Path: m.path, Version: m.version}
+	if m.loaded {
+		cur := m.current
+		st.Stream = &cur
+		st.LoadedAt = m.loadedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if m.lastErr != nil {
+		st.LastError = m.lastErr.Error()
+		st.LastErrorAt = m.lastErrAt.UTC().Format(time.RFC3339Nano)
+	}
+	return st
+}
+
+// Watch reloads the config whenever the file changes until ctx is done. It
+// watches the parent directory so editors that replace the file by rename
+// are noticed, and polls the file every pollInterval when notifications are
+// unavailable. onReload, when set, receives the result of every reload.
+func (m *Manager) Watch(ctx context.Context, pollInterval time.Duration, onReload func(error)) error {
+	report := func(err error) {
+		if onReload != nil {
+			onReload(err)
+		}
+	}
+	fw, err := fswatch.New([]string{filepath.Dir(m.path)})
+	if errors.Is(err, fswatch.ErrUnsupported) {
+		return m.poll(ctx, pollInterval, report)
+	}
+	if err != nil {
+		return fmt.Errorf("watch stream config: %w", err)
+	}
+
+	changed := make(chan struct{}, 1)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- fw.Run(ctx, func(path string) {
+			if filepath.Clean(path) != m.path {
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watchErr:
+			return err
+		case <-changed:
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(reloadDebounce):
+			}
+			// Drop notifications that arrived while debouncing.
+			select {
+			case <-changed:
+			default:
+			}
+			report(m.Reload())
+		}
+	}
+}
+
+func (m *Manager) poll(ctx context.Context, interval time.Duration, report func(error)) error {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			info, err := os.Stat(m.path)
+			if err != nil {
+				continue
+			}
+			m.mu.RLock()
+			same := info.ModTime().Equal(m.lastModTime) && info.Size() == m.lastSize
+			m.mu.RUnlock()
+			if !same {
+				report(m.Reload())
+			}
+		}
+	}
+}
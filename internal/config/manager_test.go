@@ -0,0 +1,97 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const validConfig = `{"classes":[{"class_id":"class-a","base_dir":"class-a","cameras":[{"id":"front"},{"id":"back"}]}]}`
+
+func TestReloadRejectsInvalidConfigAndKeepsPrevious(t *testing.T) {
+	root := t.TempDir()
+	mustClassDirs(t, root, "class-a", "class-b")
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, validConfig)
+
+	m := NewManager(cfgPath)
+	cur, err := m.Current()
+	if err != nil {
+		t.Fatalf("current: %v", err)
+	}
+	if m.Version() != 1 || len(cur.Classes) != 1 {
+		t.Fatalf("unexpected initial state version=%d classes=%d", m.Version(), len(cur.Classes))
+	}
+
+	// Missing the back camera.
+	mustWrite(t, cfgPath, `{"classes":[{"class_id":"class-a","base_dir":"class-a","cameras":[{"id":"front"}]}]}`)
+	if err := m.Reload(); err == nil {
+		t.Fatalf("expected invalid config to be rejected")
+	}
+	cur, err = m.Current()
+	if err != nil || cur.Classes[0].ClassID != "class-a" || m.Version() != 1 {
+		t.Fatalf("expected previous config to stay active, version=%d err=%v", m.Version(), err)
+	}
+	if st := m.Status(); st.LastError == "" || st.Stream == nil {
+		t.Fatalf("expected status to report the rejected edit: %+v", st)
+	}
+
+	mustWrite(t, cfgPath, `{"classes":[{"class_id":"class-b","base_dir":"class-b","cameras":[{"id":"front"},{"id":"back"}]}]}`)
+	if err := m.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	cur, _ = m.Current()
+	if cur.Classes[0].ClassID != "class-b" || m.Version() != 2 || m.Status().LastError != "" {
+		t.Fatalf("expected new config version 2, got %s version=%d", cur.Classes[0].ClassID, m.Version())
+	}
+}
+
+func TestWatchReloadsChangedConfig(t *testing.T) {
+	root := t.TempDir()
+	mustClassDirs(t, root, "class-a", "class-b")
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, validConfig)
+	m := NewManager(cfgPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloaded := make(chan error, 8)
+	go func() { _ = m.Watch(ctx, 50*time.Millisecond, func(err error) { reloaded <- err }) }()
+	time.Sleep(100 * time.Millisecond)
+
+	mustWrite(t, cfgPath, `{"classes":[{"class_id":"class-b","base_dir":"class-b","cameras":[{"id":"front"},{"id":"back"}]}]}`)
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("config change was not picked up")
+	}
+	cur, _ := m.Current()
+	if cur.Classes[0].ClassID != "class-b" {
+		t.Fatalf("expected class-b, got %s", cur.Classes[0].ClassID)
+	}
+}
+
+func mustClassDirs(t *testing.T, root string, classes ...string) {
+	t.Helper()
+	for _, cls := range classes {
+		for _, cam := range []string{"front", "back"} {
+			for _, sub := range []string{"images", "events"} {
+				if err := os.MkdirAll(filepath.Join(root, cls, cam, sub), 0o755); err != nil {
+					t.Fatalf("mkdir: %v", err)
+				}
+			}
+		}
+	}
+}
+
+func mustWrite(t *testing.T, path, s string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,9 @@
+// Package fswatch reports files that appear in a set of directories using the
+// platform's filesystem notification API.
+package fswatch
+
+import "errors"
+
+// ErrUnsupported is returned by New on platforms without a notification
+// backend. Callers are expected to fall back to polling.
+var ErrUnsupported = errors.New("filesystem notifications are not supported on this platform")
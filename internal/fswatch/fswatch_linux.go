@@ -0,0 +1,113 @@
+//go:build linux
+
+package fswatch
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const pollTimeoutMillis = 250
+
+// Watcher delivers inotify create, modify, close-write and moved-to
+// notifications for a fixed set of directories. Modify events let appends to
+// files that stay open (tailed NDJSON) be noticed without waiting for close.
+// A watched directory that is removed or moved away, or a kernel queue
+// overflow, ends Run with an error since notifications were lost.
+type Watcher struct {
+	fd      int
+	byWatch map[int32]string
+}
+
+// New registers dirs with inotify. Notifications are queued by the kernel
+// from this point on, so callers can run a catch-up scan after New returns
+// without missing files created in between.
+func New(dirs []string) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	w := &Watcher{fd: fd, byWatch: make(map[int32]string, len(dirs))}
+	for _, dir := range dirs {
+		wd, err := unix.InotifyAddWatch(fd, dir, unix.IN_CREATE|unix.IN_MODIFY|unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF)
+		if err != nil {
+			_ = unix.Close(fd)
+			return nil, fmt.Errorf("watch %s: %w", dir, err)
+		}
+		w.byWatch[int32(wd)] = dir
+	}
+	return w, nil
+}
+
+// Run calls fn with the full path of every regular file reported until ctx
+// is done, then releases the inotify descriptor. It returns nil on
+// cancellation and an error once a watched directory is gone or the kernel
+// dropped notifications.
+func (w *Watcher) Run(ctx context.Context, fn func(path string)) error {
+	defer w.Close()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ready, err := unix.Poll(fds, pollTimeoutMillis)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("poll inotify: %w", err)
+		}
+		if ready == 0 {
+			continue
+		}
+		n, err := unix.Read(w.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read inotify: %w", err)
+		}
+
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + unix.SizeofInotifyEvent
+			end := start + int(ev.Len)
+			off = end
+			if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+				return fmt.Errorf("inotify queue overflowed")
+			}
+			if ev.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0 {
+				return fmt.Errorf("watched directory %s was removed or moved", w.byWatch[ev.Wd])
+			}
+			if ev.Mask&unix.IN_ISDIR != 0 || ev.Len == 0 || end > n {
+				continue
+			}
+			dir, ok := w.byWatch[ev.Wd]
+			if !ok {
+				continue
+			}
+			name := strings.TrimRight(string(buf[start:end]), "\x00")
+			if name == "" {
+				continue
+			}
+			fn(filepath.Join(dir, name))
+		}
+	}
+}
+
+// Close releases the inotify descriptor. It is safe to call more than once.
+func (w *Watcher) Close() error {
+	if w.fd < 0 {
+		return nil
+	}
+	err := unix.Close(w.fd)
+	w.fd = -1
+	return err
+}
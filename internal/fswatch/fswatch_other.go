@@ -0,0 +1,19 @@
+//go:build !linux
+
+package fswatch
+
+import "context"
+
+// Watcher is a placeholder on platforms without a notification backend.
+type Watcher struct{}
+
+// New always fails with ErrUnsupported on this platform.
+func New(dirs []string) (*Watcher, error) {
+	return nil, ErrUnsupported
+}
+
+func (w *Watcher) Run(ctx context.Context, fn func(path string)) error {
+	return ErrUnsupported
+}
+
+func (w *Watcher) Close() error { return nil }
@@ -0,0 +1,135 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"ai-json/internal/input"
+	"ai-json/internal/model"
+	"ai-json/internal/store"
+)
+
+// memberFilter decides whether a pass ingests an archive member. final
+// reports that a rejected member will never be wanted by a later pass, so it
+// does not keep the archive from being recorded as ingested.
+type memberFilter func(member string) (keep, final bool)
+
+// prepareArchive is the read/parse stage for a tar or zip bundle. Every event
+// member is stored as its own virtual file (<archive>!/<member>) with its own
+// ingested_files row, so a member already stored with the same size and
+// mtime is skipped and a malformed member is retried or quarantined on its
+// own. Members rejected by filter are skipped. The archive itself is recorded
+// in ingested_files once no member failed or was rejected for now only, so
+// later passes skip it without opening it; force bypasses that check.
+//
+// Members are decoded while they are stored, one event at a time, so neither
+// the bundle nor a whole member is held in memory. That makes reading part of
+// the returned write, which runs on the writer.
+func (r *Runner) prepareArchive(classID, cameraID, file string, info os.FileInfo, filter memberFilter, force bool, stats *RunStats) (fileWrite, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, nil
+	}
+	if !force {
+		should, err := r.Store.ShouldIngestFile(absFile, info.Size(), info.ModTime().Unix())
+		if err != nil {
+			return nil, err
+		}
+		if !should {
+			stats.SkippedFiles++
+			return nil, nil
+		}
+	}
+	failure, failed, err := r.Store.GetIngestFailureByPath(absFile)
+	if err != nil {
+		return nil, err
+	}
+	if failed && failure.Status == store.FailureQuarantined && failure.SizeBytes == info.Size() && failure.ModUnix == info.ModTime().Unix() {
+		stats.SkippedFiles++
+		return nil, nil
+	}
+
+	return func() error {
+		failedBefore := stats.FailedFiles
+		complete := true
+		// Store errors stop the walk; read and decode errors only fail the
+		// member.
+		var storeErr error
+		err := input.WalkEventSources(absFile, func(m input.EventSource, rd io.Reader) error {
+			if filter != nil {
+				if keep, final := filter(m.Path); !keep {
+					stats.SkippedFiles++
+					complete = complete && final
+					return nil
+				}
+			}
+			storeErr = r.ingestMember(classID, cameraID, m, rd, stats)
+			return storeErr
+		})
+		if storeErr != nil {
+			return storeErr
+		}
+		if err != nil {
+			return r.recordFailure(classID, cameraID, absFile, info, fmt.Errorf("read %s: %w", absFile, err), stats)
+		}
+		if failed {
+			if err := r.Store.ClearIngestFailure(absFile); err != nil {
+				return err
+			}
+		}
+		if !complete || stats.FailedFiles > failedBefore {
+			return nil
+		}
+		return r.Store.MarkFileIngested(absFile, info.Size(), info.ModTime().Unix())
+	}, nil
+}
+
+// ingestMember streams one archive member from rd into the store. It skips
+// members already stored or quarantined, and records a read or decode error
+// as a failure of the member; only store errors are returned.
+func (r *Runner) ingestMember(classID, cameraID string, m input.EventSource, rd io.Reader, stats *RunStats) error {
+	should, err := r.Store.ShouldIngestFile(m.Path, m.Info.Size(), m.Info.ModTime().Unix())
+	if err != nil {
+		return err
+	}
+	if !should {
+		stats.SkippedFiles++
+		return nil
+	}
+	failure, failed, err := r.Store.GetIngestFailureByPath(m.Path)
+	if err != nil {
+		return err
+	}
+	if failed && failure.Status == store.FailureQuarantined && failure.SizeBytes == m.Info.Size() && failure.ModUnix == m.Info.ModTime().Unix() {
+		stats.SkippedFiles++
+		return nil
+	}
+
+	var storeErr error
+	res, err := r.Store.IngestFileStreamContext(context.Background(), m.Path, m.Info.Size(), m.Info.ModTime().Unix(), func(yield func(model.Event) error) error {
+		return model.DecodeEvents(rd, func(ev model.Event) error {
+			ev.Raw["stream_class_id"] = classID
+			ev.Raw["stream_camera_id"] = cameraID
+			storeErr = yield(ev)
+			return storeErr
+		})
+	})
+	if storeErr != nil {
+		return fmt.Errorf("insert from %s: %w", m.Path, storeErr)
+	}
+	if err != nil {
+		return r.recordFailure(classID, cameraID, m.Path, m.Info, fmt.Errorf("parse %s: %w", m.Path, err), stats)
+	}
+	if failed {
+		if err := r.Store.ClearIngestFailure(m.Path); err != nil {
+			return err
+		}
+	}
+	stats.ProcessedFiles++
+	stats.InsertedEvents += res.Inserted
+	stats.DuplicateEvents += res.Duplicates
+	return nil
+}
@@ -0,0 +1,183 @@
+package ingest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-json/internal/input"
+	"ai-json/internal/store"
+)
+
+func TestArchiveMembersAreIngestedAsVirtualFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "a", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "a", cam, "events"))
+	}
+	now := time.Now().Unix()
+	e := `[{"event_type":"person_tracked","camera_id":"front","timestamp":1,"emitted_at":1}]`
+	members := []struct {
+		name string
+		body []byte
+	}{
+		{strconv.FormatInt(now-10, 10) + ".json", withTimestamp(e, now-10)},
+		{strconv.FormatInt(now-5, 10) + ".json", []byte(`[{"event_type":`)},
+		{strconv.FormatInt(now-7200, 10) + ".json", withTimestamp(e, now-7200)},
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, m := range members {
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0o644, Size: int64(len(m.body)), ModTime: time.Unix(now-60, 0), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("tar header: %v", err)
+		}
+		_, _ = tw.Write(m.body)
+	}
+	_ = tw.Close()
+	_ = gw.Close()
+	archive := filepath.Join(root, "a", "front", "events", "bundle.tar.gz")
+	mustWrite(t, archive, buf.Bytes())
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"a","cameras":[{"id":"front","file_pattern":"*.tar.gz"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Hour, MaxFileAttempts: 1}
+
+	// The old member is outside the max-past window and the malformed one is
+	// quarantined straight away.
+	stats := mustRun(t, r)
+	if stats.ProcessedFiles != 1 || stats.InsertedEvents != 1 || stats.QuarantinedFiles != 1 || stats.SkippedFiles != 1 {
+		t.Fatalf("unexpected first pass: %+v", stats)
+	}
+	events, _, err := st.ListEvents(store.EventFilter{})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d err=%v", len(events), err)
+	}
+	if events[0].SourceFile != input.MemberPath(archive, members[0].name) {
+		t.Fatalf("expected member source path, got %s", events[0].SourceFile)
+	}
+	failures, err := st.ListIngestFailures(store.FailureQuarantined, 10)
+	if err != nil || len(failures) != 1 || !strings.HasSuffix(failures[0].Path, input.MemberSeparator+members[1].name) {
+		t.Fatalf("expected quarantined member failure, got %+v err=%v", failures, err)
+	}
+
+	// Every member is settled, so the archive is recorded as ingested.
+	stats = mustRun(t, r)
+	if stats.ProcessedFiles != 0 || stats.FailedFiles != 0 {
+		t.Fatalf("unexpected second pass: %+v", stats)
+	}
+	stats = mustRun(t, r)
+	if stats.SkippedFiles != 1 || stats.ProcessedFiles != 0 {
+		t.Fatalf("expected the unchanged archive to be skipped whole, got %+v", stats)
+	}
+}
+
+func TestBackfillIngestsArchiveSkippedAsTooOld(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "a", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "a", cam, "events"))
+	}
+	old := time.Now().Unix() - 7200
+	body := withTimestamp(`[{"event_type":"person_tracked","camera_id":"front","timestamp":1,"emitted_at":1}]`, old)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: strconv.FormatInt(old, 10) + ".json", Mode: 0o644, Size: int64(len(body)), ModTime: time.Unix(old, 0), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("tar header: %v", err)
+	}
+	_, _ = tw.Write(body)
+	_ = tw.Close()
+	_ = gw.Close()
+	mustWrite(t, filepath.Join(root, "a", "front", "events", "bundle.tar.gz"), buf.Bytes())
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"a","cameras":[{"id":"front","file_pattern":"*.tar.gz"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Minute}
+
+	// The regular pass rejects the only member as too old for good and
+	// records the archive as ingested.
+	if stats := mustRun(t, r); stats.InsertedEvents != 0 || stats.SkippedFiles != 1 {
+		t.Fatalf("unexpected regular pass: %+v", stats)
+	}
+
+	job, err := r.StartBackfill(BackfillRequest{})
+	if err != nil {
+		t.Fatalf("start backfill: %v", err)
+	}
+	job, err = r.RunBackfill(job.ID, nil)
+	if err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+	if job.Status != store.BackfillCompleted || job.InsertedEvents != 1 || job.ProcessedFiles != 1 {
+		t.Fatalf("expected the backfill to ingest the old member, got %+v", job)
+	}
+
+	// A second backfill finds the member stored.
+	job, err = r.StartBackfill(BackfillRequest{})
+	if err != nil {
+		t.Fatalf("start backfill: %v", err)
+	}
+	if job, err = r.RunBackfill(job.ID, nil); err != nil || job.InsertedEvents != 0 || job.ProcessedFiles != 0 {
+		t.Fatalf("expected nothing new on a second backfill, got %+v err=%v", job, err)
+	}
+}
+
+func TestArchiveMemberFailingMidStreamStoresNothing(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "a", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "a", cam, "events"))
+	}
+	now := time.Now().Unix()
+	name := strconv.FormatInt(now-10, 10) + ".json"
+	body := []byte(`[{"event_type":"a","timestamp":1},{"event_type":"a","timestamp":2},{"event_type":`)
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), ModTime: time.Unix(now-60, 0), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("tar header: %v", err)
+	}
+	_, _ = tw.Write(body)
+	_ = tw.Close()
+	_ = gw.Close()
+	archive := filepath.Join(root, "a", "front", "events", "bundle.tar.gz")
+	mustWrite(t, archive, buf.Bytes())
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"a","cameras":[{"id":"front","file_pattern":"*.tar.gz"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Hour, MaxFileAttempts: 3}
+
+	// The events decoded before the error are rolled back with the member.
+	stats := mustRun(t, r)
+	if stats.FailedFiles != 1 || stats.InsertedEvents != 0 || stats.ProcessedFiles != 0 {
+		t.Fatalf("expected a failed member, got %+v", stats)
+	}
+	if _, total, err := st.ListEvents(store.EventFilter{}); err != nil || total != 0 {
+		t.Fatalf("expected no stored events, got %d err=%v", total, err)
+	}
+	failures, err := st.ListIngestFailures(store.FailureRetrying, 10)
+	if err != nil || len(failures) != 1 || failures[0].Path != input.MemberPath(archive, name) {
+		t.Fatalf("expected a member failure, got %+v err=%v", failures, err)
+	}
+}
@@ -0,0 +1,293 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"ai-json/internal/input"
+	"ai-json/internal/store"
+)
+
+const defaultBackfillChunkSize = 100
+
+// BackfillRequest selects historical event files by class, camera and
+// filename epoch. Empty ID lists select everything; a zero bound leaves that
+// side of the range open. Files without an epoch filename are only included
+// when both bounds are open.
+type BackfillRequest struct {
+	ClassIDs  []string
+	CameraIDs []string
+	FromEpoch int64
+	ToEpoch   int64
+	ChunkSize int
+}
+
+type backfillFile struct {
+	ClassID  string
+	CameraID string
+	Mode     string
+	Path     string
+}
+
+// StartBackfill validates req and records a new backfill job for r's stream
+// config. Call RunBackfill to process it.
+func (r *Runner) StartBackfill(req BackfillRequest) (store.BackfillJob, error) {
+	if err := r.applyDefaults(); err != nil {
+		return store.BackfillJob{}, err
+	}
+	if req.FromEpoch < 0 || req.ToEpoch < 0 {
+		return store.BackfillJob{}, fmt.Errorf("from/to epoch must be >= 0")
+	}
+	if req.FromEpoch > 0 && req.ToEpoch > 0 && req.FromEpoch > req.ToEpoch {
+		return store.BackfillJob{}, fmt.Errorf("from epoch must be <= to epoch")
+	}
+	if req.ChunkSize <= 0 {
+		req.ChunkSize = defaultBackfillChunkSize
+	}
+	absStream, err := filepath.Abs(r.StreamPath)
+	if err != nil {
+		return store.BackfillJob{}, fmt.Errorf("resolve stream config path: %w", err)
+	}
+	return r.Store.CreateBackfillJob(store.BackfillJob{
+		StreamPath: absStream,
+		ClassIDs:   req.ClassIDs,
+		CameraIDs:  req.CameraIDs,
+		FromEpoch:  req.FromEpoch,
+		ToEpoch:    req.ToEpoch,
+		ChunkSize:  req.ChunkSize,
+		Status:     store.BackfillRunning,
+	})
+}
+
+// RunBackfill processes (or resumes) the job with the given id. MaxPastAge is
+// ignored; MinFileAge still guards against half-written files. Progress is
+// persisted and reported through progress after every chunk. Files already
+// ingested with the same size and mtime are skipped, so re-running a job is
+// safe. The cursor never moves past a file skipped for being younger than
+// MinFileAge: later files are still ingested, but the job then ends failed
+// and resuming it retries from that file.
+func (r *Runner) RunBackfill(jobID int64, progress func(store.BackfillJob)) (store.BackfillJob, error) {
+	return r.RunBackfillContext(context.Background(), jobID, progress)
+}
+
+// RunBackfillContext is RunBackfill with cancellation. It stops between
+// files once ctx is done and marks the job failed with ctx.Err(); files stored
+// before that are skipped when the job is resumed.
+func (r *Runner) RunBackfillContext(ctx context.Context, jobID int64, progress func(store.BackfillJob)) (store.BackfillJob, error) {
+	if err := r.applyDefaults(); err != nil {
+		return store.BackfillJob{}, err
+	}
+	job, err := r.Store.GetBackfillJob(jobID)
+	if err != nil {
+		return job, fmt.Errorf("load backfill job %d: %w", jobID, err)
+	}
+	if job.Status == store.BackfillCompleted {
+		return job, nil
+	}
+	started := time.Now()
+	total := RunStats{}
+	fail := func(err error) (store.BackfillJob, error) {
+		job.Status = store.BackfillFailed
+		job.Error = err.Error()
+		if uerr := r.Store.UpdateBackfillJob(&job); uerr != nil {
+			return job, fmt.Errorf("%v (and %v)", err, uerr)
+		}
+		if rerr := r.recordRun(store.TriggerBackfill, started, total, err); rerr != nil {
+			return job, fmt.Errorf("%v (and %v)", err, rerr)
+		}
+		if progress != nil {
+			progress(job)
+		}
+		return job, err
+	}
+
+	files, err := backfillFiles(job)
+	if err != nil {
+		return fail(err)
+	}
+	remaining := files
+	if job.Cursor != "" {
+		remaining = remaining[sort.Search(len(remaining), func(i int) bool { return remaining[i].Path > job.Cursor }):]
+	}
+	job.Status = store.BackfillRunning
+	job.Error = ""
+	job.TotalFiles = len(files)
+	job.DoneFiles = len(files) - len(remaining)
+	if err := r.Store.UpdateBackfillJob(&job); err != nil {
+		return job, err
+	}
+
+	// young is the index in remaining of the first file skipped for
+	// MinFileAge, -1 while there is none.
+	young, youngCount := -1, 0
+	for start := 0; start < len(remaining); start += job.ChunkSize {
+		end := min(start+job.ChunkSize, len(remaining))
+		stats := RunStats{}
+		now := time.Now()
+		for i, f := range remaining[start:end] {
+			if err := ctx.Err(); err != nil {
+				job.ProcessedFiles += stats.ProcessedFiles
+				job.InsertedEvents += stats.InsertedEvents
+				job.SkippedFiles += stats.SkippedFiles
+				total.add(stats)
+				return fail(err)
+			}
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				stats.SkippedFiles++
+				continue
+			}
+			ingest := r.ingestFile
+			switch {
+			case f.Mode == input.ModeTail && !input.IsCompressed(f.Path) && !input.IsArchive(f.Path):
+				ingest = r.tailFile
+			case now.Sub(info.ModTime()) < r.MinFileAge:
+				stats.SkippedFiles++
+				if young < 0 {
+					young = start + i
+				}
+				youngCount++
+				continue
+			case input.IsArchive(f.Path):
+				// A regular pass may have recorded the archive as ingested
+				// after rejecting its members for the max-past window, so
+				// its members are always checked; those already stored are
+				// skipped one by one.
+				ingest = func(classID, cameraID, file string, info os.FileInfo, stats *RunStats) error {
+					write, err := r.prepareArchive(classID, cameraID, file, info, jobRange(job), true, stats)
+					if err != nil || write == nil {
+						return err
+					}
+					return write()
+				}
+			}
+			if err := ingest(f.ClassID, f.CameraID, f.Path, info, &stats); err != nil {
+				job.ProcessedFiles += stats.ProcessedFiles
+				job.InsertedEvents += stats.InsertedEvents
+				job.SkippedFiles += stats.SkippedFiles
+				total.add(stats)
+				return fail(err)
+			}
+		}
+		total.add(stats)
+		job.ProcessedFiles += stats.ProcessedFiles
+		job.InsertedEvents += stats.InsertedEvents
+		job.SkippedFiles += stats.SkippedFiles
+		switch {
+		case young < 0:
+			job.DoneFiles += end - start
+			job.Cursor = remaining[end-1].Path
+		case young >= start:
+			// The cursor stops before the first young file.
+			job.DoneFiles += young - start
+			if young > 0 {
+				job.Cursor = remaining[young-1].Path
+			}
+		}
+		if err := r.Store.UpdateBackfillJob(&job); err != nil {
+			return job, err
+		}
+		if progress != nil {
+			progress(job)
+		}
+	}
+
+	if youngCount > 0 {
+		return fail(fmt.Errorf("%d files younger than min file age were not ingested; resume the job to retry from %s", youngCount, remaining[young].Path))
+	}
+	job.Status = store.BackfillCompleted
+	if err := r.Store.UpdateBackfillJob(&job); err != nil {
+		return job, err
+	}
+	if err := r.recordRun(store.TriggerBackfill, started, total, nil); err != nil {
+		return job, err
+	}
+	if progress != nil {
+		progress(job)
+	}
+	return job, nil
+}
+
+// backfillFiles lists the files selected by job, sorted by absolute path so
+// the cursor has a stable meaning across runs.
+func backfillFiles(job store.BackfillJob) ([]backfillFile, error) {
+	resolved, err := input.ResolveStreamConfig(job.StreamPath)
+	if err != nil {
+		return nil, err
+	}
+	classes := toSet(job.ClassIDs)
+	cameras := toSet(job.CameraIDs)
+	ranged := job.FromEpoch > 0 || job.ToEpoch > 0
+
+	out := make([]backfillFile, 0)
+	for _, cls := range resolved.Classes {
+		if len(classes) > 0 {
+			if _, ok := classes[cls.ClassID]; !ok {
+				continue
+			}
+		}
+		for _, cam := range cls.Cameras {
+			if len(cameras) > 0 {
+				if _, ok := cameras[cam.ID]; !ok {
+					continue
+				}
+			}
+			files, err := input.ResolveCameraEventFiles(resolved.ConfigDir, cls.BaseDir, cam)
+			if err != nil {
+				return nil, fmt.Errorf("resolve event files class=%s camera=%s: %w", cls.ClassID, cam.ID, err)
+			}
+			for _, f := range files {
+				// Archives are selected whole; their members are filtered by
+				// jobRange while ingesting.
+				if ranged && !input.IsArchive(f) {
+					epochTS, ok := epochFromJSONFilename(f)
+					if !ok {
+						continue
+					}
+					if job.FromEpoch > 0 && epochTS < job.FromEpoch {
+						continue
+					}
+					if job.ToEpoch > 0 && epochTS > job.ToEpoch {
+						continue
+					}
+				}
+				abs, err := filepath.Abs(f)
+				if err != nil {
+					continue
+				}
+				out = append(out, backfillFile{ClassID: cls.ClassID, CameraID: cam.ID, Mode: cam.Mode, Path: abs})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// jobRange keeps archive members whose epoch name lies in the job's range.
+// Members are only filtered when a bound is set, and a rejection is not final
+// because a later job may select them.
+func jobRange(job store.BackfillJob) memberFilter {
+	if job.FromEpoch <= 0 && job.ToEpoch <= 0 {
+		return nil
+	}
+	return func(member string) (bool, bool) {
+		epochTS, ok := epochFromJSONFilename(member)
+		if !ok {
+			return false, false
+		}
+		inRange := (job.FromEpoch <= 0 || epochTS >= job.FromEpoch) && (job.ToEpoch <= 0 || epochTS <= job.ToEpoch)
+		return inRange, false
+	}
+}
+
+func toSet(values []string) map[string]struct{} {
+	out := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		out[v] = struct{}{}
+	}
+	return out
+}
@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"ai-json/internal/store"
+)
+
+func TestBackfillIngestsOldFilesInRange(t *testing.T) {
+	root := t.TempDir()
+	mustMkdir(t, filepath.Join(root, "c", "front", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "front", "events"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "events"))
+
+	e := `[{"event_type":"person_tracked","room_id":"r","camera_id":"front","pipeline":"p","confidence":0.9,"timestamp":1,"frame_timestamp":1,"frame_source_timestamp":1,"emitted_at":1,"timestamp_offset_seconds":0,"timestamp_stabilizer_skew_seconds":0,"frame_age_seconds":0.1,"frame_transport_delay_seconds":0.1}]`
+	old := time.Now().Add(-30 * 24 * time.Hour).Unix()
+	for i := int64(0); i < 5; i++ {
+		mustWrite(t, filepath.Join(root, "c", "front", "events", strconv.FormatInt(old+i, 10)+".json"), withTimestamp(e, old+i))
+	}
+	mustWrite(t, filepath.Join(root, "c", "back", "events", strconv.FormatInt(old, 10)+".json"), []byte(e))
+	mustWrite(t, filepath.Join(root, "c", "front", "events", strconv.FormatInt(old-100, 10)+".json"), withTimestamp(e, old-100))
+
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond}
+	live, err := r.RunOnce()
+	if err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	if live.ProcessedFiles != 0 {
+		t.Fatalf("expected live run to skip old files, processed %d", live.ProcessedFiles)
+	}
+
+	job, err := r.StartBackfill(BackfillRequest{CameraIDs: []string{"front"}, FromEpoch: old, ToEpoch: old + 10, ChunkSize: 2})
+	if err != nil {
+		t.Fatalf("start backfill: %v", err)
+	}
+	progressCalls := 0
+	job, err = r.RunBackfill(job.ID, func(store.BackfillJob) { progressCalls++ })
+	if err != nil {
+		t.Fatalf("run backfill: %v", err)
+	}
+	if job.Status != store.BackfillCompleted || job.TotalFiles != 5 || job.ProcessedFiles != 5 || job.InsertedEvents != 5 {
+		t.Fatalf("unexpected job result: %+v", job)
+	}
+	if progressCalls != 4 {
+		t.Fatalf("expected 3 chunk updates plus completion, got %d", progressCalls)
+	}
+
+	// Re-running a completed job is a no-op; a fresh job over the same range
+	// relies on ShouldIngestFile and inserts nothing.
+	again, err := r.StartBackfill(BackfillRequest{CameraIDs: []string{"front"}, FromEpoch: old, ToEpoch: old + 10})
+	if err != nil {
+		t.Fatalf("start second backfill: %v", err)
+	}
+	again, err = r.RunBackfill(again.ID, nil)
+	if err != nil {
+		t.Fatalf("run second backfill: %v", err)
+	}
+	if again.InsertedEvents != 0 || again.SkippedFiles != 5 {
+		t.Fatalf("expected idempotent re-run, got %+v", again)
+	}
+}
+
+func TestBackfillResumesFromCursor(t *testing.T) {
+	root := t.TempDir()
+	mustMkdir(t, filepath.Join(root, "c", "front", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "front", "events"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "events"))
+	e := `[{"event_type":"frame_tick","timestamp":1}]`
+	for i := 1; i <= 3; i++ {
+		mustWrite(t, filepath.Join(root, "c", "front", "events", strconv.Itoa(1000+i)+".json"), []byte(e))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond}
+	job, err := r.StartBackfill(BackfillRequest{FromEpoch: 1000, ToEpoch: 2000, ChunkSize: 1})
+	if err != nil {
+		t.Fatalf("start backfill: %v", err)
+	}
+	// Simulate an interruption after the first file was committed.
+	job.Status = store.BackfillFailed
+	job.Cursor = filepath.Join(root, "c", "front", "events", "1001.json")
+	if err := st.UpdateBackfillJob(&job); err != nil {
+		t.Fatalf("update job: %v", err)
+	}
+
+	job, err = r.RunBackfill(job.ID, nil)
+	if err != nil {
+		t.Fatalf("resume backfill: %v", err)
+	}
+	if job.Status != store.BackfillCompleted || job.DoneFiles != 3 || job.ProcessedFiles != 2 {
+		t.Fatalf("expected resume to process remaining 2 files, got %+v", job)
+	}
+}
+
+func TestBackfillCursorStopsBeforeYoungFile(t *testing.T) {
+	root := t.TempDir()
+	mustMkdir(t, filepath.Join(root, "c", "front", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "front", "events"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "events"))
+	old := time.Now().Add(-time.Hour)
+	paths := make([]string, 0, 3)
+	for i := 1; i <= 3; i++ {
+		p := filepath.Join(root, "c", "front", "events", strconv.Itoa(1000+i)+".json")
+		mustWrite(t, p, []byte(`[{"event_type":"frame_tick","timestamp":`+strconv.Itoa(i)+`}]`))
+		paths = append(paths, p)
+	}
+	// 1002.json is still being written.
+	for _, p := range []string{paths[0], paths[2]} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Minute}
+	job, err := r.StartBackfill(BackfillRequest{FromEpoch: 1000, ToEpoch: 2000, ChunkSize: 2})
+	if err != nil {
+		t.Fatalf("start backfill: %v", err)
+	}
+	job, err = r.RunBackfill(job.ID, nil)
+	if err == nil || job.Status != store.BackfillFailed {
+		t.Fatalf("expected the job to fail on the young file, got %+v err=%v", job, err)
+	}
+	if job.Cursor != paths[0] || job.DoneFiles != 1 || job.ProcessedFiles != 2 {
+		t.Fatalf("expected the cursor to stop at %s with 1001 and 1003 ingested, got %+v", paths[0], job)
+	}
+
+	if err := os.Chtimes(paths[1], old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	job, err = r.RunBackfill(job.ID, nil)
+	if err != nil {
+		t.Fatalf("resume backfill: %v", err)
+	}
+	if job.Status != store.BackfillCompleted || job.DoneFiles != 3 || job.ProcessedFiles != 3 || job.Cursor != paths[2] {
+		t.Fatalf("expected the resumed job to ingest 1002 and complete, got %+v", job)
+	}
+	events, _, err := st.ListEvents(store.EventFilter{})
+	if err != nil || len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d err=%v", len(events), err)
+	}
+}
@@ -0,0 +1,135 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"ai-json/internal/input"
+	"ai-json/internal/store"
+)
+
+// failureWrite defers recordFailure to the store stage.
+func (r *Runner) failureWrite(classID, cameraID, path string, info os.FileInfo, cause error, stats *RunStats) fileWrite {
+	return func() error { return r.recordFailure(classID, cameraID, path, info, cause, stats) }
+}
+
+// recordFailure stores a read/parse failure for path and quarantines the
+// file once it has failed MaxFileAttempts times in a row.
+func (r *Runner) recordFailure(classID, cameraID, path string, info os.FileInfo, cause error, stats *RunStats) error {
+	stats.FailedFiles++
+	f, err := r.Store.RecordIngestFailure(store.IngestFailure{
+		Path:      path,
+		ClassID:   classID,
+		CameraID:  cameraID,
+		SizeBytes: info.Size(),
+		ModUnix:   info.ModTime().Unix(),
+		Error:     cause.Error(),
+	})
+	if err != nil {
+		return err
+	}
+	if f.Attempts < r.MaxFileAttempts {
+		return nil
+	}
+
+	dest := ""
+	if r.QuarantineDir != "" {
+		// A file that cannot be moved is still quarantined in place.
+		if moved, err := moveFile(path, filepath.Join(r.QuarantineDir, classID, cameraID, filepath.Base(path))); err == nil {
+			dest = moved
+		}
+	}
+	if err := r.Store.QuarantineIngestFailure(f.ID, dest); err != nil {
+		return err
+	}
+	stats.QuarantinedFiles++
+	return nil
+}
+
+// RetryFailure resets the attempt count of a recorded failure, moves a
+// quarantined file back to its original path and ingests it immediately,
+// bypassing the age window. A failed archive member is retried by re-reading
+// its archive. If it fails again a new failure is recorded.
+func (r *Runner) RetryFailure(id int64) (RunStats, error) {
+	if err := r.applyDefaults(); err != nil {
+		return RunStats{}, err
+	}
+	f, err := r.Store.GetIngestFailure(id)
+	if err != nil {
+		return RunStats{}, err
+	}
+	if f.QuarantinedPath != "" {
+		if _, err := moveFile(f.QuarantinedPath, f.Path); err != nil {
+			return RunStats{}, fmt.Errorf("restore quarantined file: %w", err)
+		}
+	}
+	if err := r.Store.ResetIngestFailure(f.ID); err != nil {
+		return RunStats{}, err
+	}
+
+	if archive, _, ok := input.SplitMemberPath(f.Path); ok {
+		info, err := os.Stat(archive)
+		if err != nil {
+			return RunStats{}, fmt.Errorf("stat %s: %w", archive, err)
+		}
+		stats := RunStats{}
+		write, err := r.prepareArchive(f.ClassID, f.CameraID, archive, info, nil, true, &stats)
+		if err == nil && write != nil {
+			err = write()
+		}
+		return stats, err
+	}
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return RunStats{}, fmt.Errorf("stat %s: %w", f.Path, err)
+	}
+	stats := RunStats{}
+	if err := r.ingestFile(f.ClassID, f.CameraID, f.Path, info, &stats); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// moveFile renames src to dst, creating dst's directory. When the rename
+// fails because dst is on another filesystem, the file is copied and src
+// removed.
+func moveFile(src, dst string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+	err := os.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		err = copyAndRemove(src, dst)
+	}
+	if err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func copyAndRemove(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"ai-json/internal/store"
+)
+
+func TestMalformedFileIsQuarantinedAndRetried(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "c", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "c", cam, "events"))
+	}
+
+	e := `[{"event_type":"person_tracked","room_id":"r","camera_id":"front","pipeline":"p","confidence":0.9,"timestamp":1,"frame_timestamp":1,"frame_source_timestamp":1,"emitted_at":1,"timestamp_offset_seconds":0,"timestamp_stabilizer_skew_seconds":0,"frame_age_seconds":0.1,"frame_transport_delay_seconds":0.1}]`
+	now := time.Now().Unix()
+	bad := filepath.Join(root, "c", "front", "events", strconv.FormatInt(now-2, 10)+".json")
+	good := filepath.Join(root, "c", "front", "events", strconv.FormatInt(now-1, 10)+".json")
+	mustWrite(t, bad, []byte(`[{"event_type":`))
+	mustWrite(t, good, []byte(e))
+
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	quarantine := filepath.Join(root, "quarantine")
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Hour, MaxFileAttempts: 2, QuarantineDir: quarantine}
+
+	first, err := r.RunOnce()
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if first.ProcessedFiles != 1 || first.InsertedEvents != 1 || first.FailedFiles != 1 || first.QuarantinedFiles != 0 {
+		t.Fatalf("expected bad file to be recorded without aborting, got %+v", first)
+	}
+
+	second, err := r.RunOnce()
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if second.FailedFiles != 1 || second.QuarantinedFiles != 1 {
+		t.Fatalf("expected quarantine on second attempt, got %+v", second)
+	}
+	moved := filepath.Join(quarantine, "c", "front", filepath.Base(bad))
+	if _, err := os.Stat(moved); err != nil {
+		t.Fatalf("expected quarantined file at %s: %v", moved, err)
+	}
+
+	failures, err := st.ListIngestFailures(store.FailureQuarantined, 10)
+	if err != nil {
+		t.Fatalf("list failures: %v", err)
+	}
+	if len(failures) != 1 || failures[0].Attempts != 2 || failures[0].QuarantinedPath != moved {
+		t.Fatalf("unexpected failures: %+v", failures)
+	}
+
+	// Fix the file in quarantine, then retry it through the runner.
+	mustWrite(t, moved, withTimestamp(e, 2))
+	stats, err := r.RetryFailure(failures[0].ID)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if stats.ProcessedFiles != 1 || stats.InsertedEvents != 1 {
+		t.Fatalf("unexpected retry stats: %+v", stats)
+	}
+	if _, found, err := st.GetIngestFailureByPath(bad); err != nil || found {
+		t.Fatalf("expected failure to be cleared, found=%v err=%v", found, err)
+	}
+}
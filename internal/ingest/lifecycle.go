@@ -0,0 +1,114 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ai-json/internal/input"
+	"ai-json/internal/store"
+)
+
+// applyAfterIngest runs each camera's after_ingest policy on the files listed
+// for this pass. A file qualifies once the store holds it with its current
+// size and mtime (and, for tailed files, every byte consumed) and both its
+// mtime and its ingestion are at least GraceSeconds old. A file whose epoch
+// name is outside the max-past window is never stored and qualifies once its
+// mtime is GraceSeconds old. Files that failed or were quarantined are left
+// alone so they can be inspected and retried. Every move and delete, and
+// every failed attempt, is recorded in file_actions; only store errors are
+// returned.
+func (r *Runner) applyAfterIngest(ctx context.Context, jobs []cameraJob, now time.Time, stats *RunStats) error {
+	for _, job := range jobs {
+		policy := job.AfterIngest
+		if policy.Action == "" || policy.Action == input.AfterIngestKeep {
+			continue
+		}
+		grace := time.Duration(policy.GraceSeconds) * time.Second
+		for _, file := range job.Files {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := r.afterIngestFile(job, file, grace, now, stats); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Runner) afterIngestFile(job cameraJob, file string, grace time.Duration, now time.Time, stats *RunStats) error {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil
+	}
+	info, err := os.Stat(absFile)
+	if err != nil || now.Sub(info.ModTime()) < grace {
+		return nil
+	}
+	stored, found, err := r.Store.GetIngestedFile(absFile)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !found:
+		// A file outside the max-past window is never stored, and never
+		// will be by a regular pass.
+		if epoch, ok := epochFromJSONFilename(absFile); !ok || now.Unix()-epoch <= int64(r.MaxPastAge.Seconds()) {
+			return nil
+		}
+	case stored.SizeBytes != info.Size() || stored.ModUnix != info.ModTime().Unix() || now.Sub(stored.IngestedAt) < grace:
+		return nil
+	case job.Mode == input.ModeTail && !input.IsCompressed(absFile) && !input.IsArchive(absFile) && stored.Offset < info.Size():
+		// A trailing partial line has not been consumed yet.
+		return nil
+	}
+
+	action := store.FileAction{Path: absFile, ClassID: job.ClassID, CameraID: job.CameraID, Action: job.AfterIngest.Action}
+	switch job.AfterIngest.Action {
+	case input.AfterIngestMove:
+		dest := filepath.Join(job.AfterIngest.ArchiveDir, fileDate(absFile, info), filepath.Base(absFile))
+		if _, err := os.Stat(dest); err == nil {
+			action.Error = fmt.Sprintf("destination %s already exists", dest)
+		} else if _, err := moveFile(absFile, dest); err != nil {
+			action.Error = err.Error()
+		} else {
+			action.DestPath = dest
+			stats.MovedFiles++
+		}
+	case input.AfterIngestDelete:
+		if err := os.Remove(absFile); err != nil {
+			action.Error = err.Error()
+		} else {
+			stats.DeletedFiles++
+		}
+	default:
+		return nil
+	}
+
+	if action.Error != "" {
+		stats.FailedActions++
+		// A failure that keeps repeating is recorded once.
+		last, ok, err := r.Store.LatestFileAction(absFile)
+		if err != nil {
+			return err
+		}
+		if ok && last.Action == action.Action && last.Error == action.Error {
+			return nil
+		}
+	}
+	_, err = r.Store.RecordFileAction(action)
+	return err
+}
+
+// fileDate returns the YYYY/MM/DD partition (UTC) of an event file, from its
+// epoch name or, failing that, its mtime.
+func fileDate(path string, info os.FileInfo) string {
+	t := info.ModTime()
+	if epoch, ok := epochFromJSONFilename(path); ok {
+		t = time.Unix(epoch, 0)
+	}
+	return t.UTC().Format("2006/01/02")
+}
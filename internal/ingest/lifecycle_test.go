@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"ai-json/internal/input"
+	"ai-json/internal/store"
+)
+
+func TestAfterIngestMovesAndDeletesIngestedFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "a", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "a", cam, "events"))
+	}
+	epoch := time.Now().Unix() - 60
+	e := `[{"event_type":"person_tracked","camera_id":"front","timestamp":1,"emitted_at":1}]`
+	name := strconv.FormatInt(epoch, 10) + ".json"
+	front := filepath.Join(root, "a", "front", "events", name)
+	back := filepath.Join(root, "a", "back", "events", name)
+	mustWrite(t, front, withTimestamp(e, epoch))
+	mustWrite(t, back, withTimestamp(e, epoch+1))
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"a","cameras":[
+		{"id":"front","after_ingest":{"action":"move","archive_dir":"./archive","grace_seconds":0}},
+		{"id":"back","after_ingest":{"action":"delete","grace_seconds":0}}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Hour}
+
+	// Files ingested in a pass are only acted on once their ingestion is older
+	// than the grace period, which at the latest is the next pass.
+	stats := mustRun(t, r)
+	if stats.InsertedEvents != 2 {
+		t.Fatalf("expected 2 inserted events, got %+v", stats)
+	}
+	if stats.MovedFiles == 0 {
+		stats = mustRun(t, r)
+	}
+	if stats.MovedFiles != 1 || stats.DeletedFiles != 1 || stats.FailedActions != 0 {
+		t.Fatalf("unexpected lifecycle stats: %+v", stats)
+	}
+
+	dest := filepath.Join(root, "archive", time.Unix(epoch, 0).UTC().Format("2006/01/02"), name)
+	if _, err := os.Stat(dest); err != nil {
+		t.Fatalf("expected moved file at %s: %v", dest, err)
+	}
+	for _, p := range []string{front, back} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be gone, stat err=%v", p, err)
+		}
+	}
+
+	actions, err := st.ListFileActions("", "", 10)
+	if err != nil || len(actions) != 2 {
+		t.Fatalf("expected 2 file actions, got %+v err=%v", actions, err)
+	}
+	moved, err := st.ListFileActions(front, input.AfterIngestMove, 10)
+	if err != nil || len(moved) != 1 || moved[0].DestPath != dest || moved[0].Error != "" {
+		t.Fatalf("unexpected move action: %+v err=%v", moved, err)
+	}
+
+	// Events keep their original source file.
+	events, _, err := st.ListEvents(store.EventFilter{})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d err=%v", len(events), err)
+	}
+	stats = mustRun(t, r)
+	if stats.MovedFiles != 0 || stats.DeletedFiles != 0 || stats.FailedActions != 0 {
+		t.Fatalf("expected nothing left to act on, got %+v", stats)
+	}
+}
+
+func TestAfterIngestActsOnFilesOutsideMaxPast(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "a", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "a", cam, "events"))
+	}
+	now := time.Now().Unix()
+	old := filepath.Join(root, "a", "front", "events", strconv.FormatInt(now-7200, 10)+".json")
+	mustWrite(t, old, withTimestamp(`[{"event_type":"person_tracked","camera_id":"front","timestamp":1,"emitted_at":1}]`, now-7200))
+	broken := filepath.Join(root, "a", "front", "events", strconv.FormatInt(now-10, 10)+".json")
+	mustWrite(t, broken, []byte(`[{"event_type":`))
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"a","cameras":[
+		{"id":"front","after_ingest":{"action":"delete","grace_seconds":0}},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Minute, MaxFileAttempts: 1}
+
+	// The old file is skipped for good and cleaned up; the quarantined one
+	// stays for inspection.
+	stats := mustRun(t, r)
+	if stats.InsertedEvents != 0 || stats.QuarantinedFiles != 1 || stats.DeletedFiles != 1 || stats.FailedActions != 0 {
+		t.Fatalf("unexpected pass: %+v", stats)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be deleted, stat err=%v", old, err)
+	}
+	if _, err := os.Stat(broken); err != nil {
+		t.Fatalf("expected the quarantined file to stay: %v", err)
+	}
+	actions, err := st.ListFileActions(old, input.AfterIngestDelete, 10)
+	if err != nil || len(actions) != 1 || actions[0].Error != "" {
+		t.Fatalf("expected one recorded delete, got %+v err=%v", actions, err)
+	}
+}
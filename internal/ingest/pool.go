@@ -0,0 +1,166 @@
+package ingest
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"ai-json/internal/input"
+)
+
+// CameraStats reports one camera's share of a RunOnce pass.
+type CameraStats struct {
+	ClassID          string `json:"class_id"`
+	CameraID         string `json:"camera_id"`
+	ProcessedFiles   int    `json:"processed_files"`
+	InsertedEvents   int    `json:"inserted_events"`
+	DuplicateEvents  int    `json:"duplicate_events"`
+	SkippedFiles     int    `json:"skipped_files"`
+	FailedFiles      int    `json:"failed_files"`
+	QuarantinedFiles int    `json:"quarantined_files"`
+	// NewestFileEpoch is the newest event-file epoch listed for the camera,
+	// from the file name or, failing that, its mtime.
+	NewestFileEpoch int64 `json:"newest_file_epoch,omitempty"`
+	// LagSeconds is the run's start time minus NewestFileEpoch.
+	LagSeconds int64  `json:"lag_seconds,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// ReadMillis is time spent listing, reading and parsing files.
+	ReadMillis int64 `json:"read_ms"`
+	// WriteMillis is time spent waiting for and running store writes.
+	WriteMillis int64 `json:"write_ms"`
+	TotalMillis int64 `json:"total_ms"`
+}
+
+type cameraJob struct {
+	ClassID  string
+	CameraID string
+	Mode     string
+	Files    []string
+	// AfterIngest is applied to Files after the pass.
+	AfterIngest input.AfterIngest
+}
+
+type writeRequest struct {
+	write fileWrite
+	done  chan error
+}
+
+// runCameras processes jobs with at most r.Workers concurrent readers. Every
+// fileWrite is executed by one writer goroutine, and a worker waits for its
+// write before reading the camera's next file, which keeps per-camera order.
+// The first error, or cancellation of ctx between files, stops the remaining
+// cameras; stats gathered so far are still returned.
+func (r *Runner) runCameras(ctx context.Context, jobs []cameraJob, now time.Time) (RunStats, error) {
+	writes := make(chan writeRequest)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for req := range writes {
+			req.done <- req.write()
+		}
+	}()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	perCamera := make([]RunStats, len(jobs))
+	cameras := make([]CameraStats, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(r.Workers, max(len(jobs), 1)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				job := jobs[i]
+				stats := &perCamera[i]
+				cs := CameraStats{ClassID: job.ClassID, CameraID: job.CameraID, NewestFileEpoch: newestFileEpoch(job.Files)}
+				if cs.NewestFileEpoch > 0 {
+					cs.LagSeconds = now.Unix() - cs.NewestFileEpoch
+				}
+				began := time.Now()
+				for _, file := range job.Files {
+					if failed() {
+						cs.Error = "aborted after another camera failed"
+						break
+					}
+					if err := ctx.Err(); err != nil {
+						cs.Error = err.Error()
+						mu.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						mu.Unlock()
+						break
+					}
+					readStart := time.Now()
+					_, write, err := r.prepareCandidate(job.ClassID, job.CameraID, job.Mode, file, now, stats)
+					cs.ReadMillis += time.Since(readStart).Milliseconds()
+					if err == nil && write != nil {
+						writeStart := time.Now()
+						done := make(chan error, 1)
+						writes <- writeRequest{write: write, done: done}
+						err = <-done
+						cs.WriteMillis += time.Since(writeStart).Milliseconds()
+					}
+					if err != nil {
+						cs.Error = err.Error()
+						mu.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						mu.Unlock()
+						break
+					}
+				}
+				cs.TotalMillis = time.Since(began).Milliseconds()
+				cs.ProcessedFiles = stats.ProcessedFiles
+				cs.InsertedEvents = stats.InsertedEvents
+				cs.DuplicateEvents = stats.DuplicateEvents
+				cs.SkippedFiles = stats.SkippedFiles
+				cs.FailedFiles = stats.FailedFiles
+				cs.QuarantinedFiles = stats.QuarantinedFiles
+				cameras[i] = cs
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	close(writes)
+	<-writerDone
+
+	total := RunStats{Cameras: cameras}
+	for _, s := range perCamera {
+		total.add(s)
+	}
+	return total, firstErr
+}
+
+// newestFileEpoch returns the highest epoch among files, taken from epoch
+// file names or, for other names, from the modification time.
+func newestFileEpoch(files []string) int64 {
+	newest := int64(0)
+	for _, f := range files {
+		epoch, ok := epochFromJSONFilename(f)
+		if !ok {
+			info, err := os.Stat(f)
+			if err != nil {
+				continue
+			}
+			epoch = info.ModTime().Unix()
+		}
+		newest = max(newest, epoch)
+	}
+	return newest
+}
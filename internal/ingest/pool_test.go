@@ -0,0 +1,102 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"ai-json/internal/store"
+)
+
+func TestRunOnceParallelReportsPerCameraStats(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now().Unix()
+	e := `[{"event_type":"person_tracked","camera_id":"x","timestamp":1,"emitted_at":1}]`
+	classes := []string{"a", "b", "c"}
+	cfg := `{"classes":[`
+	for ci, cls := range classes {
+		for _, cam := range []string{"front", "back"} {
+			mustMkdir(t, filepath.Join(root, cls, cam, "images"))
+			mustMkdir(t, filepath.Join(root, cls, cam, "events"))
+			for i := int64(0); i < 3; i++ {
+				ts := now - 10 + i
+				name := filepath.Join(root, cls, cam, "events", strconv.FormatInt(ts, 10)+".json")
+				mustWrite(t, name, withTimestamp(e, int64(ci)*1000+ts))
+			}
+		}
+		if ci > 0 {
+			cfg += ","
+		}
+		cfg += `{"class_id":"` + cls + `","cameras":[{"id":"front"},{"id":"back"}]}`
+	}
+	cfg += `]}`
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(cfg))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Hour, Workers: 3}
+	stats, err := r.RunOnce()
+	if err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	// front and back of one class share event content, so the second camera
+	// of each class stores duplicates only.
+	if stats.ProcessedFiles != 18 || stats.InsertedEvents != 9 || stats.DuplicateEvents != 9 {
+		t.Fatalf("unexpected totals: %+v", stats)
+	}
+	if len(stats.Cameras) != 6 {
+		t.Fatalf("expected 6 camera entries, got %d", len(stats.Cameras))
+	}
+	for i, cs := range stats.Cameras {
+		if cs.ClassID != classes[i/2] || cs.ProcessedFiles != 3 {
+			t.Fatalf("unexpected camera stats %d: %+v", i, cs)
+		}
+	}
+}
+
+func TestRunOnceContextStopsWhenCancelled(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now().Unix()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "a", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "a", cam, "events"))
+	}
+	e := `[{"event_type":"person_tracked","camera_id":"front","timestamp":1,"emitted_at":1}]`
+	mustWrite(t, filepath.Join(root, "a", "front", "events", strconv.FormatInt(now-5, 10)+".json"), withTimestamp(e, now))
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"a","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	r := &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Nanosecond, MaxPastAge: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stats, err := r.RunOnceContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if stats.ProcessedFiles != 0 {
+		t.Fatalf("expected no files after cancellation, got %+v", stats)
+	}
+	run, ok, err := st.LatestIngestRun("")
+	if err != nil || !ok || run.Status != store.RunFailed {
+		t.Fatalf("expected a failed run to be recorded, got %+v ok=%v err=%v", run, ok, err)
+	}
+
+	stats, err = r.RunOnceContext(context.Background())
+	if err != nil || stats.ProcessedFiles != 1 {
+		t.Fatalf("expected the file on the next pass, got %+v err=%v", stats, err)
+	}
+}
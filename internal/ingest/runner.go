@@ -1,6 +1,7 @@
 package ingest
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"ai-json/internal/config"
 	"ai-json/internal/input"
 	"ai-json/internal/model"
 	"ai-json/internal/store"
@@ -19,101 +21,284 @@ type Runner struct {
 	StreamPath string
 	MinFileAge time.Duration
 	MaxPastAge time.Duration
+	// MaxFileAttempts is how many times an unreadable or unparsable file is
+	// retried before it is quarantined (default 3).
+	MaxFileAttempts int
+	// QuarantineDir, when set, receives quarantined files under
+	// <class_id>/<camera_id>/. When empty they are left in place and ignored
+	// until they change; after_ingest never moves or deletes them.
+	QuarantineDir string
+	// Workers bounds how many cameras are read and parsed concurrently
+	// (default 4).
+	Workers int
+	// Trigger is recorded with every run in the ingest history (default
+	// store.TriggerScheduler).
+	Trigger string
+	// Config, when set, supplies the stream config instead of reading
+	// StreamPath on every pass, so edits validated by the manager apply to the
+	// next pass and invalid edits never reach ingestion.
+	Config *config.Manager
 }
 
 type RunStats struct {
-	ProcessedFiles int `json:"processed_files"`
-	InsertedEvents int `json:"inserted_events"`
-	SkippedFiles   int `json:"skipped_files"`
+	ProcessedFiles   int `json:"processed_files"`
+	InsertedEvents   int `json:"inserted_events"`
+	DuplicateEvents  int `json:"duplicate_events"`
+	SkippedFiles     int `json:"skipped_files"`
+	FailedFiles      int `json:"failed_files"`
+	QuarantinedFiles int `json:"quarantined_files"`
+	// MalformedLines counts unparsable NDJSON lines skipped in tail mode.
+	MalformedLines int `json:"malformed_lines"`
+	// TailResets counts tailed files re-read from the start after truncation
+	// or rotation.
+	TailResets int `json:"tail_resets"`
+	// MovedFiles and DeletedFiles count after_ingest actions; FailedActions
+	// counts moves and deletes that failed.
+	MovedFiles    int `json:"moved_files"`
+	DeletedFiles  int `json:"deleted_files"`
+	FailedActions int `json:"failed_actions"`
+	// Cameras holds per-camera counts and timings for RunOnce passes.
+	Cameras []CameraStats `json:"cameras,omitempty"`
 }
 
+// add accumulates the counters of o into s. Per-camera entries are not
+// merged.
+func (s *RunStats) add(o RunStats) {
+	s.ProcessedFiles += o.ProcessedFiles
+	s.InsertedEvents += o.InsertedEvents
+	s.DuplicateEvents += o.DuplicateEvents
+	s.SkippedFiles += o.SkippedFiles
+	s.FailedFiles += o.FailedFiles
+	s.QuarantinedFiles += o.QuarantinedFiles
+	s.MalformedLines += o.MalformedLines
+	s.TailResets += o.TailResets
+	s.MovedFiles += o.MovedFiles
+	s.DeletedFiles += o.DeletedFiles
+	s.FailedActions += o.FailedActions
+}
+
+// RunOnce scans every camera once. Cameras are read and parsed concurrently
+// by Workers goroutines while all store writes go through a single writer, so
+// files of one camera are still stored in name order. Every run is recorded
+// in the ingest history.
 func (r *Runner) RunOnce() (RunStats, error) {
-	if r.Store == nil {
-		return RunStats{}, fmt.Errorf("store is required")
+	return r.RunOnceContext(context.Background())
+}
+
+// RunOnceContext is RunOnce with cancellation. A file whose store write has
+// started is always finished, so cancelling never leaves a half-ingested
+// file; no further files are read once ctx is done and ctx.Err() is returned
+// (and recorded) with the stats gathered so far.
+func (r *Runner) RunOnceContext(ctx context.Context) (RunStats, error) {
+	if err := r.applyDefaults(); err != nil {
+		return RunStats{}, err
 	}
-	if r.StreamPath == "" {
-		r.StreamPath = "stream.json"
+	started := time.Now()
+	stats, err := r.runOnce(ctx, started)
+	if rerr := r.recordRun(r.Trigger, started, stats, err); rerr != nil && err == nil {
+		err = rerr
 	}
-	if r.MinFileAge <= 0 {
-		r.MinFileAge = 2 * time.Second
-	}
-	if r.MaxPastAge <= 0 {
-		r.MaxPastAge = 1 * time.Minute
+	return stats, err
+}
+
+// resolveStream returns the active stream config.
+func (r *Runner) resolveStream() (input.ResolvedStream, error) {
+	if r.Config != nil {
+		return r.Config.Current()
 	}
+	return input.ResolveStreamConfig(r.StreamPath)
+}
 
-	resolved, err := input.ResolveStreamConfig(r.StreamPath)
+func (r *Runner) runOnce(ctx context.Context, now time.Time) (RunStats, error) {
+	resolved, err := r.resolveStream()
 	if err != nil {
 		return RunStats{}, err
 	}
 
-	stats := RunStats{}
-	now := time.Now()
+	jobs := make([]cameraJob, 0)
 	for _, cls := range resolved.Classes {
 		for _, cam := range cls.Cameras {
 			files, err := input.ResolveCameraEventFiles(resolved.ConfigDir, cls.BaseDir, cam)
 			if err != nil {
-				return stats, fmt.Errorf("resolve event files class=%s camera=%s: %w", cls.ClassID, cam.ID, err)
+				return RunStats{}, fmt.Errorf("resolve event files class=%s camera=%s: %w", cls.ClassID, cam.ID, err)
 			}
 			sort.Strings(files)
-			for _, file := range files {
-				info, err := os.Stat(file)
-				if err != nil {
-					continue
-				}
-				epochTS, ok := epochFromJSONFilename(file)
-				if ok {
-					if now.Unix()-epochTS > int64(r.MaxPastAge.Seconds()) {
-						stats.SkippedFiles++
-						continue
-					}
-				}
-				if now.Sub(info.ModTime()) < r.MinFileAge {
-					stats.SkippedFiles++
-					continue
-				}
-				absFile, err := filepath.Abs(file)
-				if err != nil {
-					continue
-				}
-				should, err := r.Store.ShouldIngestFile(absFile, info.Size(), info.ModTime().Unix())
-				if err != nil {
-					return stats, err
-				}
-				if !should {
-					stats.SkippedFiles++
-					continue
-				}
-
-				b, err := os.ReadFile(absFile)
-				if err != nil {
-					return stats, fmt.Errorf("read %s: %w", absFile, err)
-				}
-				events, err := model.ParseEvents(b)
-				if err != nil {
-					return stats, fmt.Errorf("parse %s: %w", absFile, err)
-				}
-				for i := range events {
-					events[i].Raw["stream_class_id"] = cls.ClassID
-					events[i].Raw["stream_camera_id"] = cam.ID
-				}
-				n, err := r.Store.InsertEvents(events, absFile)
-				if err != nil {
-					return stats, fmt.Errorf("insert from %s: %w", absFile, err)
-				}
-				if err := r.Store.MarkFileIngested(absFile, info.Size(), info.ModTime().Unix()); err != nil {
-					return stats, err
-				}
-				stats.ProcessedFiles++
-				stats.InsertedEvents += n
-			}
+			jobs = append(jobs, cameraJob{ClassID: cls.ClassID, CameraID: cam.ID, Mode: cam.Mode, Files: files, AfterIngest: cam.AfterIngest})
 		}
 	}
+	stats, err := r.runCameras(ctx, jobs, now)
+	if err != nil {
+		// Files are only moved or deleted after a clean pass.
+		return stats, err
+	}
+	return stats, r.applyAfterIngest(ctx, jobs, now, &stats)
+}
 
-	return stats, nil
+func (r *Runner) applyDefaults() error {
+	if r.Store == nil {
+		return fmt.Errorf("store is required")
+	}
+	if r.StreamPath == "" {
+		r.StreamPath = "stream.json"
+	}
+	if r.MinFileAge <= 0 {
+		r.MinFileAge = 2 * time.Second
+	}
+	if r.MaxPastAge <= 0 {
+		r.MaxPastAge = 1 * time.Minute
+	}
+	if r.MaxFileAttempts <= 0 {
+		r.MaxFileAttempts = 3
+	}
+	if r.Workers <= 0 {
+		r.Workers = 4
+	}
+	if r.Trigger == "" {
+		r.Trigger = store.TriggerScheduler
+	}
+	return nil
+}
+
+// fileWrite is the store stage of ingesting one file, produced by the
+// read/parse stage. Parallel runs execute every fileWrite on a single writer
+// goroutine.
+type fileWrite func() error
+
+// ingestCandidate applies the max-past window, the min-file-age guard and
+// change detection to one file, ingesting it when needed. It returns false
+// only when the file is still too new to be read safely, so callers that track
+// individual files can retry it later. Tailed files skip the min-file-age
+// guard because only complete lines are read.
+func (r *Runner) ingestCandidate(classID, cameraID, mode, file string, now time.Time, stats *RunStats) (bool, error) {
+	settled, write, err := r.prepareCandidate(classID, cameraID, mode, file, now, stats)
+	if err != nil || write == nil {
+		return settled, err
+	}
+	return settled, write()
+}
+
+// prepareCandidate is the read/parse stage of ingestCandidate. A nil write
+// means there is nothing to store.
+func (r *Runner) prepareCandidate(classID, cameraID, mode, file string, now time.Time, stats *RunStats) (bool, fileWrite, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return true, nil, nil
+	}
+	epochTS, ok := epochFromJSONFilename(file)
+	if ok {
+		if now.Unix()-epochTS > int64(r.MaxPastAge.Seconds()) {
+			stats.SkippedFiles++
+			return true, nil, nil
+		}
+	}
+	// Compressed files and archives cannot be appended to, so they are always
+	// ingested whole.
+	packed := input.IsCompressed(file) || input.IsArchive(file)
+	if mode == input.ModeTail && !packed {
+		// A delta above maxTailBatchBytes records only the consumed size, so
+		// the rest is picked up by the next pass.
+		write, _, err := r.prepareTail(classID, cameraID, file, info, stats)
+		return true, write, err
+	}
+	if now.Sub(info.ModTime()) < r.MinFileAge {
+		stats.SkippedFiles++
+		return false, nil, nil
+	}
+	if input.IsArchive(file) {
+		write, err := r.prepareArchive(classID, cameraID, file, info, r.withinMaxPast(now), false, stats)
+		return true, write, err
+	}
+	write, err := r.prepareFile(classID, cameraID, file, info, stats)
+	return true, write, err
+}
+
+// withinMaxPast keeps archive members whose epoch name is inside the
+// max-past window; members without an epoch name are kept. Members only get
+// older, so a rejection is final.
+func (r *Runner) withinMaxPast(now time.Time) memberFilter {
+	return func(member string) (bool, bool) {
+		epochTS, ok := epochFromJSONFilename(member)
+		return !ok || now.Unix()-epochTS <= int64(r.MaxPastAge.Seconds()), true
+	}
+}
+
+// ingestFile parses and stores one event file (plain, compressed or an
+// archive) unless the store already holds it with the same size and mtime.
+// Read and parse errors are recorded as ingest failures instead of being
+// returned, so one bad file does not abort the cycle; only store errors are
+// returned.
+func (r *Runner) ingestFile(classID, cameraID, file string, info os.FileInfo, stats *RunStats) error {
+	write, err := r.prepareFile(classID, cameraID, file, info, stats)
+	if err != nil || write == nil {
+		return err
+	}
+	return write()
+}
+
+// prepareFile is the read/parse stage of ingestFile.
+func (r *Runner) prepareFile(classID, cameraID, file string, info os.FileInfo, stats *RunStats) (fileWrite, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, nil
+	}
+	if input.IsArchive(absFile) {
+		return r.prepareArchive(classID, cameraID, absFile, info, nil, false, stats)
+	}
+	return r.prepareSource(classID, cameraID, absFile, info, func() ([]byte, error) { return input.ReadEventFile(absFile) }, stats)
+}
+
+// prepareSource parses one event file, or archive member when path is a
+// virtual member path, whose content is returned by read. read is only called
+// when the file changed since it was last stored and is not quarantined.
+func (r *Runner) prepareSource(classID, cameraID, absFile string, info os.FileInfo, read func() ([]byte, error), stats *RunStats) (fileWrite, error) {
+	should, err := r.Store.ShouldIngestFile(absFile, info.Size(), info.ModTime().Unix())
+	if err != nil {
+		return nil, err
+	}
+	if !should {
+		stats.SkippedFiles++
+		return nil, nil
+	}
+	failure, failed, err := r.Store.GetIngestFailureByPath(absFile)
+	if err != nil {
+		return nil, err
+	}
+	if failed && failure.Status == store.FailureQuarantined && failure.SizeBytes == info.Size() && failure.ModUnix == info.ModTime().Unix() {
+		stats.SkippedFiles++
+		return nil, nil
+	}
+
+	b, err := read()
+	if err != nil {
+		return r.failureWrite(classID, cameraID, absFile, info, fmt.Errorf("read %s: %w", absFile, err), stats), nil
+	}
+	events, err := model.ParseEvents(b)
+	if err != nil {
+		return r.failureWrite(classID, cameraID, absFile, info, fmt.Errorf("parse %s: %w", absFile, err), stats), nil
+	}
+	for i := range events {
+		events[i].Raw["stream_class_id"] = classID
+		events[i].Raw["stream_camera_id"] = cameraID
+	}
+	return func() error {
+		res, err := r.Store.IngestFile(absFile, info.Size(), info.ModTime().Unix(), events)
+		if err != nil {
+			return fmt.Errorf("insert from %s: %w", absFile, err)
+		}
+		if failed {
+			if err := r.Store.ClearIngestFailure(absFile); err != nil {
+				return err
+			}
+		}
+		stats.ProcessedFiles++
+		stats.InsertedEvents += res.Inserted
+		stats.DuplicateEvents += res.Duplicates
+		return nil
+	}, nil
 }
 
 func epochFromJSONFilename(path string) (int64, bool) {
-	base := filepath.Base(path)
+	base := input.TrimCompressionExt(filepath.Base(path))
 	name := strings.TrimSuffix(base, filepath.Ext(base))
 	v, err := strconv.ParseInt(name, 10, 64)
 	if err != nil || v <= 0 {
@@ -3,6 +3,8 @@ package ingest
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -52,6 +54,12 @@ func TestRunOnceSkipsAlreadyIngested(t *testing.T) {
 	}
 }
 
+// withTimestamp returns event JSON e with its "timestamp":1 replaced so
+// otherwise identical test events get distinct fingerprints.
+func withTimestamp(e string, ts int64) []byte {
+	return []byte(strings.Replace(e, `"timestamp":1,`, `"timestamp":`+strconv.FormatInt(ts, 10)+`,`, 1))
+}
+
 func mustMkdir(t *testing.T, p string) {
 	t.Helper()
 	if err := os.MkdirAll(p, 0o755); err != nil {
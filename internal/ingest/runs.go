@@ -0,0 +1,39 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"ai-json/internal/store"
+)
+
+// recordRun stores one ingestion pass and its per-camera outcomes in the
+// ingest history.
+func (r *Runner) recordRun(trigger string, started time.Time, stats RunStats, runErr error) error {
+	finished := time.Now()
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("encode run stats: %w", err)
+	}
+	run := store.IngestRun{
+		Trigger:        trigger,
+		Status:         store.RunSucceeded,
+		StartedAt:      started.UTC().Format(time.RFC3339Nano),
+		FinishedAt:     finished.UTC().Format(time.RFC3339Nano),
+		DurationMillis: finished.Sub(started).Milliseconds(),
+		Stats:          b,
+	}
+	if runErr != nil {
+		run.Status = store.RunFailed
+		run.Error = runErr.Error()
+	}
+	cameras := make([]store.CameraRunOutcome, 0, len(stats.Cameras))
+	for _, c := range stats.Cameras {
+		cameras = append(cameras, store.CameraRunOutcome{ClassID: c.ClassID, CameraID: c.CameraID, NewestFileEpoch: c.NewestFileEpoch, Error: c.Error})
+	}
+	if _, err := r.Store.RecordIngestRun(run, cameras); err != nil {
+		return fmt.Errorf("record ingest run: %w", err)
+	}
+	return nil
+}
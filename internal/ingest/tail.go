@@ -0,0 +1,227 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"ai-json/internal/model"
+	"ai-json/internal/store"
+)
+
+// maxHashedLineBytes caps how much of the last consumed line is hashed, so
+// rotation checks read a bounded window before the stored offset.
+const maxHashedLineBytes = 64 << 10
+
+// maxTailLineBytes is the longest line a tailed file may hold; a longer line
+// is skipped as malformed.
+const maxTailLineBytes = 4 << 20
+
+// maxTailBatchBytes caps how much of a tailed file one read stage consumes,
+// so a large delta is stored over several batches instead of being held in
+// memory at once. It is a variable so tests can lower it.
+var maxTailBatchBytes int64 = 32 << 20
+
+// tailFile ingests the complete NDJSON lines appended to file since the
+// stored offset. A trailing line without a newline is left for the next pass.
+// When the file shrank below the offset (truncation) or the line ending at the
+// offset no longer matches its stored hash (rotation or rewrite), reading
+// restarts from the beginning; events already stored are kept and re-read
+// ones are dropped as duplicates. Deltas above maxTailBatchBytes are stored
+// in several batches.
+func (r *Runner) tailFile(classID, cameraID, file string, info os.FileInfo, stats *RunStats) error {
+	for {
+		write, more, err := r.prepareTail(classID, cameraID, file, info, stats)
+		if err != nil || write == nil {
+			return err
+		}
+		if err := write(); err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// prepareTail is the read/parse stage of tailFile. It reads line by line and
+// stops after maxTailBatchBytes; more reports that complete lines are left,
+// in which case the consumed size rather than the file size is recorded, so
+// the next pass or batch carries on.
+func (r *Runner) prepareTail(classID, cameraID, file string, info os.FileInfo, stats *RunStats) (fileWrite, bool, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, false, nil
+	}
+	should, err := r.Store.ShouldIngestFile(absFile, info.Size(), info.ModTime().Unix())
+	if err != nil {
+		return nil, false, err
+	}
+	if !should {
+		stats.SkippedFiles++
+		return nil, false, nil
+	}
+	state, _, err := r.Store.GetTailState(absFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	f, err := os.Open(absFile)
+	if err != nil {
+		return r.failureWrite(classID, cameraID, absFile, info, fmt.Errorf("open %s: %w", absFile, err), stats), false, nil
+	}
+	defer f.Close()
+
+	offset := state.Offset
+	if offset > 0 {
+		intact, err := tailIntact(f, info.Size(), state)
+		if err != nil {
+			return r.failureWrite(classID, cameraID, absFile, info, fmt.Errorf("read %s: %w", absFile, err), stats), false, nil
+		}
+		if !intact {
+			offset = 0
+			stats.TailResets++
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return r.failureWrite(classID, cameraID, absFile, info, fmt.Errorf("seek %s: %w", absFile, err), stats), false, nil
+	}
+
+	br := bufio.NewReaderSize(f, maxTailLineBytes)
+	events := make([]model.Event, 0)
+	var consumed int64
+	more := false
+	for {
+		if consumed >= maxTailBatchBytes {
+			more = true
+			break
+		}
+		line, n, err := readTailLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r.failureWrite(classID, cameraID, absFile, info, fmt.Errorf("read %s: %w", absFile, err), stats), false, nil
+		}
+		consumed += n
+		if line == nil {
+			stats.MalformedLines++
+			continue
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		// The reader reuses its buffer; the event keeps its bytes.
+		ev, err := model.DecodeEvent(append([]byte(nil), line...))
+		if err != nil {
+			stats.MalformedLines++
+			continue
+		}
+		ev.Raw["stream_class_id"] = classID
+		ev.Raw["stream_camera_id"] = cameraID
+		events = append(events, ev)
+	}
+	if consumed == 0 {
+		// Only a partial line so far.
+		stats.SkippedFiles++
+		return nil, false, nil
+	}
+
+	end := offset + consumed
+	hash, err := lineHashAt(f, end)
+	if err != nil {
+		return r.failureWrite(classID, cameraID, absFile, info, fmt.Errorf("read %s: %w", absFile, err), stats), false, nil
+	}
+	next := store.TailState{Offset: end, LastLineHash: hash}
+	size := info.Size()
+	if more {
+		size = end
+	}
+	return func() error {
+		res, err := r.Store.AppendFileEvents(absFile, size, info.ModTime().Unix(), next, events)
+		if err != nil {
+			return fmt.Errorf("insert from %s: %w", absFile, err)
+		}
+		stats.ProcessedFiles++
+		stats.InsertedEvents += res.Inserted
+		stats.DuplicateEvents += res.Duplicates
+		return nil
+	}, more, nil
+}
+
+// readTailLine returns the next complete line of br, newline included, with
+// its length. A line longer than br's buffer is consumed and returned as nil.
+// io.EOF means no complete line is left; a trailing partial line is read but
+// not counted.
+func readTailLine(br *bufio.Reader) ([]byte, int64, error) {
+	line, err := br.ReadSlice('\n')
+	if err == nil {
+		return line, int64(len(line)), nil
+	}
+	if err != bufio.ErrBufferFull {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, err
+	}
+	n := int64(len(line))
+	for {
+		chunk, err := br.ReadSlice('\n')
+		n += int64(len(chunk))
+		switch err {
+		case nil:
+			return nil, n, nil
+		case bufio.ErrBufferFull:
+		default:
+			return nil, 0, err
+		}
+	}
+}
+
+// tailIntact reports whether f still holds the content consumed up to
+// state.Offset: the file must not be shorter than the offset and the line
+// ending at the offset must hash to state.LastLineHash.
+func tailIntact(f *os.File, size int64, state store.TailState) (bool, error) {
+	if size < state.Offset {
+		return false, nil
+	}
+	hash, err := lineHashAt(f, state.Offset)
+	if err != nil || hash == "" {
+		return false, err
+	}
+	return hash == state.LastLineHash, nil
+}
+
+// lineHashAt returns the lastLineHash of the line ending at offset, or ""
+// when no line ends there.
+func lineHashAt(f *os.File, offset int64) (string, error) {
+	start := max(offset-maxHashedLineBytes-1, 0)
+	window := make([]byte, offset-start)
+	if _, err := f.ReadAt(window, start); err != nil {
+		return "", err
+	}
+	if len(window) == 0 || window[len(window)-1] != '\n' {
+		return "", nil
+	}
+	return lastLineHash(window), nil
+}
+
+// lastLineHash hashes the last line of b, which must end with a newline,
+// keeping at most maxHashedLineBytes of it.
+func lastLineHash(b []byte) string {
+	body := b[:len(b)-1]
+	if i := bytes.LastIndexByte(body, '\n'); i >= 0 {
+		body = body[i+1:]
+	}
+	if len(body) > maxHashedLineBytes {
+		body = body[len(body)-maxHashedLineBytes:]
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,191 @@
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ai-json/internal/model"
+	"ai-json/internal/store"
+)
+
+func TestTailModeIngestsOnlyAppendedLines(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "c", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "c", cam, "events"))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front","mode":"tail","file_pattern":"*.ndjson"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MaxPastAge: 0}
+
+	rolling := filepath.Join(root, "c", "front", "events", "rolling.ndjson")
+	mustWrite(t, rolling, []byte("{\"event_type\":\"a\",\"timestamp\":1}\n{\"event_type\":\"a\",\"timestamp\":2}\n{\"event_type\":\"a\",\"time"))
+	stats := mustRun(t, r)
+	if stats.InsertedEvents != 2 {
+		t.Fatalf("expected 2 complete lines, got %+v", stats)
+	}
+
+	appendTo(t, rolling, "stamp\":3}\nnot json\n")
+	stats = mustRun(t, r)
+	if stats.InsertedEvents != 1 || stats.MalformedLines != 1 || stats.TailResets != 0 {
+		t.Fatalf("expected completed partial line only, got %+v", stats)
+	}
+
+	// Truncation restarts from the beginning.
+	mustWrite(t, rolling, []byte("{\"event_type\":\"b\",\"timestamp\":4}\n"))
+	stats = mustRun(t, r)
+	if stats.InsertedEvents != 1 || stats.TailResets != 1 {
+		t.Fatalf("expected truncation reset, got %+v", stats)
+	}
+
+	// A rotated file that is longer than the old offset is detected by the
+	// last-line hash.
+	mustWrite(t, rolling, []byte("{\"event_type\":\"c\",\"timestamp\":5}\n{\"event_type\":\"c\",\"timestamp\":6}\n"))
+	stats = mustRun(t, r)
+	if stats.InsertedEvents != 2 || stats.TailResets != 1 {
+		t.Fatalf("expected rotation reset, got %+v", stats)
+	}
+
+	rows, total, err := st.ListEvents(store.EventFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if total != 6 || rows[0].FileID == nil {
+		t.Fatalf("expected 6 linked events, got total=%d", total)
+	}
+}
+
+func TestTailModeKeepsRawJSONAndFingerprint(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "c", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "c", cam, "events"))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front","mode":"tail","file_pattern":"*.ndjson"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MaxPastAge: 0}
+
+	// 2^53 + 1 does not survive a round trip through float64.
+	line := `{"event_type":"person_tracked", "track_id":9007199254740993,"timestamp":1.50}`
+	mustWrite(t, filepath.Join(root, "c", "front", "events", "rolling.ndjson"), []byte(line+"\n"))
+	if stats := mustRun(t, r); stats.InsertedEvents != 1 {
+		t.Fatalf("expected 1 insert, got %+v", stats)
+	}
+	rows, _, err := st.ListEvents(store.EventFilter{Limit: 10})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("list: %v %d", err, len(rows))
+	}
+	if string(rows[0].Raw) != line {
+		t.Fatalf("expected raw_json as written, got %s", rows[0].Raw)
+	}
+	if rows[0].TrackID == nil || *rows[0].TrackID != 9007199254740993 {
+		t.Fatalf("expected exact track_id, got %v", rows[0].TrackID)
+	}
+
+	// The same event posted or read from a whole file has the same
+	// fingerprint, so it is a duplicate.
+	events, err := model.ParseEvents([]byte(line))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	events[0].Raw["stream_class_id"] = "c"
+	events[0].Raw["stream_camera_id"] = "front"
+	res, err := st.InsertEvents(events, "post")
+	if err != nil || res.Duplicates != 1 {
+		t.Fatalf("expected a duplicate, got %+v %v", res, err)
+	}
+}
+
+func mustRun(t *testing.T, r *Runner) RunStats {
+	t.Helper()
+	stats, err := r.RunOnce()
+	if err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	return stats
+}
+
+func appendTo(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatalf("append %s: %v", path, err)
+	}
+}
+
+func TestTailModeSkipsOversizedLinesAndBatchesLargeDeltas(t *testing.T) {
+	defer func(n int64) { maxTailBatchBytes = n }(maxTailBatchBytes)
+	maxTailBatchBytes = 64
+
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "c", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "c", cam, "events"))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front","mode":"tail","file_pattern":"*.ndjson"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+	r := &Runner{Store: st, StreamPath: cfgPath, MaxPastAge: 0}
+
+	var b bytes.Buffer
+	b.WriteString("{\"event_type\":\"a\",\"timestamp\":1}\n")
+	b.WriteString("{\"event_type\":\"big\",\"pad\":\"" + strings.Repeat("x", maxTailLineBytes) + "\"}\n")
+	for i := 2; i <= 5; i++ {
+		fmt.Fprintf(&b, "{\"event_type\":\"a\",\"timestamp\":%d}\n", i)
+	}
+	rolling := filepath.Join(root, "c", "front", "events", "rolling.ndjson")
+	mustWrite(t, rolling, b.Bytes())
+
+	// The first pass stops after the batch cap; the next passes carry on from
+	// the stored offset.
+	stats := mustRun(t, r)
+	if stats.InsertedEvents != 1 || stats.MalformedLines != 1 {
+		t.Fatalf("expected the first batch only, got %+v", stats)
+	}
+	total := stats.InsertedEvents
+	for range 5 {
+		total += mustRun(t, r).InsertedEvents
+	}
+	if total != 5 {
+		t.Fatalf("expected 5 events over several passes, got %d", total)
+	}
+	if stats := mustRun(t, r); stats.SkippedFiles != 1 || stats.ProcessedFiles != 0 {
+		t.Fatalf("expected the fully read file to be skipped, got %+v", stats)
+	}
+
+	// Backfill reads every remaining batch at once.
+	appendTo(t, rolling, "{\"event_type\":\"a\",\"timestamp\":6}\n{\"event_type\":\"a\",\"timestamp\":7}\n{\"event_type\":\"a\",\"timestamp\":8}\n")
+	job, err := r.StartBackfill(BackfillRequest{})
+	if err != nil {
+		t.Fatalf("start backfill: %v", err)
+	}
+	job, err = r.RunBackfill(job.ID, nil)
+	if err != nil || job.InsertedEvents != 3 {
+		t.Fatalf("expected backfill to read all batches, got %+v err=%v", job, err)
+	}
+}
@@ -0,0 +1,322 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"ai-json/internal/fswatch"
+	"ai-json/internal/input"
+)
+
+// pendingRetryInterval controls how often files reported by the watcher but
+// still younger than MinFileAge are re-checked.
+const pendingRetryInterval = 500 * time.Millisecond
+
+// Watcher ingests event files as soon as the kernel reports them instead of
+// re-scanning every camera directory on a fixed tick. A periodic reconcile
+// pass runs RunOnce so files missed by notifications (queue overflow, explicit
+// event_files/event_globs outside events_dir) are still picked up.
+type Watcher struct {
+	Runner            *Runner
+	ReconcileInterval time.Duration
+	// OnRun, when set, is called after every reconcile pass and every batch of
+	// notified files, and with the error when the directory watch fails.
+	// Batches that settle a file or fail are recorded in the ingest history
+	// like reconcile passes.
+	OnRun func(RunStats, error)
+}
+
+type watchTarget struct {
+	ClassID  string
+	CameraID string
+	Mode     string
+	Dir      string
+	Pattern  string
+	// AfterIngest is applied to the files a batch settles.
+	AfterIngest input.AfterIngest
+}
+
+// Run watches and ingests until ctx is done. It only returns an error when
+// the runner is misconfigured or the platform has no notification support
+// (fswatch.ErrUnsupported). Any other watch failure (a configured events_dir
+// that does not exist yet, a directory removed or moved while watched, a
+// kernel queue overflow) is reported through OnRun and the watch is set up
+// again on the next pending-file tick or reconcile pass, followed by a full
+// scan for the files missed meanwhile; the reconcile passes keep ingesting
+// in the meantime.
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.Runner == nil {
+		return fmt.Errorf("runner is required")
+	}
+	r := w.Runner
+	if err := r.applyDefaults(); err != nil {
+		return err
+	}
+	if w.ReconcileInterval <= 0 {
+		w.ReconcileInterval = time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	notified := make(chan string, 256)
+	watchErr := make(chan error, 1)
+
+	var (
+		targets   []watchTarget
+		version   int64
+		watching  bool
+		stopWatch = func() {}
+		// failure is the last reported setup error, so retries that keep
+		// failing the same way are reported once.
+		failure string
+	)
+	defer func() { stopWatch() }()
+	pending := map[string]watchTarget{}
+
+	// refresh (re)registers the watched directories when the watch is down
+	// or a reloaded config may have added or moved cameras.
+	refresh := func() error {
+		v := w.configVersion()
+		if watching && v == version {
+			return nil
+		}
+		resolved, err := r.resolveStream()
+		if err != nil {
+			// The reconcile pass reports it.
+			return nil
+		}
+		stopWatch()
+		stopWatch, watching = func() {}, false
+		select {
+		case <-watchErr:
+			// From the watch just stopped.
+		default:
+		}
+		targets, version = watchTargets(resolved), v
+		for p := range pending {
+			if _, ok := matchWatchTarget(targets, p); !ok {
+				delete(pending, p)
+			}
+		}
+		stop, err := watchFiles(ctx, targets, notified, watchErr)
+		if errors.Is(err, fswatch.ErrUnsupported) {
+			return err
+		}
+		if err != nil {
+			if err.Error() != failure {
+				failure = err.Error()
+				w.report(RunStats{}, fmt.Errorf("watch event dirs (retrying): %w", err))
+			}
+			return nil
+		}
+		stopWatch, watching, failure = stop, true, ""
+		return nil
+	}
+
+	if err := refresh(); err != nil {
+		return err
+	}
+	w.report(r.RunOnceContext(ctx))
+
+	reconcile := time.NewTicker(w.ReconcileInterval)
+	defer reconcile.Stop()
+	retry := time.NewTicker(pendingRetryInterval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watchErr:
+			stopWatch()
+			stopWatch, watching = func() {}, false
+			w.report(RunStats{}, fmt.Errorf("watch event dirs (retrying): %w", err))
+		case path := <-notified:
+			if t, ok := matchWatchTarget(targets, path); ok {
+				pending[path] = t
+			}
+		case <-retry.C:
+			if !watching {
+				if err := refresh(); err != nil {
+					return err
+				}
+				if watching {
+					// Files may have arrived while nothing was watched.
+					w.report(r.RunOnceContext(ctx))
+				}
+			}
+			if len(pending) == 0 {
+				continue
+			}
+			w.report(w.flushPending(ctx, pending))
+		case <-reconcile.C:
+			if err := refresh(); err != nil {
+				return err
+			}
+			w.report(r.RunOnceContext(ctx))
+		}
+	}
+}
+
+// configVersion returns the runner's config manager version, or 0 when the
+// runner reads StreamPath directly.
+func (w *Watcher) configVersion() int64 {
+	if w.Runner.Config == nil {
+		return 0
+	}
+	return w.Runner.Config.Version()
+}
+
+// watchFiles starts a kernel watch on the target directories, forwarding
+// reported paths to notified. The returned function stops the watch and waits
+// for it to release its descriptor.
+func watchFiles(ctx context.Context, targets []watchTarget, notified chan<- string, watchErr chan<- error) (func(), error) {
+	fw, err := fswatch.New(watchDirs(targets))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := fw.Run(ctx, func(path string) {
+			select {
+			case notified <- path:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			select {
+			case watchErr <- err:
+			default:
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+// flushPending ingests a batch of pending files and, when it settled a file
+// or failed, records it as a run. As in RunOnce, the after_ingest policies
+// are applied to the settled files once the batch succeeded; the files'
+// grace period is measured from the end of the batch.
+func (w *Watcher) flushPending(ctx context.Context, pending map[string]watchTarget) (RunStats, error) {
+	r := w.Runner
+	started := time.Now()
+	settled, stats, err := w.ingestPending(ctx, pending)
+	if len(settled) == 0 && err == nil {
+		return stats, nil
+	}
+	if err == nil {
+		err = r.applyAfterIngest(ctx, settledJobs(settled), time.Now(), &stats)
+	}
+	if rerr := r.recordRun(r.Trigger, started, stats, err); rerr != nil && err == nil {
+		err = rerr
+	}
+	return stats, err
+}
+
+// ingestPending tries every pending file in name order and drops the ones that
+// reached a final state (ingested, unchanged, outside the max-past window or
+// gone), returning them with their targets. Files still younger than
+// MinFileAge stay pending. It stops between files once ctx is done.
+func (w *Watcher) ingestPending(ctx context.Context, pending map[string]watchTarget) (map[string]watchTarget, RunStats, error) {
+	paths := make([]string, 0, len(pending))
+	for p := range pending {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	settled := map[string]watchTarget{}
+	stats := RunStats{}
+	now := time.Now()
+	for _, p := range paths {
+		if ctx.Err() != nil {
+			return settled, stats, nil
+		}
+		t := pending[p]
+		ok, err := w.Runner.ingestCandidate(t.ClassID, t.CameraID, t.Mode, p, now, &stats)
+		if err != nil {
+			delete(pending, p)
+			return settled, stats, err
+		}
+		if ok {
+			delete(pending, p)
+			settled[p] = t
+		}
+	}
+	return settled, stats, nil
+}
+
+// settledJobs groups settled files by camera, in name order.
+func settledJobs(settled map[string]watchTarget) []cameraJob {
+	paths := make([]string, 0, len(settled))
+	for p := range settled {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	jobs := make([]cameraJob, 0)
+	index := map[[2]string]int{}
+	for _, p := range paths {
+		t := settled[p]
+		key := [2]string{t.ClassID, t.CameraID}
+		i, ok := index[key]
+		if !ok {
+			i = len(jobs)
+			index[key] = i
+			jobs = append(jobs, cameraJob{ClassID: t.ClassID, CameraID: t.CameraID, Mode: t.Mode, AfterIngest: t.AfterIngest})
+		}
+		jobs[i].Files = append(jobs[i].Files, p)
+	}
+	return jobs
+}
+
+func (w *Watcher) report(stats RunStats, err error) {
+	if w.OnRun != nil {
+		w.OnRun(stats, err)
+	}
+}
+
+func watchTargets(resolved input.ResolvedStream) []watchTarget {
+	out := make([]watchTarget, 0)
+	for _, cls := range resolved.Classes {
+		for _, cam := range cls.Cameras {
+			out = append(out, watchTarget{ClassID: cls.ClassID, CameraID: cam.ID, Mode: cam.Mode, Dir: filepath.Clean(cam.EventsDir), Pattern: cam.FilePattern, AfterIngest: cam.AfterIngest})
+		}
+	}
+	return out
+}
+
+func watchDirs(targets []watchTarget) []string {
+	seen := map[string]struct{}{}
+	out := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if _, ok := seen[t.Dir]; ok {
+			continue
+		}
+		seen[t.Dir] = struct{}{}
+		out = append(out, t.Dir)
+	}
+	return out
+}
+
+func matchWatchTarget(targets []watchTarget, path string) (watchTarget, bool) {
+	dir := filepath.Clean(filepath.Dir(path))
+	base := filepath.Base(path)
+	for _, t := range targets {
+		if t.Dir != dir {
+			continue
+		}
+		if ok, _ := filepath.Match(t.Pattern, base); ok {
+			return t, true
+		}
+	}
+	return watchTarget{}, false
+}
@@ -0,0 +1,307 @@
+//go:build linux
+
+package ingest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-json/internal/store"
+)
+
+func TestWatcherIngestsNotifiedFile(t *testing.T) {
+	root := t.TempDir()
+	mustMkdir(t, filepath.Join(root, "c", "front", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "front", "events"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "events"))
+
+	cfg := `{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front"},{"id":"back"}]}]}`
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(cfg))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := make(chan RunStats, 16)
+	w := Watcher{
+		Runner:            &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Millisecond},
+		ReconcileInterval: time.Hour,
+		OnRun: func(stats RunStats, err error) {
+			if err != nil {
+				t.Errorf("watch run: %v", err)
+			}
+			runs <- stats
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	// Initial reconcile pass on an empty tree.
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("initial reconcile did not run")
+	}
+
+	e := `[{"event_type":"person_tracked","room_id":"r","camera_id":"front","pipeline":"p","confidence":0.9,"timestamp":1,"frame_timestamp":1,"frame_source_timestamp":1,"emitted_at":1,"timestamp_offset_seconds":0,"timestamp_stabilizer_skew_seconds":0,"frame_age_seconds":0.1,"frame_transport_delay_seconds":0.1}]`
+	mustWrite(t, filepath.Join(root, "c", "front", "events", "e1.json"), []byte(e))
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case stats := <-runs:
+			if stats.ProcessedFiles == 1 && stats.InsertedEvents == 1 {
+				cancel()
+				if err := <-done; err != nil {
+					t.Fatalf("watcher returned error: %v", err)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatalf("notified file was not ingested")
+		}
+	}
+}
+
+func TestWatcherRetriesMissingEventsDir(t *testing.T) {
+	root := t.TempDir()
+	mustMkdir(t, filepath.Join(root, "c", "front", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "images"))
+	mustMkdir(t, filepath.Join(root, "c", "back", "events"))
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type run struct {
+		stats RunStats
+		err   error
+	}
+	runs := make(chan run, 64)
+	w := Watcher{
+		Runner:            &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Millisecond},
+		ReconcileInterval: 100 * time.Millisecond,
+		OnRun:             func(stats RunStats, err error) { runs <- run{stats, err} },
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	// The front events_dir does not exist yet: passes fail, the watcher keeps
+	// going.
+	select {
+	case got := <-runs:
+		if got.err == nil {
+			t.Fatalf("expected the missing events_dir to be reported")
+		}
+	case err := <-done:
+		t.Fatalf("watcher stopped: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("initial pass did not run")
+	}
+
+	mustMkdir(t, filepath.Join(root, "c", "front", "events"))
+	deadline := time.After(5 * time.Second)
+	for recovered := false; !recovered; {
+		select {
+		case got := <-runs:
+			recovered = got.err == nil
+		case err := <-done:
+			t.Fatalf("watcher stopped: %v", err)
+		case <-deadline:
+			t.Fatalf("watcher did not recover once events_dir existed")
+		}
+	}
+
+	e := `{"event_type":"person_tracked","room_id":"r","camera_id":"front","pipeline":"p","timestamp":1}`
+	mustWrite(t, filepath.Join(root, "c", "front", "events", "e1.json"), []byte(e))
+	deadline = time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-runs:
+			if got.err != nil {
+				t.Fatalf("run after recovery: %v", got.err)
+			}
+			if got.stats.InsertedEvents == 1 {
+				cancel()
+				if err := <-done; err != nil {
+					t.Fatalf("watcher returned error: %v", err)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatalf("file in the new events_dir was not ingested")
+		}
+	}
+}
+
+func TestWatcherRewatchesRecreatedEventsDir(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "c", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "c", cam, "events"))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front"},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type run struct {
+		stats RunStats
+		err   error
+	}
+	runs := make(chan run, 64)
+	w := Watcher{
+		Runner:            &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Millisecond},
+		ReconcileInterval: time.Hour,
+		OnRun:             func(stats RunStats, err error) { runs <- run{stats, err} },
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	select {
+	case got := <-runs:
+		if got.err != nil {
+			t.Fatalf("initial pass: %v", got.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("initial reconcile did not run")
+	}
+
+	// Removing the watched directory is reported without waiting for the
+	// hourly reconcile.
+	dir := filepath.Join(root, "c", "front", "events")
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("remove events dir: %v", err)
+	}
+	select {
+	case got := <-runs:
+		if got.err == nil {
+			t.Fatalf("expected the lost watch to be reported, got %+v", got.stats)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("lost watch was not reported")
+	}
+
+	mustMkdir(t, dir)
+	deadline := time.After(5 * time.Second)
+	for recovered := false; !recovered; {
+		select {
+		case got := <-runs:
+			recovered = got.err == nil
+		case <-deadline:
+			t.Fatalf("watch was not set up again")
+		}
+	}
+
+	e := `{"event_type":"person_tracked","room_id":"r","camera_id":"front","pipeline":"p","timestamp":1}`
+	mustWrite(t, filepath.Join(dir, "e1.json"), []byte(e))
+	deadline = time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-runs:
+			if got.err != nil {
+				t.Fatalf("run after recovery: %v", got.err)
+			}
+			if got.stats.InsertedEvents == 1 {
+				cancel()
+				if err := <-done; err != nil {
+					t.Fatalf("watcher returned error: %v", err)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatalf("file in the recreated events_dir was not ingested")
+		}
+	}
+}
+
+func TestWatcherRecordsBatchesAndAppliesAfterIngest(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "c", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "c", cam, "events"))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[
+		{"id":"front","after_ingest":{"action":"delete","grace_seconds":0}},{"id":"back"}]}]}`))
+
+	st, err := store.Open(filepath.Join(root, "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer st.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := make(chan RunStats, 16)
+	w := Watcher{
+		Runner:            &Runner{Store: st, StreamPath: cfgPath, MinFileAge: time.Millisecond},
+		ReconcileInterval: time.Hour,
+		OnRun: func(stats RunStats, err error) {
+			if err != nil {
+				t.Errorf("watch run: %v", err)
+			}
+			runs <- stats
+		},
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("initial reconcile did not run")
+	}
+
+	file := filepath.Join(root, "c", "front", "events", "e1.json")
+	mustWrite(t, file, []byte(`{"event_type":"person_tracked","room_id":"r","camera_id":"front","pipeline":"p","timestamp":1}`))
+	deadline := time.After(5 * time.Second)
+	for batch := false; !batch; {
+		select {
+		case stats := <-runs:
+			batch = stats.InsertedEvents == 1
+			if batch && stats.DeletedFiles != 1 {
+				t.Fatalf("expected the batch to apply after_ingest, got %+v", stats)
+			}
+		case <-deadline:
+			t.Fatalf("notified file was not ingested")
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("watcher returned error: %v", err)
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be deleted, stat err=%v", file, err)
+	}
+	recorded, err := st.ListIngestRuns("", "", 10)
+	if err != nil {
+		t.Fatalf("list runs: %v", err)
+	}
+	// The initial reconcile pass and the batch; retries of a file still too
+	// young are not recorded.
+	if len(recorded) != 2 || !strings.Contains(string(recorded[0].Stats), `"inserted_events":1`) {
+		t.Fatalf("expected the reconcile pass and the batch to be recorded, got %+v", recorded)
+	}
+}
@@ -0,0 +1,271 @@
+package input
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MemberSeparator joins an archive path and a member name into the virtual
+// path of an archive member, e.g. /srv/front/events/day.tar.gz!/1771230000.json.
+const MemberSeparator = "!/"
+
+// EventSource is one event file ready to parse: a plain or compressed file on
+// disk, or a member of a tar/zip archive.
+type EventSource struct {
+	// Path is the absolute file path or, for archive members, the virtual
+	// member path (see MemberSeparator).
+	Path string
+	// Info holds the size and modification time of the file or member.
+	Info fs.FileInfo
+	// Data is the decompressed content.
+	Data []byte
+}
+
+// IsCompressed reports whether path is a single compressed file (.gz, .gzip,
+// .zst, .zstd) rather than an archive.
+func IsCompressed(path string) bool {
+	return compressionOf(path) != "" && !IsArchive(path)
+}
+
+// IsArchive reports whether path is a tar (optionally gzip or zstd
+// compressed) or zip archive.
+func IsArchive(path string) bool {
+	name := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".tgz"), strings.HasSuffix(name, ".tzst"):
+		return true
+	}
+	return strings.HasSuffix(strings.ToLower(TrimCompressionExt(path)), ".tar")
+}
+
+// TrimCompressionExt strips a trailing .gz/.gzip/.zst/.zstd extension, so
+// "1771230000.json.gz" becomes "1771230000.json".
+func TrimCompressionExt(name string) string {
+	if ext := compressionOf(name); ext != "" {
+		return name[:len(name)-len(ext)]
+	}
+	return name
+}
+
+// MemberPath returns the virtual path of member inside archive.
+func MemberPath(archive, member string) string {
+	return archive + MemberSeparator + member
+}
+
+// SplitMemberPath splits a virtual member path into the archive path and the
+// member name. ok is false for ordinary paths.
+func SplitMemberPath(p string) (archive, member string, ok bool) {
+	i := strings.Index(p, MemberSeparator)
+	if i < 0 {
+		return p, "", false
+	}
+	return p[:i], p[i+len(MemberSeparator):], true
+}
+
+// ReadEventFile returns the content of path, decompressed according to its
+// extension.
+func ReadEventFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, closeFn, err := decompress(f, compressionOf(path), path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	return io.ReadAll(r)
+}
+
+// ReadEventSources returns the event files held by path: the file itself
+// (decompressed) or, for archives, every member that looks like an event file
+// (.json, .ndjson, .jsonl, optionally compressed), sorted by member name.
+func ReadEventSources(path string) ([]EventSource, error) {
+	var out []EventSource
+	err := WalkEventSources(path, func(src EventSource, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			if archive, member, ok := SplitMemberPath(src.Path); ok {
+				return fmt.Errorf("read %s member %s: %w", archive, member, err)
+			}
+			return err
+		}
+		src.Data = b
+		out = append(out, src)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// WalkEventSources is the streaming counterpart of ReadEventSources: fn gets
+// each event file held by path with a reader over its decompressed content
+// (src.Data is nil), so no file or member is held in memory. Zip members are
+// visited by name and tar members in archive order. The reader is only valid
+// until fn returns; an error from fn stops the walk and is returned
+// unchanged.
+func WalkEventSources(path string, fn func(src EventSource, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if IsArchive(path) {
+		if strings.HasSuffix(strings.ToLower(path), ".zip") {
+			return walkZip(f, path, fn)
+		}
+		return walkTar(f, path, fn)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r, closeFn, err := decompress(f, compressionOf(path), path)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	return fn(EventSource{Path: path, Info: info}, r)
+}
+
+func walkTar(f *os.File, archive string, fn func(EventSource, io.Reader) error) error {
+	r, closeFn, err := decompress(f, tarCompression(archive), archive)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar %s: %w", archive, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !isEventMember(hdr.Name) {
+			continue
+		}
+		src := EventSource{Path: MemberPath(archive, path.Clean(hdr.Name)), Info: hdr.FileInfo()}
+		if err := walkMember(tr, hdr.Name, src, fn); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(f *os.File, archive string, fn func(EventSource, io.Reader) error) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("read zip %s: %w", archive, err)
+	}
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, zf := range zr.File {
+		if zf.Mode().IsRegular() && isEventMember(zf.Name) {
+			files = append(files, zf)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return path.Clean(files[i].Name) < path.Clean(files[j].Name) })
+	for _, zf := range files {
+		rc, err := zf.Open()
+		if err != nil {
+			return fmt.Errorf("open %s member %s: %w", archive, zf.Name, err)
+		}
+		src := EventSource{Path: MemberPath(archive, path.Clean(zf.Name)), Info: zf.FileInfo()}
+		err = walkMember(rc, zf.Name, src, fn)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkMember passes one archive member to fn, decompressing members such as
+// 1771230000.json.gz.
+func walkMember(r io.Reader, name string, src EventSource, fn func(EventSource, io.Reader) error) error {
+	dr, closeFn, err := decompress(r, compressionOf(name), name)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", src.Path, err)
+	}
+	defer closeFn()
+	return fn(src, dr)
+}
+
+// isEventMember skips archive metadata (macOS resource forks, dotfiles) and
+// anything that is not an event file.
+func isEventMember(name string) bool {
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(name, "__MACOSX/") {
+		return false
+	}
+	switch strings.ToLower(path.Ext(TrimCompressionExt(base))) {
+	case ".json", ".ndjson", ".jsonl":
+		return true
+	}
+	return false
+}
+
+// decompress wraps r for the compression extension ext (as returned by
+// compressionOf); name is only used in errors. The returned close function
+// releases decoder resources.
+func decompress(r io.Reader, ext, name string) (io.Reader, func(), error) {
+	switch ext {
+	case ".gz", ".gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gzip %s: %w", name, err)
+		}
+		return gz, func() { _ = gz.Close() }, nil
+	case ".zst", ".zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("zstd %s: %w", name, err)
+		}
+		return zr, zr.Close, nil
+	}
+	return r, func() {}, nil
+}
+
+// compressionOf returns the lower-cased compression extension of name, or ""
+// when it is not compressed.
+func compressionOf(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".gz", ".gzip", ".zst", ".zstd"} {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// tarCompression is compressionOf for tar archives, which also accepts the
+// .tgz and .tzst shorthands.
+func tarCompression(archive string) string {
+	switch lower := strings.ToLower(archive); {
+	case strings.HasSuffix(lower, ".tgz"):
+		return ".gz"
+	case strings.HasSuffix(lower, ".tzst"):
+		return ".zst"
+	}
+	return compressionOf(archive)
+}
@@ -2,6 +2,7 @@ package input
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -17,6 +18,23 @@ type Dataset struct {
 }
 
 func Load(paths []string, globs []string) (Dataset, error) {
+	all := make([]model.Event, 0)
+	ds, err := Stream(paths, globs, func(ev model.Event) error {
+		all = append(all, ev)
+		return nil
+	})
+	if err != nil {
+		return Dataset{}, err
+	}
+	ds.Events = all
+	return ds, nil
+}
+
+// Stream is the streaming counterpart of Load: every event of the resolved
+// files is passed to fn as it is decoded and the returned Dataset lists the
+// files but holds no events. An error from fn stops the pass and is returned
+// unchanged.
+func Stream(paths []string, globs []string, fn func(model.Event) error) (Dataset, error) {
 	files, err := resolveFiles(paths, globs)
 	if err != nil {
 		return Dataset{}, err
@@ -25,20 +43,42 @@ func Load(paths []string, globs []string) (Dataset, error) {
 		return Dataset{}, fmt.Errorf("no input files resolved")
 	}
 
-	all := make([]model.Event, 0)
+	loaded := make([]string, 0, len(files))
 	for _, f := range files {
-		b, err := os.ReadFile(f)
-		if err != nil {
-			return Dataset{}, fmt.Errorf("read %s: %w", f, err)
-		}
-		events, err := model.ParseEvents(b)
+		sources, err := decodeFile(f, func(_ string, ev model.Event) error { return fn(ev) })
 		if err != nil {
-			return Dataset{}, fmt.Errorf("parse %s: %w", f, err)
+			return Dataset{}, err
 		}
-		all = append(all, events...)
+		loaded = append(loaded, sources...)
 	}
+	return Dataset{Files: loaded}, nil
+}
 
-	return Dataset{Files: files, Events: all}, nil
+// decodeFile streams the events of every source held by file (the file
+// itself or its archive members) to fn and returns the source paths. Read and
+// parse failures are wrapped with the offending path; errors from fn are
+// returned unchanged.
+func decodeFile(file string, fn func(source string, ev model.Event) error) ([]string, error) {
+	var (
+		sources       []string
+		fnErr, srcErr error
+	)
+	err := WalkEventSources(file, func(src EventSource, r io.Reader) error {
+		err := model.DecodeEvents(r, func(ev model.Event) error {
+			fnErr = fn(src.Path, ev)
+			return fnErr
+		})
+		if err != nil && fnErr == nil {
+			err = fmt.Errorf("parse %s: %w", src.Path, err)
+		}
+		srcErr = err
+		sources = append(sources, src.Path)
+		return err
+	})
+	if err != nil && err != srcErr {
+		err = fmt.Errorf("read %s: %w", file, err)
+	}
+	return sources, err
 }
 
 func resolveFiles(paths []string, globs []string) ([]string, error) {
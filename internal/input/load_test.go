@@ -1,9 +1,16 @@
 package input
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestLoadFromGlob(t *testing.T) {
@@ -21,3 +28,64 @@ func TestLoadFromGlob(t *testing.T) {
 		t.Fatalf("unexpected dataset sizes: files=%d events=%d", len(ds.Files), len(ds.Events))
 	}
 }
+
+func TestLoadReadsCompressedFilesAndArchives(t *testing.T) {
+	dir := t.TempDir()
+	event := func(ts string) []byte {
+		return []byte(`[{"event_type":"person_tracked","camera_id":"front","timestamp":` + ts + `}]`)
+	}
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write(event("1"))
+	_ = gw.Close()
+	mustWrite(t, filepath.Join(dir, "1771230001.json.gz"), gz.Bytes())
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("zstd writer: %v", err)
+	}
+	mustWrite(t, filepath.Join(dir, "1771230002.json.zst"), zw.EncodeAll(event("2"), nil))
+	_ = zw.Close()
+
+	var tgz bytes.Buffer
+	tgw := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(tgw)
+	for _, m := range []struct{ name, ts string }{{"day/1771230003.json", "3"}, {"day/1771230004.json", "4"}, {"day/README.txt", ""}} {
+		body := event(m.ts)
+		if err := tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("tar header: %v", err)
+		}
+		_, _ = tw.Write(body)
+	}
+	_ = tw.Close()
+	_ = tgw.Close()
+	mustWrite(t, filepath.Join(dir, "day.tar.gz"), tgz.Bytes())
+
+	var zb bytes.Buffer
+	zipw := zip.NewWriter(&zb)
+	w, _ := zipw.Create("1771230005.json")
+	_, _ = w.Write(event("5"))
+	_ = zipw.Close()
+	mustWrite(t, filepath.Join(dir, "bundle.zip"), zb.Bytes())
+
+	ds, err := Load(nil, []string{filepath.Join(dir, "*")})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(ds.Events) != 5 || len(ds.Files) != 5 {
+		t.Fatalf("expected 5 events from 5 virtual files, got events=%d files=%v", len(ds.Events), ds.Files)
+	}
+	members := 0
+	for _, f := range ds.Files {
+		if archive, member, ok := SplitMemberPath(f); ok {
+			members++
+			if !IsArchive(archive) || strings.HasSuffix(member, ".txt") {
+				t.Fatalf("unexpected member path %s", f)
+			}
+		}
+	}
+	if members != 3 {
+		t.Fatalf("expected 3 archive members, got %d in %v", members, ds.Files)
+	}
+}
@@ -16,6 +16,42 @@ const (
 	CameraBack  = "back"
 )
 
+// Camera ingestion modes. In batch mode every event file is parsed as a whole
+// and re-ingested when it changes; in tail mode files are append-only NDJSON
+// and only newly appended complete lines are ingested.
+const (
+	ModeBatch = "batch"
+	ModeTail  = "tail"
+)
+
+// After-ingest actions applied to event files once they are fully stored, or
+// skipped for good as older than the max-past window. Failed and quarantined
+// files are exempt.
+const (
+	AfterIngestKeep   = "keep"
+	AfterIngestMove   = "move"
+	AfterIngestDelete = "delete"
+)
+
+// defaultAfterIngestGrace is how long a stored file is left alone before it is
+// moved or deleted when grace_seconds is omitted.
+const defaultAfterIngestGrace = 3600
+
+// AfterIngestConfig is the per-camera after_ingest policy. ArchiveDir is
+// required for move; files land in ArchiveDir/YYYY/MM/DD/ by file epoch.
+type AfterIngestConfig struct {
+	Action       string `json:"action"`
+	ArchiveDir   string `json:"archive_dir,omitempty"`
+	GraceSeconds *int64 `json:"grace_seconds,omitempty"`
+}
+
+// AfterIngest is the resolved after_ingest policy of a camera.
+type AfterIngest struct {
+	Action       string `json:"action"`
+	ArchiveDir   string `json:"archive_dir,omitempty"`
+	GraceSeconds int64  `json:"grace_seconds"`
+}
+
 // StreamConfig defines top-level stream.json layout.
 type StreamConfig struct {
 	Version string        `json:"version"`
@@ -36,28 +72,33 @@ type CameraConfig struct {
 	FilePattern string   `json:"file_pattern"`
 	EventFiles  []string `json:"event_files,omitempty"`
 	EventGlobs  []string `json:"event_globs,omitempty"`
+	Mode        string   `json:"mode,omitempty"`
+	// AfterIngest defaults to keeping files in place.
+	AfterIngest *AfterIngestConfig `json:"after_ingest,omitempty"`
 }
 
 type ResolvedStream struct {
-	ConfigPath string
-	ConfigDir  string
-	Classes    []ResolvedClass
+	ConfigPath string          `json:"config_path"`
+	ConfigDir  string          `json:"config_dir"`
+	Classes    []ResolvedClass `json:"classes"`
 }
 
 type ResolvedClass struct {
-	ClassID string
-	Name    string
-	BaseDir string
-	Cameras []ResolvedCamera
+	ClassID string           `json:"class_id"`
+	Name    string           `json:"name,omitempty"`
+	BaseDir string           `json:"base_dir"`
+	Cameras []ResolvedCamera `json:"cameras"`
 }
 
 type ResolvedCamera struct {
-	ID          string
-	ImagesDir   string
-	EventsDir   string
-	FilePattern string
-	EventFiles  []string
-	EventGlobs  []string
+	ID          string      `json:"id"`
+	ImagesDir   string      `json:"images_dir"`
+	EventsDir   string      `json:"events_dir"`
+	FilePattern string      `json:"file_pattern"`
+	EventFiles  []string    `json:"event_files,omitempty"`
+	EventGlobs  []string    `json:"event_globs,omitempty"`
+	Mode        string      `json:"mode"`
+	AfterIngest AfterIngest `json:"after_ingest"`
 }
 
 type StreamSummary struct {
@@ -86,12 +127,29 @@ type CameraSummary struct {
 }
 
 func LoadFromStreamConfig(path string) (Dataset, error) {
+	all := make([]model.Event, 0)
+	ds, err := StreamFromStreamConfig(path, func(ev model.Event) error {
+		all = append(all, ev)
+		return nil
+	})
+	if err != nil {
+		return Dataset{}, err
+	}
+	ds.Events = all
+	return ds, nil
+}
+
+// StreamFromStreamConfig is the streaming counterpart of
+// LoadFromStreamConfig: every event, tagged with its stream_class_id and
+// stream_camera_id, is passed to fn as it is decoded, and the returned
+// Dataset holds the file list and stream summary but no events. An error from
+// fn stops the pass and is returned unchanged.
+func StreamFromStreamConfig(path string, fn func(model.Event) error) (Dataset, error) {
 	resolved, err := ResolveStreamConfig(path)
 	if err != nil {
 		return Dataset{}, err
 	}
 
-	allEvents := make([]model.Event, 0)
 	allFiles := make([]string, 0)
 	stream := StreamSummary{ConfigPath: resolved.ConfigPath, TotalClasses: len(resolved.Classes), Classes: make([]ClassSummary, 0, len(resolved.Classes))}
 
@@ -108,22 +166,20 @@ func LoadFromStreamConfig(path string) (Dataset, error) {
 				return Dataset{}, fmt.Errorf("class %s camera %s events: %w", cls.ClassID, cam.ID, err)
 			}
 			cameraEventCount := 0
+			cameraFiles := make([]string, 0, len(eventFiles))
 			for _, f := range eventFiles {
-				b, err := os.ReadFile(f)
+				sources, err := decodeFile(f, func(_ string, ev model.Event) error {
+					ev.Raw["stream_class_id"] = cls.ClassID
+					ev.Raw["stream_camera_id"] = cam.ID
+					cameraEventCount++
+					return fn(ev)
+				})
 				if err != nil {
-					return Dataset{}, fmt.Errorf("read %s: %w", f, err)
+					return Dataset{}, err
 				}
-				events, err := model.ParseEvents(b)
-				if err != nil {
-					return Dataset{}, fmt.Errorf("parse %s: %w", f, err)
-				}
-				for i := range events {
-					events[i].Raw["stream_class_id"] = cls.ClassID
-					events[i].Raw["stream_camera_id"] = cam.ID
-				}
-				cameraEventCount += len(events)
-				allEvents = append(allEvents, events...)
+				cameraFiles = append(cameraFiles, sources...)
 			}
+			eventFiles = cameraFiles
 
 			stream.TotalImages += imageCount
 			stream.TotalEventFiles += len(eventFiles)
@@ -142,7 +198,7 @@ func LoadFromStreamConfig(path string) (Dataset, error) {
 	}
 
 	sort.Strings(allFiles)
-	return Dataset{Files: allFiles, Events: allEvents, Stream: &stream}, nil
+	return Dataset{Files: allFiles, Stream: &stream}, nil
 }
 
 func ResolveStreamConfig(path string) (ResolvedStream, error) {
@@ -233,6 +289,20 @@ func ResolveStreamConfig(path string) (ResolvedStream, error) {
 				pattern = "*.json"
 			}
 
+			mode := strings.TrimSpace(cam.Mode)
+			switch mode {
+			case "":
+				mode = ModeBatch
+			case ModeBatch, ModeTail:
+			default:
+				return ResolvedStream{}, fmt.Errorf("class %s camera %s has invalid mode %q (expected %s or %s)", classCfg.ClassID, camID, cam.Mode, ModeBatch, ModeTail)
+			}
+
+			after, err := resolveAfterIngest(cfgDir, cam.AfterIngest)
+			if err != nil {
+				return ResolvedStream{}, fmt.Errorf("class %s camera %s after_ingest: %w", classCfg.ClassID, camID, err)
+			}
+
 			resolvedClass.Cameras = append(resolvedClass.Cameras, ResolvedCamera{
 				ID:          camID,
 				ImagesDir:   imagesDir,
@@ -240,6 +310,8 @@ func ResolveStreamConfig(path string) (ResolvedStream, error) {
 				FilePattern: pattern,
 				EventFiles:  cam.EventFiles,
 				EventGlobs:  cam.EventGlobs,
+				Mode:        mode,
+				AfterIngest: after,
 			})
 		}
 		resolved.Classes = append(resolved.Classes, resolvedClass)
@@ -247,6 +319,37 @@ func ResolveStreamConfig(path string) (ResolvedStream, error) {
 	return resolved, nil
 }
 
+func resolveAfterIngest(cfgDir string, cfg *AfterIngestConfig) (AfterIngest, error) {
+	if cfg == nil {
+		return AfterIngest{Action: AfterIngestKeep}, nil
+	}
+	out := AfterIngest{Action: strings.TrimSpace(cfg.Action), GraceSeconds: defaultAfterIngestGrace}
+	switch out.Action {
+	case "", AfterIngestKeep:
+		return AfterIngest{Action: AfterIngestKeep}, nil
+	case AfterIngestMove, AfterIngestDelete:
+	default:
+		return AfterIngest{}, fmt.Errorf("invalid action %q (expected %s, %s or %s)", cfg.Action, AfterIngestKeep, AfterIngestMove, AfterIngestDelete)
+	}
+	if cfg.GraceSeconds != nil {
+		if *cfg.GraceSeconds < 0 {
+			return AfterIngest{}, fmt.Errorf("grace_seconds must be >= 0")
+		}
+		out.GraceSeconds = *cfg.GraceSeconds
+	}
+	if out.Action == AfterIngestMove {
+		dir := strings.TrimSpace(cfg.ArchiveDir)
+		if dir == "" {
+			return AfterIngest{}, fmt.Errorf("archive_dir is required for %s", AfterIngestMove)
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cfgDir, dir)
+		}
+		out.ArchiveDir = dir
+	}
+	return out, nil
+}
+
 func ResolveCameraEventFiles(cfgDir string, baseDir string, cam ResolvedCamera) ([]string, error) {
 	paths := make([]string, 0)
 	for _, f := range cam.EventFiles {
@@ -81,6 +81,28 @@ func TestLoadFromStreamConfigRequiresFrontBack(t *testing.T) {
 	}
 }
 
+func TestResolveStreamConfigCameraMode(t *testing.T) {
+	root := t.TempDir()
+	for _, cam := range []string{"front", "back"} {
+		mustMkdir(t, filepath.Join(root, "c", cam, "images"))
+		mustMkdir(t, filepath.Join(root, "c", cam, "events"))
+	}
+	cfgPath := filepath.Join(root, "stream.json")
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front","mode":"tail"},{"id":"back"}]}]}`))
+	resolved, err := ResolveStreamConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if got := resolved.Classes[0].Cameras; got[0].Mode != ModeTail || got[1].Mode != ModeBatch {
+		t.Fatalf("unexpected modes: %q %q", got[0].Mode, got[1].Mode)
+	}
+
+	mustWrite(t, cfgPath, []byte(`{"classes":[{"class_id":"c","base_dir":"c","cameras":[{"id":"front","mode":"follow"},{"id":"back"}]}]}`))
+	if _, err := ResolveStreamConfig(cfgPath); err == nil {
+		t.Fatalf("expected error for invalid mode")
+	}
+}
+
 func mustMkdir(t *testing.T, path string) {
 	t.Helper()
 	if err := os.MkdirAll(path, 0o755); err != nil {
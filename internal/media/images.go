@@ -28,6 +28,11 @@ func NewStreamImageResolver(streamPath string) (*StreamImageResolver, error) {
 	if err != nil {
 		return nil, err
 	}
+	return NewResolverFromStream(resolved), nil
+}
+
+// NewResolverFromStream builds a resolver from an already resolved config.
+func NewResolverFromStream(resolved input.ResolvedStream) *StreamImageResolver {
 	m := map[string]string{}
 	for _, cls := range resolved.Classes {
 		for _, cam := range cls.Cameras {
@@ -35,7 +40,7 @@ func NewStreamImageResolver(streamPath string) (*StreamImageResolver, error) {
 			m[key] = cam.ImagesDir
 		}
 	}
-	return &StreamImageResolver{byClassCamera: m}, nil
+	return &StreamImageResolver{byClassCamera: m}
 }
 
 func (r *StreamImageResolver) ResolveImagePath(classID, cameraID string, ts int64) (string, bool) {
@@ -0,0 +1,361 @@
+package model
+
+// Typed structs for the perception and inference event catalog listed in
+// docs/API.md. Every struct embeds Header; payload fields are optional
+// (pointers or nil slices when absent) unless the type registers them as
+// required. Keys without a field stay available through Event.Raw.
+
+// ID is an identifier that pipelines emit either as a string or as a number.
+type ID string
+
+// Header holds the fields shared by every event. EventType is the normalized
+// name from "event_type" or "type".
+type Header struct {
+	EventType                      string   `json:"event_type"`
+	RoomID                         string   `json:"room_id"`
+	CameraID                       string   `json:"camera_id"`
+	Pipeline                       string   `json:"pipeline"`
+	Confidence                     *float64 `json:"confidence"`
+	Timestamp                      *float64 `json:"timestamp"`
+	FrameTimestamp                 *float64 `json:"frame_timestamp"`
+	FrameSourceTimestamp           *float64 `json:"frame_source_timestamp"`
+	EmittedAt                      *float64 `json:"emitted_at"`
+	TimestampOffsetSeconds         *float64 `json:"timestamp_offset_seconds"`
+	TimestampStabilizerSkewSeconds *float64 `json:"timestamp_stabilizer_skew_seconds"`
+	FrameAgeSeconds                *float64 `json:"frame_age_seconds"`
+	FrameTransportDelaySeconds     *float64 `json:"frame_transport_delay_seconds"`
+	PersonID                       *string  `json:"person_id"`
+	GlobalPersonID                 *int64   `json:"global_person_id"`
+	TrackID                        *int64   `json:"track_id"`
+}
+
+func (h *Header) EventHeader() *Header { return h }
+
+// PersonEvent is implemented by the per-track person events, which must carry
+// track_id and person_id.
+type PersonEvent interface {
+	TypedEvent
+	PersonInfo() *Person
+}
+
+// Person holds the identity fields of per-track person events.
+type Person struct {
+	PersonName *string `json:"person_name"`
+	PersonRole string  `json:"person_role"`
+}
+
+func (p *Person) PersonInfo() *Person { return p }
+
+// PostureFields are shared by posture_changed and sleeping_suspected.
+type PostureFields struct {
+	Posture     string   `json:"posture"`
+	Orientation string   `json:"orientation"`
+	Role        string   `json:"role"`
+	BowRatio    *float64 `json:"bow_ratio"`
+	AspectRatio *float64 `json:"aspect_ratio"`
+}
+
+// DeviceUsage is shared by the device and phone usage events.
+type DeviceUsage struct {
+	DeviceType      string    `json:"device_type"`
+	PersonName      *string   `json:"person_name"`
+	PersonRole      string    `json:"person_role"`
+	DurationSeconds *float64  `json:"duration_seconds"`
+	BBox            []float64 `json:"bbox"`
+}
+
+// GroupFields are shared by group_formed and group_updated.
+type GroupFields struct {
+	GroupID         ID       `json:"group_id"`
+	TrackIDs        []int64  `json:"track_ids"`
+	GlobalIDs       []int64  `json:"global_ids"`
+	PersonIDs       []string `json:"person_ids"`
+	Size            *int64   `json:"size"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+}
+
+// Inference holds the fields common to inference outputs ("type" events),
+// which summarize a window of perception events.
+type Inference struct {
+	WindowStart     *float64 `json:"window_start"`
+	WindowEnd       *float64 `json:"window_end"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+	Score           *float64 `json:"score"`
+	Severity        string   `json:"severity"`
+	Reason          string   `json:"reason"`
+	TrackIDs        []int64  `json:"track_ids"`
+	GlobalIDs       []int64  `json:"global_ids"`
+	PersonIDs       []string `json:"person_ids"`
+	Summary         any      `json:"summary"`
+}
+
+// Perception events.
+
+type FrameTick struct {
+	Header
+	DetectionsCount          *int64 `json:"detections_count"`
+	ObjectsCount             *int64 `json:"objects_count"`
+	SecondaryDetectionsCount *int64 `json:"secondary_detections_count"`
+}
+
+type FrameSkipped struct {
+	Header
+	Reason        string `json:"reason"`
+	SkippedFrames *int64 `json:"skipped_frames"`
+}
+
+type PersonDetected struct {
+	Header
+	Person
+	BBox []float64 `json:"bbox"`
+}
+
+type PersonTracked struct {
+	Header
+	Person
+	BBox []float64 `json:"bbox"`
+}
+
+type PersonLost struct {
+	Header
+	Person
+}
+
+type RoleAssigned struct {
+	Header
+	Person
+	Role                     string   `json:"role"`
+	RoleReason               string   `json:"role_reason"`
+	StudentTopRatio          *float64 `json:"student_top_ratio"`
+	StudentBottomRatio       *float64 `json:"student_bottom_ratio"`
+	StudentTopDeficit        *float64 `json:"student_top_deficit"`
+	StudentBottomDeficit     *float64 `json:"student_bottom_deficit"`
+	HeightRatio              *float64 `json:"height_ratio"`
+	StudentFullUniform       *bool    `json:"student_full_uniform"`
+	SeatedTopOnlyStudent     *bool    `json:"seated_top_only_student"`
+	TeacherStandingCandidate *bool    `json:"teacher_standing_candidate"`
+}
+
+type IdentityResolved struct {
+	Header
+	PersonName *string  `json:"person_name"`
+	PersonRole string   `json:"person_role"`
+	MatchScore *float64 `json:"match_score"`
+}
+
+type HeadOrientationChanged struct {
+	Header
+	Person
+	Orientation string `json:"orientation"`
+}
+
+type BodyMovement struct {
+	Header
+	PersonRole      string   `json:"person_role"`
+	MovementType    string   `json:"movement_type"`
+	Magnitude       *float64 `json:"magnitude"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+}
+
+type PostureChanged struct {
+	Header
+	Person
+	PostureFields
+}
+
+type SleepingSuspected struct {
+	Header
+	Person
+	PostureFields
+	BowingDurationSeconds   *float64 `json:"bowing_duration_seconds"`
+	HeadDownDurationSeconds *float64 `json:"head_down_duration_seconds"`
+	SleepDurationSeconds    *float64 `json:"sleep_duration_seconds"`
+}
+
+type ObjectDetected struct {
+	Header
+	ObjectID    ID        `json:"object_id"`
+	ObjectClass string    `json:"object_class"`
+	BBox        []float64 `json:"bbox"`
+}
+
+type ObjectAssociated struct {
+	Header
+	ObjectID    ID        `json:"object_id"`
+	ObjectClass string    `json:"object_class"`
+	BBox        []float64 `json:"bbox"`
+	PersonRole  string    `json:"person_role"`
+}
+
+type DeviceUsageDetected struct {
+	Header
+	DeviceUsage
+}
+
+type TeacherPhoneUsage struct {
+	Header
+	DeviceUsage
+}
+
+type StudentPhoneUsage struct {
+	Header
+	DeviceUsage
+}
+
+type PhoneUsageDetected struct {
+	Header
+	DeviceUsage
+}
+
+type ProximityEvent struct {
+	Header
+	TrackIDs        []int64  `json:"track_ids"`
+	GlobalIDs       []int64  `json:"global_ids"`
+	PersonIDs       []string `json:"person_ids"`
+	Distance        *float64 `json:"distance"`
+	Status          string   `json:"status"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+}
+
+type GroupFormed struct {
+	Header
+	GroupFields
+}
+
+type GroupUpdated struct {
+	Header
+	GroupFields
+}
+
+type AttentionObservation struct {
+	Header
+	PersonRole     string   `json:"person_role"`
+	Orientation    string   `json:"orientation"`
+	Target         string   `json:"target"`
+	AttentionScore *float64 `json:"attention_score"`
+}
+
+// Inference events.
+
+type CheatingSuspicion struct {
+	Header
+	Inference
+}
+
+type TeacherEngagement struct {
+	Header
+	Inference
+}
+
+type ParticipationSummary struct {
+	Header
+	Inference
+}
+
+type TeacherStudentInteraction struct {
+	Header
+	Inference
+}
+
+type TeacherAbsence struct {
+	Header
+	Inference
+}
+
+type PaperInteraction struct {
+	Header
+	Inference
+}
+
+type SafetySuspicion struct {
+	Header
+	Inference
+}
+
+type AttentionSummary struct {
+	Header
+	Inference
+}
+
+type OfftaskMovement struct {
+	Header
+	Inference
+}
+
+type StudentSleepRisk struct {
+	Header
+	Inference
+}
+
+type StudentDeviceDistraction struct {
+	Header
+	Inference
+}
+
+type TeacherDeviceUsage struct {
+	Header
+	Inference
+}
+
+type StudentBehaviorSummary struct {
+	Header
+	Inference
+}
+
+type GroupParticipationSummary struct {
+	Header
+	Inference
+}
+
+type GroupCollaboration struct {
+	Header
+	Inference
+}
+
+type LessonComprehensiveSummary struct {
+	Header
+	Inference
+}
+
+func init() {
+	person := []string{"track_id", "person_id"}
+
+	RegisterEventType("frame_tick", DecodeAs[FrameTick]())
+	RegisterEventType("frame_skipped", DecodeAs[FrameSkipped]())
+	RegisterEventType("person_detected", DecodeAs[PersonDetected](person...))
+	RegisterEventType("person_tracked", DecodeAs[PersonTracked](person...))
+	RegisterEventType("person_lost", DecodeAs[PersonLost](person...))
+	RegisterEventType("role_assigned", DecodeAs[RoleAssigned](person...))
+	RegisterEventType("identity_resolved", DecodeAs[IdentityResolved]())
+	RegisterEventType("head_orientation_changed", DecodeAs[HeadOrientationChanged](person...))
+	RegisterEventType("body_movement", DecodeAs[BodyMovement]())
+	RegisterEventType("posture_changed", DecodeAs[PostureChanged](person...))
+	RegisterEventType("sleeping_suspected", DecodeAs[SleepingSuspected](person...))
+	RegisterEventType("object_detected", DecodeAs[ObjectDetected]())
+	RegisterEventType("object_associated", DecodeAs[ObjectAssociated]())
+	RegisterEventType("device_usage_detected", DecodeAs[DeviceUsageDetected]())
+	RegisterEventType("teacher_phone_usage", DecodeAs[TeacherPhoneUsage]())
+	RegisterEventType("student_phone_usage", DecodeAs[StudentPhoneUsage]())
+	RegisterEventType("phone_usage_detected", DecodeAs[PhoneUsageDetected]())
+	RegisterEventType("proximity_event", DecodeAs[ProximityEvent]())
+	RegisterEventType("group_formed", DecodeAs[GroupFormed]())
+	RegisterEventType("group_updated", DecodeAs[GroupUpdated]())
+	RegisterEventType("attention_observation", DecodeAs[AttentionObservation]())
+
+	RegisterEventType("cheating_suspicion", DecodeAs[CheatingSuspicion]())
+	RegisterEventType("teacher_engagement", DecodeAs[TeacherEngagement]())
+	RegisterEventType("participation_summary", DecodeAs[ParticipationSummary]())
+	RegisterEventType("teacher_student_interaction", DecodeAs[TeacherStudentInteraction]())
+	RegisterEventType("teacher_absence", DecodeAs[TeacherAbsence]())
+	RegisterEventType("paper_interaction", DecodeAs[PaperInteraction]())
+	RegisterEventType("safety_suspicion", DecodeAs[SafetySuspicion]())
+	RegisterEventType("attention_summary", DecodeAs[AttentionSummary]())
+	RegisterEventType("offtask_movement", DecodeAs[OfftaskMovement]())
+	RegisterEventType("student_sleep_risk", DecodeAs[StudentSleepRisk]())
+	RegisterEventType("student_device_distraction", DecodeAs[StudentDeviceDistraction]())
+	RegisterEventType("teacher_device_usage", DecodeAs[TeacherDeviceUsage]())
+	RegisterEventType("student_behavior_summary", DecodeAs[StudentBehaviorSummary]())
+	RegisterEventType("group_participation_summary", DecodeAs[GroupParticipationSummary]())
+	RegisterEventType("group_collaboration", DecodeAs[GroupCollaboration]())
+	RegisterEventType("lesson_comprehensive_summary", DecodeAs[LessonComprehensiveSummary]())
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode"
+)
+
+// EventStream produces events by calling yield once per event and stops at
+// the first error yield returns, which it returns unchanged. A reader bound
+// to DecodeEvents is an EventStream:
+//
+//	func(yield func(Event) error) error { return DecodeEvents(r, yield) }
+type EventStream func(yield func(Event) error) error
+
+// DecodeEvents is the streaming counterpart of ParseEvents: it accepts the
+// same inputs (a JSON array, a single object or NDJSON) but decodes them one
+// event at a time, so only the current event is held in memory. fn is called
+// for each event in input order; decoding stops at the first error fn
+// returns, which DecodeEvents returns unchanged.
+func DecodeEvents(r io.Reader, fn func(Event) error) error {
+	br := bufio.NewReader(r)
+	first, err := firstNonSpace(br)
+	if err == io.EOF {
+		return errors.New("empty input")
+	}
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("decode JSON array: %w", err)
+		}
+		for i := 0; dec.More(); i++ {
+			ev, err := decodeNext(dec)
+			if err != nil {
+				return fmt.Errorf("decode JSON array element %d: %w", i, err)
+			}
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return fmt.Errorf("decode JSON array: %w", err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return errors.New("decode JSON array: unexpected data after array")
+		}
+		return nil
+	}
+
+	// A single object and NDJSON are both a sequence of top-level objects.
+	for i := 0; ; i++ {
+		ev, err := decodeNext(dec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decode JSON object %d: %w", i, err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeNext reads the next value of dec as an event, keeping its bytes.
+func decodeNext(dec *json.Decoder) (Event, error) {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return Event{}, err
+	}
+	return DecodeEvent(raw)
+}
+
+// firstNonSpace returns the first non-whitespace byte of br without
+// consuming it.
+func firstNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			return b, br.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEventsMatchesParseEvents(t *testing.T) {
+	b, err := os.ReadFile("../../.material/samples/1771233054.json")
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	inputs := map[string]string{
+		"sample": string(b),
+		"ndjson": "{\"event_type\":\"a\",\"timestamp\":1}\n\n{\"event_type\":\"b\",\"timestamp\":2}\n",
+		"object": "\n  {\n    \"event_type\": \"a\",\n    \"timestamp\": 1\n  }\n",
+		"empty":  " [ ] ",
+	}
+	for name, in := range inputs {
+		want, err := ParseEvents([]byte(in))
+		if err != nil {
+			t.Fatalf("%s: parse: %v", name, err)
+		}
+		var got []Event
+		if err := DecodeEvents(strings.NewReader(in), func(ev Event) error {
+			got = append(got, ev)
+			return nil
+		}); err != nil {
+			t.Fatalf("%s: decode: %v", name, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d events, got %d", name, len(want), len(got))
+		}
+		for i := range got {
+			if fingerprint(t, got[i]) != fingerprint(t, want[i]) {
+				t.Fatalf("%s: event %d differs", name, i)
+			}
+		}
+	}
+}
+
+func TestDecodeEventsStopsOnErrors(t *testing.T) {
+	stop := errors.New("stop")
+	seen := 0
+	err := DecodeEvents(strings.NewReader(`[{"event_type":"a"},{"event_type":"b"},{"event_type":"c"}]`), func(Event) error {
+		seen++
+		if seen == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || seen != 2 {
+		t.Fatalf("expected fn error after 2 events, got err=%v seen=%d", err, seen)
+	}
+
+	for _, in := range []string{"", "  \n", `[{"event_type":"a"},`, `[{"event_type":"a"}] {}`, "{\"event_type\":\"a\"}\n{\"event_type\":", `[null]`, "{\"event_type\":\"a\"}\nnull\n"} {
+		if err := DecodeEvents(strings.NewReader(in), func(Event) error { return nil }); err == nil {
+			t.Fatalf("expected error for %q", in)
+		}
+	}
+}
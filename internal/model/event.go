@@ -1,17 +1,24 @@
 package model
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"sort"
-	"strings"
+	"strconv"
 )
 
-// Event stores one decoded event while keeping all original keys.
+// Event stores one decoded event while keeping all original keys. Numbers
+// in Raw are json.Number, so integers keep their exact value; use the typed
+// accessors rather than type assertions.
 type Event struct {
 	Raw map[string]any
+	// RawJSON is the event exactly as it appeared in the input, or nil for
+	// events built in code. Keys added to Raw after decoding (such as the
+	// stream routing keys) are not reflected in it.
+	RawJSON json.RawMessage
 }
 
 // EventTypeName normalizes the event type across streams:
@@ -49,40 +56,51 @@ type CommonFields struct {
 
 // ParseEvents decodes one input that can be an array, a single object, or NDJSON.
 func ParseEvents(data []byte) ([]Event, error) {
-	trimmed := strings.TrimSpace(string(data))
-	if trimmed == "" {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
 		return nil, errors.New("empty input")
 	}
 
-	if strings.HasPrefix(trimmed, "[") {
-		var arr []map[string]any
-		if err := json.Unmarshal(data, &arr); err != nil {
+	if trimmed[0] == '[' {
+		var arr []json.RawMessage
+		if err := json.Unmarshal(trimmed, &arr); err != nil {
 			return nil, fmt.Errorf("decode JSON array: %w", err)
 		}
-		return wrap(arr), nil
+		out := make([]Event, 0, len(arr))
+		for _, raw := range arr {
+			ev, err := DecodeEvent(raw)
+			if err != nil {
+				return nil, fmt.Errorf("decode JSON array: %w", err)
+			}
+			out = append(out, ev)
+		}
+		return out, nil
 	}
 
-	if strings.HasPrefix(trimmed, "{") {
-		var obj map[string]any
-		if err := json.Unmarshal(data, &obj); err != nil {
+	if trimmed[0] == '{' {
+		ev, err := DecodeEvent(trimmed)
+		if err == nil {
+			return []Event{ev}, nil
+		}
+		// Several objects on separate lines are NDJSON, not one broken object.
+		if !bytes.Contains(trimmed, []byte("\n")) {
 			return nil, fmt.Errorf("decode JSON object: %w", err)
 		}
-		return []Event{{Raw: obj}}, nil
 	}
 
 	// Fallback: NDJSON
-	lines := strings.Split(trimmed, "\n")
+	lines := bytes.Split(trimmed, []byte("\n"))
 	out := make([]Event, 0, len(lines))
 	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
 			continue
 		}
-		var obj map[string]any
-		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		ev, err := DecodeEvent(line)
+		if err != nil {
 			return nil, fmt.Errorf("decode NDJSON line %d: %w", i+1, err)
 		}
-		out = append(out, Event{Raw: obj})
+		out = append(out, ev)
 	}
 	if len(out) == 0 {
 		return nil, errors.New("no events decoded")
@@ -90,12 +108,22 @@ func ParseEvents(data []byte) ([]Event, error) {
 	return out, nil
 }
 
-func wrap(arr []map[string]any) []Event {
-	out := make([]Event, 0, len(arr))
-	for _, it := range arr {
-		out = append(out, Event{Raw: it})
-	}
-	return out
+// DecodeEvent decodes one JSON object with numbers kept as json.Number and
+// the object's bytes kept as RawJSON, as ParseEvents does for each event.
+func DecodeEvent(b []byte) (Event, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return Event{}, err
+	}
+	if obj == nil {
+		return Event{}, errors.New("event is null, expected an object")
+	}
+	if dec.More() {
+		return Event{}, errors.New("unexpected data after object")
+	}
+	return Event{Raw: obj, RawJSON: b}, nil
 }
 
 func (e Event) Keys() []string {
@@ -141,22 +169,15 @@ func (e Event) Float64(key string) (float64, bool) {
 	if !ok || v == nil {
 		return 0, false
 	}
-	n, ok := v.(float64)
-	if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
-		return 0, false
-	}
-	return n, true
+	return AsFloat64(v)
 }
 
 func (e Event) Int64(key string) (int64, bool) {
-	n, ok := e.Float64(key)
-	if !ok {
-		return 0, false
-	}
-	if math.Mod(n, 1) != 0 {
+	v, ok := e.Raw[key]
+	if !ok || v == nil {
 		return 0, false
 	}
-	return int64(n), true
+	return AsInt64(v)
 }
 
 func (e Event) NullableInt64(key string) (*int64, bool) {
@@ -167,11 +188,10 @@ func (e Event) NullableInt64(key string) (*int64, bool) {
 	if v == nil {
 		return nil, true
 	}
-	n, ok := v.(float64)
-	if !ok || math.Mod(n, 1) != 0 {
+	i, ok := AsInt64(v)
+	if !ok {
 		return nil, false
 	}
-	i := int64(n)
 	return &i, true
 }
 
@@ -206,15 +226,86 @@ func (e Event) Int64Slice(key string) ([]int64, bool) {
 	}
 	out := make([]int64, 0, len(arr))
 	for _, item := range arr {
-		n, ok := item.(float64)
-		if !ok || math.Mod(n, 1) != 0 {
+		n, ok := AsInt64(item)
+		if !ok {
 			return nil, false
 		}
-		out = append(out, int64(n))
+		out = append(out, n)
 	}
 	return out, true
 }
 
+// AsFloat64 converts a decoded JSON value to a finite float64. It accepts
+// json.Number, float64 and strings holding a JSON number such as "12.5".
+func AsFloat64(v any) (float64, bool) {
+	var n float64
+	switch t := v.(type) {
+	case float64:
+		n = t
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return 0, false
+		}
+		n = f
+	case string:
+		if !isNumberText(t) {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return 0, false
+		}
+		n = f
+	default:
+		return 0, false
+	}
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, false
+	}
+	return n, true
+}
+
+// AsInt64 converts a decoded JSON value to an int64. Integer literals are
+// converted exactly, so IDs above 2^53 keep every digit; other numbers must
+// be integral and in range. Like AsFloat64 it accepts numeric strings.
+func AsInt64(v any) (int64, bool) {
+	var text string
+	switch t := v.(type) {
+	case float64:
+		if math.Mod(t, 1) != 0 || t < math.MinInt64 || t >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(t), true
+	case json.Number:
+		text = string(t)
+	case string:
+		if !isNumberText(t) {
+			return 0, false
+		}
+		text = t
+	default:
+		return 0, false
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return i, true
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, false
+	}
+	return AsInt64(f)
+}
+
+// isNumberText reports whether s is a JSON number literal, which rules out
+// the "NaN", "Inf", hex and underscore forms strconv also accepts.
+func isNumberText(s string) bool {
+	if s == "" || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
 func (e Event) ParseCommonFields() (CommonFields, []string) {
 	var (
 		c        CommonFields
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"encoding/json"
+	"math"
 	"os"
 	"testing"
 )
@@ -57,3 +59,101 @@ func TestEventTypeNameFallsBackToType(t *testing.T) {
 		}
 	}
 }
+
+func TestFingerprintIgnoresStreamRoutingAndTypeKey(t *testing.T) {
+	perception, err := ParseEvents([]byte(`{"event_type":"person_tracked","camera_id":"front","track_id":3,"timestamp":10.5,"emitted_at":11}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	routed, err := ParseEvents([]byte(`{"type":"person_tracked","camera_id":"front","track_id":3,"timestamp":10.5,"emitted_at":11,"stream_class_id":"c","stream_camera_id":"front"}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	later, err := ParseEvents([]byte(`{"event_type":"person_tracked","camera_id":"front","track_id":3,"timestamp":10.5,"emitted_at":12}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if fingerprint(t, perception[0]) != fingerprint(t, routed[0]) {
+		t.Fatalf("expected identical fingerprints")
+	}
+	if fingerprint(t, perception[0]) == fingerprint(t, later[0]) {
+		t.Fatalf("expected emitted_at to change the fingerprint")
+	}
+}
+
+func TestFingerprintFailsOnUnencodableEvent(t *testing.T) {
+	for _, v := range []any{math.NaN(), math.Inf(1), json.Number("abc")} {
+		fp, err := Event{Raw: map[string]any{"event_type": "frame_tick", "timestamp": v}}.Fingerprint()
+		if err == nil || fp != "" {
+			t.Fatalf("expected no fingerprint for timestamp %v, got %q err=%v", v, fp, err)
+		}
+	}
+}
+
+func fingerprint(t *testing.T, ev Event) string {
+	t.Helper()
+	fp, err := ev.Fingerprint()
+	if err != nil {
+		t.Fatalf("fingerprint: %v", err)
+	}
+	return fp
+}
+
+func TestParseEventsMultiLineNDJSON(t *testing.T) {
+	input := "{\"event_type\":\"a\",\"timestamp\":1}\n{\"event_type\":\"b\",\"timestamp\":2}\n"
+	events, err := ParseEvents([]byte(input))
+	if err != nil {
+		t.Fatalf("parse ndjson: %v", err)
+	}
+	if len(events) != 2 || events[1].EventTypeName() != "b" {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+}
+
+func TestNumbersAreDecodedExactly(t *testing.T) {
+	input := `{"event_type":"person_tracked", "track_id":9007199254740993, "global_person_id":"9223372036854775807", "confidence":"0.75", "timestamp":1771233054.125, "frame_age_seconds":"NaN", "track_ids":[1,"2",3.0]}`
+	events, err := ParseEvents([]byte(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ev := events[0]
+	if string(ev.RawJSON) != input {
+		t.Fatalf("expected original bytes, got %s", ev.RawJSON)
+	}
+	if v, ok := ev.Int64("track_id"); !ok || v != 9007199254740993 {
+		t.Fatalf("expected exact track_id, got %d ok=%v", v, ok)
+	}
+	if v, ok := ev.NullableInt64("global_person_id"); !ok || *v != 9223372036854775807 {
+		t.Fatalf("expected numeric string global_person_id, got %v ok=%v", v, ok)
+	}
+	if v, ok := ev.Float64("confidence"); !ok || v != 0.75 {
+		t.Fatalf("expected numeric string confidence, got %v ok=%v", v, ok)
+	}
+	if _, ok := ev.Int64("timestamp"); ok {
+		t.Fatalf("expected fractional timestamp to be rejected as int64")
+	}
+	if _, ok := ev.Float64("frame_age_seconds"); ok {
+		t.Fatalf("expected NaN string to be rejected")
+	}
+	if ids, ok := ev.Int64Slice("track_ids"); !ok || len(ids) != 3 || ids[1] != 2 || ids[2] != 3 {
+		t.Fatalf("unexpected track_ids %v ok=%v", ids, ok)
+	}
+}
+
+func TestFingerprintIsStableAcrossNumberRepresentations(t *testing.T) {
+	decoded, err := ParseEvents([]byte(`{"event_type":"frame_tick","timestamp":10.50,"detections_count":2,"bbox":[1,2,3e0,4]}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// Events used to be decoded with float64 numbers.
+	legacy := Event{Raw: map[string]any{"event_type": "frame_tick", "timestamp": 10.5, "detections_count": 2.0, "bbox": []any{1.0, 2.0, 3.0, 4.0}}}
+	if fingerprint(t, decoded[0]) != fingerprint(t, legacy) {
+		t.Fatalf("expected fingerprint to match the float64 representation")
+	}
+
+	a, _ := ParseEvents([]byte(`{"event_type":"person_tracked","track_id":9007199254740993}`))
+	b, _ := ParseEvents([]byte(`{"event_type":"person_tracked","track_id":9007199254740992}`))
+	if fingerprint(t, a[0]) == fingerprint(t, b[0]) {
+		t.Fatalf("expected large IDs to hash differently")
+	}
+}
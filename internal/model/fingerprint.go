@@ -0,0 +1,72 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Fingerprint returns a stable content hash used to recognise the same event
+// arriving through different sources. The stream routing keys added at ingest
+// time (stream_class_id, stream_camera_id) are ignored, the event type is
+// normalized across "event_type"/"type", and camera_id falls back to
+// stream_camera_id, so an event posted to the API and the same event read from
+// a camera file hash identically. All remaining fields (track, timestamps,
+// emitted_at, payload) are part of the hash with keys in sorted order. An
+// event that cannot be encoded as JSON (a NaN or infinite float, an invalid
+// json.Number) has no fingerprint and returns an error.
+func (e Event) Fingerprint() (string, error) {
+	canon := make(map[string]any, len(e.Raw)+1)
+	for k, v := range e.Raw {
+		switch k {
+		case "stream_class_id", "stream_camera_id", "event_type", "type":
+			continue
+		}
+		canon[k] = canonicalNumbers(v)
+	}
+	canon["event_type"] = e.EventTypeName()
+	if _, ok := canon["camera_id"]; !ok {
+		if cam, ok := e.String("stream_camera_id"); ok {
+			canon["camera_id"] = cam
+		}
+	}
+	// encoding/json sorts map keys, which makes the encoding canonical.
+	b, err := json.Marshal(canon)
+	if err != nil {
+		return "", fmt.Errorf("fingerprint event: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalNumbers rewrites json.Number values as float64, the type events
+// were decoded to before numbers were kept exact, so "1", "1.0" and "1e0"
+// hash alike and existing fingerprints stay valid. Integers that float64
+// cannot hold exactly keep their literal so distinct large IDs stay distinct.
+func canonicalNumbers(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil && (i > 1<<53 || i < -(1<<53)) {
+			return t
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, item := range t {
+			out[k] = canonicalNumbers(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, item := range t {
+			out[i] = canonicalNumbers(item)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,257 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TypedEvent is implemented by the struct of every catalogued event type
+// (see catalog.go). Values are always pointers, e.g. *PersonTracked.
+type TypedEvent interface {
+	EventHeader() *Header
+}
+
+// Decoder turns a generic event into its typed struct.
+type Decoder func(Event) (TypedEvent, error)
+
+// ErrUnknownEventType is returned by Event.Typed for event types without a
+// registered decoder.
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// FieldError is one field of an event that could not be decoded.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// DecodeError lists the fields of an event that are missing (when required)
+// or hold a value of the wrong type.
+type DecodeError struct {
+	EventType string
+	Fields    []FieldError
+}
+
+func (e *DecodeError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return fmt.Sprintf("decode %s: %s", e.EventType, strings.Join(parts, "; "))
+}
+
+// Has reports whether field failed to decode. It is safe on a nil error.
+func (e *DecodeError) Has(field string) bool {
+	if e == nil {
+		return false
+	}
+	for _, f := range e.Fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Decoder{}
+)
+
+// RegisterEventType maps an event type name to its decoder, replacing any
+// earlier registration. The catalogued types register themselves.
+func RegisterEventType(name string, d Decoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = d
+}
+
+// LookupEventType returns the decoder registered for name.
+func LookupEventType(name string) (Decoder, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	d, ok := registry[name]
+	return d, ok
+}
+
+// EventTypes returns the registered event type names in sorted order.
+func EventTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	out := make([]string, 0, len(registry))
+	for name := range registry {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Typed decodes the event into the struct registered for its type. Unknown
+// types return an error wrapping ErrUnknownEventType. When fields are missing
+// or mistyped it returns a *DecodeError together with the value decoded from
+// the remaining fields, so callers can still inspect what was valid.
+func (e Event) Typed() (TypedEvent, error) {
+	name := e.EventTypeName()
+	d, ok := LookupEventType(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEventType, name)
+	}
+	return d(e)
+}
+
+// DecodeAs returns a Decoder that fills a new T from the event's keys using
+// the struct's json tags, with embedded structs flattened. Numbers are read
+// with AsFloat64/AsInt64, so numeric strings are accepted. A JSON null leaves
+// the field at its zero value. Fields listed in required must be present
+// (null is allowed).
+func DecodeAs[T any, PT interface {
+	*T
+	TypedEvent
+}](required ...string) Decoder {
+	return func(e Event) (TypedEvent, error) {
+		out := PT(new(T))
+		var fields []FieldError
+		decodeStruct(e.Raw, reflect.ValueOf(out).Elem(), &fields)
+		for _, name := range required {
+			if _, ok := e.Raw[name]; !ok {
+				fields = append(fields, FieldError{Field: name, Message: "missing"})
+			}
+		}
+		name := e.EventTypeName()
+		out.EventHeader().EventType = name
+		if len(fields) > 0 {
+			return out, &DecodeError{EventType: name, Fields: fields}
+		}
+		return out, nil
+	}
+}
+
+var idType = reflect.TypeOf(ID(""))
+
+// decodeStruct fills dst from obj and appends one FieldError per top-level
+// key that does not fit its field.
+func decodeStruct(obj map[string]any, dst reflect.Value, fields *[]FieldError) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			decodeStruct(obj, dst.Field(i), fields)
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || !sf.IsExported() {
+			continue
+		}
+		v, ok := obj[name]
+		if !ok {
+			continue
+		}
+		val, err := convertValue(v, sf.Type)
+		if err != nil {
+			*fields = append(*fields, FieldError{Field: name, Message: err.Error()})
+			continue
+		}
+		dst.Field(i).Set(val)
+	}
+}
+
+// convertValue converts a decoded JSON value to type t.
+func convertValue(v any, t reflect.Type) (reflect.Value, error) {
+	if v == nil {
+		return reflect.Zero(t), nil
+	}
+	if t == idType {
+		switch id := v.(type) {
+		case string:
+			return reflect.ValueOf(ID(id)), nil
+		case json.Number:
+			return reflect.ValueOf(ID(id)), nil
+		}
+		return reflect.Value{}, errors.New("expected string or number")
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		inner, err := convertValue(v, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(t.Elem())
+		p.Elem().Set(inner)
+		return p, nil
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return reflect.Value{}, errors.New("expected string")
+		}
+		return reflect.ValueOf(s).Convert(t), nil
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return reflect.Value{}, errors.New("expected boolean")
+		}
+		return reflect.ValueOf(b).Convert(t), nil
+	case reflect.Float64:
+		n, ok := AsFloat64(v)
+		if !ok {
+			return reflect.Value{}, errors.New("expected number")
+		}
+		return reflect.ValueOf(n).Convert(t), nil
+	case reflect.Int64, reflect.Int:
+		n, ok := AsInt64(v)
+		if !ok {
+			return reflect.Value{}, errors.New("expected integer")
+		}
+		return reflect.ValueOf(n).Convert(t), nil
+	case reflect.Slice:
+		arr, ok := v.([]any)
+		if !ok {
+			return reflect.Value{}, errors.New("expected array")
+		}
+		out := reflect.MakeSlice(t, 0, len(arr))
+		for i, item := range arr {
+			val, err := convertValue(item, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element %d: %w", i, err)
+			}
+			out = reflect.Append(out, val)
+		}
+		return out, nil
+	case reflect.Map:
+		obj, ok := v.(map[string]any)
+		if !ok || t.Key().Kind() != reflect.String {
+			return reflect.Value{}, errors.New("expected object")
+		}
+		out := reflect.MakeMapWithSize(t, len(obj))
+		for k, item := range obj {
+			val, err := convertValue(item, t.Elem())
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %q: %w", k, err)
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), val)
+		}
+		return out, nil
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return reflect.Value{}, errors.New("expected object")
+		}
+		out := reflect.New(t).Elem()
+		var fields []FieldError
+		decodeStruct(obj, out, &fields)
+		if len(fields) > 0 {
+			return reflect.Value{}, fmt.Errorf("%s: %s", fields[0].Field, fields[0].Message)
+		}
+		return out, nil
+	case reflect.Interface:
+		if !reflect.TypeOf(v).AssignableTo(t) {
+			return reflect.Value{}, fmt.Errorf("expected %s", t)
+		}
+		return reflect.ValueOf(v), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported field type %s", t)
+}
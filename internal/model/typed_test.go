@@ -0,0 +1,71 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTypedDecodesCatalogEvents(t *testing.T) {
+	if n := len(EventTypes()); n != 37 {
+		t.Fatalf("expected 37 catalogued event types, got %d", n)
+	}
+
+	events, err := ParseEvents([]byte(`[
+		{"event_type":"person_tracked","room_id":"room1","camera_id":"front","confidence":"0.85","timestamp":1771233054.2,"track_id":16,"person_id":"unknown:3","global_person_id":3,"bbox":[343,316,463,500],"person_name":null,"person_role":"student"},
+		{"type":"cheating_suspicion","room_id":"room1","score":0.7,"track_ids":[1,2],"summary":{"note":"x"}}
+	]`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	typed, err := events[0].Typed()
+	if err != nil {
+		t.Fatalf("typed: %v", err)
+	}
+	pt, ok := typed.(*PersonTracked)
+	if !ok {
+		t.Fatalf("expected *PersonTracked, got %T", typed)
+	}
+	if *pt.TrackID != 16 || *pt.Confidence != 0.85 || len(pt.BBox) != 4 || pt.PersonName != nil || pt.PersonRole != "student" || pt.EventType != "person_tracked" {
+		t.Fatalf("unexpected person_tracked: %+v", pt)
+	}
+
+	typed, err = events[1].Typed()
+	if err != nil {
+		t.Fatalf("typed inference: %v", err)
+	}
+	cs := typed.(*CheatingSuspicion)
+	if cs.EventType != "cheating_suspicion" || *cs.Score != 0.7 || len(cs.TrackIDs) != 2 || cs.Summary == nil {
+		t.Fatalf("unexpected cheating_suspicion: %+v", cs)
+	}
+}
+
+func TestTypedReportsFieldErrors(t *testing.T) {
+	events, err := ParseEvents([]byte(`[
+		{"event_type":"proximity_event","track_ids":[1,"x"],"distance":2.5,"status":"close"},
+		{"event_type":"person_lost","person_id":7},
+		{"event_type":"not_catalogued"}
+	]`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	typed, err := events[0].Typed()
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || !decodeErr.Has("track_ids") || decodeErr.Has("distance") {
+		t.Fatalf("expected track_ids decode error, got %v", err)
+	}
+	prox := typed.(*ProximityEvent)
+	if prox.TrackIDs != nil || *prox.Distance != 2.5 || prox.Status != "close" {
+		t.Fatalf("expected remaining fields to be decoded, got %+v", prox)
+	}
+
+	_, err = events[1].Typed()
+	if !errors.As(err, &decodeErr) || !decodeErr.Has("track_id") || !decodeErr.Has("person_id") {
+		t.Fatalf("expected missing track_id and mistyped person_id, got %v", err)
+	}
+
+	if _, err := events[2].Typed(); !errors.Is(err, ErrUnknownEventType) {
+		t.Fatalf("expected ErrUnknownEventType, got %v", err)
+	}
+}
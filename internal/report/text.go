@@ -34,7 +34,7 @@ func RenderText(result analyze.Analysis, files []string, stream *input.StreamSum
 
 	fmt.Fprintf(&b, "Total Events: %d\n", result.TotalEvents)
 	fmt.Fprintf(&b, "Unique Person IDs: %d | Global IDs: %d | Track IDs: %d\n", result.UniquePersonIDs, result.UniqueGlobalIDs, result.UniqueTrackIDs)
-	fmt.Fprintf(&b, "Validation: %d errors, %d warnings\n", result.ErrorCount, result.WarningCount)
+	fmt.Fprintf(&b, "Validation: %d errors, %d warnings (%d temporal)\n", result.ErrorCount, result.WarningCount, result.TemporalIssueCount)
 	fmt.Fprintf(&b, "\n")
 
 	fmt.Fprintf(&b, "Event Types\n")
@@ -87,20 +87,33 @@ func RenderText(result analyze.Analysis, files []string, stream *input.StreamSum
 		fmt.Fprintf(&b, "\n")
 	}
 
-	if len(result.Issues) > 0 {
-		fmt.Fprintf(&b, "Issues\n")
-		limit := len(result.Issues)
-		if maxIssues > 0 && maxIssues < limit {
-			limit = maxIssues
-		}
-		for i := 0; i < limit; i++ {
-			it := result.Issues[i]
-			fmt.Fprintf(&b, "- [%s] %s (event #%d, type=%s): %s\n", strings.ToUpper(string(it.Severity)), it.Code, it.EventIndex, it.EventType, it.Message)
-		}
-		if limit < len(result.Issues) {
-			fmt.Fprintf(&b, "- ... %d more issues not shown\n", len(result.Issues)-limit)
-		}
-	}
+	// Issues may already be capped by the analyzer; the counts cover all.
+	writeIssues(&b, "Issues", result.Issues, result.ErrorCount+result.WarningCount-result.TemporalIssueCount, maxIssues)
+	writeIssues(&b, "Temporal Issues", result.TemporalIssues, result.TemporalIssueCount, maxIssues)
 
 	return b.String()
 }
+
+// writeIssues lists up to maxIssues of issues (0 lists all) under title,
+// noting how many of total are not shown.
+func writeIssues(b *strings.Builder, title string, issues []analyze.ValidationIssue, total, maxIssues int) {
+	if len(issues) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s\n", title)
+	limit := len(issues)
+	if maxIssues > 0 && maxIssues < limit {
+		limit = maxIssues
+	}
+	for i := 0; i < limit; i++ {
+		it := issues[i]
+		where := fmt.Sprintf("event #%d, type=%s", it.EventIndex, it.EventType)
+		if it.Path != "" {
+			where += ", path=" + it.Path
+		}
+		fmt.Fprintf(b, "- [%s] %s (%s): %s\n", strings.ToUpper(string(it.Severity)), it.Code, where, it.Message)
+	}
+	if limit < total {
+		fmt.Fprintf(b, "- ... %d more issues not shown\n", total-limit)
+	}
+}
@@ -40,3 +40,30 @@ func TestRenderTextIncludesStreamInventory(t *testing.T) {
 		t.Fatalf("missing class id")
 	}
 }
+
+func TestRenderTextListsTemporalIssues(t *testing.T) {
+	result := analyze.Analysis{
+		TotalEvents:  3,
+		WarningCount: 3,
+		Issues: []analyze.ValidationIssue{
+			{Severity: analyze.SeverityWarn, Code: "negative_transport_delay", EventIndex: 0, EventType: "person_tracked", Path: "/frame_transport_delay_seconds", Message: "slightly negative"},
+		},
+		TemporalIssues: []analyze.ValidationIssue{
+			{Severity: analyze.SeverityWarn, Code: "person_lost_without_detected", EventIndex: 2, EventType: "person_lost", Path: "/track_id", Message: "track 4 lost"},
+		},
+		TemporalIssueCount: 2,
+	}
+	out := RenderText(result, nil, nil, 1)
+	for _, want := range []string{
+		"Validation: 0 errors, 3 warnings (2 temporal)",
+		"- [WARN] negative_transport_delay (event #0, type=person_tracked, path=/frame_transport_delay_seconds): slightly negative\n",
+		"Temporal Issues\n- [WARN] person_lost_without_detected (event #2, type=person_lost, path=/track_id): track 4 lost\n- ... 1 more issues not shown\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "more issues not shown") != 1 {
+		t.Fatalf("only the temporal section is truncated:\n%s", out)
+	}
+}
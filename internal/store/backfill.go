@@ -0,0 +1,143 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	BackfillRunning   = "running"
+	BackfillCompleted = "completed"
+	BackfillFailed    = "failed"
+)
+
+// BackfillJob tracks one historical ingestion over a class/camera selection
+// and an epoch range. Cursor is the last file (in sorted path order) whose
+// chunk was fully committed, which lets an interrupted job resume.
+type BackfillJob struct {
+	ID             int64    `json:"id"`
+	StreamPath     string   `json:"stream_path"`
+	ClassIDs       []string `json:"class_ids"`
+	CameraIDs      []string `json:"camera_ids"`
+	FromEpoch      int64    `json:"from_epoch"`
+	ToEpoch        int64    `json:"to_epoch"`
+	ChunkSize      int      `json:"chunk_size"`
+	Status         string   `json:"status"`
+	TotalFiles     int      `json:"total_files"`
+	DoneFiles      int      `json:"done_files"`
+	ProcessedFiles int      `json:"processed_files"`
+	InsertedEvents int      `json:"inserted_events"`
+	SkippedFiles   int      `json:"skipped_files"`
+	Cursor         string   `json:"cursor,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	CreatedAt      string   `json:"created_at"`
+	UpdatedAt      string   `json:"updated_at"`
+}
+
+func (s *Store) CreateBackfillJob(job BackfillJob) (BackfillJob, error) {
+	classIDs, err := json.Marshal(nonNilStrings(job.ClassIDs))
+	if err != nil {
+		return job, fmt.Errorf("encode class ids: %w", err)
+	}
+	cameraIDs, err := json.Marshal(nonNilStrings(job.CameraIDs))
+	if err != nil {
+		return job, fmt.Errorf("encode camera ids: %w", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if job.Status == "" {
+		job.Status = BackfillRunning
+	}
+	res, err := s.db.Exec(`INSERT INTO backfill_jobs(stream_path, class_ids, camera_ids, from_epoch, to_epoch, chunk_size, status, created_at, updated_at)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, job.StreamPath, string(classIDs), string(cameraIDs), job.FromEpoch, job.ToEpoch, job.ChunkSize, job.Status, now, now)
+	if err != nil {
+		return job, fmt.Errorf("create backfill job: %w", err)
+	}
+	if job.ID, err = res.LastInsertId(); err != nil {
+		return job, fmt.Errorf("backfill job id: %w", err)
+	}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	return job, nil
+}
+
+// UpdateBackfillJob persists the progress counters, cursor, status and error
+// of job.
+func (s *Store) UpdateBackfillJob(job *BackfillJob) error {
+	job.UpdatedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.Exec(`UPDATE backfill_jobs SET
+  status = ?, total_files = ?, done_files = ?, processed_files = ?, inserted_events = ?,
+  skipped_files = ?, cursor = ?, error = ?, updated_at = ?
+WHERE id = ?`, job.Status, job.TotalFiles, job.DoneFiles, job.ProcessedFiles, job.InsertedEvents, job.SkippedFiles, job.Cursor, job.Error, job.UpdatedAt, job.ID)
+	if err != nil {
+		return fmt.Errorf("update backfill job: %w", err)
+	}
+	return nil
+}
+
+// GetBackfillJob returns sql.ErrNoRows when the job does not exist.
+func (s *Store) GetBackfillJob(id int64) (BackfillJob, error) {
+	row := s.reader.QueryRow(`SELECT `+backfillColumns+` FROM backfill_jobs WHERE id = ?`, id)
+	return scanBackfillJob(row)
+}
+
+func (s *Store) ListBackfillJobs(limit int) ([]BackfillJob, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+	rows, err := s.reader.Query(`SELECT `+backfillColumns+` FROM backfill_jobs ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query backfill jobs: %w", err)
+	}
+	defer rows.Close()
+	out := make([]BackfillJob, 0)
+	for rows.Next() {
+		job, err := scanBackfillJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate backfill jobs: %w", err)
+	}
+	return out, nil
+}
+
+const backfillColumns = `id, stream_path, class_ids, camera_ids, from_epoch, to_epoch, chunk_size, status,
+total_files, done_files, processed_files, inserted_events, skipped_files, cursor, error, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBackfillJob(row rowScanner) (BackfillJob, error) {
+	var (
+		job       BackfillJob
+		classIDs  string
+		cameraIDs string
+	)
+	err := row.Scan(&job.ID, &job.StreamPath, &classIDs, &cameraIDs, &job.FromEpoch, &job.ToEpoch, &job.ChunkSize, &job.Status,
+		&job.TotalFiles, &job.DoneFiles, &job.ProcessedFiles, &job.InsertedEvents, &job.SkippedFiles, &job.Cursor, &job.Error, &job.CreatedAt, &job.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return job, err
+	}
+	if err != nil {
+		return job, fmt.Errorf("scan backfill job: %w", err)
+	}
+	if err := json.Unmarshal([]byte(classIDs), &job.ClassIDs); err != nil {
+		return job, fmt.Errorf("decode class ids: %w", err)
+	}
+	if err := json.Unmarshal([]byte(cameraIDs), &job.CameraIDs); err != nil {
+		return job, fmt.Errorf("decode camera ids: %w", err)
+	}
+	return job, nil
+}
+
+func nonNilStrings(v []string) []string {
+	if v == nil {
+		return []string{}
+	}
+	return v
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"runtime"
+)
+
+// The database runs in WAL mode so readers never block the writer or each
+// other. A Store holds two pools on the same file:
+//
+//   - db: one read-write connection that serializes every write and the
+//     reads that belong to a write path (retention, reconciliation), so
+//     they see a consistent state
+//   - reader: read-only connections for queries such as ListEvents,
+//     Summary, DailyStudentMetrics and the list endpoints, and for the
+//     per-file lookups of the ingest read stage (ShouldIngestFile,
+//     GetIngestFailureByPath, GetTailState), so parallel workers are not
+//     queued behind the writer; each query sees the last committed state
+//     when it starts
+//
+// Only one connection may write at a time; busy_timeout makes other
+// processes on the same database (an `ai-json` command next to the API)
+// wait for the lock instead of failing.
+
+const busyTimeoutMillis = 5000
+
+// readPoolSize is the number of read-only connections.
+var readPoolSize = max(4, runtime.GOMAXPROCS(0))
+
+// writerDSN opens path read-write with WAL. synchronous=NORMAL is durable
+// across application crashes in WAL mode and only risks the last commits on
+// power loss. Write transactions start IMMEDIATE so they take the write lock
+// up front rather than failing to upgrade a read lock.
+func writerDSN(path string) string {
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeoutMillis))
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	q.Set("_txlock", "immediate")
+	return fileURI(path) + "?" + q.Encode()
+}
+
+func readerDSN(path string) string {
+	q := url.Values{}
+	q.Set("mode", "ro")
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeoutMillis))
+	return fileURI(path) + "?" + q.Encode()
+}
+
+func fileURI(path string) string {
+	return "file:" + (&url.URL{Path: path}).EscapedPath()
+}
+
+// openPools opens the writer and the read-only pool for path. An in-memory
+// database is private to its connection, so it gets the writer alone.
+func openPools(path string) (*sql.DB, *sql.DB, error) {
+	if path == "" || path == ":memory:" {
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open sqlite: %w", err)
+		}
+		db.SetMaxOpenConns(1)
+		return db, db, nil
+	}
+
+	db, err := sql.Open("sqlite", writerDSN(path))
+	if err != nil {
+		return nil, nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	// The writer creates the file and switches it to WAL before any reader
+	// connects; a read-only connection can do neither.
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("open sqlite: %w", err)
+	}
+
+	reader, err := sql.Open("sqlite", readerDSN(path))
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("open sqlite reader: %w", err)
+	}
+	reader.SetMaxOpenConns(readPoolSize)
+	reader.SetMaxIdleConns(readPoolSize)
+	return db, reader, nil
+}
+
+func (s *Store) Close() error {
+	err := s.db.Close()
+	if s.reader != s.db {
+		err = errors.Join(err, s.reader.Close())
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"ai-json/internal/model"
+)
+
+// dedupeBatchSize bounds how many legacy rows Dedupe loads per transaction.
+const dedupeBatchSize = 500
+
+// DedupeResult reports the work done by Dedupe.
+type DedupeResult struct {
+	Scanned       int `json:"scanned"`
+	Fingerprinted int `json:"fingerprinted"`
+	Removed       int `json:"removed"`
+}
+
+// Dedupe computes fingerprints for events stored before fingerprints existed
+// and deletes each such event whose fingerprint is already held by another
+// row or by a pruned event in pruned_fingerprints; among legacy copies the
+// lowest id is kept. It only touches rows with a
+// NULL fingerprint, so it is cheap to run again. With dryRun the database is
+// left unchanged and the result reports what would be removed.
+func (s *Store) Dedupe(dryRun bool) (DedupeResult, error) {
+	res := DedupeResult{}
+	// seen tracks fingerprints assigned during a dry run, where they are not
+	// written back and therefore cannot be found by the lookup query.
+	seen := map[string]struct{}{}
+	lastID := int64(0)
+	for {
+		type legacyRow struct {
+			id  int64
+			raw string
+		}
+		rows, err := s.db.Query("SELECT id, raw_json FROM events WHERE fingerprint IS NULL AND id > ? ORDER BY id LIMIT ?", lastID, dedupeBatchSize)
+		if err != nil {
+			return res, fmt.Errorf("query legacy events: %w", err)
+		}
+		batch := make([]legacyRow, 0, dedupeBatchSize)
+		for rows.Next() {
+			var r legacyRow
+			if err := rows.Scan(&r.id, &r.raw); err != nil {
+				rows.Close()
+				return res, fmt.Errorf("scan legacy event: %w", err)
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return res, fmt.Errorf("iterate legacy events: %w", err)
+		}
+		if len(batch) == 0 {
+			return res, nil
+		}
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return res, fmt.Errorf("begin tx: %w", err)
+		}
+		for _, r := range batch {
+			lastID = r.id
+			res.Scanned++
+			var obj map[string]any
+			if err := json.Unmarshal([]byte(r.raw), &obj); err != nil {
+				continue
+			}
+			fp, err := model.Event{Raw: obj}.Fingerprint()
+			if err != nil {
+				continue
+			}
+
+			_, dup := seen[fp]
+			if !dup {
+				err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM events WHERE fingerprint = ?1)
+  OR EXISTS(SELECT 1 FROM pruned_fingerprints WHERE fingerprint = ?1)`, fp).Scan(&dup)
+				if err != nil {
+					_ = tx.Rollback()
+					return res, fmt.Errorf("lookup fingerprint: %w", err)
+				}
+			}
+			if dup {
+				res.Removed++
+				if !dryRun {
+					if _, err := tx.Exec("DELETE FROM events WHERE id = ?", r.id); err != nil {
+						_ = tx.Rollback()
+						return res, fmt.Errorf("delete duplicate event: %w", err)
+					}
+				}
+				continue
+			}
+			res.Fingerprinted++
+			if dryRun {
+				seen[fp] = struct{}{}
+				continue
+			}
+			if _, err := tx.Exec("UPDATE events SET fingerprint = ? WHERE id = ?", fp, r.id); err != nil {
+				_ = tx.Rollback()
+				return res, fmt.Errorf("set fingerprint: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return res, fmt.Errorf("commit tx: %w", err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	FailureRetrying    = "retrying"
+	FailureQuarantined = "quarantined"
+)
+
+// IngestFailure records an event file that could not be read or parsed.
+// Attempts counts consecutive failures of the same file version (size and
+// mtime); a changed file starts again at one.
+type IngestFailure struct {
+	ID              int64  `json:"id"`
+	Path            string `json:"path"`
+	ClassID         string `json:"class_id"`
+	CameraID        string `json:"camera_id"`
+	SizeBytes       int64  `json:"size_bytes"`
+	ModUnix         int64  `json:"mod_unix"`
+	Error           string `json:"error"`
+	Attempts        int    `json:"attempts"`
+	Status          string `json:"status"`
+	QuarantinedPath string `json:"quarantined_path,omitempty"`
+	FirstFailedAt   string `json:"first_failed_at"`
+	LastFailedAt    string `json:"last_failed_at"`
+}
+
+// RecordIngestFailure upserts the failure row for f.Path and returns it with
+// the updated attempt count.
+func (s *Store) RecordIngestFailure(f IngestFailure) (IngestFailure, error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.Exec(`INSERT INTO ingest_failures(path, class_id, camera_id, size_bytes, mod_unix, error, attempts, status, first_failed_at, last_failed_at)
+VALUES(?, ?, ?, ?, ?, ?, 1, ?, ?, ?)
+ON CONFLICT(path) DO UPDATE SET
+  class_id = excluded.class_id,
+  camera_id = excluded.camera_id,
+  error = excluded.error,
+  attempts = CASE WHEN ingest_failures.size_bytes = excluded.size_bytes AND ingest_failures.mod_unix = excluded.mod_unix
+    THEN ingest_failures.attempts + 1 ELSE 1 END,
+  size_bytes = excluded.size_bytes,
+  mod_unix = excluded.mod_unix,
+  status = excluded.status,
+  quarantined_path = '',
+  last_failed_at = excluded.last_failed_at`, f.Path, f.ClassID, f.CameraID, f.SizeBytes, f.ModUnix, f.Error, FailureRetrying, now, now)
+	if err != nil {
+		return f, fmt.Errorf("record ingest failure: %w", err)
+	}
+	out, _, err := s.GetIngestFailureByPath(f.Path)
+	return out, err
+}
+
+// QuarantineIngestFailure marks a failure as given up on. quarantinedPath is
+// where the file was moved to, or empty when it was left in place.
+func (s *Store) QuarantineIngestFailure(id int64, quarantinedPath string) error {
+	_, err := s.db.Exec(`UPDATE ingest_failures SET status = ?, quarantined_path = ? WHERE id = ?`, FailureQuarantined, quarantinedPath, id)
+	if err != nil {
+		return fmt.Errorf("quarantine ingest failure: %w", err)
+	}
+	return nil
+}
+
+// ResetIngestFailure clears the attempt count so the file is tried again.
+func (s *Store) ResetIngestFailure(id int64) error {
+	_, err := s.db.Exec(`UPDATE ingest_failures SET status = ?, attempts = 0, quarantined_path = '' WHERE id = ?`, FailureRetrying, id)
+	if err != nil {
+		return fmt.Errorf("reset ingest failure: %w", err)
+	}
+	return nil
+}
+
+// ClearIngestFailure removes the failure row for path after a successful
+// ingestion.
+func (s *Store) ClearIngestFailure(path string) error {
+	if _, err := s.db.Exec(`DELETE FROM ingest_failures WHERE path = ?`, path); err != nil {
+		return fmt.Errorf("clear ingest failure: %w", err)
+	}
+	return nil
+}
+
+// GetIngestFailureByPath returns the failure row for path; found is false
+// when there is none. It reads from the read-only pool.
+func (s *Store) GetIngestFailureByPath(path string) (IngestFailure, bool, error) {
+	f, err := scanIngestFailure(s.reader.QueryRow(`SELECT `+failureColumns+` FROM ingest_failures WHERE path = ?`, path))
+	if err == sql.ErrNoRows {
+		return f, false, nil
+	}
+	if err != nil {
+		return f, false, err
+	}
+	return f, true, nil
+}
+
+// GetIngestFailure returns sql.ErrNoRows when the failure does not exist.
+func (s *Store) GetIngestFailure(id int64) (IngestFailure, error) {
+	return scanIngestFailure(s.reader.QueryRow(`SELECT `+failureColumns+` FROM ingest_failures WHERE id = ?`, id))
+}
+
+// ListIngestFailures returns failures newest first, optionally filtered by
+// status.
+func (s *Store) ListIngestFailures(status string, limit int) ([]IngestFailure, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	query := `SELECT ` + failureColumns + ` FROM ingest_failures`
+	args := make([]any, 0, 2)
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY last_failed_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.reader.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query ingest failures: %w", err)
+	}
+	defer rows.Close()
+	out := make([]IngestFailure, 0)
+	for rows.Next() {
+		f, err := scanIngestFailure(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate ingest failures: %w", err)
+	}
+	return out, nil
+}
+
+const failureColumns = `id, path, class_id, camera_id, size_bytes, mod_unix, error, attempts, status, quarantined_path, first_failed_at, last_failed_at`
+
+func scanIngestFailure(row rowScanner) (IngestFailure, error) {
+	var f IngestFailure
+	err := row.Scan(&f.ID, &f.Path, &f.ClassID, &f.CameraID, &f.SizeBytes, &f.ModUnix, &f.Error, &f.Attempts, &f.Status, &f.QuarantinedPath, &f.FirstFailedAt, &f.LastFailedAt)
+	if err == sql.ErrNoRows {
+		return f, err
+	}
+	if err != nil {
+		return f, fmt.Errorf("scan ingest failure: %w", err)
+	}
+	return f, nil
+}
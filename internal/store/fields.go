@@ -0,0 +1,263 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Promoted fields are raw_json values exposed as indexed columns, so queries
+// can filter on them without running json_extract on every row. Each one is
+// a VIRTUAL generated column named field_<name> with its own index. SQLite
+// computes the column from raw_json, so events stored before a field was
+// promoted are covered without rewriting them. The built-in fields come
+// with migration 7. Others are promoted at startup (--promote-fields) and
+// recorded in promoted_fields, which lists every field with its
+// expression.
+
+type PromotedField struct {
+	Name string `json:"name"`
+	// Path is the JSON path read from raw_json, e.g. $.posture.
+	Path string `json:"path"`
+	// Type is how values are stored and compared: text, real or integer.
+	Type    string `json:"type"`
+	Builtin bool   `json:"builtin"`
+}
+
+// FieldPredicate restricts a promoted field. All set parts must match.
+type FieldPredicate struct {
+	Field string
+	// In matches any of the values; one value is an equality test.
+	In []any
+	// Min and Max are inclusive bounds; nil leaves that side open.
+	Min any
+	Max any
+}
+
+// builtinFields are promoted by migration 7; do not change the list.
+var builtinFields = []PromotedField{
+	{Name: "pipeline", Path: "$.pipeline", Type: "text", Builtin: true},
+	{Name: "person_role", Path: "$.person_role", Type: "text", Builtin: true},
+	{Name: "person_name", Path: "$.person_name", Type: "text", Builtin: true},
+	{Name: "posture", Path: "$.posture", Type: "text", Builtin: true},
+	{Name: "orientation", Path: "$.orientation", Type: "text", Builtin: true},
+}
+
+const promotedFieldsSchema = `
+CREATE TABLE IF NOT EXISTS promoted_fields (
+  name TEXT PRIMARY KEY,
+  path TEXT NOT NULL,
+  type TEXT NOT NULL,
+  expression TEXT NOT NULL,
+  builtin INTEGER NOT NULL DEFAULT 0,
+  promoted_at TEXT NOT NULL
+)`
+
+var (
+	fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+	// Paths are inlined into the column definition, which cannot take
+	// parameters, so only plain keys and array indexes are allowed.
+	fieldPathPattern = regexp.MustCompile(`^\$(\.[A-Za-z_][A-Za-z0-9_]*|\[[0-9]+\])+$`)
+)
+
+func fieldColumn(name string) string { return "field_" + name }
+
+// fieldExpr is the generated column's expression. person_role falls back to
+// role, as the posture events name it.
+func fieldExpr(f PromotedField) string {
+	if f.Builtin && f.Name == "person_role" {
+		return "COALESCE(json_extract(raw_json, '$.person_role'), json_extract(raw_json, '$.role'))"
+	}
+	return "json_extract(raw_json, '" + f.Path + "')"
+}
+
+func (f PromotedField) validate() error {
+	if !fieldNamePattern.MatchString(f.Name) {
+		return fmt.Errorf("invalid field name %q (lowercase letters, digits and _)", f.Name)
+	}
+	if !fieldPathPattern.MatchString(f.Path) {
+		return fmt.Errorf("field %s: invalid path %q (expected e.g. $.posture or $.bbox[0])", f.Name, f.Path)
+	}
+	switch f.Type {
+	case "text", "real", "integer":
+	default:
+		return fmt.Errorf("field %s: invalid type %q (expected text, real or integer)", f.Name, f.Type)
+	}
+	return nil
+}
+
+// Value converts a query parameter to the field's type.
+func (f PromotedField) Value(raw string) (any, error) {
+	switch f.Type {
+	case "real":
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %s expects a number, got %q", f.Name, raw)
+		}
+		return v, nil
+	case "integer":
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %s expects an integer, got %q", f.Name, raw)
+		}
+		return v, nil
+	}
+	return raw, nil
+}
+
+// ParsePromotedFields parses the --promote-fields flag: comma separated
+// name=path pairs with an optional :text, :real or :integer type, e.g.
+// "distance=$.distance:real,device=$.device_type".
+func ParsePromotedFields(spec string) ([]PromotedField, error) {
+	var out []PromotedField
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid promoted field %q (expected name=$.path[:type])", item)
+		}
+		f := PromotedField{Name: strings.TrimSpace(name), Path: strings.TrimSpace(path), Type: "text"}
+		if p, typ, ok := strings.Cut(f.Path, ":"); ok {
+			f.Path, f.Type = p, typ
+		}
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// addPromotedField adds f's column, index and registry row.
+func addPromotedField(m *migrationTx, f PromotedField) error {
+	col := fieldColumn(f.Name)
+	if err := m.ensureColumn("events", col, fmt.Sprintf("%s GENERATED ALWAYS AS (%s) VIRTUAL", strings.ToUpper(f.Type), fieldExpr(f))); err != nil {
+		return err
+	}
+	if err := m.exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_events_%s ON events(%s)", col, col)); err != nil {
+		return err
+	}
+	builtin := 0
+	if f.Builtin {
+		builtin = 1
+	}
+	return m.exec(fmt.Sprintf("INSERT OR IGNORE INTO promoted_fields(name, path, type, expression, builtin, promoted_at) VALUES('%s', '%s', '%s', '%s', %d, '%s')",
+		f.Name, f.Path, f.Type, strings.ReplaceAll(fieldExpr(f), "'", "''"), builtin, time.Now().UTC().Format(time.RFC3339Nano)))
+}
+
+func upgradePromotedFields(m *migrationTx) error {
+	if err := m.exec(promotedFieldsSchema); err != nil {
+		return err
+	}
+	for _, f := range builtinFields {
+		if err := addPromotedField(m, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PromotedFields returns the promoted fields by name.
+func (s *Store) PromotedFields() []PromotedField {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]PromotedField, 0, len(s.fields))
+	for _, f := range s.fields {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// PromotedField returns the promoted field called name.
+func (s *Store) PromotedField(name string) (PromotedField, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.fields[name]
+	return f, ok
+}
+
+func (s *Store) PromoteFields(fields []PromotedField) ([]PromotedField, error) {
+	return s.PromoteFieldsContext(context.Background(), fields)
+}
+
+// PromoteFieldsContext adds the fields that are not promoted yet and returns
+// them. Promoting a name again with the same path and type does nothing;
+// with a different one it fails, as the column cannot change in place.
+// Indexing a new field reads every stored event once.
+func (s *Store) PromoteFieldsContext(ctx context.Context, fields []PromotedField) ([]PromotedField, error) {
+	added := make([]PromotedField, 0)
+	var pending []PromotedField
+	for _, f := range fields {
+		if err := f.validate(); err != nil {
+			return added, err
+		}
+		if have, ok := s.PromotedField(f.Name); ok {
+			if have.Path != f.Path || have.Type != f.Type {
+				return added, fmt.Errorf("field %s is already promoted from %s (%s)", f.Name, have.Path, have.Type)
+			}
+			continue
+		}
+		pending = append(pending, f)
+	}
+	if len(pending) == 0 {
+		return added, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return added, fmt.Errorf("begin promote fields: %w", err)
+	}
+	defer tx.Rollback()
+	m := &migrationTx{ctx: ctx, tx: tx}
+	for _, f := range pending {
+		if err := addPromotedField(m, f); err != nil {
+			return added, fmt.Errorf("promote field %s: %w", f.Name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return added, fmt.Errorf("commit promote fields: %w", err)
+	}
+	if err := s.loadPromotedFields(ctx); err != nil {
+		return added, err
+	}
+	return append(added, pending...), nil
+}
+
+// loadPromotedFields refreshes the field cache from promoted_fields, which
+// does not exist before migration 7.
+func (s *Store) loadPromotedFields(ctx context.Context) error {
+	fields := map[string]PromotedField{}
+	var n int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'promoted_fields'").Scan(&n); err != nil {
+		return fmt.Errorf("load promoted fields: %w", err)
+	}
+	if n > 0 {
+		rows, err := s.db.QueryContext(ctx, "SELECT name, path, type, builtin FROM promoted_fields")
+		if err != nil {
+			return fmt.Errorf("load promoted fields: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var f PromotedField
+			if err := rows.Scan(&f.Name, &f.Path, &f.Type, &f.Builtin); err != nil {
+				return fmt.Errorf("scan promoted field: %w", err)
+			}
+			fields[f.Name] = f
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("iterate promoted fields: %w", err)
+		}
+	}
+	s.mu.Lock()
+	s.fields = fields
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,429 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Identity reconciliation links the per-camera identities of a class into
+// persons. A per-camera identity is the event's global_person_id, else its
+// person_id, else its track_id, on one camera (stream_camera_id, else
+// camera_id) of one class (stream_class_id, else room_id). Identities are
+// linked in two passes:
+//
+//   - resolved names: identities that carry the same person_name, or the same
+//     resolved person_id on identity_resolved events, are one person
+//   - co-occurrence: an identity on one camera and one on another camera of
+//     the class are linked when each is the other's best match by the Jaccard
+//     score of the seconds they were seen in, the score and overlap reach
+//     the thresholds and the runner-up on either side trails by Margin
+//
+// Co-occurrence never links two identities with different resolved names or
+// roles, and never adds a second identity of a camera a person already has.
+
+// ReconcileOptions tune the co-occurrence pass; zero values use the
+// defaults.
+type ReconcileOptions struct {
+	// MinOverlapSeconds is the least number of shared seconds (default 10).
+	MinOverlapSeconds int
+	// MinScore is the least Jaccard score of the shared seconds (default
+	// 0.5).
+	MinScore float64
+	// Margin is how far the runner-up score must trail the best one on both
+	// sides (default 0.1), so ambiguous matches stay unlinked.
+	Margin float64
+}
+
+func (o ReconcileOptions) withDefaults() ReconcileOptions {
+	if o.MinOverlapSeconds <= 0 {
+		o.MinOverlapSeconds = 10
+	}
+	if o.MinScore <= 0 {
+		o.MinScore = 0.5
+	}
+	if o.Margin <= 0 {
+		o.Margin = 0.1
+	}
+	return o
+}
+
+// Link reasons of a PersonIdentity.
+const (
+	LinkSingle           = "single"
+	LinkPersonName       = "person_name"
+	LinkIdentityResolved = "identity_resolved"
+	LinkCoOccurrence     = "co_occurrence"
+)
+
+type identityKey struct {
+	classID  string
+	cameraID string
+	kind     string
+	value    string
+}
+
+// identityObservation is one stored event as the reconciler sees it.
+type identityObservation struct {
+	classID   string
+	cameraID  string
+	eventType string
+	globalID  *int64
+	personID  string
+	trackID   *int64
+	ts        float64
+	name      string
+	role      string
+}
+
+func (o identityObservation) key() (identityKey, bool) {
+	k := identityKey{classID: o.classID, cameraID: o.cameraID}
+	switch {
+	case o.globalID != nil:
+		k.kind, k.value = "global", strconv.FormatInt(*o.globalID, 10)
+	case o.personID != "":
+		k.kind, k.value = "person", o.personID
+	case o.trackID != nil:
+		k.kind, k.value = "track", strconv.FormatInt(*o.trackID, 10)
+	default:
+		return k, false
+	}
+	return k, true
+}
+
+type localIdentity struct {
+	key      identityKey
+	seconds  map[int64]struct{}
+	first    float64
+	last     float64
+	events   int64
+	names    map[string]int
+	roles    map[string]int
+	resolved bool
+	reason   string
+	score    float64
+}
+
+type identityReconciler struct {
+	opts   ReconcileOptions
+	locals map[identityKey]*localIdentity
+	parent map[identityKey]identityKey
+}
+
+func newIdentityReconciler(opts ReconcileOptions) *identityReconciler {
+	return &identityReconciler{opts: opts.withDefaults(), locals: map[identityKey]*localIdentity{}, parent: map[identityKey]identityKey{}}
+}
+
+func (r *identityReconciler) add(o identityObservation) {
+	key, ok := o.key()
+	if !ok {
+		return
+	}
+	l, ok := r.locals[key]
+	if !ok {
+		l = &localIdentity{key: key, seconds: map[int64]struct{}{}, first: o.ts, last: o.ts, names: map[string]int{}, roles: map[string]int{}, reason: LinkSingle}
+		r.locals[key] = l
+		r.parent[key] = key
+	}
+	l.seconds[int64(o.ts)] = struct{}{}
+	l.first = min(l.first, o.ts)
+	l.last = max(l.last, o.ts)
+	l.events++
+	if o.name != "" {
+		l.names[o.name]++
+	}
+	if o.eventType == "identity_resolved" {
+		// A resolved person_id names the identity like person_name does.
+		if o.name == "" && o.personID != "" && !strings.HasPrefix(o.personID, "unknown:") {
+			l.names[o.personID]++
+		}
+		if len(l.names) > 0 {
+			l.resolved = true
+		}
+	}
+	if o.role != "" {
+		l.roles[o.role]++
+	}
+}
+
+func (r *identityReconciler) find(k identityKey) identityKey {
+	for r.parent[k] != k {
+		r.parent[k] = r.parent[r.parent[k]]
+		k = r.parent[k]
+	}
+	return k
+}
+
+func (r *identityReconciler) union(a, b identityKey) {
+	ra, rb := r.find(a), r.find(b)
+	if ra != rb {
+		r.parent[rb] = ra
+	}
+}
+
+// sortedKeys returns the identity keys in a stable order so results do not
+// depend on map iteration.
+func (r *identityReconciler) sortedKeys() []identityKey {
+	keys := make([]identityKey, 0, len(r.locals))
+	for k := range r.locals {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return lessIdentityKey(keys[i], keys[j]) })
+	return keys
+}
+
+func lessIdentityKey(a, b identityKey) bool {
+	if a.classID != b.classID {
+		return a.classID < b.classID
+	}
+	if a.cameraID != b.cameraID {
+		return a.cameraID < b.cameraID
+	}
+	if a.kind != b.kind {
+		return a.kind < b.kind
+	}
+	return a.value < b.value
+}
+
+// result links the identities and returns one person per group.
+func (r *identityReconciler) result() []Person {
+	keys := r.sortedKeys()
+	r.linkNames(keys)
+	r.linkCoOccurrence(keys)
+
+	groups := map[identityKey][]*localIdentity{}
+	roots := make([]identityKey, 0)
+	for _, k := range keys {
+		root := r.find(k)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], r.locals[k])
+	}
+	out := make([]Person, 0, len(roots))
+	for _, root := range roots {
+		out = append(out, newPerson(groups[root]))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ClassID != out[j].ClassID {
+			return out[i].ClassID < out[j].ClassID
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func (r *identityReconciler) linkNames(keys []identityKey) {
+	byName := map[[2]string]identityKey{}
+	for _, k := range keys {
+		l := r.locals[k]
+		name := topCount(l.names)
+		if name == "" {
+			continue
+		}
+		l.reason = LinkPersonName
+		if l.resolved {
+			l.reason = LinkIdentityResolved
+		}
+		nk := [2]string{k.classID, name}
+		if first, ok := byName[nk]; ok {
+			r.union(first, k)
+		} else {
+			byName[nk] = k
+		}
+	}
+}
+
+type coOccurrence struct {
+	a, b  identityKey
+	score float64
+}
+
+func (r *identityReconciler) linkCoOccurrence(keys []identityKey) {
+	// Per class, the identities of each camera.
+	cameras := map[string]map[string][]identityKey{}
+	for _, k := range keys {
+		if cameras[k.classID] == nil {
+			cameras[k.classID] = map[string][]identityKey{}
+		}
+		cameras[k.classID][k.cameraID] = append(cameras[k.classID][k.cameraID], k)
+	}
+	classIDs := make([]string, 0, len(cameras))
+	for c := range cameras {
+		classIDs = append(classIDs, c)
+	}
+	sort.Strings(classIDs)
+
+	for _, classID := range classIDs {
+		cameraIDs := make([]string, 0, len(cameras[classID]))
+		for c := range cameras[classID] {
+			cameraIDs = append(cameraIDs, c)
+		}
+		sort.Strings(cameraIDs)
+		for i := 0; i < len(cameraIDs); i++ {
+			for j := i + 1; j < len(cameraIDs); j++ {
+				for _, m := range r.mutualMatches(cameras[classID][cameraIDs[i]], cameras[classID][cameraIDs[j]]) {
+					if !r.compatible(m.a, m.b) {
+						continue
+					}
+					r.union(m.a, m.b)
+					for _, k := range []identityKey{m.a, m.b} {
+						if l := r.locals[k]; l.reason == LinkSingle {
+							l.reason, l.score = LinkCoOccurrence, m.score
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// mutualMatches scores every pair of as and bs and returns the pairs that
+// are each other's clear best match.
+func (r *identityReconciler) mutualMatches(as, bs []identityKey) []coOccurrence {
+	best := func() map[identityKey][2]coOccurrence { return map[identityKey][2]coOccurrence{} }
+	bestA, bestB := best(), best()
+	record := func(m map[identityKey][2]coOccurrence, k identityKey, c coOccurrence) {
+		top := m[k]
+		switch {
+		case c.score > top[0].score:
+			top[1], top[0] = top[0], c
+		case c.score > top[1].score:
+			top[1] = c
+		}
+		m[k] = top
+	}
+	for _, a := range as {
+		for _, b := range bs {
+			if r.find(a) == r.find(b) {
+				continue
+			}
+			overlap, score := jaccard(r.locals[a].seconds, r.locals[b].seconds)
+			if overlap < r.opts.MinOverlapSeconds || score < r.opts.MinScore {
+				continue
+			}
+			c := coOccurrence{a: a, b: b, score: score}
+			record(bestA, a, c)
+			record(bestB, b, c)
+		}
+	}
+	out := make([]coOccurrence, 0)
+	for _, a := range as {
+		top, ok := bestA[a]
+		if !ok {
+			continue
+		}
+		c := top[0]
+		other := bestB[c.b]
+		if other[0].a != a || c.score-top[1].score < r.opts.Margin || c.score-other[1].score < r.opts.Margin {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// compatible reports whether the persons of a and b may be merged: they do
+// not share a camera and their names and roles do not disagree.
+func (r *identityReconciler) compatible(a, b identityKey) bool {
+	ra, rb := r.find(a), r.find(b)
+	camerasA := map[string]bool{}
+	var nameA, nameB, roleA, roleB string
+	for k, l := range r.locals {
+		switch r.find(k) {
+		case ra:
+			camerasA[k.cameraID] = true
+			nameA = firstNonEmptyString(nameA, topCount(l.names))
+			roleA = firstNonEmptyString(roleA, topCount(l.roles))
+		}
+	}
+	for k, l := range r.locals {
+		if r.find(k) != rb {
+			continue
+		}
+		if camerasA[k.cameraID] {
+			return false
+		}
+		nameB = firstNonEmptyString(nameB, topCount(l.names))
+		roleB = firstNonEmptyString(roleB, topCount(l.roles))
+	}
+	return (nameA == "" || nameB == "" || nameA == nameB) && (roleA == "" || roleB == "" || roleA == roleB)
+}
+
+// jaccard returns how many seconds a and b share and the Jaccard score.
+func jaccard(a, b map[int64]struct{}) (int, float64) {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for sec := range a {
+		if _, ok := b[sec]; ok {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0, 0
+	}
+	return shared, float64(shared) / float64(union)
+}
+
+func newPerson(members []*localIdentity) Person {
+	sort.Slice(members, func(i, j int) bool { return lessIdentityKey(members[i].key, members[j].key) })
+	names, roles := map[string]int{}, map[string]int{}
+	cameras := map[string]bool{}
+	p := Person{ClassID: members[0].key.classID, FirstSeen: members[0].first, LastSeen: members[0].last, Identities: make([]PersonIdentity, 0, len(members))}
+	for _, l := range members {
+		for n, c := range l.names {
+			names[n] += c
+		}
+		for n, c := range l.roles {
+			roles[n] += c
+		}
+		p.FirstSeen = min(p.FirstSeen, l.first)
+		p.LastSeen = max(p.LastSeen, l.last)
+		p.EventCount += l.events
+		if !cameras[l.key.cameraID] {
+			cameras[l.key.cameraID] = true
+			p.Cameras = append(p.Cameras, l.key.cameraID)
+		}
+		p.Identities = append(p.Identities, PersonIdentity{
+			CameraID:   l.key.cameraID,
+			Kind:       l.key.kind,
+			Value:      l.key.value,
+			Reason:     l.reason,
+			Score:      l.score,
+			FirstSeen:  l.first,
+			LastSeen:   l.last,
+			EventCount: l.events,
+		})
+	}
+	p.Name = topCount(names)
+	p.Role = topCount(roles)
+	if p.Name != "" {
+		p.ID = p.ClassID + ":" + p.Name
+	} else {
+		first := members[0].key
+		p.ID = fmt.Sprintf("%s:%s:%s%s", first.classID, first.cameraID, first.kind[:1], first.value)
+	}
+	return p
+}
+
+// topCount returns the most frequent key, the smallest one on ties.
+func topCount(counts map[string]int) string {
+	best, bestN := "", 0
+	for k, n := range counts {
+		if n > bestN || (n == bestN && k < best) {
+			best, bestN = k, n
+		}
+	}
+	return best
+}
+
+func firstNonEmptyString(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
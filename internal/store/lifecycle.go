@@ -0,0 +1,111 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IngestedFile is the ingested_files row of one event file or archive member.
+type IngestedFile struct {
+	ID         int64
+	Path       string
+	SizeBytes  int64
+	ModUnix    int64
+	IngestedAt time.Time
+	// Offset is the consumed byte offset of tailed files (0 otherwise).
+	Offset int64
+}
+
+// FileAction records what the after_ingest policy did to an event file.
+// Failed actions are recorded with Error set and DestPath empty.
+type FileAction struct {
+	ID       int64  `json:"id"`
+	Path     string `json:"path"`
+	ClassID  string `json:"class_id"`
+	CameraID string `json:"camera_id"`
+	Action   string `json:"action"`
+	DestPath string `json:"dest_path,omitempty"`
+	Error    string `json:"error,omitempty"`
+	ActedAt  string `json:"acted_at"`
+}
+
+// GetIngestedFile returns the ingested_files row for path; found is false
+// when the file was never stored.
+func (s *Store) GetIngestedFile(path string) (IngestedFile, bool, error) {
+	f := IngestedFile{Path: path}
+	var ingestedAt string
+	err := s.db.QueryRow("SELECT id, size_bytes, mod_unix, ingested_at, offset_bytes FROM ingested_files WHERE path = ?", path).Scan(&f.ID, &f.SizeBytes, &f.ModUnix, &ingestedAt, &f.Offset)
+	if err == sql.ErrNoRows {
+		return f, false, nil
+	}
+	if err != nil {
+		return f, false, fmt.Errorf("get ingested file: %w", err)
+	}
+	if f.IngestedAt, err = time.Parse(time.RFC3339Nano, ingestedAt); err != nil {
+		return f, false, fmt.Errorf("parse ingested_at of %s: %w", path, err)
+	}
+	return f, true, nil
+}
+
+// RecordFileAction appends a to the file action history.
+func (s *Store) RecordFileAction(a FileAction) (FileAction, error) {
+	a.ActedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	res, err := s.db.Exec(`INSERT INTO file_actions(path, class_id, camera_id, action, dest_path, error, acted_at)
+VALUES(?, ?, ?, ?, ?, ?, ?)`, a.Path, a.ClassID, a.CameraID, a.Action, a.DestPath, a.Error, a.ActedAt)
+	if err != nil {
+		return a, fmt.Errorf("record file action: %w", err)
+	}
+	if a.ID, err = res.LastInsertId(); err != nil {
+		return a, fmt.Errorf("file action id: %w", err)
+	}
+	return a, nil
+}
+
+// LatestFileAction returns the newest action recorded for path and false
+// when there is none.
+func (s *Store) LatestFileAction(path string) (FileAction, bool, error) {
+	actions, err := s.ListFileActions(path, "", 1)
+	if err != nil || len(actions) == 0 {
+		return FileAction{}, false, err
+	}
+	return actions[0], true, nil
+}
+
+// ListFileActions returns the newest actions first, optionally filtered by
+// original path and action.
+func (s *Store) ListFileActions(path, action string, limit int) ([]FileAction, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	where := " WHERE 1=1"
+	args := make([]any, 0, 3)
+	if path != "" {
+		where += " AND path = ?"
+		args = append(args, path)
+	}
+	if action != "" {
+		where += " AND action = ?"
+		args = append(args, action)
+	}
+	args = append(args, limit)
+	rows, err := s.reader.Query(`SELECT id, path, class_id, camera_id, action, dest_path, error, acted_at
+FROM file_actions`+where+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query file actions: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]FileAction, 0)
+	for rows.Next() {
+		var a FileAction
+		if err := rows.Scan(&a.ID, &a.Path, &a.ClassID, &a.CameraID, &a.Action, &a.DestPath, &a.Error, &a.ActedAt); err != nil {
+			return nil, fmt.Errorf("scan file action: %w", err)
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate file actions: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,441 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The schema is built by numbered migrations applied in order. Each one runs
+// in its own transaction together with its schema_migrations row, so a
+// failed upgrade leaves the database at the last version that succeeded.
+// Databases created before versioning have no schema_migrations table and
+// start at version 0; the early migrations check the existing schema, so
+// they bring such databases to the same state as new ones. Append new
+// migrations to the list; never edit or reorder applied ones.
+
+// ErrSchemaTooNew is returned when a database was migrated by a newer binary
+// than this one.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+type migration struct {
+	version int
+	name    string
+	up      func(m *migrationTx) error
+}
+
+var migrations = []migration{
+	{1, "base_schema", func(m *migrationTx) error { return m.exec(baseSchema) }},
+	{2, "event_file_links", upgradeFileLinks},
+	{3, "event_fingerprints", upgradeFingerprints},
+	{4, "tail_offsets", func(m *migrationTx) error {
+		if err := m.ensureColumn("ingested_files", "offset_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return m.ensureColumn("ingested_files", "last_line_hash", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{5, "persons", func(m *migrationTx) error { return m.exec(personsSchema) }},
+	{6, "rollups", func(m *migrationTx) error { return m.exec(rollupsSchema) }},
+	{7, "promoted_fields", upgradePromotedFields},
+	{8, "pruned_fingerprints", func(m *migrationTx) error { return m.exec(prunedFingerprintsSchema) }},
+}
+
+// baseSchema is the schema as of the first versioned release.
+const baseSchema = `
+CREATE TABLE IF NOT EXISTS events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ingested_at TEXT NOT NULL,
+  source_file TEXT NOT NULL,
+  stream_class_id TEXT,
+  stream_camera_id TEXT,
+  event_type TEXT,
+  room_id TEXT,
+  camera_id TEXT,
+  person_id TEXT,
+  global_person_id INTEGER,
+  track_id INTEGER,
+  confidence REAL,
+  timestamp REAL,
+  raw_json TEXT NOT NULL,
+  file_id INTEGER REFERENCES ingested_files(id),
+  fingerprint TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_events_event_type ON events(event_type);
+CREATE INDEX IF NOT EXISTS idx_events_stream_class ON events(stream_class_id);
+CREATE INDEX IF NOT EXISTS idx_events_stream_camera ON events(stream_camera_id);
+CREATE INDEX IF NOT EXISTS idx_events_camera ON events(camera_id);
+CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
+CREATE TABLE IF NOT EXISTS ingested_files (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  path TEXT NOT NULL UNIQUE,
+  size_bytes INTEGER NOT NULL,
+  mod_unix INTEGER NOT NULL,
+  ingested_at TEXT NOT NULL,
+  offset_bytes INTEGER NOT NULL DEFAULT 0,
+  last_line_hash TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS backfill_jobs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  stream_path TEXT NOT NULL,
+  class_ids TEXT NOT NULL,
+  camera_ids TEXT NOT NULL,
+  from_epoch INTEGER NOT NULL,
+  to_epoch INTEGER NOT NULL,
+  chunk_size INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  total_files INTEGER NOT NULL DEFAULT 0,
+  done_files INTEGER NOT NULL DEFAULT 0,
+  processed_files INTEGER NOT NULL DEFAULT 0,
+  inserted_events INTEGER NOT NULL DEFAULT 0,
+  skipped_files INTEGER NOT NULL DEFAULT 0,
+  cursor TEXT NOT NULL DEFAULT '',
+  error TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ingest_failures (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  path TEXT NOT NULL UNIQUE,
+  class_id TEXT NOT NULL,
+  camera_id TEXT NOT NULL,
+  size_bytes INTEGER NOT NULL,
+  mod_unix INTEGER NOT NULL,
+  error TEXT NOT NULL,
+  attempts INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  quarantined_path TEXT NOT NULL DEFAULT '',
+  first_failed_at TEXT NOT NULL,
+  last_failed_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ingest_failures_status ON ingest_failures(status);
+CREATE TABLE IF NOT EXISTS ingest_runs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  trigger TEXT NOT NULL,
+  status TEXT NOT NULL,
+  started_at TEXT NOT NULL,
+  finished_at TEXT NOT NULL,
+  duration_ms INTEGER NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  stats_json TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ingest_camera_status (
+  class_id TEXT NOT NULL,
+  camera_id TEXT NOT NULL,
+  last_run_id INTEGER NOT NULL,
+  last_run_at TEXT NOT NULL,
+  last_success_run_id INTEGER,
+  last_success_at TEXT,
+  last_error TEXT NOT NULL DEFAULT '',
+  newest_file_epoch INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (class_id, camera_id)
+);
+CREATE TABLE IF NOT EXISTS file_actions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  path TEXT NOT NULL,
+  class_id TEXT NOT NULL DEFAULT '',
+  camera_id TEXT NOT NULL DEFAULT '',
+  action TEXT NOT NULL,
+  dest_path TEXT NOT NULL DEFAULT '',
+  error TEXT NOT NULL DEFAULT '',
+  acted_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_file_actions_path ON file_actions(path);
+`
+
+const personsSchema = `
+CREATE TABLE IF NOT EXISTS persons (
+  id TEXT PRIMARY KEY,
+  class_id TEXT NOT NULL,
+  name TEXT NOT NULL DEFAULT '',
+  role TEXT NOT NULL DEFAULT '',
+  first_seen REAL NOT NULL,
+  last_seen REAL NOT NULL,
+  event_count INTEGER NOT NULL,
+  reconciled_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_persons_class ON persons(class_id);
+CREATE TABLE IF NOT EXISTS person_identities (
+  person_id TEXT NOT NULL REFERENCES persons(id),
+  class_id TEXT NOT NULL,
+  camera_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  value TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  score REAL NOT NULL DEFAULT 0,
+  first_seen REAL NOT NULL,
+  last_seen REAL NOT NULL,
+  event_count INTEGER NOT NULL,
+  PRIMARY KEY (class_id, camera_id, kind, value)
+);
+CREATE INDEX IF NOT EXISTS idx_person_identities_person ON person_identities(person_id);
+`
+
+const rollupsSchema = `
+CREATE TABLE IF NOT EXISTS event_rollups (
+  class_id TEXT NOT NULL,
+  camera_id TEXT NOT NULL,
+  event_type TEXT NOT NULL,
+  minute INTEGER NOT NULL,
+  event_count INTEGER NOT NULL,
+  confidence_sum REAL NOT NULL DEFAULT 0,
+  confidence_count INTEGER NOT NULL DEFAULT 0,
+  min_timestamp REAL NOT NULL,
+  max_timestamp REAL NOT NULL,
+  PRIMARY KEY (class_id, camera_id, event_type, minute)
+);
+CREATE INDEX IF NOT EXISTS idx_event_rollups_minute ON event_rollups(minute);
+CREATE TABLE IF NOT EXISTS student_second_rollups (
+  class_id TEXT NOT NULL,
+  camera_id TEXT NOT NULL,
+  sec INTEGER NOT NULL,
+  students INTEGER NOT NULL,
+  PRIMARY KEY (class_id, camera_id, sec)
+);
+CREATE INDEX IF NOT EXISTS idx_student_second_rollups_sec ON student_second_rollups(sec);
+`
+
+// prunedFingerprintsSchema keeps the fingerprints of events folded into
+// rollups, so re-ingesting them is a duplicate rather than a second count.
+const prunedFingerprintsSchema = `
+CREATE TABLE IF NOT EXISTS pruned_fingerprints (
+  fingerprint TEXT PRIMARY KEY,
+  event_type TEXT NOT NULL,
+  pruned_at TEXT NOT NULL
+) WITHOUT ROWID;
+`
+
+// Migration is one applied or pending migration with the statements it ran.
+type Migration struct {
+	Version    int      `json:"version"`
+	Name       string   `json:"name"`
+	Statements []string `json:"statements,omitempty"`
+}
+
+// LatestSchemaVersion is the schema version this binary migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// OpenWithoutMigrating opens the database like Open but leaves pending
+// migrations for Migrate. A database newer than the binary is still refused.
+func OpenWithoutMigrating(path string) (*Store, error) {
+	db, reader, err := openPools(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{db: db, reader: reader}
+	if _, err := s.checkSchemaVersion(context.Background()); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	if err := s.loadPromotedFields(context.Background()); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// SchemaVersion returns the latest migration applied to the database, 0 for
+// new databases and databases created before versioning.
+func (s *Store) SchemaVersion() (int, error) {
+	return schemaVersion(context.Background(), s.db)
+}
+
+// Migrate applies the pending migrations and returns them. With dryRun they
+// all run in one transaction that is rolled back, so the returned statements
+// are those an upgrade would run and the database is left unchanged.
+func (s *Store) Migrate(dryRun bool) ([]Migration, error) {
+	return s.MigrateContext(context.Background(), dryRun)
+}
+
+// MigrateContext is Migrate with a context; cancelling ctx rolls back the
+// migration in progress.
+func (s *Store) MigrateContext(ctx context.Context, dryRun bool) ([]Migration, error) {
+	current, err := s.checkSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]Migration, 0)
+	var tx *sql.Tx
+	defer func() {
+		if tx != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	for _, mig := range migrations {
+		if mig.version <= current {
+			continue
+		}
+		if tx == nil {
+			if tx, err = s.db.BeginTx(ctx, nil); err != nil {
+				return applied, fmt.Errorf("begin migration %d: %w", mig.version, err)
+			}
+			if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at TEXT NOT NULL
+)`); err != nil {
+				return applied, fmt.Errorf("create schema_migrations: %w", err)
+			}
+			// Another process may have migrated since the version was read.
+			if current, err = schemaVersion(ctx, tx); err != nil {
+				return applied, err
+			}
+			if mig.version <= current {
+				_ = tx.Rollback()
+				tx = nil
+				continue
+			}
+		}
+
+		m := &migrationTx{ctx: ctx, tx: tx}
+		if err := mig.up(m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", mig.version, mig.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, ?)", mig.version, mig.name, time.Now().UTC().Format(time.RFC3339Nano)); err != nil {
+			return applied, fmt.Errorf("record migration %d: %w", mig.version, err)
+		}
+		if !dryRun {
+			if err := tx.Commit(); err != nil {
+				return applied, fmt.Errorf("commit migration %d: %w", mig.version, err)
+			}
+			tx = nil
+		}
+		applied = append(applied, Migration{Version: mig.version, Name: mig.name, Statements: m.statements})
+	}
+	if len(applied) > 0 && !dryRun {
+		if err := s.loadPromotedFields(ctx); err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// checkSchemaVersion returns the database's version, or ErrSchemaTooNew.
+func (s *Store) checkSchemaVersion(ctx context.Context) (int, error) {
+	version, err := schemaVersion(ctx, s.db)
+	if err != nil {
+		return 0, err
+	}
+	if version > LatestSchemaVersion() {
+		return version, fmt.Errorf("%w: version %d, this binary supports up to %d", ErrSchemaTooNew, version, LatestSchemaVersion())
+	}
+	return version, nil
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, q queryer) (int, error) {
+	var n int
+	if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&n); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	var version int
+	if err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrationTx runs a migration's statements in its transaction and records
+// them for Migrate's result.
+type migrationTx struct {
+	ctx        context.Context
+	tx         *sql.Tx
+	statements []string
+}
+
+func (m *migrationTx) exec(query string) error {
+	m.statements = append(m.statements, strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	_, err := m.tx.ExecContext(m.ctx, query)
+	return err
+}
+
+func (m *migrationTx) hasColumn(table, column string) (bool, error) {
+	rows, err := m.tx.QueryContext(m.ctx, "SELECT name FROM pragma_table_xinfo(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// ensureColumn adds column to table with the given type/constraints when an
+// older database does not have it yet.
+func (m *migrationTx) ensureColumn(table, column, decl string) error {
+	has, err := m.hasColumn(table, column)
+	if err != nil || has {
+		return err
+	}
+	return m.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+}
+
+// upgradeFileLinks brings databases created before events were linked to
+// ingested_files up to date: it rebuilds ingested_files with an integer id,
+// adds events.file_id and links existing events by source_file.
+func upgradeFileLinks(m *migrationTx) error {
+	hasFileID, err := m.hasColumn("events", "file_id")
+	if err != nil {
+		return err
+	}
+	hasID, err := m.hasColumn("ingested_files", "id")
+	if err != nil {
+		return err
+	}
+	if hasFileID && hasID {
+		return m.exec("CREATE INDEX IF NOT EXISTS idx_events_file_id ON events(file_id)")
+	}
+
+	if !hasID {
+		if err := m.exec(`
+CREATE TABLE ingested_files_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  path TEXT NOT NULL UNIQUE,
+  size_bytes INTEGER NOT NULL,
+  mod_unix INTEGER NOT NULL,
+  ingested_at TEXT NOT NULL
+);
+INSERT INTO ingested_files_new(path, size_bytes, mod_unix, ingested_at)
+  SELECT path, size_bytes, mod_unix, ingested_at FROM ingested_files ORDER BY path;
+DROP TABLE ingested_files;
+ALTER TABLE ingested_files_new RENAME TO ingested_files;`); err != nil {
+			return err
+		}
+	}
+	if !hasFileID {
+		if err := m.exec("ALTER TABLE events ADD COLUMN file_id INTEGER REFERENCES ingested_files(id)"); err != nil {
+			return err
+		}
+	}
+	return m.exec(`UPDATE events SET file_id = (SELECT f.id FROM ingested_files f WHERE f.path = events.source_file)
+WHERE file_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_events_file_id ON events(file_id);`)
+}
+
+// upgradeFingerprints adds events.fingerprint to older databases. Existing
+// rows keep a NULL fingerprint (NULLs never collide in the unique index) until
+// Dedupe computes them and removes duplicates.
+func upgradeFingerprints(m *migrationTx) error {
+	if err := m.ensureColumn("events", "fingerprint", "TEXT"); err != nil {
+		return err
+	}
+	return m.exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_events_fingerprint ON events(fingerprint)")
+}
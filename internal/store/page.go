@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ListEventsPage pages through events with keyset cursors instead of
+// offsets, so deep pages cost the same as the first and rows appended by
+// ingestion do not shift later pages. Events are ordered by id or by
+// (timestamp, id); a cursor encodes the sort key of the row it continues
+// from, the order and the direction, and is only valid with the same sort
+// and order.
+
+const (
+	SortByID        = "id"
+	SortByTimestamp = "timestamp"
+	OrderAsc        = "asc"
+	OrderDesc       = "desc"
+)
+
+// ErrInvalidCursor is returned for cursors that cannot be decoded or were
+// issued for a different sort or order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type EventPageRequest struct {
+	// Sort is SortByID (default) or SortByTimestamp; sorting by timestamp
+	// skips events without one.
+	Sort string
+	// Order is OrderDesc (default) or OrderAsc.
+	Order string
+	// Cursor is a NextCursor or PrevCursor from an earlier page; empty
+	// starts at the first page. Sort and Order default to the cursor's.
+	Cursor string
+	// IncludeTotal counts every matching event, which scans the filter's
+	// whole range.
+	IncludeTotal bool
+}
+
+type EventPage struct {
+	Events []EventRecord `json:"events"`
+	Sort   string        `json:"sort"`
+	Order  string        `json:"order"`
+	Limit  int           `json:"limit"`
+	// NextCursor and PrevCursor are empty when there is no page in that
+	// direction.
+	NextCursor string `json:"next_cursor"`
+	PrevCursor string `json:"prev_cursor"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+type eventCursor struct {
+	Sort   string   `json:"s"`
+	Order  string   `json:"o"`
+	Before bool     `json:"b,omitempty"`
+	ID     int64    `json:"id"`
+	TS     *float64 `json:"ts,omitempty"`
+}
+
+func (c eventCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeEventCursor(v string) (eventCursor, error) {
+	var c eventCursor
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return c, ErrInvalidCursor
+	}
+	if (c.Sort != SortByID && c.Sort != SortByTimestamp) || (c.Order != OrderAsc && c.Order != OrderDesc) || (c.Sort == SortByTimestamp && c.TS == nil) {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func (s *Store) ListEventsPage(f EventFilter, p EventPageRequest) (EventPage, error) {
+	return s.ListEventsPageContext(context.Background(), f, p)
+}
+
+// ListEventsPageContext returns up to f.Limit events matching f from the
+// position p.Cursor points at. f.Offset is ignored.
+func (s *Store) ListEventsPageContext(ctx context.Context, f EventFilter, p EventPageRequest) (EventPage, error) {
+	if f.Limit <= 0 || f.Limit > 1000 {
+		f.Limit = 200
+	}
+	var (
+		cur       eventCursor
+		hasCursor = p.Cursor != ""
+	)
+	if hasCursor {
+		var err error
+		if cur, err = decodeEventCursor(p.Cursor); err != nil {
+			return EventPage{}, err
+		}
+		if (p.Sort != "" && p.Sort != cur.Sort) || (p.Order != "" && p.Order != cur.Order) {
+			return EventPage{}, fmt.Errorf("%w: issued for sort=%s order=%s", ErrInvalidCursor, cur.Sort, cur.Order)
+		}
+		p.Sort, p.Order = cur.Sort, cur.Order
+	}
+	if p.Sort == "" {
+		p.Sort = SortByID
+	}
+	if p.Order == "" {
+		p.Order = OrderDesc
+	}
+	if p.Sort != SortByID && p.Sort != SortByTimestamp {
+		return EventPage{}, fmt.Errorf("unknown sort %q", p.Sort)
+	}
+	if p.Order != OrderAsc && p.Order != OrderDesc {
+		return EventPage{}, fmt.Errorf("unknown order %q", p.Order)
+	}
+	out := EventPage{Sort: p.Sort, Order: p.Order, Limit: f.Limit}
+
+	where, args := buildWhere(f)
+	if p.Sort == SortByTimestamp {
+		where = andWhere(where, "timestamp IS NOT NULL")
+	}
+	if p.IncludeTotal {
+		var total int64
+		if err := s.reader.QueryRowContext(ctx, "SELECT COUNT(*) FROM events"+where, args...).Scan(&total); err != nil {
+			return out, fmt.Errorf("count events: %w", err)
+		}
+		out.Total = &total
+	}
+
+	// A backward page scans against the order and is reversed afterwards.
+	backward := hasCursor && cur.Before
+	ascending := (p.Order == OrderAsc) != backward
+	cmp, dir := "<", "DESC"
+	if ascending {
+		cmp, dir = ">", "ASC"
+	}
+	orderBy := " ORDER BY id " + dir
+	if p.Sort == SortByTimestamp {
+		orderBy = " ORDER BY timestamp " + dir + ", id " + dir
+	}
+	if hasCursor {
+		if p.Sort == SortByTimestamp {
+			where = andWhere(where, "(timestamp, id) "+cmp+" (?, ?)")
+			args = append(args, *cur.TS, cur.ID)
+		} else {
+			where = andWhere(where, "id "+cmp+" ?")
+			args = append(args, cur.ID)
+		}
+	}
+
+	rows, err := s.reader.QueryContext(ctx, "SELECT "+eventColumns+" FROM events"+where+orderBy+" LIMIT ?", append(args, f.Limit+1)...)
+	if err != nil {
+		return out, fmt.Errorf("query events: %w", err)
+	}
+	defer rows.Close()
+	events, err := scanEvents(rows)
+	if err != nil {
+		return out, err
+	}
+	more := len(events) > f.Limit
+	if more {
+		events = events[:f.Limit]
+	}
+	if backward {
+		slices.Reverse(events)
+	}
+	out.Events = events
+
+	at := func(r EventRecord, before bool) string {
+		return eventCursor{Sort: p.Sort, Order: p.Order, Before: before, ID: r.ID, TS: r.Timestamp}.encode()
+	}
+	switch {
+	case len(events) == 0 && hasCursor:
+		// Nothing past the cursor; offer the way back from the same spot.
+		back := cur
+		back.Before = !cur.Before
+		if backward {
+			out.NextCursor = back.encode()
+		} else {
+			out.PrevCursor = back.encode()
+		}
+	case len(events) > 0:
+		first, last := events[0], events[len(events)-1]
+		if backward {
+			out.NextCursor = at(last, false)
+			if more {
+				out.PrevCursor = at(first, true)
+			}
+		} else {
+			if more {
+				out.NextCursor = at(last, false)
+			}
+			if hasCursor {
+				out.PrevCursor = at(first, true)
+			}
+		}
+	}
+	return out, nil
+}
+
+// andWhere adds clause to a WHERE built by buildWhere.
+func andWhere(where, clause string) string {
+	if where == "" {
+		return " WHERE " + clause
+	}
+	return where + " AND " + clause
+}
@@ -0,0 +1,337 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Person is a persons row: one individual of a class, merged from the
+// per-camera identities that refer to them (see identity.go). ID is derived
+// from the evidence, "<class>:<name>" for named persons and
+// "<class>:<camera>:<g|p|t><value>" from the first identity otherwise, so it
+// is stable across reconciliations that find the same links.
+type Person struct {
+	ID           string           `json:"id"`
+	ClassID      string           `json:"class_id"`
+	Name         string           `json:"name,omitempty"`
+	Role         string           `json:"role,omitempty"`
+	FirstSeen    float64          `json:"first_seen"`
+	LastSeen     float64          `json:"last_seen"`
+	EventCount   int64            `json:"event_count"`
+	Cameras      []string         `json:"cameras"`
+	Identities   []PersonIdentity `json:"identities"`
+	ReconciledAt string           `json:"reconciled_at,omitempty"`
+}
+
+// PersonIdentity is one per-camera identity of a person: Kind is "global"
+// (global_person_id), "person" (person_id) or "track" (track_id). Reason is
+// the evidence that placed it in the person, one of the Link constants, and
+// Score the Jaccard score of a co-occurrence link.
+type PersonIdentity struct {
+	CameraID   string  `json:"camera_id"`
+	Kind       string  `json:"kind"`
+	Value      string  `json:"value"`
+	Reason     string  `json:"reason"`
+	Score      float64 `json:"score,omitempty"`
+	FirstSeen  float64 `json:"first_seen"`
+	LastSeen   float64 `json:"last_seen"`
+	EventCount int64   `json:"event_count"`
+}
+
+// ReconcileResult reports what ReconcilePersons stored.
+type ReconcileResult struct {
+	Events      int64 `json:"events"`
+	Persons     int   `json:"persons"`
+	Identities  int   `json:"identities"`
+	MultiCamera int   `json:"multi_camera_persons"`
+}
+
+// PersonFilter selects persons for ListPersons. CameraIDs keeps persons
+// with an identity on one of the cameras.
+type PersonFilter struct {
+	ClassIDs  []string
+	CameraIDs []string
+	Limit     int
+	Offset    int
+}
+
+// ReconcilePersons rebuilds the persons of classIDs (all classes when empty)
+// from every stored event with an identity.
+func (s *Store) ReconcilePersons(classIDs []string, opts ReconcileOptions) (ReconcileResult, error) {
+	return s.ReconcilePersonsContext(context.Background(), classIDs, opts)
+}
+
+// ReconcilePersonsContext is ReconcilePersons with a context; the old
+// persons are replaced in one transaction, which cancelling ctx rolls back.
+func (s *Store) ReconcilePersonsContext(ctx context.Context, classIDs []string, opts ReconcileOptions) (ReconcileResult, error) {
+	var res ReconcileResult
+	rec := newIdentityReconciler(opts)
+	where, args := buildWhere(EventFilter{ClassIDs: classIDs})
+	clauses := "timestamp IS NOT NULL AND (global_person_id IS NOT NULL OR COALESCE(person_id, '') != '' OR track_id IS NOT NULL)"
+	if where == "" {
+		where = " WHERE " + clauses
+	} else {
+		where += " AND " + clauses
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT COALESCE(NULLIF(stream_class_id, ''), room_id, ''), COALESCE(NULLIF(stream_camera_id, ''), camera_id, ''), event_type,
+  global_person_id, COALESCE(person_id, ''), track_id, timestamp,
+  COALESCE(field_person_name, ''),
+  COALESCE(field_person_role, '')
+FROM events`+where+` ORDER BY timestamp ASC, id ASC`, args...)
+	if err != nil {
+		return res, fmt.Errorf("query identities: %w", err)
+	}
+	for rows.Next() {
+		var (
+			o        identityObservation
+			globalID sql.NullInt64
+			trackID  sql.NullInt64
+		)
+		if err := rows.Scan(&o.classID, &o.cameraID, &o.eventType, &globalID, &o.personID, &trackID, &o.ts, &o.name, &o.role); err != nil {
+			rows.Close()
+			return res, fmt.Errorf("scan identity: %w", err)
+		}
+		if globalID.Valid {
+			o.globalID = &globalID.Int64
+		}
+		if trackID.Valid {
+			o.trackID = &trackID.Int64
+		}
+		rec.add(o)
+		res.Events++
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return res, fmt.Errorf("iterate identities: %w", err)
+	}
+	rows.Close()
+	persons := rec.result()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if len(classIDs) == 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM person_identities; DELETE FROM persons;")
+	} else {
+		classArgs := make([]any, len(classIDs))
+		for i, id := range classIDs {
+			classArgs[i] = id
+		}
+		in := "(" + placeholders(len(classIDs)) + ")"
+		if _, err = tx.ExecContext(ctx, "DELETE FROM person_identities WHERE class_id IN "+in, classArgs...); err == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM persons WHERE class_id IN "+in, classArgs...)
+		}
+	}
+	if err != nil {
+		return res, fmt.Errorf("clear persons: %w", err)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, p := range persons {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO persons(id, class_id, name, role, first_seen, last_seen, event_count, reconciled_at)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?)`, p.ID, p.ClassID, p.Name, p.Role, p.FirstSeen, p.LastSeen, p.EventCount, now); err != nil {
+			return res, fmt.Errorf("insert person %s: %w", p.ID, err)
+		}
+		for _, id := range p.Identities {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO person_identities(person_id, class_id, camera_id, kind, value, reason, score, first_seen, last_seen, event_count)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, p.ID, p.ClassID, id.CameraID, id.Kind, id.Value, id.Reason, id.Score, id.FirstSeen, id.LastSeen, id.EventCount); err != nil {
+				return res, fmt.Errorf("insert identity of %s: %w", p.ID, err)
+			}
+		}
+		res.Persons++
+		res.Identities += len(p.Identities)
+		if len(p.Cameras) > 1 {
+			res.MultiCamera++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("commit tx: %w", err)
+	}
+	return res, nil
+}
+
+func (s *Store) ListPersons(f PersonFilter) ([]Person, int64, error) {
+	return s.ListPersonsContext(context.Background(), f)
+}
+
+// ListPersonsContext is ListPersons with a context that aborts the queries.
+func (s *Store) ListPersonsContext(ctx context.Context, f PersonFilter) ([]Person, int64, error) {
+	if f.Limit <= 0 || f.Limit > 1000 {
+		f.Limit = 200
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	clauses := make([]string, 0)
+	args := make([]any, 0)
+	if len(f.ClassIDs) > 0 {
+		clauses = append(clauses, "class_id IN ("+placeholders(len(f.ClassIDs))+")")
+		for _, v := range f.ClassIDs {
+			args = append(args, v)
+		}
+	}
+	if len(f.CameraIDs) > 0 {
+		clauses = append(clauses, "id IN (SELECT person_id FROM person_identities WHERE camera_id IN ("+placeholders(len(f.CameraIDs))+"))")
+		for _, v := range f.CameraIDs {
+			args = append(args, v)
+		}
+	}
+	where := ""
+	if len(clauses) > 0 {
+		where = " WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	var total int64
+	if err := s.reader.QueryRowContext(ctx, "SELECT COUNT(*) FROM persons"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count persons: %w", err)
+	}
+	rows, err := s.reader.QueryContext(ctx, "SELECT "+personColumns+" FROM persons"+where+" ORDER BY class_id ASC, first_seen ASC, id ASC LIMIT ? OFFSET ?", append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query persons: %w", err)
+	}
+	out := make([]Person, 0)
+	for rows.Next() {
+		p, err := scanPerson(rows)
+		if err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("scan person: %w", err)
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, 0, fmt.Errorf("iterate persons: %w", err)
+	}
+	rows.Close()
+
+	for i := range out {
+		if err := s.loadPersonIdentities(ctx, &out[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+	return out, total, nil
+}
+
+func (s *Store) GetPerson(id string) (Person, error) {
+	return s.GetPersonContext(context.Background(), id)
+}
+
+// GetPersonContext returns the person with its identities; the error is
+// sql.ErrNoRows when there is no such person.
+func (s *Store) GetPersonContext(ctx context.Context, id string) (Person, error) {
+	p, err := scanPerson(s.reader.QueryRowContext(ctx, "SELECT "+personColumns+" FROM persons WHERE id = ?", id))
+	if err != nil {
+		return p, err
+	}
+	if err := s.loadPersonIdentities(ctx, &p); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// PersonTimeline returns the events of all identities of p, oldest first,
+// filtered by f's event types, confidence and time range.
+func (s *Store) PersonTimeline(p Person, f EventFilter) ([]EventRecord, int64, error) {
+	return s.PersonTimelineContext(context.Background(), p, f)
+}
+
+// PersonTimelineContext is PersonTimeline with a context that aborts the
+// queries.
+func (s *Store) PersonTimelineContext(ctx context.Context, p Person, f EventFilter) ([]EventRecord, int64, error) {
+	if f.Limit <= 0 || f.Limit > 1000 {
+		f.Limit = 200
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	f.ClassIDs, f.CameraIDs = nil, nil
+	if len(p.Identities) == 0 {
+		return make([]EventRecord, 0), 0, nil
+	}
+	where, args := buildWhere(f)
+
+	// An event belongs to the identity its first present ID names, as in
+	// identityObservation.key.
+	matches := make([]string, 0, len(p.Identities))
+	for _, id := range p.Identities {
+		var match string
+		switch id.Kind {
+		case "global":
+			match = "global_person_id = ?"
+		case "person":
+			match = "global_person_id IS NULL AND person_id = ?"
+		case "track":
+			match = "global_person_id IS NULL AND COALESCE(person_id, '') = '' AND track_id = ?"
+		default:
+			continue
+		}
+		var value any = id.Value
+		if id.Kind != "person" {
+			n, err := strconv.ParseInt(id.Value, 10, 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("identity %s of %s: %w", id.Value, p.ID, err)
+			}
+			value = n
+		}
+		matches = append(matches, "(COALESCE(NULLIF(stream_class_id, ''), room_id) = ? AND COALESCE(NULLIF(stream_camera_id, ''), camera_id) = ? AND "+match+")")
+		args = append(args, p.ClassID, id.CameraID, value)
+	}
+	clause := "(" + strings.Join(matches, " OR ") + ")"
+	if where == "" {
+		where = " WHERE " + clause
+	} else {
+		where += " AND " + clause
+	}
+
+	var total int64
+	if err := s.reader.QueryRowContext(ctx, "SELECT COUNT(*) FROM events"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count timeline: %w", err)
+	}
+	rows, err := s.reader.QueryContext(ctx, "SELECT "+eventColumns+" FROM events"+where+" ORDER BY timestamp ASC, id ASC LIMIT ? OFFSET ?", append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query timeline: %w", err)
+	}
+	defer rows.Close()
+	out, err := scanEvents(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, total, nil
+}
+
+const personColumns = "id, class_id, name, role, first_seen, last_seen, event_count, reconciled_at"
+
+func scanPerson(row rowScanner) (Person, error) {
+	var p Person
+	err := row.Scan(&p.ID, &p.ClassID, &p.Name, &p.Role, &p.FirstSeen, &p.LastSeen, &p.EventCount, &p.ReconciledAt)
+	return p, err
+}
+
+func (s *Store) loadPersonIdentities(ctx context.Context, p *Person) error {
+	rows, err := s.reader.QueryContext(ctx, `SELECT camera_id, kind, value, reason, score, first_seen, last_seen, event_count
+FROM person_identities WHERE person_id = ? ORDER BY camera_id, kind, value`, p.ID)
+	if err != nil {
+		return fmt.Errorf("query identities of %s: %w", p.ID, err)
+	}
+	defer rows.Close()
+	p.Cameras = make([]string, 0)
+	p.Identities = make([]PersonIdentity, 0)
+	for rows.Next() {
+		var id PersonIdentity
+		if err := rows.Scan(&id.CameraID, &id.Kind, &id.Value, &id.Reason, &id.Score, &id.FirstSeen, &id.LastSeen, &id.EventCount); err != nil {
+			return fmt.Errorf("scan identity of %s: %w", p.ID, err)
+		}
+		if len(p.Cameras) == 0 || p.Cameras[len(p.Cameras)-1] != id.CameraID {
+			p.Cameras = append(p.Cameras, id.CameraID)
+		}
+		p.Identities = append(p.Identities, id)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,434 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention deletes raw events older than a per-event-type TTL. Before rows
+// are deleted they are folded into two rollup tables, which Summary and
+// DailyStudentMetrics read alongside the remaining events:
+//
+//   - event_rollups: per class, camera, event type and minute, the event
+//     count, the confidence sum and count, and the first and last timestamp
+//   - student_second_rollups: per class, camera and second, the number of
+//     distinct students as DailyStudentMetrics counts them
+//
+// Event rollups are additive and every event is counted either as a raw row
+// or in a rollup, never both; an event that arrives late for an already
+// pruned minute stays raw until the next run folds it in. The fingerprints
+// of pruned events are kept in pruned_fingerprints for FingerprintTTL, so an
+// event re-posted or re-read from a file after it was rolled up is dropped as
+// a duplicate. Distinct student
+// counts are not additive, so a second present in both places counts the
+// larger of the two. Cutoffs are rounded down to the minute so no minute or
+// second of one event type is split between raw rows and rollups. Events
+// without an event type or timestamp are never deleted.
+
+// RetentionPolicy is how long raw events are kept. A zero TTL keeps events
+// forever.
+type RetentionPolicy struct {
+	// DefaultTTL applies to event types not listed in EventTypes.
+	DefaultTTL time.Duration
+	EventTypes map[string]time.Duration
+	// FingerprintTTL is how long the fingerprints of pruned events are kept
+	// after pruning; 0 keeps them forever. Past it, a re-read copy of a
+	// pruned event is stored and counted again.
+	FingerprintTTL time.Duration
+}
+
+// DefaultFingerprintTTL is the FingerprintTTL of a policy that does not set
+// one.
+const DefaultFingerprintTTL = 90 * 24 * time.Hour
+
+// retentionFile is the JSON form of a RetentionPolicy:
+//
+//	{"default_ttl": "90d", "event_types": {"frame_tick": "24h", "lesson_comprehensive_summary": "0"}, "fingerprint_ttl": "180d"}
+type retentionFile struct {
+	DefaultTTL     string            `json:"default_ttl"`
+	EventTypes     map[string]string `json:"event_types"`
+	FingerprintTTL string            `json:"fingerprint_ttl"`
+}
+
+// ParseTTL parses a retention TTL: a Go duration such as "36h", a whole
+// number of days such as "30d", or "0" to keep events forever.
+func ParseTTL(v string) (time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "0" {
+		return 0, nil
+	}
+	var (
+		d   time.Duration
+		err error
+	)
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(v)
+	}
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid ttl %q (expected a duration like 36h or 30d)", v)
+	}
+	return d, nil
+}
+
+// ParseRetentionPolicy decodes a retention file. Unknown keys are rejected
+// so a typo does not silently keep data forever.
+func ParseRetentionPolicy(data []byte) (RetentionPolicy, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var f retentionFile
+	if err := dec.Decode(&f); err != nil {
+		return RetentionPolicy{}, fmt.Errorf("decode retention policy: %w", err)
+	}
+	p := RetentionPolicy{FingerprintTTL: DefaultFingerprintTTL}
+	var err error
+	if p.DefaultTTL, err = ParseTTL(f.DefaultTTL); err != nil {
+		return p, fmt.Errorf("default_ttl: %w", err)
+	}
+	if f.FingerprintTTL != "" {
+		if p.FingerprintTTL, err = ParseTTL(f.FingerprintTTL); err != nil {
+			return p, fmt.Errorf("fingerprint_ttl: %w", err)
+		}
+	}
+	for eventType, v := range f.EventTypes {
+		ttl, err := ParseTTL(v)
+		if err != nil {
+			return p, fmt.Errorf("event_types.%s: %w", eventType, err)
+		}
+		p = p.withTTL(eventType, ttl)
+	}
+	return p, nil
+}
+
+// LoadRetentionPolicy reads the retention file at path.
+func LoadRetentionPolicy(path string) (RetentionPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return RetentionPolicy{}, fmt.Errorf("read retention policy: %w", err)
+	}
+	p, err := ParseRetentionPolicy(b)
+	if err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+// RetentionPolicyFromFlags builds the policy given by the --retention-file
+// and --retention-ttl flags; either may be empty and the TTLs apply over the
+// file. Without a file, FingerprintTTL is DefaultFingerprintTTL.
+func RetentionPolicyFromFlags(path, ttls string) (RetentionPolicy, error) {
+	p := RetentionPolicy{FingerprintTTL: DefaultFingerprintTTL}
+	if path != "" {
+		var err error
+		if p, err = LoadRetentionPolicy(path); err != nil {
+			return p, err
+		}
+	}
+	return p.WithTTLs(ttls)
+}
+
+// WithTTLs returns p with the comma separated event_type=ttl pairs in spec
+// applied over it, as given by the --retention-ttl flags. The type
+// "default" sets DefaultTTL and "fingerprints" sets FingerprintTTL.
+func (p RetentionPolicy) WithTTLs(spec string) (RetentionPolicy, error) {
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		eventType, v, ok := strings.Cut(pair, "=")
+		eventType = strings.TrimSpace(eventType)
+		if !ok || eventType == "" {
+			return p, fmt.Errorf("invalid retention ttl %q (expected event_type=ttl)", pair)
+		}
+		ttl, err := ParseTTL(v)
+		if err != nil {
+			return p, fmt.Errorf("%s: %w", eventType, err)
+		}
+		switch eventType {
+		case "default":
+			p.DefaultTTL = ttl
+			continue
+		case "fingerprints":
+			p.FingerprintTTL = ttl
+			continue
+		}
+		p = p.withTTL(eventType, ttl)
+	}
+	return p, nil
+}
+
+func (p RetentionPolicy) withTTL(eventType string, ttl time.Duration) RetentionPolicy {
+	types := make(map[string]time.Duration, len(p.EventTypes)+1)
+	for k, v := range p.EventTypes {
+		types[k] = v
+	}
+	types[eventType] = ttl
+	p.EventTypes = types
+	return p
+}
+
+// TTL returns how long raw events of eventType are kept; 0 means forever.
+func (p RetentionPolicy) TTL(eventType string) time.Duration {
+	if ttl, ok := p.EventTypes[eventType]; ok {
+		return ttl
+	}
+	return p.DefaultTTL
+}
+
+// Empty reports whether the policy keeps every event forever.
+func (p RetentionPolicy) Empty() bool {
+	if p.DefaultTTL > 0 {
+		return false
+	}
+	for _, ttl := range p.EventTypes {
+		if ttl > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RetentionTypeResult is what one retention run did to one event type.
+type RetentionTypeResult struct {
+	EventType  string  `json:"event_type"`
+	TTLSeconds float64 `json:"ttl_seconds"`
+	// Cutoff is the epoch timestamp raw events were deleted before.
+	Cutoff  float64 `json:"cutoff"`
+	Deleted int64   `json:"deleted"`
+	// RollupRows and StudentSeconds count the rollup rows written or
+	// updated.
+	RollupRows     int64 `json:"rollup_rows"`
+	StudentSeconds int64 `json:"student_seconds"`
+}
+
+type RetentionResult struct {
+	Now        float64               `json:"now"`
+	DryRun     bool                  `json:"dry_run"`
+	Deleted    int64                 `json:"deleted"`
+	EventTypes []RetentionTypeResult `json:"event_types"`
+	// ExpiredFingerprints counts the pruned_fingerprints rows dropped for
+	// being older than the policy's FingerprintTTL.
+	ExpiredFingerprints int64 `json:"expired_fingerprints"`
+}
+
+func (s *Store) ApplyRetention(p RetentionPolicy, now time.Time, dryRun bool) (RetentionResult, error) {
+	return s.ApplyRetentionContext(context.Background(), p, now, dryRun)
+}
+
+// ApplyRetentionContext rolls up and deletes the events p no longer keeps as
+// of now, then drops the pruned fingerprints older than p.FingerprintTTL.
+// Each event type and the fingerprint expiry is handled in its own
+// transaction; with dryRun the transactions are rolled back, so the result
+// shows what a real run would do.
+func (s *Store) ApplyRetentionContext(ctx context.Context, p RetentionPolicy, now time.Time, dryRun bool) (RetentionResult, error) {
+	out := RetentionResult{Now: float64(now.Unix()), DryRun: dryRun, EventTypes: make([]RetentionTypeResult, 0)}
+	if p.Empty() {
+		return out, nil
+	}
+	types, err := s.storedEventTypes(ctx)
+	if err != nil {
+		return out, err
+	}
+	for _, eventType := range types {
+		ttl := p.TTL(eventType)
+		if ttl <= 0 {
+			continue
+		}
+		cutoff := math.Floor(float64(now.Add(-ttl).Unix())/60) * 60
+		res, err := s.pruneEventType(ctx, eventType, cutoff, now, dryRun)
+		if err != nil {
+			return out, err
+		}
+		res.TTLSeconds = ttl.Seconds()
+		out.Deleted += res.Deleted
+		out.EventTypes = append(out.EventTypes, res)
+	}
+	if p.FingerprintTTL > 0 {
+		out.ExpiredFingerprints, err = s.expireFingerprints(ctx, now.Add(-p.FingerprintTTL), dryRun)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// expireFingerprints deletes the pruned_fingerprints rows pruned before
+// cutoff.
+func (s *Store) expireFingerprints(ctx context.Context, cutoff time.Time, dryRun bool) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin fingerprint expiry: %w", err)
+	}
+	defer tx.Rollback()
+	r, err := tx.ExecContext(ctx, "DELETE FROM pruned_fingerprints WHERE julianday(pruned_at) < julianday(?)", cutoff.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, fmt.Errorf("expire pruned fingerprints: %w", err)
+	}
+	n, _ := r.RowsAffected()
+	if dryRun {
+		return n, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return n, fmt.Errorf("commit fingerprint expiry: %w", err)
+	}
+	return n, nil
+}
+
+func (s *Store) storedEventTypes(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT event_type FROM events WHERE event_type IS NOT NULL AND event_type != ''")
+	if err != nil {
+		return nil, fmt.Errorf("list event types: %w", err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("scan event type: %w", err)
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate event types: %w", err)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (s *Store) pruneEventType(ctx context.Context, eventType string, cutoff float64, now time.Time, dryRun bool) (RetentionTypeResult, error) {
+	res := RetentionTypeResult{EventType: eventType, Cutoff: cutoff}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, fmt.Errorf("begin retention %s: %w", eventType, err)
+	}
+	defer tx.Rollback()
+
+	if eventType == "person_tracked" || eventType == "person_detected" {
+		// Count whole seconds from the first one being deleted, including
+		// the other person type's rows, which may be kept longer.
+		r, err := tx.ExecContext(ctx, `INSERT INTO student_second_rollups(class_id, camera_id, sec, students)
+SELECT class_id, camera_id, sec, COUNT(DISTINCT pid) FROM (
+  SELECT `+classKeyExpr+` AS class_id, `+cameraKeyExpr+` AS camera_id, CAST(timestamp AS INTEGER) AS sec, `+studentPIDExpr+` AS pid
+  FROM events
+  WHERE `+studentEventsWhere+`
+    AND timestamp >= (SELECT CAST(MIN(timestamp) AS INTEGER) FROM events WHERE event_type = ? AND timestamp < ?) AND timestamp < ?
+)
+WHERE class_id != '' AND camera_id != '' AND pid IS NOT NULL AND pid != ''
+GROUP BY class_id, camera_id, sec
+ON CONFLICT(class_id, camera_id, sec) DO UPDATE SET students = MAX(students, excluded.students)`, eventType, cutoff, cutoff)
+		if err != nil {
+			return res, fmt.Errorf("roll up student seconds for %s: %w", eventType, err)
+		}
+		res.StudentSeconds, _ = r.RowsAffected()
+	}
+
+	r, err := tx.ExecContext(ctx, `INSERT INTO event_rollups(class_id, camera_id, event_type, minute, event_count, confidence_sum, confidence_count, min_timestamp, max_timestamp)
+SELECT COALESCE(`+classKeyExpr+`, ''), COALESCE(`+cameraKeyExpr+`, ''), event_type, CAST(timestamp / 60 AS INTEGER) * 60,
+  COUNT(*), COALESCE(SUM(confidence), 0), COUNT(confidence), MIN(timestamp), MAX(timestamp)
+FROM events
+WHERE event_type = ? AND timestamp < ?
+GROUP BY 1, 2, 3, 4
+ON CONFLICT(class_id, camera_id, event_type, minute) DO UPDATE SET
+  event_count = event_count + excluded.event_count,
+  confidence_sum = confidence_sum + excluded.confidence_sum,
+  confidence_count = confidence_count + excluded.confidence_count,
+  min_timestamp = MIN(min_timestamp, excluded.min_timestamp),
+  max_timestamp = MAX(max_timestamp, excluded.max_timestamp)`, eventType, cutoff)
+	if err != nil {
+		return res, fmt.Errorf("roll up %s events: %w", eventType, err)
+	}
+	res.RollupRows, _ = r.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO pruned_fingerprints(fingerprint, event_type, pruned_at)
+SELECT fingerprint, event_type, ? FROM events WHERE event_type = ? AND timestamp < ? AND fingerprint IS NOT NULL`,
+		now.UTC().Format(time.RFC3339Nano), eventType, cutoff); err != nil {
+		return res, fmt.Errorf("record pruned %s fingerprints: %w", eventType, err)
+	}
+	r, err = tx.ExecContext(ctx, "DELETE FROM events WHERE event_type = ? AND timestamp < ?", eventType, cutoff)
+	if err != nil {
+		return res, fmt.Errorf("delete %s events: %w", eventType, err)
+	}
+	res.Deleted, _ = r.RowsAffected()
+
+	if dryRun {
+		return res, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("commit retention %s: %w", eventType, err)
+	}
+	return res, nil
+}
+
+// rollupWhere translates f for the event_rollups table. Rollups keep no
+// confidence, track or promoted fields per event, so filters on those cannot
+// use them. Time bounds match whole minutes that overlap the range, so a
+// from_ts or to_ts inside a rolled-up minute counts that minute's events
+// from both sides of the bound.
+func rollupWhere(f EventFilter) (string, []any, bool) {
+	if f.MinConfidence != nil || len(f.TrackIDs) > 0 || len(f.Fields) > 0 {
+		return "", nil, false
+	}
+	clauses := make([]string, 0)
+	args := make([]any, 0)
+	in := func(col string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		clauses = append(clauses, col+" IN ("+placeholders(len(values))+")")
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	in("event_type", f.EventTypes)
+	in("class_id", f.ClassIDs)
+	in("camera_id", f.CameraIDs)
+	if f.FromTS != nil {
+		clauses = append(clauses, "max_timestamp >= ?")
+		args = append(args, *f.FromTS)
+	}
+	if f.ToTS != nil {
+		clauses = append(clauses, "min_timestamp <= ?")
+		args = append(args, *f.ToTS)
+	}
+	if len(clauses) == 0 {
+		return "", args, true
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args, true
+}
+
+// mergeCounts adds b into a and orders the result like groupCounts.
+func mergeCounts(a, b []CountItem) []CountItem {
+	if len(b) == 0 {
+		return a
+	}
+	byKey := make(map[string]int64, len(a)+len(b))
+	for _, c := range a {
+		byKey[c.Key] += c.Count
+	}
+	for _, c := range b {
+		byKey[c.Key] += c.Count
+	}
+	out := make([]CountItem, 0, len(byKey))
+	for k, c := range byKey {
+		out = append(out, CountItem{Key: k, Count: c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Ingestion run triggers.
+const (
+	TriggerScheduler = "scheduler"
+	TriggerAPI       = "api"
+	TriggerBackfill  = "backfill"
+)
+
+// Ingestion run statuses.
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// maxIngestRuns bounds the ingest_runs history; older rows are pruned when a
+// new run is recorded.
+const maxIngestRuns = 10000
+
+// IngestRun is one recorded ingestion pass. Stats holds the runner's RunStats
+// as JSON, including per-camera entries.
+type IngestRun struct {
+	ID             int64           `json:"id"`
+	Trigger        string          `json:"trigger"`
+	Status         string          `json:"status"`
+	StartedAt      string          `json:"started_at"`
+	FinishedAt     string          `json:"finished_at"`
+	DurationMillis int64           `json:"duration_ms"`
+	Error          string          `json:"error,omitempty"`
+	Stats          json.RawMessage `json:"stats"`
+}
+
+// CameraRunOutcome is one camera's result within a recorded run.
+type CameraRunOutcome struct {
+	ClassID  string
+	CameraID string
+	// NewestFileEpoch is the newest event-file epoch listed for the camera
+	// (0 when unknown).
+	NewestFileEpoch int64
+	Error           string
+}
+
+// CameraIngestStatus is the latest ingestion state of one camera.
+type CameraIngestStatus struct {
+	ClassID          string `json:"class_id"`
+	CameraID         string `json:"camera_id"`
+	LastRunID        int64  `json:"last_run_id"`
+	LastRunAt        string `json:"last_run_at"`
+	LastSuccessRunID int64  `json:"last_success_run_id,omitempty"`
+	LastSuccessAt    string `json:"last_success_at,omitempty"`
+	LastError        string `json:"last_error,omitempty"`
+	NewestFileEpoch  int64  `json:"newest_file_epoch,omitempty"`
+	// LagSeconds is wall-clock time minus NewestFileEpoch at read time.
+	LagSeconds *int64 `json:"lag_seconds,omitempty"`
+}
+
+// RecordIngestRun stores run and updates the per-camera status rows in one
+// transaction. A camera counts as successful when its outcome has no error.
+func (s *Store) RecordIngestRun(run IngestRun, cameras []CameraRunOutcome) (IngestRun, error) {
+	if len(run.Stats) == 0 {
+		run.Stats = json.RawMessage("{}")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return run, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.Exec(`INSERT INTO ingest_runs(trigger, status, started_at, finished_at, duration_ms, error, stats_json)
+VALUES(?, ?, ?, ?, ?, ?, ?)`, run.Trigger, run.Status, run.StartedAt, run.FinishedAt, run.DurationMillis, run.Error, string(run.Stats))
+	if err != nil {
+		return run, fmt.Errorf("insert ingest run: %w", err)
+	}
+	if run.ID, err = res.LastInsertId(); err != nil {
+		return run, fmt.Errorf("ingest run id: %w", err)
+	}
+
+	for _, c := range cameras {
+		var successID any
+		var successAt any
+		if c.Error == "" {
+			successID = run.ID
+			successAt = run.FinishedAt
+		}
+		_, err := tx.Exec(`INSERT INTO ingest_camera_status(class_id, camera_id, last_run_id, last_run_at, last_success_run_id, last_success_at, last_error, newest_file_epoch)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(class_id, camera_id) DO UPDATE SET
+  last_run_id = excluded.last_run_id,
+  last_run_at = excluded.last_run_at,
+  last_success_run_id = COALESCE(excluded.last_success_run_id, last_success_run_id),
+  last_success_at = COALESCE(excluded.last_success_at, last_success_at),
+  last_error = excluded.last_error,
+  newest_file_epoch = MAX(excluded.newest_file_epoch, newest_file_epoch)`,
+			c.ClassID, c.CameraID, run.ID, run.FinishedAt, successID, successAt, c.Error, c.NewestFileEpoch)
+		if err != nil {
+			return run, fmt.Errorf("update camera ingest status: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM ingest_runs WHERE id <= ?", run.ID-maxIngestRuns); err != nil {
+		return run, fmt.Errorf("prune ingest runs: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return run, fmt.Errorf("commit tx: %w", err)
+	}
+	return run, nil
+}
+
+// ListIngestRuns returns the newest runs first, optionally filtered by trigger
+// and status.
+func (s *Store) ListIngestRuns(trigger, status string, limit int) ([]IngestRun, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+	where := " WHERE 1=1"
+	args := make([]any, 0, 3)
+	if trigger != "" {
+		where += " AND trigger = ?"
+		args = append(args, trigger)
+	}
+	if status != "" {
+		where += " AND status = ?"
+		args = append(args, status)
+	}
+	args = append(args, limit)
+	rows, err := s.reader.Query(`SELECT id, trigger, status, started_at, finished_at, duration_ms, error, stats_json
+FROM ingest_runs`+where+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query ingest runs: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]IngestRun, 0)
+	for rows.Next() {
+		run, err := scanIngestRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate ingest runs: %w", err)
+	}
+	return out, nil
+}
+
+// LatestIngestRun returns the newest run with the given status (any status
+// when empty) and false when there is none.
+func (s *Store) LatestIngestRun(status string) (IngestRun, bool, error) {
+	runs, err := s.ListIngestRuns("", status, 1)
+	if err != nil || len(runs) == 0 {
+		return IngestRun{}, false, err
+	}
+	return runs[0], true, nil
+}
+
+// ListCameraIngestStatus returns every camera seen by a recorded run with its
+// lag computed against now.
+func (s *Store) ListCameraIngestStatus(now time.Time) ([]CameraIngestStatus, error) {
+	rows, err := s.reader.Query(`SELECT class_id, camera_id, last_run_id, last_run_at, last_success_run_id, last_success_at, last_error, newest_file_epoch
+FROM ingest_camera_status ORDER BY class_id, camera_id`)
+	if err != nil {
+		return nil, fmt.Errorf("query camera ingest status: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]CameraIngestStatus, 0)
+	for rows.Next() {
+		var (
+			c         CameraIngestStatus
+			successID sql.NullInt64
+			successAt sql.NullString
+		)
+		if err := rows.Scan(&c.ClassID, &c.CameraID, &c.LastRunID, &c.LastRunAt, &successID, &successAt, &c.LastError, &c.NewestFileEpoch); err != nil {
+			return nil, fmt.Errorf("scan camera ingest status: %w", err)
+		}
+		c.LastSuccessRunID = successID.Int64
+		c.LastSuccessAt = successAt.String
+		if c.NewestFileEpoch > 0 {
+			lag := now.Unix() - c.NewestFileEpoch
+			c.LagSeconds = &lag
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate camera ingest status: %w", err)
+	}
+	return out, nil
+}
+
+func scanIngestRun(row rowScanner) (IngestRun, error) {
+	var (
+		run   IngestRun
+		stats string
+	)
+	if err := row.Scan(&run.ID, &run.Trigger, &run.Status, &run.StartedAt, &run.FinishedAt, &run.DurationMillis, &run.Error, &stats); err != nil {
+		return run, fmt.Errorf("scan ingest run: %w", err)
+	}
+	run.Stats = json.RawMessage(stats)
+	return run, nil
+}
@@ -1,10 +1,12 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -14,13 +16,20 @@ import (
 )
 
 type Store struct {
-	db *sql.DB
+	db     *sql.DB
+	reader *sql.DB
+
+	mu     sync.RWMutex
+	fields map[string]PromotedField
 }
 
 type EventFilter struct {
-	ClassIDs      []string
-	CameraIDs     []string
-	EventTypes    []string
+	ClassIDs   []string
+	CameraIDs  []string
+	EventTypes []string
+	TrackIDs   []int64
+	// Fields are predicates on promoted fields (see fields.go).
+	Fields        []FieldPredicate
 	MinConfidence *float64
 	FromTS        *float64
 	ToTS          *float64
@@ -32,6 +41,7 @@ type EventRecord struct {
 	ID             int64           `json:"id"`
 	IngestedAt     string          `json:"ingested_at"`
 	SourceFile     string          `json:"source_file"`
+	FileID         *int64          `json:"file_id,omitempty"`
 	StreamClassID  string          `json:"stream_class_id,omitempty"`
 	StreamCameraID string          `json:"stream_camera_id,omitempty"`
 	EventType      string          `json:"event_type"`
@@ -45,6 +55,14 @@ type EventRecord struct {
 	Raw            json.RawMessage `json:"raw"`
 }
 
+// InsertResult reports how many events were stored and how many were dropped
+// because an event with the same fingerprint already exists, or was stored
+// and has since been folded into the rollups by retention.
+type InsertResult struct {
+	Inserted   int `json:"inserted"`
+	Duplicates int `json:"duplicates"`
+}
+
 type Summary struct {
 	TotalEvents        int64       `json:"total_events"`
 	DistinctClasses    int64       `json:"distinct_classes"`
@@ -69,148 +87,260 @@ type StudentDailyMetric struct {
 	SampledSeconds  int64   `json:"sampled_seconds"`
 }
 
+// Open opens the database at path and applies the pending migrations (see
+// migrate.go). A database migrated by a newer binary is refused with
+// ErrSchemaTooNew.
 func Open(path string) (*Store, error) {
-	db, err := sql.Open("sqlite", path)
+	s, err := OpenWithoutMigrating(path)
 	if err != nil {
-		return nil, fmt.Errorf("open sqlite: %w", err)
+		return nil, err
 	}
-	db.SetMaxOpenConns(1)
-
-	s := &Store{db: db}
-	if err := s.migrate(); err != nil {
-		_ = db.Close()
+	if _, err := s.Migrate(false); err != nil {
+		_ = s.Close()
 		return nil, err
 	}
 	return s, nil
 }
 
-func (s *Store) Close() error { return s.db.Close() }
-
-func (s *Store) migrate() error {
-	schema := `
-CREATE TABLE IF NOT EXISTS events (
-  id INTEGER PRIMARY KEY AUTOINCREMENT,
-  ingested_at TEXT NOT NULL,
-  source_file TEXT NOT NULL,
-  stream_class_id TEXT,
-  stream_camera_id TEXT,
-  event_type TEXT,
-  room_id TEXT,
-  camera_id TEXT,
-  person_id TEXT,
-  global_person_id INTEGER,
-  track_id INTEGER,
-  confidence REAL,
-  timestamp REAL,
-  raw_json TEXT NOT NULL
-);
-CREATE INDEX IF NOT EXISTS idx_events_event_type ON events(event_type);
-CREATE INDEX IF NOT EXISTS idx_events_stream_class ON events(stream_class_id);
-CREATE INDEX IF NOT EXISTS idx_events_stream_camera ON events(stream_camera_id);
-CREATE INDEX IF NOT EXISTS idx_events_camera ON events(camera_id);
-CREATE INDEX IF NOT EXISTS idx_events_timestamp ON events(timestamp);
-CREATE TABLE IF NOT EXISTS ingested_files (
-  path TEXT PRIMARY KEY,
-  size_bytes INTEGER NOT NULL,
-  mod_unix INTEGER NOT NULL,
-  ingested_at TEXT NOT NULL
-);
-`
-	_, err := s.db.Exec(schema)
-	if err != nil {
-		return fmt.Errorf("migrate schema: %w", err)
-	}
-	return nil
-}
-
 func (s *Store) IngestDataset(ds input.Dataset) (int, error) {
 	total := 0
 	src := "dataset"
 	if ds.Stream != nil {
 		src = ds.Stream.ConfigPath
 	}
-	n, err := s.InsertEvents(ds.Events, src)
+	res, err := s.InsertEvents(ds.Events, src)
 	if err != nil {
 		return 0, err
 	}
-	total += n
+	total += res.Inserted
 	return total, nil
 }
 
-func (s *Store) InsertEvents(events []model.Event, source string) (int, error) {
-	tx, err := s.db.Begin()
+// InsertEvents stores events that are not already present (by fingerprint).
+func (s *Store) InsertEvents(events []model.Event, source string) (InsertResult, error) {
+	return s.InsertEventsContext(context.Background(), events, source)
+}
+
+// InsertEventsContext is InsertEvents with a context; cancelling ctx rolls
+// the whole batch back.
+func (s *Store) InsertEventsContext(ctx context.Context, events []model.Event, source string) (InsertResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, fmt.Errorf("begin tx: %w", err)
+		return InsertResult{}, fmt.Errorf("begin tx: %w", err)
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	stmt, err := tx.Prepare(`
-INSERT INTO events(
-  ingested_at, source_file, stream_class_id, stream_camera_id,
-  event_type, room_id, camera_id, person_id, global_person_id,
-  track_id, confidence, timestamp, raw_json
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	res, err := insertEvents(ctx, tx, events, source, nil)
 	if err != nil {
-		return 0, fmt.Errorf("prepare insert: %w", err)
+		return res, err
 	}
-	defer stmt.Close()
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("commit tx: %w", err)
+	}
+	return res, nil
+}
 
-	now := time.Now().UTC().Format(time.RFC3339Nano)
-	count := 0
-	for _, ev := range events {
-		raw, err := json.Marshal(ev.Raw)
-		if err != nil {
-			return count, fmt.Errorf("marshal event raw: %w", err)
-		}
+// InsertEventStream is the streaming counterpart of InsertEvents: every event
+// produced by stream is stored as it arrives, in one transaction, so the
+// events are never held in memory together. An error from stream rolls the
+// whole stream back and is returned unchanged.
+func (s *Store) InsertEventStream(stream model.EventStream, source string) (InsertResult, error) {
+	return s.InsertEventStreamContext(context.Background(), stream, source)
+}
 
-		eventType := ev.EventTypeName()
-		roomID, _ := ev.String("room_id")
-		cameraID, _ := ev.String("camera_id")
-		personID, _ := ev.String("person_id")
-		streamClassID, _ := ev.String("stream_class_id")
-		streamCameraID, _ := ev.String("stream_camera_id")
-		globalID, _ := ev.Int64("global_person_id")
-		trackID, _ := ev.Int64("track_id")
-		confidence, _ := ev.Float64("confidence")
-		ts, _ := ev.Float64("timestamp")
+// InsertEventStreamContext is InsertEventStream with a context.
+func (s *Store) InsertEventStreamContext(ctx context.Context, stream model.EventStream, source string) (InsertResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return InsertResult{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
 
-		var (
-			globalPtr any
-			trackPtr  any
-			confPtr   any
-			tsPtr     any
-		)
-		if _, ok := ev.Raw["global_person_id"]; ok {
-			globalPtr = globalID
-		}
-		if _, ok := ev.Raw["track_id"]; ok {
-			trackPtr = trackID
-		}
-		if _, ok := ev.Raw["confidence"]; ok {
-			confPtr = confidence
+	ins, err := newEventInserter(ctx, tx, source, nil)
+	if err != nil {
+		return InsertResult{}, err
+	}
+	defer ins.close()
+	if err := stream(ins.insert); err != nil {
+		return ins.res, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ins.res, fmt.Errorf("commit tx: %w", err)
+	}
+	return ins.res, nil
+}
+
+// IngestFile stores the events parsed from one file and records the file as
+// ingested in a single transaction, so a crash can never leave events behind
+// without the matching ingested_files row. Events stored from an earlier
+// version of the same file are replaced.
+func (s *Store) IngestFile(path string, sizeBytes int64, modUnix int64, events []model.Event) (InsertResult, error) {
+	return s.IngestFileContext(context.Background(), path, sizeBytes, modUnix, events)
+}
+
+// IngestFileContext is IngestFile with a context; cancelling ctx rolls the
+// file back as a whole.
+func (s *Store) IngestFileContext(ctx context.Context, path string, sizeBytes int64, modUnix int64, events []model.Event) (InsertResult, error) {
+	return s.IngestFileStreamContext(ctx, path, sizeBytes, modUnix, func(yield func(model.Event) error) error {
+		for _, ev := range events {
+			if err := yield(ev); err != nil {
+				return err
+			}
 		}
-		if _, ok := ev.Raw["timestamp"]; ok {
-			tsPtr = ts
+		return nil
+	})
+}
+
+// IngestFileStreamContext is the streaming counterpart of IngestFileContext:
+// the events produced by stream are inserted as they come, in the same
+// transaction as the file's ingested_files row. An error from stream rolls
+// the file back and is returned unchanged.
+func (s *Store) IngestFileStreamContext(ctx context.Context, path string, sizeBytes int64, modUnix int64, stream model.EventStream) (InsertResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return InsertResult{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var fileID int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO ingested_files(path, size_bytes, mod_unix, ingested_at)
+VALUES(?, ?, ?, ?)
+ON CONFLICT(path) DO UPDATE SET
+  size_bytes = excluded.size_bytes,
+  mod_unix = excluded.mod_unix,
+  ingested_at = excluded.ingested_at
+RETURNING id`, path, sizeBytes, modUnix, time.Now().UTC().Format(time.RFC3339Nano)).Scan(&fileID)
+	if err != nil {
+		return InsertResult{}, fmt.Errorf("mark file ingested: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM events WHERE file_id = ?", fileID); err != nil {
+		return InsertResult{}, fmt.Errorf("delete previous events: %w", err)
+	}
+
+	ins, err := newEventInserter(ctx, tx, path, fileID)
+	if err != nil {
+		return InsertResult{}, err
+	}
+	defer ins.close()
+	if err := stream(ins.insert); err != nil {
+		return ins.res, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ins.res, fmt.Errorf("commit tx: %w", err)
+	}
+	return ins.res, nil
+}
+
+// insertEvents writes events inside tx, skipping events whose fingerprint is
+// already stored or pruned. fileID is nil for events that do not come from a tracked
+// file.
+func insertEvents(ctx context.Context, tx *sql.Tx, events []model.Event, source string, fileID any) (InsertResult, error) {
+	ins, err := newEventInserter(ctx, tx, source, fileID)
+	if err != nil {
+		return InsertResult{}, err
+	}
+	defer ins.close()
+	for _, ev := range events {
+		if err := ins.insert(ev); err != nil {
+			return ins.res, err
 		}
+	}
+	return ins.res, nil
+}
+
+// eventInserter stores events one at a time through a prepared statement.
+type eventInserter struct {
+	ctx    context.Context
+	stmt   *sql.Stmt
+	now    string
+	source string
+	fileID any
+	res    InsertResult
+}
 
-		if _, err := stmt.Exec(now, source, streamClassID, streamCameraID, eventType, roomID, cameraID, personID, globalPtr, trackPtr, confPtr, tsPtr, string(raw)); err != nil {
-			return count, fmt.Errorf("insert event: %w", err)
+func newEventInserter(ctx context.Context, tx *sql.Tx, source string, fileID any) (*eventInserter, error) {
+	stmt, err := tx.PrepareContext(ctx, `
+INSERT INTO events(
+  ingested_at, source_file, stream_class_id, stream_camera_id,
+  event_type, room_id, camera_id, person_id, global_person_id,
+  track_id, confidence, timestamp, raw_json, file_id, fingerprint
+) SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?15
+WHERE NOT EXISTS (SELECT 1 FROM pruned_fingerprints WHERE fingerprint = ?15)
+ON CONFLICT(fingerprint) DO NOTHING`)
+	if err != nil {
+		return nil, fmt.Errorf("prepare insert: %w", err)
+	}
+	return &eventInserter{ctx: ctx, stmt: stmt, now: time.Now().UTC().Format(time.RFC3339Nano), source: source, fileID: fileID}, nil
+}
+
+func (ins *eventInserter) close() { _ = ins.stmt.Close() }
+
+// insert stores ev and counts it as inserted or duplicate.
+func (ins *eventInserter) insert(ev model.Event) error {
+	// Decoded events are stored byte for byte as they arrived.
+	raw := []byte(ev.RawJSON)
+	if raw == nil {
+		var err error
+		if raw, err = json.Marshal(ev.Raw); err != nil {
+			return fmt.Errorf("marshal event raw: %w", err)
 		}
-		count++
 	}
 
-	if err := tx.Commit(); err != nil {
-		return count, fmt.Errorf("commit tx: %w", err)
+	eventType := ev.EventTypeName()
+	roomID, _ := ev.String("room_id")
+	cameraID, _ := ev.String("camera_id")
+	personID, _ := ev.String("person_id")
+	streamClassID, _ := ev.String("stream_class_id")
+	streamCameraID, _ := ev.String("stream_camera_id")
+	globalID, _ := ev.Int64("global_person_id")
+	trackID, _ := ev.Int64("track_id")
+	confidence, _ := ev.Float64("confidence")
+	ts, _ := ev.Float64("timestamp")
+
+	var (
+		globalPtr any
+		trackPtr  any
+		confPtr   any
+		tsPtr     any
+	)
+	if _, ok := ev.Raw["global_person_id"]; ok {
+		globalPtr = globalID
+	}
+	if _, ok := ev.Raw["track_id"]; ok {
+		trackPtr = trackID
+	}
+	if _, ok := ev.Raw["confidence"]; ok {
+		confPtr = confidence
 	}
-	return count, nil
+	if _, ok := ev.Raw["timestamp"]; ok {
+		tsPtr = ts
+	}
+
+	fingerprint, err := ev.Fingerprint()
+	if err != nil {
+		return err
+	}
+	out, err := ins.stmt.ExecContext(ins.ctx, ins.now, ins.source, streamClassID, streamCameraID, eventType, roomID, cameraID, personID, globalPtr, trackPtr, confPtr, tsPtr, string(raw), ins.fileID, fingerprint)
+	if err != nil {
+		return fmt.Errorf("insert event: %w", err)
+	}
+	if n, _ := out.RowsAffected(); n == 0 {
+		ins.res.Duplicates++
+		return nil
+	}
+	ins.res.Inserted++
+	return nil
 }
 
+// ShouldIngestFile reports whether path is not stored yet or changed size or
+// mtime since. It reads from the read-only pool, so the ingest workers'
+// read stage does not wait for the writer.
 func (s *Store) ShouldIngestFile(path string, sizeBytes int64, modUnix int64) (bool, error) {
 	var (
 		oldSize int64
 		oldMod  int64
 	)
-	err := s.db.QueryRow("SELECT size_bytes, mod_unix FROM ingested_files WHERE path = ?", path).Scan(&oldSize, &oldMod)
+	err := s.reader.QueryRow("SELECT size_bytes, mod_unix FROM ingested_files WHERE path = ?", path).Scan(&oldSize, &oldMod)
 	if err == sql.ErrNoRows {
 		return true, nil
 	}
@@ -237,6 +367,11 @@ ON CONFLICT(path) DO UPDATE SET
 }
 
 func (s *Store) ListEvents(f EventFilter) ([]EventRecord, int64, error) {
+	return s.ListEventsContext(context.Background(), f)
+}
+
+// ListEventsContext is ListEvents with a context that aborts the queries.
+func (s *Store) ListEventsContext(ctx context.Context, f EventFilter) ([]EventRecord, int64, error) {
 	where, args := buildWhere(f)
 	if f.Limit <= 0 || f.Limit > 1000 {
 		f.Limit = 200
@@ -247,72 +382,58 @@ func (s *Store) ListEvents(f EventFilter) ([]EventRecord, int64, error) {
 
 	countQuery := "SELECT COUNT(*) FROM events" + where
 	var total int64
-	if err := s.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+	if err := s.reader.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
 		return nil, 0, fmt.Errorf("count events: %w", err)
 	}
 
-	query := `SELECT id, ingested_at, source_file, stream_class_id, stream_camera_id, event_type, room_id, camera_id, person_id, global_person_id, track_id, confidence, timestamp, raw_json
-FROM events` + where + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	query := "SELECT " + eventColumns + " FROM events" + where + " ORDER BY id DESC LIMIT ? OFFSET ?"
 	args = append(args, f.Limit, f.Offset)
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.reader.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("query events: %w", err)
 	}
 	defer rows.Close()
 
-	out := make([]EventRecord, 0)
-	for rows.Next() {
-		var r EventRecord
-		var (
-			globalID sql.NullInt64
-			trackID  sql.NullInt64
-			conf     sql.NullFloat64
-			ts       sql.NullFloat64
-			raw      string
-		)
-		if err := rows.Scan(&r.ID, &r.IngestedAt, &r.SourceFile, &r.StreamClassID, &r.StreamCameraID, &r.EventType, &r.RoomID, &r.CameraID, &r.PersonID, &globalID, &trackID, &conf, &ts, &raw); err != nil {
-			return nil, 0, fmt.Errorf("scan event: %w", err)
-		}
-		if globalID.Valid {
-			v := globalID.Int64
-			r.GlobalPersonID = &v
-		}
-		if trackID.Valid {
-			v := trackID.Int64
-			r.TrackID = &v
-		}
-		if conf.Valid {
-			v := conf.Float64
-			r.Confidence = &v
-		}
-		if ts.Valid {
-			v := ts.Float64
-			r.Timestamp = &v
-		}
-		r.Raw = json.RawMessage(raw)
-		out = append(out, r)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("iterate events: %w", err)
+	out, err := scanEvents(rows)
+	if err != nil {
+		return nil, 0, err
 	}
 	return out, total, nil
 }
 
 func (s *Store) GetEventByID(id int64) (EventRecord, error) {
+	return s.GetEventByIDContext(context.Background(), id)
+}
+
+// GetEventByIDContext is GetEventByID with a context.
+func (s *Store) GetEventByIDContext(ctx context.Context, id int64) (EventRecord, error) {
+	return scanEvent(s.reader.QueryRowContext(ctx, "SELECT "+eventColumns+" FROM events WHERE id = ?", id))
+}
+
+// eventColumns are the columns scanEvent reads, in order.
+const eventColumns = "id, ingested_at, source_file, file_id, stream_class_id, stream_camera_id, event_type, room_id, camera_id, person_id, global_person_id, track_id, confidence, timestamp, raw_json"
+
+// scanEvent reads one row selected with eventColumns. Errors are returned
+// unwrapped so callers can test for sql.ErrNoRows.
+func scanEvent(row rowScanner) (EventRecord, error) {
 	var r EventRecord
 	var (
+		fileID   sql.NullInt64
 		globalID sql.NullInt64
 		trackID  sql.NullInt64
 		conf     sql.NullFloat64
 		ts       sql.NullFloat64
 		raw      string
 	)
-	err := s.db.QueryRow(`SELECT id, ingested_at, source_file, stream_class_id, stream_camera_id, event_type, room_id, camera_id, person_id, global_person_id, track_id, confidence, timestamp, raw_json
-FROM events WHERE id = ?`, id).Scan(&r.ID, &r.IngestedAt, &r.SourceFile, &r.StreamClassID, &r.StreamCameraID, &r.EventType, &r.RoomID, &r.CameraID, &r.PersonID, &globalID, &trackID, &conf, &ts, &raw)
+	err := row.Scan(&r.ID, &r.IngestedAt, &r.SourceFile, &fileID, &r.StreamClassID, &r.StreamCameraID, &r.EventType, &r.RoomID, &r.CameraID, &r.PersonID, &globalID, &trackID, &conf, &ts, &raw)
 	if err != nil {
 		return r, err
 	}
+	if fileID.Valid {
+		v := fileID.Int64
+		r.FileID = &v
+	}
 	if globalID.Valid {
 		v := globalID.Int64
 		r.GlobalPersonID = &v
@@ -333,57 +454,164 @@ FROM events WHERE id = ?`, id).Scan(&r.ID, &r.IngestedAt, &r.SourceFile, &r.Stre
 	return r, nil
 }
 
+func scanEvents(rows *sql.Rows) ([]EventRecord, error) {
+	out := make([]EventRecord, 0)
+	for rows.Next() {
+		r, err := scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan event: %w", err)
+		}
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate events: %w", err)
+	}
+	return out, nil
+}
+
+// Class and camera keys: the stream config IDs, else the IDs in the event.
+const (
+	classKeyExpr  = "COALESCE(NULLIF(stream_class_id, ''), room_id)"
+	cameraKeyExpr = "COALESCE(NULLIF(stream_camera_id, ''), camera_id)"
+)
+
+// Student presence is counted from person_tracked and person_detected events
+// of students, by global person ID, else track ID, else person ID.
+const (
+	studentEventsWhere = "event_type IN ('person_tracked','person_detected') AND field_person_role = 'student'"
+	studentPIDExpr     = "COALESCE(CAST(global_person_id AS TEXT), CAST(track_id AS TEXT), person_id)"
+)
+
+// Summary aggregates the events matching f. Events removed by retention are
+// included from their rollups unless f filters on confidence or track IDs.
 func (s *Store) Summary(f EventFilter) (Summary, error) {
+	return s.SummaryContext(context.Background(), f)
+}
+
+// SummaryContext is Summary with a context that aborts the queries.
+func (s *Store) SummaryContext(ctx context.Context, f EventFilter) (Summary, error) {
 	where, args := buildWhere(f)
-	var out Summary
+	var (
+		out       Summary
+		confSum   float64
+		confCount int64
+		timed     int64
+	)
 
-	query := `SELECT COUNT(*), COUNT(DISTINCT stream_class_id), COUNT(DISTINCT COALESCE(stream_camera_id, camera_id)),
-COALESCE(AVG(confidence),0), COALESCE(MIN(timestamp),0), COALESCE(MAX(timestamp),0)
+	query := `SELECT COUNT(*), COALESCE(SUM(confidence),0), COUNT(confidence), COUNT(timestamp), COALESCE(MIN(timestamp),0), COALESCE(MAX(timestamp),0)
 FROM events` + where
-	if err := s.db.QueryRow(query, args...).Scan(&out.TotalEvents, &out.DistinctClasses, &out.DistinctCameras, &out.AvgConfidence, &out.MinTimestamp, &out.MaxTimestamp); err != nil {
+	if err := s.reader.QueryRowContext(ctx, query, args...).Scan(&out.TotalEvents, &confSum, &confCount, &timed, &out.MinTimestamp, &out.MaxTimestamp); err != nil {
 		return out, fmt.Errorf("summary totals: %w", err)
 	}
 
 	var err error
-	if out.EventTypeCounts, err = s.groupCounts("event_type", where, args); err != nil {
+	if out.EventTypeCounts, err = s.groupCounts(ctx, "events", "event_type", "COUNT(*)", where, args); err != nil {
 		return out, err
 	}
-	if out.StreamClassCounts, err = s.groupCounts("stream_class_id", where, args); err != nil {
+	if out.StreamClassCounts, err = s.groupCounts(ctx, "events", classKeyExpr, "COUNT(*)", where, args); err != nil {
 		return out, err
 	}
-	if out.StreamCameraCounts, err = s.groupCounts("COALESCE(stream_camera_id, camera_id)", where, args); err != nil {
+	if out.StreamCameraCounts, err = s.groupCounts(ctx, "events", cameraKeyExpr, "COUNT(*)", where, args); err != nil {
 		return out, err
 	}
 
+	if rwhere, rargs, ok := rollupWhere(f); ok {
+		var (
+			events, rollups int64
+			rsum            float64
+			rcount          int64
+			rmin, rmax      float64
+		)
+		query := `SELECT COUNT(*), COALESCE(SUM(event_count),0), COALESCE(SUM(confidence_sum),0), COALESCE(SUM(confidence_count),0), COALESCE(MIN(min_timestamp),0), COALESCE(MAX(max_timestamp),0)
+FROM event_rollups` + rwhere
+		if err := s.reader.QueryRowContext(ctx, query, rargs...).Scan(&rollups, &events, &rsum, &rcount, &rmin, &rmax); err != nil {
+			return out, fmt.Errorf("summary rollup totals: %w", err)
+		}
+		if rollups > 0 {
+			out.TotalEvents += events
+			confSum += rsum
+			confCount += rcount
+			if timed == 0 || rmin < out.MinTimestamp {
+				out.MinTimestamp = rmin
+			}
+			if timed == 0 || rmax > out.MaxTimestamp {
+				out.MaxTimestamp = rmax
+			}
+			for _, g := range []struct {
+				key  string
+				dest *[]CountItem
+			}{
+				{"event_type", &out.EventTypeCounts},
+				{"class_id", &out.StreamClassCounts},
+				{"camera_id", &out.StreamCameraCounts},
+			} {
+				counts, err := s.groupCounts(ctx, "event_rollups", g.key, "SUM(event_count)", rwhere, rargs)
+				if err != nil {
+					return out, err
+				}
+				*g.dest = mergeCounts(*g.dest, counts)
+			}
+		}
+	}
+
+	if confCount > 0 {
+		out.AvgConfidence = confSum / float64(confCount)
+	}
+	out.DistinctClasses = int64(len(out.StreamClassCounts))
+	out.DistinctCameras = int64(len(out.StreamCameraCounts))
 	return out, nil
 }
 
+// DailyStudentMetrics reports per class how many students were present per
+// second between dayStart and dayEnd: the most cameras agree on at once, and
+// the average over sampled seconds. Seconds removed by retention are read
+// from their rollups.
 func (s *Store) DailyStudentMetrics(dayStart, dayEnd float64, classIDs []string) ([]StudentDailyMetric, error) {
-	clauses := []string{"timestamp >= ?", "timestamp < ?", "event_type IN ('person_tracked','person_detected')", "COALESCE(json_extract(raw_json,'$.person_role'), json_extract(raw_json,'$.role'), '') = 'student'"}
+	return s.DailyStudentMetricsContext(context.Background(), dayStart, dayEnd, classIDs)
+}
+
+// DailyStudentMetricsContext is DailyStudentMetrics with a context that aborts
+// the query.
+func (s *Store) DailyStudentMetricsContext(ctx context.Context, dayStart, dayEnd float64, classIDs []string) ([]StudentDailyMetric, error) {
+	clauses := []string{"timestamp >= ?", "timestamp < ?", studentEventsWhere}
 	args := []any{dayStart, dayEnd}
+	rollupClauses := []string{"sec >= ?", "sec < ?"}
+	rollupArgs := []any{dayStart, dayEnd}
 	if len(classIDs) > 0 {
-		clauses = append(clauses, "COALESCE(stream_class_id, room_id) IN ("+placeholders(len(classIDs))+")")
+		clauses = append(clauses, classKeyExpr+" IN ("+placeholders(len(classIDs))+")")
+		rollupClauses = append(rollupClauses, "class_id IN ("+placeholders(len(classIDs))+")")
 		for _, id := range classIDs {
 			args = append(args, id)
+			rollupArgs = append(rollupArgs, id)
 		}
 	}
 	where := " WHERE " + strings.Join(clauses, " AND ")
+	args = append(args, rollupArgs...)
 
 	query := `
 WITH filtered AS (
   SELECT
-    COALESCE(stream_class_id, room_id) AS class_id,
-    COALESCE(stream_camera_id, camera_id) AS camera_id,
+    ` + classKeyExpr + ` AS class_id,
+    ` + cameraKeyExpr + ` AS camera_id,
     CAST(timestamp AS INTEGER) AS sec,
-    COALESCE(CAST(global_person_id AS TEXT), CAST(track_id AS TEXT), person_id) AS pid
+    ` + studentPIDExpr + ` AS pid
   FROM events` + where + `
 ),
-cam_counts AS (
+raw_counts AS (
   SELECT class_id, camera_id, sec, COUNT(DISTINCT pid) AS cnt
   FROM filtered
   WHERE class_id IS NOT NULL AND class_id != '' AND camera_id IS NOT NULL AND camera_id != '' AND pid IS NOT NULL AND pid != ''
   GROUP BY class_id, camera_id, sec
 ),
+cam_counts AS (
+  SELECT class_id, camera_id, sec, MAX(cnt) AS cnt
+  FROM (
+    SELECT class_id, camera_id, sec, cnt FROM raw_counts
+    UNION ALL
+    SELECT class_id, camera_id, sec, students FROM student_second_rollups WHERE ` + strings.Join(rollupClauses, " AND ") + `
+  )
+  GROUP BY class_id, camera_id, sec
+),
 class_seconds AS (
   SELECT class_id, sec, MAX(cnt) AS class_cnt
   FROM cam_counts
@@ -394,7 +622,7 @@ FROM class_seconds
 GROUP BY class_id
 ORDER BY class_id ASC`
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.reader.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("daily student metrics query: %w", err)
 	}
@@ -413,9 +641,9 @@ ORDER BY class_id ASC`
 	return out, nil
 }
 
-func (s *Store) groupCounts(keyExpr string, where string, args []any) ([]CountItem, error) {
-	query := "SELECT " + keyExpr + " AS k, COUNT(*) FROM events" + where + " GROUP BY k ORDER BY COUNT(*) DESC, k ASC"
-	rows, err := s.db.Query(query, args...)
+func (s *Store) groupCounts(ctx context.Context, table, keyExpr, countExpr, where string, args []any) ([]CountItem, error) {
+	query := "SELECT " + keyExpr + " AS k, " + countExpr + " AS n FROM " + table + where + " GROUP BY k ORDER BY n DESC, k ASC"
+	rows, err := s.reader.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("group counts for %s: %w", keyExpr, err)
 	}
@@ -450,17 +678,44 @@ func buildWhere(f EventFilter) (string, []any) {
 		}
 	}
 	if len(f.ClassIDs) > 0 {
-		clauses = append(clauses, "COALESCE(stream_class_id, room_id) IN ("+placeholders(len(f.ClassIDs))+")")
+		clauses = append(clauses, classKeyExpr+" IN ("+placeholders(len(f.ClassIDs))+")")
 		for _, v := range f.ClassIDs {
 			args = append(args, v)
 		}
 	}
 	if len(f.CameraIDs) > 0 {
-		clauses = append(clauses, "COALESCE(stream_camera_id, camera_id) IN ("+placeholders(len(f.CameraIDs))+")")
+		clauses = append(clauses, cameraKeyExpr+" IN ("+placeholders(len(f.CameraIDs))+")")
 		for _, v := range f.CameraIDs {
 			args = append(args, v)
 		}
 	}
+	if len(f.TrackIDs) > 0 {
+		clauses = append(clauses, "track_id IN ("+placeholders(len(f.TrackIDs))+")")
+		for _, v := range f.TrackIDs {
+			args = append(args, v)
+		}
+	}
+	for _, p := range f.Fields {
+		// Left bare: SQLite reads an unknown "quoted" name as a string, and
+		// an unpromoted field should fail with no such column instead. Names
+		// that could not be promoted are never written into the query.
+		col := fieldColumn(p.Field)
+		if !fieldNamePattern.MatchString(p.Field) {
+			col = fieldColumn("")
+		}
+		if len(p.In) > 0 {
+			clauses = append(clauses, col+" IN ("+placeholders(len(p.In))+")")
+			args = append(args, p.In...)
+		}
+		if p.Min != nil {
+			clauses = append(clauses, col+" >= ?")
+			args = append(args, p.Min)
+		}
+		if p.Max != nil {
+			clauses = append(clauses, col+" <= ?")
+			args = append(args, p.Max)
+		}
+	}
 	if f.MinConfidence != nil {
 		clauses = append(clauses, "confidence >= ?")
 		args = append(args, *f.MinConfidence)
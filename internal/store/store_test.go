@@ -1,8 +1,15 @@
 package store
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,8 +36,8 @@ func TestStoreInsertListSummary(t *testing.T) {
 	if err != nil {
 		t.Fatalf("insert: %v", err)
 	}
-	if n != 2 {
-		t.Fatalf("expected 2 inserts, got %d", n)
+	if n.Inserted != 2 {
+		t.Fatalf("expected 2 inserts, got %+v", n)
 	}
 
 	rows, total, err := s.ListEvents(EventFilter{ClassIDs: []string{"class-a"}, Limit: 10})
@@ -132,4 +139,924 @@ func TestStoreInsertInferenceTypeField(t *testing.T) {
 	}
 }
 
+func TestIngestFileReplacesPreviousEvents(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	first, err := model.ParseEvents([]byte(`[{"event_type":"person_tracked","timestamp":1},{"event_type":"person_tracked","timestamp":2}]`))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.IngestFile("/data/1.json", 10, 100, first); err != nil {
+		t.Fatalf("ingest file: %v", err)
+	}
+	second, err := model.ParseEvents([]byte(`[{"event_type":"person_tracked","timestamp":1},{"event_type":"person_tracked","timestamp":2},{"event_type":"person_tracked","timestamp":3}]`))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	n, err := s.IngestFile("/data/1.json", 20, 200, second)
+	if err != nil {
+		t.Fatalf("re-ingest file: %v", err)
+	}
+	if n.Inserted != 3 {
+		t.Fatalf("expected 3 inserts, got %+v", n)
+	}
+
+	rows, total, err := s.ListEvents(EventFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if total != 3 {
+		t.Fatalf("expected previous events to be replaced, total=%d", total)
+	}
+	if rows[0].FileID == nil {
+		t.Fatalf("expected event to link to its ingested file")
+	}
+	if should, err := s.ShouldIngestFile("/data/1.json", 20, 200); err != nil || should {
+		t.Fatalf("expected file to be marked ingested, should=%v err=%v", should, err)
+	}
+}
+
+func TestOpenLinksLegacyEventsToFiles(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	_, err = db.Exec(`
+CREATE TABLE events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT, ingested_at TEXT NOT NULL, source_file TEXT NOT NULL,
+  stream_class_id TEXT, stream_camera_id TEXT, event_type TEXT, room_id TEXT, camera_id TEXT,
+  person_id TEXT, global_person_id INTEGER, track_id INTEGER, confidence REAL, timestamp REAL,
+  raw_json TEXT NOT NULL
+);
+CREATE TABLE ingested_files (path TEXT PRIMARY KEY, size_bytes INTEGER NOT NULL, mod_unix INTEGER NOT NULL, ingested_at TEXT NOT NULL);
+INSERT INTO ingested_files VALUES ('/data/1.json', 10, 100, 'x');
+INSERT INTO events(ingested_at, source_file, event_type, raw_json) VALUES ('x', '/data/1.json', 'person_tracked', '{}');`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatalf("create legacy schema: %v", err)
+	}
+
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	events, err := model.ParseEvents([]byte(`[{"event_type":"person_tracked","timestamp":1}]`))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.IngestFile("/data/1.json", 20, 200, events); err != nil {
+		t.Fatalf("re-ingest file: %v", err)
+	}
+	_, total, err := s.ListEvents(EventFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if total != 1 {
+		t.Fatalf("expected legacy event to be replaced, total=%d", total)
+	}
+}
+
+func TestInsertEventsSkipsDuplicatesAndDedupeRemovesLegacyCopies(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	events, err := model.ParseEvents([]byte(`[{"event_type":"person_tracked","camera_id":"front","track_id":1,"timestamp":5,"emitted_at":6}]`))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.InsertEvents(events, "api"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	events[0].Raw["stream_class_id"] = "class-a"
+	res, err := s.IngestFile("/data/5.json", 1, 1, events)
+	if err != nil {
+		t.Fatalf("ingest file: %v", err)
+	}
+	if res.Inserted != 0 || res.Duplicates != 1 {
+		t.Fatalf("expected duplicate to be skipped, got %+v", res)
+	}
+
+	// Simulate rows stored before fingerprints existed.
+	for i := 0; i < 2; i++ {
+		if _, err := s.db.Exec(`INSERT INTO events(ingested_at, source_file, event_type, raw_json) VALUES ('x', 'old', 'proximity_event', '{"event_type":"proximity_event","timestamp":9}')`); err != nil {
+			t.Fatalf("insert legacy: %v", err)
+		}
+	}
+	if _, err := s.db.Exec(`INSERT INTO events(ingested_at, source_file, event_type, raw_json) VALUES ('x', 'old', 'person_tracked', '{"event_type":"person_tracked","camera_id":"front","track_id":1,"timestamp":5,"emitted_at":6}')`); err != nil {
+		t.Fatalf("insert legacy: %v", err)
+	}
+
+	dry, err := s.Dedupe(true)
+	if err != nil {
+		t.Fatalf("dedupe dry run: %v", err)
+	}
+	if dry.Scanned != 3 || dry.Removed != 2 {
+		t.Fatalf("unexpected dry run result: %+v", dry)
+	}
+	got, err := s.Dedupe(false)
+	if err != nil {
+		t.Fatalf("dedupe: %v", err)
+	}
+	if got != dry {
+		t.Fatalf("expected dry run to match real run, dry=%+v got=%+v", dry, got)
+	}
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&total); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if total != 2 {
+		t.Fatalf("expected 2 events after dedupe, got %d", total)
+	}
+}
+
+func TestInsertEventsKeepsRawJSONAndExactIDs(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	original := `{ "timestamp": 12.50, "event_type":"person_tracked","track_id": 9007199254740993, "global_person_id":"42" }`
+	events, err := model.ParseEvents([]byte("[" + original + "]"))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	events[0].Raw["stream_class_id"] = "class-a"
+	if _, err := s.InsertEvents(events, "test"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	rows, _, err := s.ListEvents(EventFilter{})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d err=%v", len(rows), err)
+	}
+	if string(rows[0].Raw) != original {
+		t.Fatalf("expected raw_json to match the input, got %s", rows[0].Raw)
+	}
+	if rows[0].TrackID == nil || *rows[0].TrackID != 9007199254740993 || rows[0].GlobalPersonID == nil || *rows[0].GlobalPersonID != 42 {
+		t.Fatalf("unexpected ids: track=%v global=%v", rows[0].TrackID, rows[0].GlobalPersonID)
+	}
+	if rows[0].StreamClassID != "class-a" {
+		t.Fatalf("expected stream_class_id column, got %q", rows[0].StreamClassID)
+	}
+}
+
+func TestInsertEventsRejectsEventsWithoutFingerprint(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	events, err := model.ParseEvents([]byte(`{"event_type":"person_tracked","track_id":1,"timestamp":5}` + "\n" + `{"event_type":"person_tracked","track_id":2,"timestamp":6}`))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	// Both events fail to hash; they must not collide as duplicates.
+	for i := range events {
+		events[i].Raw["confidence"] = math.NaN()
+	}
+	res, err := s.InsertEvents(events, "test")
+	if err == nil || res.Inserted != 0 || res.Duplicates != 0 {
+		t.Fatalf("expected the insert to fail, got %+v err=%v", res, err)
+	}
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&total); err != nil || total != 0 {
+		t.Fatalf("expected no stored events, got %d err=%v", total, err)
+	}
+}
+
+func TestInsertEventStreamCommitsOrRollsBack(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	ndjson := "{\"event_type\":\"person_tracked\",\"track_id\":1,\"timestamp\":5}\n{\"event_type\":\"person_tracked\",\"track_id\":2,\"timestamp\":6}\n{\"event_type\":\"person_tracked\",\"track_id\":1,\"timestamp\":5}\n"
+	res, err := s.InsertEventStream(func(yield func(model.Event) error) error {
+		return model.DecodeEvents(strings.NewReader(ndjson), yield)
+	}, "stream")
+	if err != nil {
+		t.Fatalf("insert stream: %v", err)
+	}
+	if res.Inserted != 2 || res.Duplicates != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	// A decode error after valid events leaves nothing behind.
+	_, err = s.InsertEventStream(func(yield func(model.Event) error) error {
+		return model.DecodeEvents(strings.NewReader(`[{"event_type":"frame_tick","timestamp":7},{"event_type":`), yield)
+	}, "broken")
+	if err == nil {
+		t.Fatalf("expected decode error")
+	}
+	_, total, err := s.ListEvents(EventFilter{})
+	if err != nil || total != 2 {
+		t.Fatalf("expected 2 stored events after rollback, got %d err=%v", total, err)
+	}
+}
+
 func strconvF(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+func TestReconcilePersonsAndTimeline(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	var lines []string
+	// seen adds one event per second in [from, from+n) for the identity.
+	seen := func(eventType, camera, ids string, from, n int) {
+		for ts := from; ts < from+n; ts++ {
+			lines = append(lines, `{"event_type":"`+eventType+`","room_id":"class-a","camera_id":"`+camera+`","pipeline":"p","confidence":0.9,"timestamp":`+strconv.Itoa(ts)+`,`+ids+`}`)
+		}
+	}
+	seen("person_tracked", "front", `"global_person_id":1,"track_id":1`, 0, 20)
+	seen("person_tracked", "back", `"global_person_id":7,"track_id":1`, 0, 20)
+	// Three identities at the same time are ambiguous and stay apart.
+	seen("person_tracked", "front", `"global_person_id":3`, 200, 20)
+	seen("person_tracked", "back", `"global_person_id":9`, 200, 20)
+	seen("person_tracked", "back", `"global_person_id":10`, 200, 20)
+	// Never seen together, but resolved to the same name.
+	seen("identity_resolved", "front", `"global_person_id":4,"person_name":"Alice","person_role":"student"`, 300, 1)
+	seen("person_tracked", "front", `"global_person_id":4`, 301, 5)
+	seen("person_tracked", "back", `"person_id":"unknown:11","person_name":"Alice"`, 400, 5)
+	// Co-occurring, but a teacher and a student.
+	seen("person_tracked", "front", `"track_id":12,"person_role":"teacher"`, 500, 20)
+	seen("person_tracked", "back", `"track_id":13,"person_role":"student"`, 500, 20)
+
+	events, err := model.ParseEvents([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.InsertEvents(events, "test.json"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	res, err := s.ReconcilePersons(nil, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if res.Persons != 7 || res.Identities != 9 || res.MultiCamera != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	// Reconciling again replaces rather than duplicates.
+	if res, err = s.ReconcilePersons([]string{"class-a"}, ReconcileOptions{}); err != nil || res.Persons != 7 {
+		t.Fatalf("second reconcile: %+v %v", res, err)
+	}
+
+	persons, total, err := s.ListPersons(PersonFilter{ClassIDs: []string{"class-a"}, CameraIDs: []string{"back"}})
+	if err != nil || total != 5 || len(persons) != 5 {
+		t.Fatalf("expected 5 persons seen on back, got %d (%v)", total, err)
+	}
+	linked := persons[0]
+	if linked.ID != "class-a:back:g7" || len(linked.Identities) != 2 || linked.Identities[1].Reason != LinkCoOccurrence || linked.Identities[1].Score != 1 {
+		t.Fatalf("unexpected co-occurrence person: %+v", linked)
+	}
+
+	alice, err := s.GetPerson("class-a:Alice")
+	if err != nil {
+		t.Fatalf("get person: %v", err)
+	}
+	if alice.Role != "student" || len(alice.Cameras) != 2 || alice.EventCount != 11 || alice.Identities[1].Reason != LinkIdentityResolved {
+		t.Fatalf("unexpected named person: %+v", alice)
+	}
+	timeline, total, err := s.PersonTimeline(alice, EventFilter{Limit: 3, Offset: 5})
+	if err != nil || total != 11 || len(timeline) != 3 {
+		t.Fatalf("timeline: total %d len %d (%v)", total, len(timeline), err)
+	}
+	if *timeline[0].Timestamp != 305 || timeline[1].CameraID != "back" || *timeline[1].Timestamp != 400 {
+		t.Fatalf("timeline not merged by time: %+v", timeline)
+	}
+
+	if _, err := s.GetPerson("class-a:nobody"); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestMigrateVersionsAndRefusesNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	if v, err := s.SchemaVersion(); err != nil || v != LatestSchemaVersion() {
+		t.Fatalf("expected version %d, got %d (%v)", LatestSchemaVersion(), v, err)
+	}
+	// A database from before versioning has the tables but no versions.
+	if _, err := s.db.Exec("DROP TABLE schema_migrations; DROP TABLE person_identities; DROP TABLE persons"); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	_ = s.Close()
+
+	s, err = OpenWithoutMigrating(dbPath)
+	if err != nil {
+		t.Fatalf("open without migrating: %v", err)
+	}
+	pending, err := s.Migrate(true)
+	if err != nil || len(pending) != LatestSchemaVersion() {
+		t.Fatalf("dry run: %+v (%v)", pending, err)
+	}
+	if persons := pending[4]; persons.Name != "persons" || len(persons.Statements) != 1 || !strings.Contains(persons.Statements[0], "CREATE TABLE IF NOT EXISTS persons") {
+		t.Fatalf("unexpected pending statements: %+v", persons)
+	}
+	if !strings.HasPrefix(pending[2].Statements[0], "CREATE UNIQUE INDEX") {
+		t.Fatalf("existing fingerprint column should not be added again: %+v", pending[2])
+	}
+	if v, _ := s.SchemaVersion(); v != 0 {
+		t.Fatalf("dry run changed the version to %d", v)
+	}
+	if _, _, err := s.ListPersons(PersonFilter{}); err == nil {
+		t.Fatalf("dry run created the persons table")
+	}
+	applied, err := s.Migrate(false)
+	if err != nil || len(applied) != len(pending) {
+		t.Fatalf("migrate: %+v (%v)", applied, err)
+	}
+	if again, err := s.Migrate(false); err != nil || len(again) != 0 {
+		t.Fatalf("expected nothing pending, got %+v (%v)", again, err)
+	}
+	if _, _, err := s.ListPersons(PersonFilter{}); err != nil {
+		t.Fatalf("list persons after migrating: %v", err)
+	}
+
+	if _, err := s.db.Exec("INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, 'future', 'x')", LatestSchemaVersion()+1); err != nil {
+		t.Fatalf("insert future version: %v", err)
+	}
+	_ = s.Close()
+	if _, err := Open(dbPath); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew, got %v", err)
+	}
+	if _, err := OpenWithoutMigrating(dbPath); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew without migrating, got %v", err)
+	}
+}
+
+func TestRetentionRollsUpBeforePruning(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	const day = 86400 * 10
+	var lines []string
+	add := func(eventType, camera string, ts int, extra string) {
+		lines = append(lines, `{"event_type":"`+eventType+`","room_id":"class-a","camera_id":"`+camera+`","pipeline":"p","confidence":0.5,"timestamp":`+strconv.Itoa(ts)+extra+`}`)
+	}
+	for ts := day; ts < day+10; ts++ {
+		add("person_tracked", "front", ts, `,"person_role":"student","track_id":1`)
+		add("person_tracked", "front", ts, `,"person_role":"student","track_id":2`)
+	}
+	add("person_detected", "back", day+5, `,"person_role":"student","track_id":1`)
+	add("person_detected", "back", day+5, `,"person_role":"student","track_id":2`)
+	add("person_detected", "back", day+5, `,"person_role":"student","track_id":3`)
+	add("proximity_event", "back", day+70, `,"person_role":"teacher"`)
+	events, err := model.ParseEvents([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.InsertEvents(events, "test.json"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	type snapshot struct {
+		summary Summary
+		metrics []StudentDailyMetric
+	}
+	take := func() snapshot {
+		t.Helper()
+		summary, err := s.Summary(EventFilter{ClassIDs: []string{"class-a"}})
+		if err != nil {
+			t.Fatalf("summary: %v", err)
+		}
+		metrics, err := s.DailyStudentMetrics(day, day+86400, nil)
+		if err != nil {
+			t.Fatalf("daily metrics: %v", err)
+		}
+		return snapshot{summary, metrics}
+	}
+	same := func(label string, want snapshot) {
+		t.Helper()
+		if got := take(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: expected %+v, got %+v", label, want, got)
+		}
+	}
+	before := take()
+	if before.summary.TotalEvents != 24 || len(before.metrics) != 1 || before.metrics[0].MaxStudents != 3 || before.metrics[0].SampledSeconds != 10 {
+		t.Fatalf("unexpected baseline: %+v", before)
+	}
+
+	now := time.Unix(day+2*86400, 0)
+	policy, err := RetentionPolicy{}.WithTTLs("person_tracked=1h")
+	if err != nil {
+		t.Fatalf("parse ttls: %v", err)
+	}
+	dry, err := s.ApplyRetention(policy, now, true)
+	if err != nil || dry.Deleted != 20 || len(dry.EventTypes) != 1 {
+		t.Fatalf("dry run: %+v (%v)", dry, err)
+	}
+	if _, total, _ := s.ListEvents(EventFilter{}); total != 24 {
+		t.Fatalf("dry run deleted events: %d left", total)
+	}
+
+	res, err := s.ApplyRetention(policy, now, false)
+	if err != nil || res.Deleted != 20 || res.EventTypes[0].StudentSeconds != 11 || res.EventTypes[0].Cutoff != float64(day+2*86400-3600) {
+		t.Fatalf("retention: %+v (%v)", res, err)
+	}
+	same("after pruning person_tracked", before)
+
+	// Re-ingesting pruned events does not count them again.
+	again, err := s.InsertEvents(events, "test.json")
+	if err != nil || again.Inserted != 0 || again.Duplicates != 24 {
+		t.Fatalf("re-ingest after pruning: %+v (%v)", again, err)
+	}
+	same("after re-ingesting", before)
+
+	// A late event for a pruned minute counts raw, then from its rollup.
+	late, _ := model.ParseEvents([]byte(`{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","pipeline":"p","confidence":0.5,"timestamp":` + strconv.Itoa(day+1) + `,"person_role":"student","track_id":3}`))
+	if _, err := s.InsertEvents(late, "late.json"); err != nil {
+		t.Fatalf("insert late event: %v", err)
+	}
+	before.summary = take().summary
+	if before.summary.TotalEvents != 25 {
+		t.Fatalf("late event not counted: %+v", before.summary)
+	}
+
+	policy, _ = policy.WithTTLs("default=1h")
+	if res, err = s.ApplyRetention(policy, now, false); err != nil || res.Deleted != 5 {
+		t.Fatalf("retention with default: %+v (%v)", res, err)
+	}
+	if _, total, _ := s.ListEvents(EventFilter{}); total != 0 {
+		t.Fatalf("expected every event pruned, %d left", total)
+	}
+	same("after pruning everything", before)
+
+	from := float64(day + 60)
+	filtered, err := s.Summary(EventFilter{EventTypes: []string{"proximity_event"}, FromTS: &from})
+	if err != nil || filtered.TotalEvents != 1 || filtered.DistinctCameras != 1 || filtered.AvgConfidence != 0.5 {
+		t.Fatalf("filtered rollup summary: %+v (%v)", filtered, err)
+	}
+}
+
+func TestPrunedFingerprintsExpireAndCatchLegacyCopies(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	raw := `{"event_type":"person_tracked","camera_id":"front","track_id":1,"timestamp":5}`
+	events, err := model.ParseEvents([]byte(raw))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.InsertEvents(events, "api"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	policy, err := RetentionPolicy{FingerprintTTL: 24 * time.Hour}.WithTTLs("default=1h")
+	if err != nil {
+		t.Fatalf("parse ttls: %v", err)
+	}
+	pruned := time.Unix(86400, 0)
+	if res, err := s.ApplyRetention(policy, pruned, false); err != nil || res.Deleted != 1 || res.ExpiredFingerprints != 0 {
+		t.Fatalf("retention: %+v (%v)", res, err)
+	}
+
+	// A copy stored before fingerprints existed is a duplicate of the pruned
+	// event.
+	if _, err := s.db.Exec(`INSERT INTO events(ingested_at, source_file, event_type, raw_json) VALUES ('x', 'old', 'person_tracked', ?)`, raw); err != nil {
+		t.Fatalf("insert legacy: %v", err)
+	}
+	if got, err := s.Dedupe(false); err != nil || got.Removed != 1 || got.Fingerprinted != 0 {
+		t.Fatalf("dedupe: %+v (%v)", got, err)
+	}
+
+	// Past FingerprintTTL the fingerprint is dropped, so the event counts
+	// again.
+	res, err := s.ApplyRetention(policy, pruned.Add(23*time.Hour), true)
+	if err != nil || res.ExpiredFingerprints != 0 {
+		t.Fatalf("retention before the horizon: %+v (%v)", res, err)
+	}
+	res, err = s.ApplyRetention(policy, pruned.Add(25*time.Hour), false)
+	if err != nil || res.ExpiredFingerprints != 1 {
+		t.Fatalf("retention past the horizon: %+v (%v)", res, err)
+	}
+	if again, err := s.InsertEvents(events, "api"); err != nil || again.Inserted != 1 {
+		t.Fatalf("re-ingest past the horizon: %+v (%v)", again, err)
+	}
+}
+
+func TestParseRetentionPolicy(t *testing.T) {
+	p, err := ParseRetentionPolicy([]byte(`{"default_ttl":"30d","event_types":{"frame_tick":"36h","lesson_comprehensive_summary":"0"}}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if p.TTL("other") != 30*24*time.Hour || p.TTL("frame_tick") != 36*time.Hour || p.TTL("lesson_comprehensive_summary") != 0 {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+	if p, err = p.WithTTLs("frame_tick=2h, default=0"); err != nil || p.TTL("frame_tick") != 2*time.Hour || p.DefaultTTL != 0 {
+		t.Fatalf("flags over file: %+v (%v)", p, err)
+	}
+	if p.FingerprintTTL != DefaultFingerprintTTL {
+		t.Fatalf("expected the default fingerprint ttl, got %v", p.FingerprintTTL)
+	}
+	if p, err = p.WithTTLs("fingerprints=0"); err != nil || p.FingerprintTTL != 0 {
+		t.Fatalf("fingerprints flag: %+v (%v)", p, err)
+	}
+	if _, err := ParseRetentionPolicy([]byte(`{"default":"1d"}`)); err == nil {
+		t.Fatalf("expected unknown key to be rejected")
+	}
+	if _, err := (RetentionPolicy{}).WithTTLs("frame_tick=soon"); err == nil {
+		t.Fatalf("expected invalid ttl to be rejected")
+	}
+}
+
+func TestOpenUsesWALAndReadOnlyPool(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	var mode string
+	if err := s.reader.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("expected wal, got %q (%v)", mode, err)
+	}
+	if _, err := s.reader.Exec("DELETE FROM events"); err == nil {
+		t.Fatalf("expected the read pool to refuse writes")
+	}
+
+	// A reader holding an open snapshot does not block ingestion, and its
+	// snapshot does not see the new rows.
+	if _, err := s.InsertEvents(benchEvents(t, 0, 10), "a.json"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	rows, err := s.reader.Query("SELECT id FROM events")
+	if err != nil {
+		t.Fatalf("open read: %v", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("expected rows")
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.InsertEvents(benchEvents(t, 10, 10), "b.json")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("insert during read: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("insert blocked by an open reader")
+	}
+	if _, total, err := s.ListEvents(EventFilter{}); err != nil || total != 20 {
+		t.Fatalf("expected 20 events from a new read, got %d (%v)", total, err)
+	}
+}
+
+func TestIngestLookupsDoNotWaitForTheWriter(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+	if err := s.MarkFileIngested("/data/1.json", 10, 1); err != nil {
+		t.Fatalf("mark: %v", err)
+	}
+
+	// Hold the single writer connection in an open transaction.
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM ingested_files"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		should, err := s.ShouldIngestFile("/data/1.json", 10, 1)
+		if err == nil && should {
+			err = errors.New("expected the committed row to be seen")
+		}
+		if err == nil {
+			_, _, err = s.GetIngestFailureByPath("/data/1.json")
+		}
+		if err == nil {
+			_, _, err = s.GetTailState("/data/1.json")
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("lookup during write: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("ingest lookups blocked by an open write transaction")
+	}
+}
+
+// benchEvents returns n student person_tracked events, one per second from
+// second start.
+func benchEvents(tb testing.TB, start, n int) []model.Event {
+	tb.Helper()
+	var b strings.Builder
+	for i := start; i < start+n; i++ {
+		fmt.Fprintf(&b, `{"event_type":"person_tracked","room_id":"class-a","camera_id":"cam-%d","pipeline":"p","confidence":0.8,"timestamp":%d,"person_role":"student","track_id":%d}`+"\n", i%4, 1771200000+i, i%30)
+	}
+	events, err := model.ParseEvents([]byte(b.String()))
+	if err != nil {
+		tb.Fatalf("parse events: %v", err)
+	}
+	return events
+}
+
+func BenchmarkInsertEvents(b *testing.B) {
+	benchmarkInsertEvents(b, 0)
+}
+
+// BenchmarkInsertEventsWithReaders measures ingest throughput while readers
+// run Summary and DailyStudentMetrics over the growing table in a loop.
+func BenchmarkInsertEventsWithReaders(b *testing.B) {
+	benchmarkInsertEvents(b, 4)
+}
+
+func benchmarkInsertEvents(b *testing.B, readers int) {
+	const batch = 500
+	s, err := Open(filepath.Join(b.TempDir(), "events.db"))
+	if err != nil {
+		b.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.InsertEvents(benchEvents(b, 0, 20000), "seed.json"); err != nil {
+		b.Fatalf("seed: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var reads atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := s.Summary(EventFilter{}); err != nil {
+					b.Errorf("summary: %v", err)
+					return
+				}
+				if _, err := s.DailyStudentMetrics(1771200000, 1771286400, nil); err != nil {
+					b.Errorf("daily metrics: %v", err)
+					return
+				}
+				reads.Add(1)
+			}
+		}()
+	}
+
+	next := 20000
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		events := benchEvents(b, next, batch)
+		next += batch
+		if _, err := s.InsertEvents(events, "bench-"+strconv.Itoa(i)+".json"); err != nil {
+			b.Fatalf("insert: %v", err)
+		}
+	}
+	elapsed := time.Since(start)
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+
+	b.ReportMetric(float64(b.N*batch)/elapsed.Seconds(), "events/s")
+	if readers > 0 {
+		b.ReportMetric(float64(reads.Load())/elapsed.Seconds(), "reads/s")
+	}
+}
+
+func TestListEventsPageCursors(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	// Timestamps out of id order, with ties, and one event without a
+	// timestamp.
+	var lines []string
+	for _, ts := range []string{"30", "10", "20", "10", "40", "20"} {
+		lines = append(lines, `{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","pipeline":"p","timestamp":`+ts+`,"track_id":`+strconv.Itoa(len(lines))+`}`)
+	}
+	events, err := model.ParseEvents([]byte(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.InsertEvents(events, "test.json"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := s.db.Exec("INSERT INTO events(ingested_at, source_file, stream_class_id, stream_camera_id, event_type, room_id, camera_id, person_id, raw_json) VALUES('x', 'untimed', '', '', 'person_tracked', '', '', '', '{}')"); err != nil {
+		t.Fatalf("insert untimed: %v", err)
+	}
+
+	ids := func(page EventPage) string {
+		var out []string
+		for _, ev := range page.Events {
+			out = append(out, strconv.FormatInt(ev.ID, 10))
+		}
+		return strings.Join(out, ",")
+	}
+	page := func(limit int, p EventPageRequest) EventPage {
+		t.Helper()
+		out, err := s.ListEventsPage(EventFilter{Limit: limit}, p)
+		if err != nil {
+			t.Fatalf("page %+v: %v", p, err)
+		}
+		return out
+	}
+
+	// By (timestamp, id) ascending: 2,4 (10) 3,6 (20) 1 (30) 5 (40).
+	p1 := page(4, EventPageRequest{Sort: SortByTimestamp, Order: OrderAsc, IncludeTotal: true})
+	if ids(p1) != "2,4,3,6" || p1.PrevCursor != "" || p1.NextCursor == "" || p1.Total == nil || *p1.Total != 6 {
+		t.Fatalf("unexpected first page: %s %+v", ids(p1), p1)
+	}
+	p2 := page(4, EventPageRequest{Cursor: p1.NextCursor})
+	if ids(p2) != "1,5" || p2.NextCursor != "" || p2.PrevCursor == "" || p2.Total != nil || p2.Sort != SortByTimestamp {
+		t.Fatalf("unexpected second page: %s %+v", ids(p2), p2)
+	}
+	if back := page(4, EventPageRequest{Cursor: p2.PrevCursor}); ids(back) != "2,4,3,6" || back.PrevCursor != "" || back.NextCursor == "" {
+		t.Fatalf("unexpected page back: %s %+v", ids(back), back)
+	}
+	if back := page(2, EventPageRequest{Cursor: p2.PrevCursor}); ids(back) != "3,6" || back.PrevCursor == "" {
+		t.Fatalf("unexpected short page back: %s %+v", ids(back), back)
+	}
+
+	// By id descending (the default); rows appended meanwhile do not shift
+	// the next page.
+	d1 := page(3, EventPageRequest{})
+	if ids(d1) != "7,6,5" || d1.Order != OrderDesc {
+		t.Fatalf("unexpected desc page: %s", ids(d1))
+	}
+	if _, err := s.db.Exec("INSERT INTO events(ingested_at, source_file, stream_class_id, stream_camera_id, event_type, room_id, camera_id, person_id, raw_json) VALUES('x', 'late', '', '', 'person_tracked', '', '', '', '{}')"); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if d2 := page(3, EventPageRequest{Cursor: d1.NextCursor}); ids(d2) != "4,3,2" || d2.NextCursor == "" {
+		t.Fatalf("unexpected second desc page: %s", ids(d2))
+	}
+
+	for _, p := range []EventPageRequest{
+		{Cursor: "not-a-cursor"},
+		{Cursor: p1.NextCursor, Order: OrderDesc},
+	} {
+		if _, err := s.ListEventsPage(EventFilter{}, p); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("expected ErrInvalidCursor for %+v, got %v", p, err)
+		}
+	}
+}
+
+func TestPromotedFieldsFilterEvents(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open(dbPath)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	events, err := model.ParseEvents([]byte(`[
+		{"event_type":"person_tracked","room_id":"class-a","camera_id":"front","pipeline":"p1","timestamp":10,"person_id":"s1","person_role":"student","posture":"sitting","distance":1.5},
+		{"event_type":"posture_event","room_id":"class-a","camera_id":"front","pipeline":"p2","timestamp":20,"person_id":"s2","role":"student","posture":"standing","distance":3},
+		{"event_type":"person_tracked","room_id":"class-a","camera_id":"back","pipeline":"p1","timestamp":30,"person_id":"t1","person_role":"teacher","posture":"standing","distance":4.5}
+	]`))
+	if err != nil {
+		t.Fatalf("parse events: %v", err)
+	}
+	if _, err := s.InsertEvents(events, "test.json"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	count := func(preds ...FieldPredicate) int64 {
+		t.Helper()
+		_, total, err := s.ListEvents(EventFilter{Fields: preds, Limit: 10})
+		if err != nil {
+			t.Fatalf("list %+v: %v", preds, err)
+		}
+		return total
+	}
+	if n := count(FieldPredicate{Field: "posture", In: []any{"standing"}}); n != 2 {
+		t.Fatalf("expected 2 standing, got %d", n)
+	}
+	// person_role falls back to role.
+	if n := count(FieldPredicate{Field: "person_role", In: []any{"student"}}); n != 2 {
+		t.Fatalf("expected 2 students, got %d", n)
+	}
+	if n := count(FieldPredicate{Field: "pipeline", In: []any{"p1"}}, FieldPredicate{Field: "posture", In: []any{"sitting", "lying"}}); n != 1 {
+		t.Fatalf("expected 1 sitting p1 event, got %d", n)
+	}
+
+	// Fields promoted later cover events stored before.
+	if _, _, err := s.ListEvents(EventFilter{Fields: []FieldPredicate{{Field: "distance", Min: 2.0}}}); err == nil {
+		t.Fatalf("expected an error filtering on an unpromoted field")
+	}
+	fields, err := ParsePromotedFields("distance=$.distance:real")
+	if err != nil {
+		t.Fatalf("parse fields: %v", err)
+	}
+	added, err := s.PromoteFields(fields)
+	if err != nil || len(added) != 1 {
+		t.Fatalf("promote: %v %+v", err, added)
+	}
+	if n := count(FieldPredicate{Field: "distance", Min: 2.0, Max: 4.5}); n != 2 {
+		t.Fatalf("expected 2 events in range, got %d", n)
+	}
+	if n := count(FieldPredicate{Field: "distance", Max: 3.0}, FieldPredicate{Field: "person_role", In: []any{"student"}}); n != 2 {
+		t.Fatalf("expected 2 close students, got %d", n)
+	}
+	summary, err := s.Summary(EventFilter{Fields: []FieldPredicate{{Field: "distance", Min: 4.0}}})
+	if err != nil || summary.TotalEvents != 1 {
+		t.Fatalf("summary: %v %+v", err, summary)
+	}
+
+	// Promoting again is a no-op; a different path or type is refused.
+	if added, err := s.PromoteFields(fields); err != nil || len(added) != 0 {
+		t.Fatalf("re-promote: %v %+v", err, added)
+	}
+	if _, err := s.PromoteFields([]PromotedField{{Name: "distance", Path: "$.distance", Type: "text"}}); err == nil {
+		t.Fatalf("expected re-promoting with another type to fail")
+	}
+	for _, spec := range []string{"Distance=$.distance", "distance=distance", "d=$.x:blob", "d=$.a'b", "novalue"} {
+		if _, err := ParsePromotedFields(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+
+	// The registry survives a reopen.
+	if err := s.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	s, err = Open(dbPath)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	var names []string
+	for _, f := range s.PromotedFields() {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "distance,orientation,person_name,person_role,pipeline,posture" {
+		t.Fatalf("unexpected promoted fields: %s", got)
+	}
+	if f, ok := s.PromotedField("distance"); !ok || f.Type != "real" || f.Builtin {
+		t.Fatalf("unexpected distance field: %+v", f)
+	}
+
+	// The daily metrics read the indexed role column.
+	metrics, err := s.DailyStudentMetrics(0, 86400, nil)
+	if err != nil {
+		t.Fatalf("daily metrics: %v", err)
+	}
+	if len(metrics) == 0 {
+		t.Fatalf("expected student metrics")
+	}
+	var plan []string
+	rows, err := s.db.Query("EXPLAIN QUERY PLAN SELECT id FROM events WHERE field_posture = ?", "standing")
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			t.Fatalf("scan plan: %v", err)
+		}
+		plan = append(plan, detail)
+	}
+	if got := strings.Join(plan, ";"); !strings.Contains(got, "idx_events_field_posture") {
+		t.Fatalf("expected the posture index to be used, got %s", got)
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"ai-json/internal/model"
+)
+
+// TailState is the read position stored for an append-only event file.
+type TailState struct {
+	Offset       int64
+	LastLineHash string
+}
+
+// GetTailState returns the stored offset and last-line hash for path. found
+// is false when the file was never ingested. It reads from the read-only
+// pool.
+func (s *Store) GetTailState(path string) (TailState, bool, error) {
+	var st TailState
+	err := s.reader.QueryRow("SELECT offset_bytes, last_line_hash FROM ingested_files WHERE path = ?", path).Scan(&st.Offset, &st.LastLineHash)
+	if err == sql.ErrNoRows {
+		return st, false, nil
+	}
+	if err != nil {
+		return st, false, fmt.Errorf("get tail state: %w", err)
+	}
+	return st, true, nil
+}
+
+// AppendFileEvents stores events read from the tail of path and advances its
+// offset in one transaction. Unlike IngestFile, events already linked to the
+// file are kept.
+func (s *Store) AppendFileEvents(path string, sizeBytes int64, modUnix int64, state TailState, events []model.Event) (InsertResult, error) {
+	return s.AppendFileEventsContext(context.Background(), path, sizeBytes, modUnix, state, events)
+}
+
+// AppendFileEventsContext is AppendFileEvents with a context; cancelling ctx
+// rolls back both the events and the offset.
+func (s *Store) AppendFileEventsContext(ctx context.Context, path string, sizeBytes int64, modUnix int64, state TailState, events []model.Event) (InsertResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return InsertResult{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var fileID int64
+	err = tx.QueryRowContext(ctx, `INSERT INTO ingested_files(path, size_bytes, mod_unix, ingested_at, offset_bytes, last_line_hash)
+VALUES(?, ?, ?, ?, ?, ?)
+ON CONFLICT(path) DO UPDATE SET
+  size_bytes = excluded.size_bytes,
+  mod_unix = excluded.mod_unix,
+  ingested_at = excluded.ingested_at,
+  offset_bytes = excluded.offset_bytes,
+  last_line_hash = excluded.last_line_hash
+RETURNING id`, path, sizeBytes, modUnix, time.Now().UTC().Format(time.RFC3339Nano), state.Offset, state.LastLineHash).Scan(&fileID)
+	if err != nil {
+		return InsertResult{}, fmt.Errorf("mark file offset: %w", err)
+	}
+
+	res, err := insertEvents(ctx, tx, events, path, fileID)
+	if err != nil {
+		return res, err
+	}
+	if err := tx.Commit(); err != nil {
+		return res, fmt.Errorf("commit tx: %w", err)
+	}
+	return res, nil
+}
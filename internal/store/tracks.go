@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// TrackEvent is the part of a stored person event the track analytics use.
+type TrackEvent struct {
+	ClassID   string
+	CameraID  string
+	TrackID   int64
+	EventType string
+	Timestamp float64
+}
+
+// TrackEvents calls fn for each stored person_detected, person_tracked and
+// person_lost event with a track_id and timestamp that matches f, in
+// timestamp order. f.EventTypes, Limit and Offset are ignored.
+func (s *Store) TrackEvents(f EventFilter, fn func(TrackEvent) error) error {
+	return s.TrackEventsContext(context.Background(), f, fn)
+}
+
+// TrackEventsContext is TrackEvents with a context that aborts the query. fn
+// runs while the rows are open, so it must not use the store.
+func (s *Store) TrackEventsContext(ctx context.Context, f EventFilter, fn func(TrackEvent) error) error {
+	f.EventTypes = []string{"person_detected", "person_tracked", "person_lost"}
+	where, args := buildWhere(f)
+	query := `SELECT COALESCE(NULLIF(stream_class_id, ''), room_id, ''), COALESCE(NULLIF(stream_camera_id, ''), camera_id, ''), track_id, event_type, timestamp
+FROM events` + where + ` AND track_id IS NOT NULL AND timestamp IS NOT NULL ORDER BY timestamp ASC, id ASC`
+
+	rows, err := s.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query track events: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ev TrackEvent
+		if err := rows.Scan(&ev.ClassID, &ev.CameraID, &ev.TrackID, &ev.EventType, &ev.Timestamp); err != nil {
+			return fmt.Errorf("scan track event: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate track events: %w", err)
+	}
+	return nil
+}